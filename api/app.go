@@ -0,0 +1,36 @@
+package api
+
+import (
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/mailgun/mailgun-go"
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/thermokarst/jwt"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/webhooks"
+)
+
+// App is what the services and handlers share: the store, the token
+// middleware, and everything a write sets off. It's built once, in main.
+type App struct {
+	// Store is where everything is kept.
+	Store models.Repository
+	// Auth issues and checks tokens.
+	Auth *jwt.Middleware
+	// Mail are the Mailgun accounts, by referring origin.
+	Mail map[string]mailgun.Mailgun
+	// Changes is the change feed, fed by models.ListenChanges.
+	Changes *ChangeFeed
+	// Webhooks delivers webhook events, logging every attempt.
+	Webhooks *webhooks.Dispatcher
+}
+
+// NewApp creates an app around a store, with no Mailgun accounts.
+func NewApp(store models.Repository, auth *jwt.Middleware) *App {
+	a := &App{
+		Store:   store,
+		Auth:    auth,
+		Mail:    make(map[string]mailgun.Mailgun),
+		Changes: NewChangeFeed(),
+	}
+	a.Webhooks = webhooks.NewDispatcher()
+	a.Webhooks.Record = a.recordDelivery
+	return a
+}
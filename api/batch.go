@@ -0,0 +1,235 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/thermokarst/bactdb/errors"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/payloads"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// maxBatchOperations is the most operations a batch can have.
+const maxBatchOperations = 1000
+
+// HandleMeasurementBatch is a HTTP handler for creating, updating and deleting
+// many measurements in one request.
+func (a *App) HandleMeasurementBatch(w http.ResponseWriter, r *http.Request) *types.AppError {
+	var batch payloads.MeasurementBatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, helpers.MaxBody)).Decode(&batch); err != nil {
+		return newJSONError(err, http.StatusBadRequest)
+	}
+
+	claims := helpers.GetClaims(r)
+
+	results, status, appErr := MeasurementService{a}.Batch(r.Context(), &batch, mux.Vars(r)["genus"], &claims)
+	if appErr != nil {
+		return appErr
+	}
+
+	data, err := results.Marshal()
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	w.WriteHeader(status)
+	w.Write(data)
+	return nil
+}
+
+// Batch validates every operation in a batch, and then applies them in a
+// single transaction. Operations that fail are rolled back on their own,
+// unless the batch is atomic, in which case nothing is written.
+func (m MeasurementService) Batch(ctx context.Context, batch *payloads.MeasurementBatch, genus string, claims *types.Claims) (*payloads.MeasurementBatchResults, int, *types.AppError) {
+	if len(batch.Operations) > maxBatchOperations {
+		return nil, 0, newJSONError(errors.ErrTooManyBatchOperations, http.StatusRequestEntityTooLarge)
+	}
+
+	store := m.Store.WithContext(ctx)
+	results := &payloads.MeasurementBatchResults{
+		Results: make([]*payloads.MeasurementOperationResult, len(batch.Operations)),
+	}
+	prepared := make([]*models.Measurement, len(batch.Operations))
+	failed := false
+
+	// Validate everything before touching the DB
+	for i, op := range batch.Operations {
+		results.Results[i] = &payloads.MeasurementOperationResult{Op: op.Op, ID: op.ID}
+
+		measurement, appErr := m.prepareMeasurementOperation(ctx, op, genus, claims)
+		if appErr != nil {
+			setBatchError(results.Results[i], i, appErr)
+			failed = true
+			continue
+		}
+		prepared[i] = measurement
+	}
+
+	if batch.Atomic && failed {
+		markNotApplied(results)
+		return results, helpers.StatusUnprocessableEntity, nil
+	}
+
+	var writes []models.MeasurementWrite
+	var indexes []int
+	for i, op := range batch.Operations {
+		if prepared[i] == nil {
+			continue
+		}
+		writes = append(writes, models.MeasurementWrite{Op: op.Op, Measurement: prepared[i].MeasurementBase})
+		indexes = append(indexes, i)
+	}
+
+	errs, err := store.WriteMeasurements(writes, batch.Atomic, claims.Sub)
+	if err != nil {
+		return nil, 0, newJSONError(err, http.StatusInternalServerError)
+	}
+	for j, err := range errs {
+		if err == nil {
+			continue
+		}
+		i := indexes[j]
+		appErr := newJSONError(err, http.StatusInternalServerError)
+		setBatchError(results.Results[i], i, appErr)
+		prepared[i] = nil
+
+		if batch.Atomic {
+			markNotApplied(results)
+			return results, appErr.Status, nil
+		}
+	}
+
+	// Reload to send back down the wire
+	for i, op := range batch.Operations {
+		if prepared[i] == nil {
+			continue
+		}
+		result := results.Results[i]
+		result.ID = prepared[i].ID
+
+		if op.Op == "delete" {
+			result.Status = http.StatusNoContent
+			m.notifyWebhooks(ctx, genus, "measurements", "delete", result.ID, nil, claims)
+			continue
+		}
+
+		// The writes are committed by now, so a measurement that can't be
+		// read back is still reported as written, just without its body
+		if measurement, err := store.GetMeasurement(prepared[i].ID, genus, claims); err != nil {
+			log.Printf("Reloading measurement %d: %v", result.ID, err)
+			m.notifyWebhooks(ctx, genus, "measurements", op.Op, result.ID, nil, claims)
+		} else {
+			result.Measurement = measurement
+			m.notifyWebhooks(ctx, genus, "measurements", op.Op, result.ID, &payloads.Measurement{Measurement: measurement}, claims)
+		}
+
+		if op.Op == "create" {
+			result.Status = http.StatusCreated
+		} else {
+			result.Status = http.StatusOK
+		}
+	}
+
+	return results, http.StatusOK, nil
+}
+
+// prepareMeasurementOperation builds and validates the measurement for a
+// single batch operation.
+func (a *App) prepareMeasurementOperation(ctx context.Context, op payloads.MeasurementOperation, genus string, claims *types.Claims) (*models.Measurement, *types.AppError) {
+	store := a.Store.WithContext(ctx)
+	switch op.Op {
+	case "create":
+		var measurement models.Measurement
+		if err := json.Unmarshal(op.Measurement, &measurement); err != nil {
+			return nil, newJSONError(err, http.StatusBadRequest)
+		}
+		if measurement.MeasurementBase == nil {
+			measurement.MeasurementBase = &models.MeasurementBase{}
+		}
+		measurement.ID = 0
+		measurement.CreatedBy = claims.Sub
+		measurement.UpdatedBy = claims.Sub
+
+		if err := models.Validate(measurement.MeasurementBase); err != nil {
+			return nil, &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
+		}
+		return &measurement, nil
+	case "update":
+		measurement, err := store.GetMeasurement(op.ID, genus, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+		if !measurement.CanEdit {
+			return nil, newJSONError(errors.ErrMeasurementForbidden, http.StatusForbidden)
+		}
+		if len(op.Measurement) > 0 {
+			if err := json.Unmarshal(op.Measurement, measurement); err != nil {
+				return nil, newJSONError(err, http.StatusBadRequest)
+			}
+		}
+		measurement.ID = op.ID
+		measurement.UpdatedBy = claims.Sub
+
+		if err := models.Validate(measurement.MeasurementBase); err != nil {
+			return nil, &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
+		}
+		return measurement, nil
+	case "delete":
+		measurement, err := store.GetMeasurement(op.ID, genus, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+		if !measurement.CanEdit {
+			return nil, newJSONError(errors.ErrMeasurementNotDeleted, http.StatusForbidden)
+		}
+		return measurement, nil
+	}
+
+	return nil, newJSONError(errors.ErrUnknownBatchOperation, http.StatusBadRequest)
+}
+
+// setBatchError fails the i'th operation's result, pointing its errors at the
+// operation in the batch.
+func setBatchError(result *payloads.MeasurementOperationResult, i int, appErr *types.AppError) {
+	result.Status = appErr.Status
+	switch err := appErr.Error.(type) {
+	case types.ValidationError:
+		result.Errors = append(types.ValidationError(nil), err...)
+	case types.ErrorJSON:
+		result.Errors = types.ValidationError{batchErrorDetail(err)}
+	default:
+		result.Errors = types.ValidationError{types.NewErrorDetail(err.Error())}
+	}
+
+	prefix := fmt.Sprintf("/operations/%d/", i)
+	for j, detail := range result.Errors {
+		if detail.Source != nil {
+			result.Errors[j].Source = &types.Source{Pointer: prefix + detail.Source.Pointer}
+		}
+	}
+}
+
+// markNotApplied flags every operation that didn't fail on its own, for
+// atomic batches that were abandoned.
+func markNotApplied(results *payloads.MeasurementBatchResults) {
+	for i, result := range results.Results {
+		if result.Status < http.StatusBadRequest {
+			result.Measurement = nil
+			setBatchError(result, i, newJSONError(errors.ErrBatchOperationNotApplied, http.StatusFailedDependency))
+		}
+	}
+}
+
+func batchErrorDetail(err types.ErrorJSON) types.ErrorDetail {
+	detail := types.NewErrorDetail(err.Err.Error())
+	detail.Status = strconv.Itoa(err.Status)
+	detail.Code = err.Code
+	return detail
+}
@@ -0,0 +1,276 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/payloads"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// batchTest is a measurement service kept in memory, with a strain and a
+// characteristic to measure, and a measurement made by an admin.
+type batchTest struct {
+	t                                   *testing.T
+	svc                                 MeasurementService
+	admin, reader                       *types.Claims
+	strain, characteristic, measurement int64
+}
+
+func newBatchTest(t *testing.T) *batchTest {
+	mem := models.NewMemory()
+	genus := mem.AddGenus("Hymenobacter")
+	mem.AddTextMeasurementType("pink")
+	admin, err := mem.AddUser(models.UserBase{Email: "admin@example.com", Name: "Admin", Role: "A"}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := mem.AddUser(models.UserBase{Email: "reader@example.com", Name: "Reader", Role: "R"}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bt := &batchTest{
+		t:      t,
+		svc:    MeasurementService{NewApp(mem, nil)},
+		admin:  &types.Claims{Sub: admin.ID, Role: "A"},
+		reader: &types.Claims{Sub: reader.ID, Role: "R"},
+	}
+	species := &models.SpeciesBase{GenusID: genus.ID, SpeciesName: "H. roseus", CreatedBy: admin.ID}
+	if err := mem.CreateSpecies(species); err != nil {
+		t.Fatal(err)
+	}
+	strain := &models.StrainBase{SpeciesID: species.ID, StrainName: "AA-1", CreatedBy: admin.ID}
+	if err := mem.CreateStrain(strain); err != nil {
+		t.Fatal(err)
+	}
+	characteristic := &models.Characteristic{
+		CharacteristicBase: &models.CharacteristicBase{CharacteristicName: "Colony color", CreatedBy: admin.ID},
+		CharacteristicType: "Phenotypic",
+	}
+	if err := mem.CreateCharacteristic(characteristic, bt.admin); err != nil {
+		t.Fatal(err)
+	}
+	bt.strain, bt.characteristic = strain.ID, characteristic.ID
+
+	results, _ := bt.batch(bt.admin, false, bt.create(strain.ID))
+	bt.measurement = results.Results[0].ID
+	return bt
+}
+
+func (bt *batchTest) create(strain int64) payloads.MeasurementOperation {
+	return payloads.MeasurementOperation{
+		Op:          "create",
+		Measurement: json.RawMessage(fmt.Sprintf(`{"strain":%d,"characteristic":%d,"value":"pink"}`, strain, bt.characteristic)),
+	}
+}
+
+// batch runs operations, failing unless they were all looked at.
+func (bt *batchTest) batch(claims *types.Claims, atomic bool, ops ...payloads.MeasurementOperation) (*payloads.MeasurementBatchResults, int) {
+	bt.t.Helper()
+	results, status, appErr := bt.svc.Batch(context.Background(), &payloads.MeasurementBatch{Atomic: atomic, Operations: ops}, "hymenobacter", claims)
+	if appErr != nil {
+		bt.t.Fatalf("batch failed: %v", appErr.Error)
+	}
+	if len(results.Results) != len(ops) {
+		bt.t.Fatalf("got %d results for %d operations", len(results.Results), len(ops))
+	}
+	return results, status
+}
+
+// measurements is how many measurements are stored.
+func (bt *batchTest) measurements() int {
+	bt.t.Helper()
+	ms, err := bt.svc.Store.ListMeasurements(helpers.MeasurementListOptions{ListOptions: helpers.ListOptions{Genus: "hymenobacter"}}, bt.admin)
+	if err != nil {
+		bt.t.Fatal(err)
+	}
+	return len(*ms)
+}
+
+// statuses are the statuses of a batch's results, in order.
+func statuses(results *payloads.MeasurementBatchResults) []int {
+	var s []int
+	for _, r := range results.Results {
+		s = append(s, r.Status)
+	}
+	return s
+}
+
+func TestBatchPartialFailure(t *testing.T) {
+	bt := newBatchTest(t)
+
+	// The create for a strain that doesn't exist passes validation, and fails
+	// once it's written, on its own
+	update := payloads.MeasurementOperation{Op: "update", ID: bt.measurement, Measurement: json.RawMessage(`{"notes":"after a week"}`)}
+	results, status := bt.batch(bt.admin, false, bt.create(bt.strain), bt.create(999), update)
+	got := statuses(results)
+	if status != http.StatusOK || got[0] != http.StatusCreated || got[1] < http.StatusBadRequest || got[2] != http.StatusOK {
+		t.Fatalf("got %d %v", status, got)
+	}
+	if results.Results[0].Measurement == nil || results.Results[1].Measurement != nil || len(results.Results[1].Errors) == 0 {
+		t.Errorf("got %+v", results.Results)
+	}
+	if n := bt.measurements(); n != 2 {
+		t.Errorf("%d measurements stored, want 2", n)
+	}
+}
+
+func TestBatchAtomic(t *testing.T) {
+	bt := newBatchTest(t)
+
+	for name, failing := range map[string]payloads.MeasurementOperation{
+		"invalid":         {Op: "create", Measurement: json.RawMessage(`{}`)},
+		"write failure":   bt.create(999),
+		"unknown":         {Op: "upsert"},
+		"missing":         {Op: "delete", ID: 999},
+		"not json":        {Op: "update", ID: bt.measurement, Measurement: json.RawMessage(`"pink"`)},
+		"invalid update":  {Op: "update", ID: bt.measurement, Measurement: json.RawMessage(`{"strain":0}`)},
+		"forbidden write": {Op: "delete", ID: bt.measurement},
+	} {
+		claims := bt.admin
+		if name == "forbidden write" {
+			claims = bt.reader
+		}
+		results, status := bt.batch(claims, true, bt.create(bt.strain), failing, bt.create(bt.strain))
+		got := statuses(results)
+		// Operations that can't be applied abandon the batch before it's
+		// written, failed writes once they're tried
+		want := helpers.StatusUnprocessableEntity
+		if name == "write failure" {
+			want = got[1]
+		}
+		if status != want || got[1] < http.StatusBadRequest || got[0] != http.StatusFailedDependency || got[2] != http.StatusFailedDependency {
+			t.Errorf("%s got %d %v", name, status, got)
+			continue
+		}
+		for _, i := range []int{0, 2} {
+			r := results.Results[i]
+			if r.Measurement != nil || len(r.Errors) != 1 || r.Errors[0].Code != "batch_operation_not_applied" {
+				t.Errorf("%s operation %d got %+v", name, i, r)
+			}
+		}
+		if n := bt.measurements(); n != 1 {
+			t.Errorf("%s left %d measurements stored, want 1", name, n)
+		}
+	}
+}
+
+func TestBatchValidationErrors(t *testing.T) {
+	bt := newBatchTest(t)
+
+	results, status := bt.batch(bt.admin, false, bt.create(bt.strain), payloads.MeasurementOperation{Op: "create", Measurement: json.RawMessage(`{}`)})
+	if status != http.StatusOK || results.Results[1].Status != helpers.StatusUnprocessableEntity {
+		t.Fatalf("got %d %v", status, statuses(results))
+	}
+	pointers := make(map[string]bool)
+	for _, e := range results.Results[1].Errors {
+		if e.Source != nil {
+			pointers[e.Source.Pointer] = true
+		}
+	}
+	for _, p := range []string{"/operations/1/data/attributes/strain", "/operations/1/data/attributes/characteristic", "/operations/1/data/attributes/value"} {
+		if !pointers[p] {
+			t.Errorf("no error for %s in %+v", p, results.Results[1].Errors)
+		}
+	}
+
+	results, _ = bt.batch(bt.admin, false, payloads.MeasurementOperation{Op: "upsert"})
+	if e := results.Results[0].Errors; len(e) != 1 || e[0].Code != "batch_operation_unknown" || e[0].Status != "400" {
+		t.Errorf("unknown operation got %+v", e)
+	}
+}
+
+func TestBatchAuthorization(t *testing.T) {
+	bt := newBatchTest(t)
+
+	// Only the creator or an admin can change a measurement, however it's done
+	update := payloads.MeasurementOperation{Op: "update", ID: bt.measurement, Measurement: json.RawMessage(`{"notes":"not mine"}`)}
+	remove := payloads.MeasurementOperation{Op: "delete", ID: bt.measurement}
+	results, _ := bt.batch(bt.reader, false, update, remove, bt.create(bt.strain))
+	if got := statuses(results); got[0] != http.StatusForbidden || got[1] != http.StatusForbidden || got[2] != http.StatusCreated {
+		t.Fatalf("reader got %v", got)
+	}
+	m, err := bt.svc.Store.GetMeasurement(bt.measurement, "hymenobacter", bt.admin)
+	if err != nil || m.Notes.Valid {
+		t.Errorf("measurement changed by a reader: %+v %v", m, err)
+	}
+
+	// Everyone can change what they made themselves, and admins anything
+	own := results.Results[2].ID
+	for _, test := range []struct {
+		claims *types.Claims
+		id     int64
+	}{
+		{bt.reader, own},
+		{bt.admin, bt.measurement},
+	} {
+		results, _ = bt.batch(test.claims, false, payloads.MeasurementOperation{Op: "update", ID: test.id, Measurement: json.RawMessage(`{"notes":"changed"}`)})
+		if got := statuses(results); got[0] != http.StatusOK {
+			t.Errorf("%s got %v updating %d", test.claims.Role, got, test.id)
+		}
+		results, _ = bt.batch(test.claims, false, payloads.MeasurementOperation{Op: "delete", ID: test.id})
+		if got := statuses(results); got[0] != http.StatusNoContent {
+			t.Errorf("%s got %v deleting %d", test.claims.Role, got, test.id)
+		}
+	}
+}
+
+func TestBatchTooManyOperations(t *testing.T) {
+	bt := newBatchTest(t)
+
+	ops := make([]payloads.MeasurementOperation, maxBatchOperations+1)
+	for i := range ops {
+		ops[i] = bt.create(bt.strain)
+	}
+	_, _, appErr := bt.svc.Batch(context.Background(), &payloads.MeasurementBatch{Operations: ops}, "hymenobacter", bt.admin)
+	if appErr == nil || appErr.Status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %+v", appErr)
+	}
+	if n := bt.measurements(); n != 1 {
+		t.Errorf("got %d measurements, want the 1 made before", n)
+	}
+}
+
+// unreadableStore can't read measurements back once it's written them.
+type unreadableStore struct {
+	models.Repository
+	written bool
+}
+
+func (s *unreadableStore) WithContext(context.Context) models.Repository { return s }
+
+func (s *unreadableStore) WriteMeasurements(writes []models.MeasurementWrite, atomic bool, userID int64) ([]error, error) {
+	s.written = true
+	return s.Repository.WriteMeasurements(writes, atomic, userID)
+}
+
+func (s *unreadableStore) GetMeasurement(id int64, genus string, claims *types.Claims) (*models.Measurement, error) {
+	if s.written {
+		return nil, fmt.Errorf("connection reset")
+	}
+	return s.Repository.GetMeasurement(id, genus, claims)
+}
+
+func TestBatchReloadFailure(t *testing.T) {
+	bt := newBatchTest(t)
+	bt.svc.Store = &unreadableStore{Repository: bt.svc.Store}
+
+	// The writes are committed, so they're reported as done
+	update := payloads.MeasurementOperation{Op: "update", ID: bt.measurement, Measurement: json.RawMessage(`{"notes":"changed"}`)}
+	results, status := bt.batch(bt.admin, false, bt.create(bt.strain), update)
+	if got := statuses(results); status != http.StatusOK || got[0] != http.StatusCreated || got[1] != http.StatusOK {
+		t.Fatalf("got %d %v", status, got)
+	}
+	if results.Results[0].ID == 0 || results.Results[0].Measurement != nil {
+		t.Errorf("create got %+v", results.Results[0])
+	}
+	if n := bt.measurements(); n != 2 {
+		t.Errorf("got %d measurements, want 2", n)
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/thermokarst/bactdb/models"
+)
+
+// changeBuffer is how far a subscriber can fall behind before it's dropped.
+const changeBuffer = 64
+
+// ChangeFeed fans changes out to the subscribers for each genus.
+type ChangeFeed struct {
+	mu     sync.Mutex
+	subs   map[chan *models.Change]string
+	closed bool
+}
+
+// NewChangeFeed creates a change feed with no subscribers.
+func NewChangeFeed() *ChangeFeed {
+	return &ChangeFeed{subs: make(map[chan *models.Change]string)}
+}
+
+// Subscribe returns a channel of a genus' changes, and a func to unsubscribe
+// with. The channel is closed if the subscriber falls too far behind, so that
+// it can start over.
+func (f *ChangeFeed) Subscribe(genus string) (<-chan *models.Change, func()) {
+	c := make(chan *models.Change, changeBuffer)
+
+	f.mu.Lock()
+	if f.closed {
+		close(c)
+	} else {
+		f.subs[c] = strings.ToLower(genus)
+	}
+	f.mu.Unlock()
+
+	cancel := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if _, ok := f.subs[c]; ok {
+			delete(f.subs, c)
+			close(c)
+		}
+	}
+	return c, cancel
+}
+
+// Publish passes a change to the subscribers for its genus. Changes with no
+// genus go to everyone, as does a nil change, which means that changes may
+// have been missed.
+func (f *ChangeFeed) Publish(change *models.Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for c, genus := range f.subs {
+		if change != nil && change.Genus != "" && change.Genus != genus {
+			continue
+		}
+		select {
+		case c <- change:
+		default:
+			delete(f.subs, c)
+			close(c)
+		}
+	}
+}
+
+// Close closes every subscriber's channel, and any subscribed after, so that
+// event streams end when the server is shutting down.
+func (f *ChangeFeed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.subs {
+		delete(f.subs, c)
+		close(c)
+	}
+	f.closed = true
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/thermokarst/bactdb/models"
+)
+
+func TestChangeFeed(t *testing.T) {
+	f := NewChangeFeed()
+	hymeno, cancel := f.Subscribe("Hymenobacter")
+	defer cancel()
+	other, cancelOther := f.Subscribe("other")
+
+	f.Publish(&models.Change{Genus: "hymenobacter", Entity: "strains", ID: 1, Action: "update", User: 2})
+	f.Publish(&models.Change{Entity: "characteristics", ID: 3, Action: "insert", User: 2})
+	f.Publish(nil)
+
+	if c := <-hymeno; c.Entity != "strains" || c.ID != 1 {
+		t.Errorf("got %+v", c)
+	}
+	if c := <-hymeno; c.Entity != "characteristics" {
+		t.Errorf("got %+v", c)
+	}
+	if c := <-hymeno; c != nil {
+		t.Errorf("got %+v, want a reset", c)
+	}
+	if c := <-other; c.Entity != "characteristics" {
+		t.Errorf("other genus got %+v", c)
+	}
+	if c := <-other; c != nil {
+		t.Errorf("other genus got %+v, want a reset", c)
+	}
+
+	cancelOther()
+	cancelOther()
+	if _, ok := <-other; ok {
+		t.Error("channel still open after unsubscribing")
+	}
+}
+
+func TestChangeFeedSlowSubscriber(t *testing.T) {
+	f := NewChangeFeed()
+	c, cancel := f.Subscribe("hymenobacter")
+	defer cancel()
+
+	for i := 0; i <= changeBuffer; i++ {
+		f.Publish(&models.Change{Genus: "hymenobacter", ID: int64(i)})
+	}
+
+	n := 0
+	for range c {
+		n++
+	}
+	if n != changeBuffer {
+		t.Errorf("got %d changes before being dropped, want %d", n, changeBuffer)
+	}
+}
+
+func TestChangeFeedClose(t *testing.T) {
+	f := NewChangeFeed()
+	c, cancel := f.Subscribe("hymenobacter")
+	defer cancel()
+
+	f.Close()
+	if _, ok := <-c; ok {
+		t.Error("channel still open after closing")
+	}
+	c, cancel = f.Subscribe("hymenobacter")
+	defer cancel()
+	if _, ok := <-c; ok {
+		t.Error("subscribed after closing")
+	}
+	f.Publish(nil)
+}
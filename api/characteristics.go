@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/url"
@@ -13,7 +14,9 @@ import (
 )
 
 // CharacteristicService provides for CRUD operations
-type CharacteristicService struct{}
+type CharacteristicService struct {
+	*App
+}
 
 // Unmarshal satisfies interface Updater and interface Creater
 func (c CharacteristicService) Unmarshal(b []byte) (types.Entity, error) {
@@ -23,134 +26,162 @@ func (c CharacteristicService) Unmarshal(b []byte) (types.Entity, error) {
 }
 
 // List lists all characteristics
-func (c CharacteristicService) List(val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+func (c CharacteristicService) List(ctx context.Context, val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := c.Store.WithContext(ctx)
 	if val == nil {
-		return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusInternalServerError)
+		return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
 	}
+	payloadOpt := helpers.PayloadOptionsFromValues(val)
 	var opt helpers.ListOptions
 	if err := helpers.SchemaDecoder.Decode(&opt, *val); err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+		return nil, newJSONError(err, http.StatusBadRequest)
 	}
 
-	characteristics, err := models.ListCharacteristics(opt, claims)
+	characteristics, err := store.ListCharacteristics(opt, claims)
 	if err != nil {
 		return nil, newJSONError(err, http.StatusInternalServerError)
 	}
 
-	strainsOpt, err := models.StrainOptsFromCharacteristics(opt)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
-	}
-
-	strains, err := models.ListStrains(*strainsOpt, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+	payload := payloads.Characteristics{
+		Characteristics: characteristics,
 	}
 
-	speciesOpt, err := models.SpeciesOptsFromStrains(*strainsOpt)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
-	}
+	if payloadOpt.Includes("strains") || payloadOpt.Includes("species") {
+		strainsOpt, err := store.StrainOptsFromCharacteristics(opt)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
 
-	species, err := models.ListSpecies(*speciesOpt, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
-	}
+		if payloadOpt.Includes("strains") {
+			strains, err := store.ListStrains(*strainsOpt, claims)
+			if err != nil {
+				return nil, newJSONError(err, http.StatusInternalServerError)
+			}
+			payload.Strains = strains
+		}
 
-	measurementsOpt, err := models.MeasurementOptsFromCharacteristics(opt)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+		if payloadOpt.Includes("species") {
+			speciesOpt, err := store.SpeciesOptsFromStrains(*strainsOpt)
+			if err != nil {
+				return nil, newJSONError(err, http.StatusInternalServerError)
+			}
+
+			species, err := store.ListSpecies(*speciesOpt, claims)
+			if err != nil {
+				return nil, newJSONError(err, http.StatusInternalServerError)
+			}
+			payload.Species = species
+		}
 	}
 
-	measurements, err := models.ListMeasurements(*measurementsOpt, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
-	}
+	if payloadOpt.Includes("measurements") {
+		measurementsOpt, err := store.MeasurementOptsFromCharacteristics(opt)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
 
-	payload := payloads.Characteristics{
-		Characteristics: characteristics,
-		Measurements:    measurements,
-		Strains:         strains,
-		Species:         species,
+		measurements, err := store.ListMeasurements(*measurementsOpt, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+		payload.Measurements = measurements
 	}
 
 	return &payload, nil
 }
 
-// Get retrieves a single characteristic
-func (c CharacteristicService) Get(id int64, genus string, claims *types.Claims) (types.Entity, *types.AppError) {
-	characteristic, err := models.GetCharacteristic(id, genus, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+// Stream streams all characteristics
+func (c CharacteristicService) Stream(ctx context.Context, val *url.Values, claims *types.Claims, fn func(types.Entity) error) *types.AppError {
+	store := c.Store.WithContext(ctx)
+	if val == nil {
+		return newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
+	}
+	helpers.PayloadOptionsFromValues(val)
+	var opt helpers.ListOptions
+	if err := helpers.SchemaDecoder.Decode(&opt, *val); err != nil {
+		return newJSONError(err, http.StatusBadRequest)
 	}
 
-	strains, strainOpts, err := models.StrainsFromCharacteristicID(id, genus, claims)
+	err := store.StreamCharacteristics(opt, claims, func(characteristic *models.Characteristic) error {
+		return fn(&payloads.Characteristic{Characteristic: characteristic})
+	})
 	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+		return newJSONError(err, http.StatusInternalServerError)
 	}
+	return nil
+}
 
-	speciesOpt, err := models.SpeciesOptsFromStrains(*strainOpts)
+// Get retrieves a single characteristic
+func (c CharacteristicService) Get(ctx context.Context, id int64, genus string, payloadOpt helpers.PayloadOptions, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := c.Store.WithContext(ctx)
+	characteristic, err := store.GetCharacteristic(id, genus, claims)
 	if err != nil {
 		return nil, newJSONError(err, http.StatusInternalServerError)
 	}
 
-	species, err := models.ListSpecies(*speciesOpt, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+	payload := payloads.Characteristic{
+		Characteristic: characteristic,
 	}
 
-	measurements, _, err := models.MeasurementsFromCharacteristicID(id, genus, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+	if payloadOpt.Includes("strains") || payloadOpt.Includes("species") {
+		strains, strainOpts, err := store.StrainsFromCharacteristicID(id, genus, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+
+		if payloadOpt.Includes("strains") {
+			payload.Strains = strains
+		}
+
+		if payloadOpt.Includes("species") {
+			speciesOpt, err := store.SpeciesOptsFromStrains(*strainOpts)
+			if err != nil {
+				return nil, newJSONError(err, http.StatusInternalServerError)
+			}
+
+			species, err := store.ListSpecies(*speciesOpt, claims)
+			if err != nil {
+				return nil, newJSONError(err, http.StatusInternalServerError)
+			}
+			payload.Species = species
+		}
 	}
 
-	payload := payloads.Characteristic{
-		Characteristic: characteristic,
-		Measurements:   measurements,
-		Strains:        strains,
-		Species:        species,
+	if payloadOpt.Includes("measurements") {
+		measurements, _, err := store.MeasurementsFromCharacteristicID(id, genus, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+		payload.Measurements = measurements
 	}
 
 	return &payload, nil
 }
 
 // Update modifies an existing characteristic
-func (c CharacteristicService) Update(id int64, e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+func (c CharacteristicService) Update(ctx context.Context, id int64, e *types.Entity, genus string, p types.Precondition, claims *types.Claims) *types.AppError {
+	store := c.Store.WithContext(ctx)
 	payload := (*e).(*payloads.Characteristic)
 	payload.Characteristic.UpdatedBy = claims.Sub
 	payload.Characteristic.ID = id
 
-	// First, handle Characteristic Type
-	id, err := models.InsertOrGetCharacteristicType(payload.Characteristic.CharacteristicType, claims)
-	if err != nil {
-		return newJSONError(err, http.StatusInternalServerError)
-	}
-
 	payload.Characteristic.CanEdit = helpers.CanEdit(claims, payload.Characteristic.CreatedBy)
 
-	payload.Characteristic.CharacteristicTypeID = id
-
-	if err := models.Update(payload.Characteristic.CharacteristicBase); err != nil {
-		if err == errors.ErrCharacteristicNotUpdated {
-			return newJSONError(err, http.StatusBadRequest)
-		}
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
-		}
+	if err := store.UpdateCharacteristic(payload.Characteristic, claims, p); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	strains, strainOpts, err := models.StrainsFromCharacteristicID(id, genus, claims)
+	strains, strainOpts, err := store.StrainsFromCharacteristicID(id, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	speciesOpt, err := models.SpeciesOptsFromStrains(*strainOpts)
+	speciesOpt, err := store.SpeciesOptsFromStrains(*strainOpts)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	species, err := models.ListSpecies(*speciesOpt, claims)
+	species, err := store.ListSpecies(*speciesOpt, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
@@ -160,41 +191,38 @@ func (c CharacteristicService) Update(id int64, e *types.Entity, genus string, c
 	payload.Measurements = nil
 	payload.Species = species
 
+	c.notifyWebhooks(ctx, genus, "characteristics", "update", id, payload, claims)
+
 	return nil
 }
 
 // Create initializes a new characteristic
-func (c CharacteristicService) Create(e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+func (c CharacteristicService) Create(ctx context.Context, e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+	store := c.Store.WithContext(ctx)
 	payload := (*e).(*payloads.Characteristic)
 	payload.Characteristic.CreatedBy = claims.Sub
 	payload.Characteristic.UpdatedBy = claims.Sub
 
-	id, err := models.InsertOrGetCharacteristicType(payload.Characteristic.CharacteristicType, claims)
-	if err != nil {
-		return newJSONError(err, http.StatusInternalServerError)
-	}
-	payload.Characteristic.CharacteristicTypeID = id
-
-	if err := models.Create(payload.Characteristic.CharacteristicBase); err != nil {
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
-		}
+	if err := store.CreateCharacteristic(payload.Characteristic, claims); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	characteristic, err := models.GetCharacteristic(payload.Characteristic.ID, genus, claims)
+	characteristic, err := store.GetCharacteristic(payload.Characteristic.ID, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
 	payload.Characteristic = characteristic
 
+	c.notifyWebhooks(ctx, genus, "characteristics", "create", payload.Characteristic.ID, payload, claims)
+
 	return nil
 }
 
 // Delete deletes a single characteristic
-func (c CharacteristicService) Delete(id int64, genus string, claims *types.Claims) *types.AppError {
-	characteristic, err := models.GetCharacteristic(id, genus, claims)
+func (c CharacteristicService) Delete(ctx context.Context, id int64, genus string, p types.Precondition, claims *types.Claims) *types.AppError {
+	store := c.Store.WithContext(ctx)
+	characteristic, err := store.GetCharacteristic(id, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
@@ -203,9 +231,11 @@ func (c CharacteristicService) Delete(id int64, genus string, claims *types.Clai
 		return newJSONError(errors.ErrCharacteristicNotDeleted, http.StatusForbidden)
 	}
 
-	if err := models.Delete(characteristic.CharacteristicBase); err != nil {
+	if err := store.DeleteCharacteristic(characteristic.CharacteristicBase, claims.Sub, p); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
+	c.notifyWebhooks(ctx, genus, "characteristics", "delete", id, nil, claims)
+
 	return nil
 }
@@ -2,10 +2,12 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -19,11 +21,7 @@ import (
 // HandleCompare is a HTTP handler for comparision.
 // Comparision requires a list of strain ids and a list of characteristic ids.
 // The id order dictates the presentation order.
-func HandleCompare(w http.ResponseWriter, r *http.Request) *types.AppError {
-	// types
-	type Comparisions map[string]map[string]string
-	type ComparisionsJSON [][]string
-
+func (a *App) HandleCompare(w http.ResponseWriter, r *http.Request) *types.AppError {
 	// vars
 	mimeType := r.FormValue("mimeType")
 	if mimeType == "" {
@@ -33,77 +31,34 @@ func HandleCompare(w http.ResponseWriter, r *http.Request) *types.AppError {
 	var header string
 	var data []byte
 
-	// Get measurements for comparision
-	measService := MeasurementService{}
 	opt := r.URL.Query()
 	opt.Del("mimeType")
 	opt.Del("token")
+	opt.Del("include")
 	opt.Add("Genus", mux.Vars(r)["genus"])
-	measurementsEntity, appErr := measService.List(&opt, &claims)
+	comparisionsJSON, measurementsPayload, appErr := a.CompareMatrix(r.Context(), opt, &claims)
 	if appErr != nil {
 		return appErr
 	}
-	measurementsPayload := (measurementsEntity).(*payloads.Measurements)
-
-	// Assemble matrix
-	characteristicIDs := strings.Split(opt.Get("characteristic_ids"), ",")
 	strainIDs := strings.Split(opt.Get("strain_ids"), ",")
 
-	comparisions := make(Comparisions)
-	for _, characteristicID := range characteristicIDs {
-		characteristicIDInt, _ := strconv.ParseInt(characteristicID, 10, 0)
-		values := make(map[string]string)
-		for _, strainID := range strainIDs {
-			strainIDInt, _ := strconv.ParseInt(strainID, 10, 0)
-			for _, m := range *measurementsPayload.Measurements {
-				if (m.CharacteristicID == characteristicIDInt) && (m.StrainID == strainIDInt) {
-					if m.Notes.Valid {
-						values[strainID] = fmt.Sprintf("%s (%s)", m.Value(), m.Notes.String)
-					} else {
-						if values[strainID] != "" {
-							values[strainID] = fmt.Sprintf("%s; %s", values[strainID], m.Value())
-						} else {
-							values[strainID] = m.Value()
-						}
-					}
-				}
-			}
-			// If the strain doesn't have a measurement for this characteristic,
-			// stick an empty value in anyway (for CSV).
-			if _, ok := values[strainID]; !ok {
-				values[strainID] = ""
-			}
-		}
-
-		comparisions[characteristicID] = values
-	}
-
 	// Return, based on mimetype
 	switch mimeType {
 	case "json":
 		header = "application/json"
-
-		comparisionsJSON := make(ComparisionsJSON, 0)
-		for _, characteristicID := range characteristicIDs {
-			row := []string{characteristicID}
-			for _, strainID := range strainIDs {
-				row = append(row, comparisions[characteristicID][strainID])
-			}
-			comparisionsJSON = append(comparisionsJSON, row)
-		}
-
 		data, _ = json.Marshal(comparisionsJSON)
 	case "csv":
 		header = "text/csv"
 
 		// maps to translate ids
+		store := a.Store.WithContext(r.Context())
 		strains := make(map[string]string)
 		for _, strain := range *measurementsPayload.Strains {
 			var t string
 			if strain.TypeStrain {
 				t = "T"
 			}
-			strains[fmt.Sprintf("%d", strain.ID)] = fmt.Sprintf("%s %s %s", strain.SpeciesName(), strain.StrainName, t)
+			strains[fmt.Sprintf("%d", strain.ID)] = fmt.Sprintf("%s %s %s", store.SpeciesName(strain.StrainBase), strain.StrainName, t)
 		}
 		characteristics := make(map[string]string)
 		for _, characteristic := range *measurementsPayload.Characteristics {
@@ -121,12 +76,8 @@ func HandleCompare(w http.ResponseWriter, r *http.Request) *types.AppError {
 		wr.Write(r)
 
 		// Write data
-		for _, characteristicID := range characteristicIDs {
-			r := []string{characteristics[characteristicID]}
-			for _, strainID := range strainIDs {
-				r = append(r, comparisions[characteristicID][strainID])
-			}
-			wr.Write(r)
+		for _, row := range comparisionsJSON {
+			wr.Write(append([]string{characteristics[row[0]]}, row[1:]...))
 		}
 		wr.Flush()
 
@@ -140,3 +91,63 @@ func HandleCompare(w http.ResponseWriter, r *http.Request) *types.AppError {
 	w.Write(data)
 	return nil
 }
+
+// CompareMatrix compares measurements across strains, with the options of a
+// measurement list. There is a row for each characteristic, of its ID, then
+// its values for each of the strains, in the order they were asked for.
+func (a *App) CompareMatrix(ctx context.Context, opt url.Values, claims *types.Claims) ([][]string, *payloads.Measurements, *types.AppError) {
+	// types
+	type Comparisions map[string]map[string]string
+
+	// Get measurements for comparision
+	measService := MeasurementService{a}
+	measurementsEntity, appErr := measService.List(ctx, &opt, claims)
+	if appErr != nil {
+		return nil, nil, appErr
+	}
+	measurementsPayload := (measurementsEntity).(*payloads.Measurements)
+
+	// Assemble matrix
+	characteristicIDs := strings.Split(opt.Get("characteristic_ids"), ",")
+	strainIDs := strings.Split(opt.Get("strain_ids"), ",")
+
+	comparisions := make(Comparisions)
+	for _, characteristicID := range characteristicIDs {
+		characteristicIDInt, _ := strconv.ParseInt(characteristicID, 10, 0)
+		values := make(map[string]string)
+		for _, strainID := range strainIDs {
+			strainIDInt, _ := strconv.ParseInt(strainID, 10, 0)
+			for _, m := range *measurementsPayload.Measurements {
+				if (m.CharacteristicID == characteristicIDInt) && (m.StrainID == strainIDInt) {
+					if m.Notes.Valid {
+						values[strainID] = fmt.Sprintf("%s (%s)", m.Value(), m.Notes.String)
+					} else {
+						if values[strainID] != "" {
+							values[strainID] = fmt.Sprintf("%s; %s", values[strainID], m.Value())
+						} else {
+							values[strainID] = m.Value()
+						}
+					}
+				}
+			}
+			// If the strain doesn't have a measurement for this characteristic,
+			// stick an empty value in anyway (for CSV).
+			if _, ok := values[strainID]; !ok {
+				values[strainID] = ""
+			}
+		}
+
+		comparisions[characteristicID] = values
+	}
+
+	matrix := make([][]string, 0)
+	for _, characteristicID := range characteristicIDs {
+		row := []string{characteristicID}
+		for _, strainID := range strainIDs {
+			row = append(row, comparisions[characteristicID][strainID])
+		}
+		matrix = append(matrix, row)
+	}
+
+	return matrix, measurementsPayload, nil
+}
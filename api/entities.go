@@ -1,34 +1,48 @@
 package api
 
 import (
+	"context"
 	"net/url"
 
+	"github.com/thermokarst/bactdb/helpers"
 	"github.com/thermokarst/bactdb/types"
 )
 
 // Getter gets a single entity.
 type Getter interface {
-	Get(int64, string, *types.Claims) (types.Entity, *types.AppError)
+	Get(context.Context, int64, string, helpers.PayloadOptions, *types.Claims) (types.Entity, *types.AppError)
 }
 
 // Lister lists entities.
 type Lister interface {
-	List(*url.Values, *types.Claims) (types.Entity, *types.AppError)
+	List(context.Context, *url.Values, *types.Claims) (types.Entity, *types.AppError)
 }
 
-// Updater updates entities.
+// Streamer lists entities one at a time, as they're read, without
+// sideloading. Each is passed to fn as a single entity payload.
+type Streamer interface {
+	Stream(context.Context, *url.Values, *types.Claims, func(types.Entity) error) *types.AppError
+}
+
+// Updater updates entities, if the precondition allows their current version.
 type Updater interface {
-	Update(int64, *types.Entity, string, *types.Claims) *types.AppError
+	Update(context.Context, int64, *types.Entity, string, types.Precondition, *types.Claims) *types.AppError
 	Unmarshal([]byte) (types.Entity, error)
 }
 
+// Patcher applies partial updates on top of existing entities.
+type Patcher interface {
+	Getter
+	Updater
+}
+
 // Creater creates entities.
 type Creater interface {
-	Create(*types.Entity, string, *types.Claims) *types.AppError
+	Create(context.Context, *types.Entity, string, *types.Claims) *types.AppError
 	Unmarshal([]byte) (types.Entity, error)
 }
 
-// Deleter deletes entities.
+// Deleter deletes entities, if the precondition allows their current version.
 type Deleter interface {
-	Delete(int64, string, *types.Claims) *types.AppError
+	Delete(context.Context, int64, string, types.Precondition, *types.Claims) *types.AppError
 }
@@ -0,0 +1,517 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/thermokarst/bactdb/errors"
+	"github.com/thermokarst/bactdb/graphql"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// HandleGraphQL is a HTTP handler for read-only GraphQL queries over a genus.
+// Queries are sent as the query parameter of a GET, or as a POST, either JSON
+// encoded or as application/graphql.
+func (a *App) HandleGraphQL(w http.ResponseWriter, r *http.Request) *types.AppError {
+	var req graphql.Request
+
+	if r.Method == "GET" {
+		req.Query = r.FormValue("query")
+		req.OperationName = r.FormValue("operationName")
+		if v := r.FormValue("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return newJSONError(err, http.StatusBadRequest)
+			}
+		}
+	} else {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, helpers.MaxBody))
+		if err != nil {
+			return newJSONError(err, http.StatusBadRequest)
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/graphql" {
+			req.Query = string(body)
+		} else if err := json.Unmarshal(body, &req); err != nil {
+			return newJSONError(err, http.StatusBadRequest)
+		}
+	}
+
+	store := a.Store.WithContext(r.Context())
+	genus, err := store.GetGenus(mux.Vars(r)["genus"])
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	claims := helpers.GetClaims(r)
+	resp := graphQLSchema.Execute(newGraphQLContext(store, genus, &claims), &req)
+	if len(resp.Errors) == 1 && resp.Errors[0] == graphql.ErrTooDeep {
+		return newJSONError(errors.ErrQueryTooDeep, http.StatusBadRequest)
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	w.Write(data)
+	return nil
+}
+
+// HandleGraphQLSchema is a HTTP handler for the GraphQL schema, in the schema
+// language.
+func HandleGraphQLSchema(w http.ResponseWriter, r *http.Request) *types.AppError {
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.Write([]byte(graphQLSchema.SDL()))
+	return nil
+}
+
+// graphQLContext holds a request's store, genus and claims, and caches the
+// entities loaded while executing it.
+type graphQLContext struct {
+	store  models.Repository
+	genus  *models.Genus
+	claims *types.Claims
+	// name is the genus name as the models expect it
+	name    string
+	loaders map[string]*loader
+}
+
+func newGraphQLContext(store models.Repository, genus *models.Genus, claims *types.Claims) *graphQLContext {
+	name := strings.ToLower(genus.GenusName)
+	c := graphQLContext{store: store, genus: genus, claims: claims, name: name}
+	c.loaders = map[string]*loader{
+		"Species": newLoader(func(ids []int64) ([]interface{}, error) {
+			species, err := store.ListSpecies(helpers.ListOptions{Genus: name, IDs: ids}, claims)
+			if err != nil {
+				return nil, err
+			}
+			var list []interface{}
+			for _, s := range *species {
+				list = append(list, s)
+			}
+			return list, nil
+		}),
+		"Strain": newLoader(func(ids []int64) ([]interface{}, error) {
+			strains, err := store.ListStrains(helpers.ListOptions{Genus: name, IDs: ids}, claims)
+			if err != nil {
+				return nil, err
+			}
+			var list []interface{}
+			for _, s := range *strains {
+				list = append(list, s)
+			}
+			return list, nil
+		}),
+		"Characteristic": newLoader(func(ids []int64) ([]interface{}, error) {
+			characteristics, err := store.ListCharacteristics(helpers.ListOptions{Genus: name, IDs: ids}, claims)
+			if err != nil {
+				return nil, err
+			}
+			var list []interface{}
+			for _, c := range *characteristics {
+				list = append(list, c)
+			}
+			return list, nil
+		}),
+		"Measurement": newLoader(func(ids []int64) ([]interface{}, error) {
+			opt := helpers.MeasurementListOptions{ListOptions: helpers.ListOptions{Genus: name, IDs: ids}}
+			measurements, err := store.ListMeasurements(opt, claims)
+			if err != nil {
+				return nil, err
+			}
+			var list []interface{}
+			for _, m := range *measurements {
+				list = append(list, m)
+			}
+			return list, nil
+		}),
+		"User": newLoader(func(ids []int64) ([]interface{}, error) {
+			// Only Admins can view any users, otherwise users are limited to
+			// themselves
+			var list []interface{}
+			if claims.Role != "A" {
+				for _, id := range ids {
+					if id == claims.Sub {
+						user, err := store.GetUser(id, "", claims)
+						if err == errors.ErrUserNotFound {
+							continue
+						} else if err != nil {
+							return nil, err
+						}
+						list = append(list, user)
+					}
+				}
+				return list, nil
+			}
+
+			users, err := store.ListUsers(helpers.ListOptions{IDs: ids}, claims)
+			if err != nil {
+				return nil, err
+			}
+			for _, u := range *users {
+				list = append(list, u)
+			}
+			return list, nil
+		}),
+	}
+	return &c
+}
+
+// loader fetches entities of one type by ID, a batch at a time, and caches
+// them for the rest of the request. Entities that are not found, or not
+// visible, are left out.
+type loader struct {
+	fetch func(ids []int64) ([]interface{}, error)
+	cache map[int64]interface{}
+}
+
+func newLoader(fetch func(ids []int64) ([]interface{}, error)) *loader {
+	return &loader{fetch: fetch, cache: make(map[int64]interface{})}
+}
+
+func (l *loader) load(ids []int64) (map[int64]interface{}, error) {
+	var missing []int64
+	seen := make(map[int64]bool)
+	for _, id := range ids {
+		if _, ok := l.cache[id]; !ok && !seen[id] {
+			seen[id] = true
+			missing = append(missing, id)
+		}
+	}
+
+	// An empty list of IDs means all of them to the models
+	if len(missing) > 0 {
+		entities, err := l.fetch(missing)
+		if err != nil {
+			return nil, err
+		}
+		l.prime(entities)
+		for _, id := range missing {
+			if _, ok := l.cache[id]; !ok {
+				l.cache[id] = nil
+			}
+		}
+	}
+	return l.cache, nil
+}
+
+func (l *loader) prime(entities []interface{}) {
+	for _, e := range entities {
+		l.cache[entityID(e)] = e
+	}
+}
+
+func entityID(e interface{}) int64 {
+	switch e := e.(type) {
+	case *models.Species:
+		return e.ID
+	case *models.Strain:
+		return e.ID
+	case *models.Characteristic:
+		return e.ID
+	case *models.Measurement:
+		return e.ID
+	case *models.User:
+		return e.ID
+	}
+	return 0
+}
+
+// byIDs resolves a root field to the entities with the given IDs, in that
+// order, or to all of them, when there are none.
+func byIDs(typ string, all func(c *graphQLContext) ([]interface{}, error)) graphql.Resolver {
+	return func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		c := ctx.(*graphQLContext)
+		l := c.loaders[typ]
+
+		var entities []interface{}
+		if ids := argIDs(args["ids"]); ids != nil {
+			loaded, err := l.load(ids)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				if e := loaded[id]; e != nil {
+					entities = append(entities, e)
+				}
+			}
+		} else {
+			var err error
+			if entities, err = all(c); err != nil {
+				return nil, err
+			}
+			l.prime(entities)
+		}
+
+		return []interface{}{entities}, nil
+	}
+}
+
+func argIDs(arg interface{}) []int64 {
+	values, ok := arg.([]interface{})
+	if !ok {
+		return nil
+	}
+	ids := make([]int64, 0, len(values))
+	for _, v := range values {
+		ids = append(ids, v.(int64))
+	}
+	return ids
+}
+
+// filter resolves a list field like next, keeping the entities that keep
+// accepts.
+func filter(next graphql.Resolver, keep func(e interface{}, args map[string]interface{}) bool) graphql.Resolver {
+	return func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		values, err := next(ctx, parents, args)
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			var kept []interface{}
+			for _, e := range v.([]interface{}) {
+				if keep(e, args) {
+					kept = append(kept, e)
+				}
+			}
+			values[i] = kept
+		}
+		return values, nil
+	}
+}
+
+// one resolves a to-one relationship, loading the related entities of all
+// parents at once.
+func one(typ string, id func(parent interface{}) int64) graphql.Resolver {
+	return func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		ids := make([]int64, len(parents))
+		for i, p := range parents {
+			ids[i] = id(p)
+		}
+		loaded, err := ctx.(*graphQLContext).loaders[typ].load(ids)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(parents))
+		for i, id := range ids {
+			if e := loaded[id]; e != nil {
+				values[i] = e
+			}
+		}
+		return values, nil
+	}
+}
+
+// many resolves a to-many relationship, loading the related entities of all
+// parents at once.
+func many(typ string, ids func(parent interface{}) []int64) graphql.Resolver {
+	return func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		var all []int64
+		for _, p := range parents {
+			all = append(all, ids(p)...)
+		}
+		loaded, err := ctx.(*graphQLContext).loaders[typ].load(all)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, len(parents))
+		for i, p := range parents {
+			related := []interface{}{}
+			for _, id := range ids(p) {
+				if e := loaded[id]; e != nil {
+					related = append(related, e)
+				}
+			}
+			values[i] = related
+		}
+		return values, nil
+	}
+}
+
+// attr resolves an attribute of each parent.
+func attr(get func(parent interface{}) interface{}) graphql.Resolver {
+	return func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+		values := make([]interface{}, len(parents))
+		for i, p := range parents {
+			values[i] = get(p)
+		}
+		return values, nil
+	}
+}
+
+var graphQLSchema = graphql.NewSchema("Query",
+	&graphql.Object{
+		Name:        "Query",
+		Description: "Everything is lumped under the genus in the request path.",
+		Fields: []*graphql.FieldDef{
+			{Name: "genus", Type: "Genus!", Resolve: func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+				return []interface{}{ctx.(*graphQLContext).genus}, nil
+			}},
+			{
+				Name: "species",
+				Type: "[Species!]!",
+				Args: []*graphql.ArgDef{{Name: "ids", Type: "[ID!]"}},
+				Resolve: byIDs("Species", func(c *graphQLContext) ([]interface{}, error) {
+					return c.loaders["Species"].fetch(nil)
+				}),
+			},
+			{
+				Name: "strains",
+				Type: "[Strain!]!",
+				Args: []*graphql.ArgDef{{Name: "ids", Type: "[ID!]"}, {Name: "typeStrain", Type: "Boolean"}},
+				Resolve: filter(byIDs("Strain", func(c *graphQLContext) ([]interface{}, error) {
+					return c.loaders["Strain"].fetch(nil)
+				}), func(e interface{}, args map[string]interface{}) bool {
+					typeStrain, ok := args["typeStrain"].(bool)
+					return !ok || e.(*models.Strain).TypeStrain == typeStrain
+				}),
+			},
+			{
+				Name: "characteristics",
+				Type: "[Characteristic!]!",
+				Args: []*graphql.ArgDef{{Name: "ids", Type: "[ID!]"}, {Name: "characteristicType", Type: "String"}},
+				Resolve: filter(byIDs("Characteristic", func(c *graphQLContext) ([]interface{}, error) {
+					return c.loaders["Characteristic"].fetch(nil)
+				}), func(e interface{}, args map[string]interface{}) bool {
+					characteristicType, ok := args["characteristicType"].(string)
+					return !ok || e.(*models.Characteristic).CharacteristicType == characteristicType
+				}),
+			},
+			{
+				Name: "measurements",
+				Type: "[Measurement!]!",
+				Args: []*graphql.ArgDef{{Name: "ids", Type: "[ID!]"}, {Name: "strains", Type: "[ID!]"}, {Name: "characteristics", Type: "[ID!]"}},
+				Resolve: func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+					c := ctx.(*graphQLContext)
+					opt := helpers.MeasurementListOptions{
+						ListOptions:     helpers.ListOptions{Genus: c.name, IDs: argIDs(args["ids"])},
+						Strains:         argIDs(args["strains"]),
+						Characteristics: argIDs(args["characteristics"]),
+					}
+					measurements, err := c.store.ListMeasurements(opt, c.claims)
+					if err != nil {
+						return nil, err
+					}
+					var list []interface{}
+					for _, m := range *measurements {
+						list = append(list, m)
+					}
+					c.loaders["Measurement"].prime(list)
+					return []interface{}{list}, nil
+				},
+			},
+			{
+				Name: "users",
+				Type: "[User!]!",
+				Args: []*graphql.ArgDef{{Name: "ids", Type: "[ID!]"}},
+				Resolve: byIDs("User", func(c *graphQLContext) ([]interface{}, error) {
+					if c.claims.Role != "A" {
+						return c.loaders["User"].fetch([]int64{c.claims.Sub})
+					}
+					return c.loaders["User"].fetch(nil)
+				}),
+			},
+			{Name: "me", Type: "User", Resolve: func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+				c := ctx.(*graphQLContext)
+				return one("User", func(interface{}) int64 { return c.claims.Sub })(ctx, parents, args)
+			}},
+		},
+	},
+	&graphql.Object{
+		Name: "Genus",
+		Fields: []*graphql.FieldDef{
+			{Name: "id", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Genus).ID })},
+			{Name: "genusName", Type: "String!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Genus).GenusName })},
+			{Name: "createdAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Genus).CreatedAt })},
+			{Name: "updatedAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Genus).UpdatedAt })},
+		},
+	},
+	&graphql.Object{
+		Name: "Species",
+		Fields: []*graphql.FieldDef{
+			{Name: "id", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Species).ID })},
+			{Name: "speciesName", Type: "String!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Species).SpeciesName })},
+			{Name: "typeSpecies", Type: "Boolean", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Species).TypeSpecies })},
+			{Name: "etymology", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Species).Etymology })},
+			{Name: "totalStrains", Type: "Int!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Species).TotalStrains })},
+			{Name: "strains", Type: "[Strain!]!", Resolve: many("Strain", func(p interface{}) []int64 { return p.(*models.Species).Strains })},
+			{Name: "canEdit", Type: "Boolean!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Species).CanEdit })},
+			{Name: "createdAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Species).CreatedAt })},
+			{Name: "updatedAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Species).UpdatedAt })},
+			{Name: "createdBy", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Species).CreatedBy })},
+			{Name: "updatedBy", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Species).UpdatedBy })},
+		},
+	},
+	&graphql.Object{
+		Name: "Strain",
+		Fields: []*graphql.FieldDef{
+			{Name: "id", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Strain).ID })},
+			{Name: "strainName", Type: "String!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Strain).StrainName })},
+			{Name: "typeStrain", Type: "Boolean!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Strain).TypeStrain })},
+			{Name: "accessionNumbers", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Strain).AccessionNumbers })},
+			{Name: "genbank", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Strain).Genbank })},
+			{Name: "wholeGenomeSequence", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Strain).WholeGenomeSequence })},
+			{Name: "isolatedFrom", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Strain).IsolatedFrom })},
+			{Name: "notes", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Strain).Notes })},
+			{Name: "totalMeasurements", Type: "Int!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Strain).TotalMeasurements })},
+			{Name: "species", Type: "Species", Resolve: one("Species", func(p interface{}) int64 { return p.(*models.Strain).SpeciesID })},
+			{Name: "measurements", Type: "[Measurement!]!", Resolve: many("Measurement", func(p interface{}) []int64 { return p.(*models.Strain).Measurements })},
+			{Name: "characteristics", Type: "[Characteristic!]!", Resolve: many("Characteristic", func(p interface{}) []int64 { return p.(*models.Strain).Characteristics })},
+			{Name: "canEdit", Type: "Boolean!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Strain).CanEdit })},
+			{Name: "createdAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Strain).CreatedAt })},
+			{Name: "updatedAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Strain).UpdatedAt })},
+			{Name: "createdBy", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Strain).CreatedBy })},
+			{Name: "updatedBy", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Strain).UpdatedBy })},
+		},
+	},
+	&graphql.Object{
+		Name: "Characteristic",
+		Fields: []*graphql.FieldDef{
+			{Name: "id", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Characteristic).ID })},
+			{Name: "characteristicName", Type: "String!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Characteristic).CharacteristicName })},
+			{Name: "characteristicTypeName", Type: "String!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Characteristic).CharacteristicType })},
+			{Name: "sortOrder", Type: "Int", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Characteristic).SortOrder })},
+			{Name: "strains", Type: "[Strain!]!", Resolve: many("Strain", func(p interface{}) []int64 { return p.(*models.Characteristic).Strains })},
+			{Name: "measurements", Type: "[Measurement!]!", Resolve: many("Measurement", func(p interface{}) []int64 { return p.(*models.Characteristic).Measurements })},
+			{Name: "canEdit", Type: "Boolean!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Characteristic).CanEdit })},
+			{Name: "createdAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Characteristic).CreatedAt })},
+			{Name: "updatedAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Characteristic).UpdatedAt })},
+			{Name: "createdBy", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Characteristic).CreatedBy })},
+			{Name: "updatedBy", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Characteristic).UpdatedBy })},
+		},
+	},
+	&graphql.Object{
+		Name: "Measurement",
+		Fields: []*graphql.FieldDef{
+			{Name: "id", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Measurement).ID })},
+			{Name: "value", Type: "String!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Measurement).Value() })},
+			{Name: "confidenceInterval", Type: "Float", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Measurement).ConfidenceInterval })},
+			{Name: "unitType", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Measurement).UnitType })},
+			{Name: "testMethod", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Measurement).TestMethod })},
+			{Name: "notes", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Measurement).Notes })},
+			{Name: "strain", Type: "Strain", Resolve: one("Strain", func(p interface{}) int64 { return p.(*models.Measurement).StrainID })},
+			{Name: "characteristic", Type: "Characteristic", Resolve: one("Characteristic", func(p interface{}) int64 { return p.(*models.Measurement).CharacteristicID })},
+			{Name: "canEdit", Type: "Boolean!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Measurement).CanEdit })},
+			{Name: "createdAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Measurement).CreatedAt })},
+			{Name: "updatedAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.Measurement).UpdatedAt })},
+			{Name: "createdBy", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Measurement).CreatedBy })},
+			{Name: "updatedBy", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.Measurement).UpdatedBy })},
+		},
+	},
+	&graphql.Object{
+		Name: "User",
+		Fields: []*graphql.FieldDef{
+			{Name: "id", Type: "ID!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.User).ID })},
+			{Name: "email", Type: "String!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.User).Email })},
+			{Name: "name", Type: "String!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.User).Name })},
+			{Name: "role", Type: "String!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.User).Role })},
+			{Name: "canEdit", Type: "Boolean!", Resolve: attr(func(p interface{}) interface{} { return p.(*models.User).CanEdit })},
+			{Name: "createdAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.User).CreatedAt })},
+			{Name: "updatedAt", Type: "String", Resolve: attr(func(p interface{}) interface{} { return &p.(*models.User).UpdatedAt })},
+		},
+	},
+)
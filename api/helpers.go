@@ -2,9 +2,8 @@ package api
 
 import "github.com/thermokarst/bactdb/types"
 
+// newJSONError wraps err in the JSON error envelope. status is only a
+// fallback, for errors that don't carry one of their own.
 func newJSONError(err error, status int) *types.AppError {
-	return &types.AppError{
-		Error:  types.ErrorJSON{Err: err},
-		Status: status,
-	}
+	return types.NewAppError(err, status)
 }
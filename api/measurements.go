@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/url"
@@ -13,7 +14,9 @@ import (
 )
 
 // MeasurementService provides for CRUD operations.
-type MeasurementService struct{}
+type MeasurementService struct {
+	*App
+}
 
 // Unmarshal satisfies interface Updater and interface Creater.
 func (m MeasurementService) Unmarshal(b []byte) (types.Entity, error) {
@@ -23,52 +26,80 @@ func (m MeasurementService) Unmarshal(b []byte) (types.Entity, error) {
 }
 
 // List lists all measurements.
-func (m MeasurementService) List(val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+func (m MeasurementService) List(ctx context.Context, val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := m.Store.WithContext(ctx)
 	if val == nil {
-		return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusInternalServerError)
+		return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
 	}
+	payloadOpt := helpers.PayloadOptionsFromValues(val)
 	var opt helpers.MeasurementListOptions
 	if err := helpers.SchemaDecoder.Decode(&opt, *val); err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+		return nil, newJSONError(err, http.StatusBadRequest)
 	}
 
-	measurements, err := models.ListMeasurements(opt, claims)
+	measurements, err := store.ListMeasurements(opt, claims)
 	if err != nil {
 		return nil, newJSONError(err, http.StatusInternalServerError)
 	}
 
-	charOpts, err := models.CharacteristicOptsFromMeasurements(opt)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+	payload := payloads.Measurements{
+		Measurements: measurements,
 	}
 
-	characteristics, err := models.ListCharacteristics(*charOpts, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
-	}
+	if payloadOpt.Includes("characteristics") {
+		charOpts, err := models.CharacteristicOptsFromMeasurements(opt)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
 
-	strainOpts, err := models.StrainOptsFromMeasurements(opt)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+		characteristics, err := store.ListCharacteristics(*charOpts, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+		payload.Characteristics = characteristics
 	}
 
-	strains, err := models.ListStrains(*strainOpts, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
-	}
+	if payloadOpt.Includes("strains") {
+		strainOpts, err := models.StrainOptsFromMeasurements(opt)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
 
-	payload := payloads.Measurements{
-		Characteristics: characteristics,
-		Strains:         strains,
-		Measurements:    measurements,
+		strains, err := store.ListStrains(*strainOpts, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+		payload.Strains = strains
 	}
 
 	return &payload, nil
 }
 
+// Stream streams all measurements
+func (m MeasurementService) Stream(ctx context.Context, val *url.Values, claims *types.Claims, fn func(types.Entity) error) *types.AppError {
+	store := m.Store.WithContext(ctx)
+	if val == nil {
+		return newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
+	}
+	helpers.PayloadOptionsFromValues(val)
+	var opt helpers.MeasurementListOptions
+	if err := helpers.SchemaDecoder.Decode(&opt, *val); err != nil {
+		return newJSONError(err, http.StatusBadRequest)
+	}
+
+	err := store.StreamMeasurements(opt, claims, func(measurement *models.Measurement) error {
+		return fn(&payloads.Measurement{Measurement: measurement})
+	})
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
 // Get retrieves a single measurement.
-func (m MeasurementService) Get(id int64, genus string, claims *types.Claims) (types.Entity, *types.AppError) {
-	measurement, err := models.GetMeasurement(id, genus, claims)
+func (m MeasurementService) Get(ctx context.Context, id int64, genus string, payloadOpt helpers.PayloadOptions, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := m.Store.WithContext(ctx)
+	measurement, err := store.GetMeasurement(id, genus, claims)
 	if err != nil {
 		return nil, newJSONError(err, http.StatusInternalServerError)
 	}
@@ -81,43 +112,32 @@ func (m MeasurementService) Get(id int64, genus string, claims *types.Claims) (t
 }
 
 // Update modifies a single measurement.
-func (m MeasurementService) Update(id int64, e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+func (m MeasurementService) Update(ctx context.Context, id int64, e *types.Entity, genus string, p types.Precondition, claims *types.Claims) *types.AppError {
+	store := m.Store.WithContext(ctx)
 	payload := (*e).(*payloads.Measurement)
 	payload.Measurement.UpdatedBy = claims.Sub
 	payload.Measurement.ID = id
 
-	if payload.Measurement.TextMeasurementType.Valid {
-		id, err := models.GetTextMeasurementTypeID(payload.Measurement.TextMeasurementType.String)
-		if err != nil {
-			return newJSONError(err, http.StatusInternalServerError)
-		}
-		payload.Measurement.TextMeasurementTypeID.Int64 = id
-		payload.Measurement.TextMeasurementTypeID.Valid = true
-	}
-
-	if err := models.Update(payload.Measurement.MeasurementBase); err != nil {
-		if err == errors.ErrMeasurementNotUpdated {
-			return newJSONError(err, http.StatusBadRequest)
-		}
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
-		}
+	if err := store.UpdateMeasurement(payload.Measurement, p); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	measurement, err := models.GetMeasurement(id, genus, claims)
+	measurement, err := store.GetMeasurement(id, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
 	payload.Measurement = measurement
 
+	m.notifyWebhooks(ctx, genus, "measurements", "update", id, payload, claims)
+
 	return nil
 }
 
 // Delete deletes a single measurement.
-func (m MeasurementService) Delete(id int64, genus string, claims *types.Claims) *types.AppError {
-	measurement, err := models.GetMeasurement(id, genus, claims)
+func (m MeasurementService) Delete(ctx context.Context, id int64, genus string, p types.Precondition, claims *types.Claims) *types.AppError {
+	store := m.Store.WithContext(ctx)
+	measurement, err := store.GetMeasurement(id, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
@@ -126,32 +146,34 @@ func (m MeasurementService) Delete(id int64, genus string, claims *types.Claims)
 		return newJSONError(errors.ErrMeasurementNotDeleted, http.StatusForbidden)
 	}
 
-	if err := models.Delete(measurement.MeasurementBase); err != nil {
+	if err := store.DeleteMeasurement(measurement.MeasurementBase, claims.Sub, p); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
+	m.notifyWebhooks(ctx, genus, "measurements", "delete", id, nil, claims)
+
 	return nil
 }
 
 // Create initializes a new measurement.
-func (m MeasurementService) Create(e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+func (m MeasurementService) Create(ctx context.Context, e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+	store := m.Store.WithContext(ctx)
 	payload := (*e).(*payloads.Measurement)
 	payload.Measurement.CreatedBy = claims.Sub
 	payload.Measurement.UpdatedBy = claims.Sub
 
-	if err := models.Create(payload.Measurement.MeasurementBase); err != nil {
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
-		}
+	if err := store.CreateMeasurement(payload.Measurement.MeasurementBase); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	measurement, err := models.GetMeasurement(payload.Measurement.ID, genus, claims)
+	measurement, err := store.GetMeasurement(payload.Measurement.ID, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
 	payload.Measurement = measurement
 
+	m.notifyWebhooks(ctx, genus, "measurements", "create", payload.Measurement.ID, payload, claims)
+
 	return nil
 }
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/payloads"
+	"github.com/thermokarst/bactdb/rpc"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// NewRPCServer creates the gRPC service, over the same services as the REST
+// API. Calls have to be authenticated, so that there are claims.
+func (a *App) NewRPCServer() *rpc.Server {
+	s := rpc.NewServer("bactdb.Bactdb")
+
+	s.Unary("ListStrains", newListRequest, func(r *http.Request, req rpc.Unmarshaler) (rpc.Message, error) {
+		strains, err := a.listStrains(r, req.(*rpc.ListRequest))
+		if err != nil {
+			return nil, err
+		}
+		return &rpc.StrainList{Strains: strains}, nil
+	})
+	s.Unary("GetStrain", newGetRequest, func(r *http.Request, req rpc.Unmarshaler) (rpc.Message, error) {
+		get := req.(*rpc.GetRequest)
+		claims := helpers.GetClaims(r)
+		entity, appErr := StrainService{a}.Get(r.Context(), get.ID, get.Genus, noIncludes, &claims)
+		if appErr != nil {
+			return nil, rpcError(appErr)
+		}
+		return rpcStrain(entity.(*payloads.Strain).Strain), nil
+	})
+	s.Stream("StreamStrains", newListRequest, func(r *http.Request, req rpc.Unmarshaler, send func(rpc.Message) error) error {
+		strains, err := a.listStrains(r, req.(*rpc.ListRequest))
+		if err != nil {
+			return err
+		}
+		for _, strain := range strains {
+			if err := send(strain); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	s.Unary("ListMeasurements", newMeasurementListRequest, func(r *http.Request, req rpc.Unmarshaler) (rpc.Message, error) {
+		measurements, err := a.listMeasurements(r, req.(*rpc.MeasurementListRequest))
+		if err != nil {
+			return nil, err
+		}
+		return &rpc.MeasurementList{Measurements: measurements}, nil
+	})
+	s.Unary("GetMeasurement", newGetRequest, func(r *http.Request, req rpc.Unmarshaler) (rpc.Message, error) {
+		get := req.(*rpc.GetRequest)
+		claims := helpers.GetClaims(r)
+		entity, appErr := MeasurementService{a}.Get(r.Context(), get.ID, get.Genus, noIncludes, &claims)
+		if appErr != nil {
+			return nil, rpcError(appErr)
+		}
+		return rpcMeasurement(entity.(*payloads.Measurement).Measurement), nil
+	})
+	s.Stream("StreamMeasurements", newMeasurementListRequest, func(r *http.Request, req rpc.Unmarshaler, send func(rpc.Message) error) error {
+		measurements, err := a.listMeasurements(r, req.(*rpc.MeasurementListRequest))
+		if err != nil {
+			return err
+		}
+		for _, m := range measurements {
+			if err := send(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	s.Unary("Compare", newCompareRequest, func(r *http.Request, req rpc.Unmarshaler) (rpc.Message, error) {
+		compare := req.(*rpc.CompareRequest)
+		rows, err := a.compareRows(r, compare)
+		if err != nil {
+			return nil, err
+		}
+		return &rpc.CompareMatrix{StrainIDs: compare.StrainIDs, Rows: rows}, nil
+	})
+	s.Stream("StreamCompare", newCompareRequest, func(r *http.Request, req rpc.Unmarshaler, send func(rpc.Message) error) error {
+		rows, err := a.compareRows(r, req.(*rpc.CompareRequest))
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := send(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return s
+}
+
+// noIncludes gets an entity without sideloading anything.
+var noIncludes = helpers.PayloadOptions{Include: []string{}}
+
+func newListRequest() rpc.Unmarshaler            { return &rpc.ListRequest{} }
+func newGetRequest() rpc.Unmarshaler             { return &rpc.GetRequest{} }
+func newMeasurementListRequest() rpc.Unmarshaler { return &rpc.MeasurementListRequest{} }
+func newCompareRequest() rpc.Unmarshaler         { return &rpc.CompareRequest{} }
+
+// rpcError translates an error for the REST API into a gRPC status.
+func rpcError(appErr *types.AppError) error {
+	message := appErr.Error.Error()
+	if ej, ok := appErr.Error.(types.ErrorJSON); ok {
+		message = ej.Err.Error()
+	}
+	return rpc.Errorf(rpc.CodeFromHTTPStatus(appErr.Status), "%s", message)
+}
+
+// listValues are the query string a list request would have had.
+func listValues(genus string, ids []int64) url.Values {
+	val := url.Values{"Genus": {genus}}
+	for _, id := range ids {
+		val.Add("ids[]", strconv.FormatInt(id, 10))
+	}
+	return val
+}
+
+func (a *App) listStrains(r *http.Request, req *rpc.ListRequest) ([]*rpc.Strain, error) {
+	claims := helpers.GetClaims(r)
+	val := listValues(req.Genus, req.IDs)
+	val.Set("include", "")
+	entity, appErr := StrainService{a}.List(r.Context(), &val, &claims)
+	if appErr != nil {
+		return nil, rpcError(appErr)
+	}
+
+	var strains []*rpc.Strain
+	for _, s := range *entity.(*payloads.Strains).Strains {
+		strains = append(strains, rpcStrain(s))
+	}
+	return strains, nil
+}
+
+func (a *App) listMeasurements(r *http.Request, req *rpc.MeasurementListRequest) ([]*rpc.Measurement, error) {
+	claims := helpers.GetClaims(r)
+	val := listValues(req.Genus, req.IDs)
+	val.Set("include", "")
+	if len(req.StrainIDs) > 0 {
+		val.Set("strain_ids", joinIDs(req.StrainIDs))
+	}
+	if len(req.CharacteristicIDs) > 0 {
+		val.Set("characteristic_ids", joinIDs(req.CharacteristicIDs))
+	}
+	entity, appErr := MeasurementService{a}.List(r.Context(), &val, &claims)
+	if appErr != nil {
+		return nil, rpcError(appErr)
+	}
+
+	var measurements []*rpc.Measurement
+	for _, m := range *entity.(*payloads.Measurements).Measurements {
+		measurements = append(measurements, rpcMeasurement(m))
+	}
+	return measurements, nil
+}
+
+func (a *App) compareRows(r *http.Request, req *rpc.CompareRequest) ([]*rpc.CompareRow, error) {
+	claims := helpers.GetClaims(r)
+	val := url.Values{"Genus": {req.Genus}}
+	val.Set("strain_ids", joinIDs(req.StrainIDs))
+	val.Set("characteristic_ids", joinIDs(req.CharacteristicIDs))
+	matrix, _, appErr := a.CompareMatrix(r.Context(), val, &claims)
+	if appErr != nil {
+		return nil, rpcError(appErr)
+	}
+
+	var rows []*rpc.CompareRow
+	for _, row := range matrix {
+		id, _ := strconv.ParseInt(row[0], 10, 64)
+		rows = append(rows, &rpc.CompareRow{CharacteristicID: id, Values: row[1:]})
+	}
+	return rows, nil
+}
+
+func joinIDs(ids []int64) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(s, ",")
+}
+
+func rpcStrain(s *models.Strain) *rpc.Strain {
+	return &rpc.Strain{
+		ID:                  s.ID,
+		SpeciesID:           s.SpeciesID,
+		StrainName:          s.StrainName,
+		TypeStrain:          s.TypeStrain,
+		AccessionNumbers:    s.AccessionNumbers.String,
+		Genbank:             s.Genbank.String,
+		WholeGenomeSequence: s.WholeGenomeSequence.String,
+		IsolatedFrom:        s.IsolatedFrom.String,
+		Notes:               s.Notes.String,
+		MeasurementIDs:      s.Measurements,
+		CharacteristicIDs:   s.Characteristics,
+		TotalMeasurements:   s.TotalMeasurements,
+		CanEdit:             s.CanEdit,
+		CreatedAt:           rpcTime(s.CreatedAt),
+		UpdatedAt:           rpcTime(s.UpdatedAt),
+		CreatedBy:           s.CreatedBy,
+		UpdatedBy:           s.UpdatedBy,
+	}
+}
+
+func rpcMeasurement(m *models.Measurement) *rpc.Measurement {
+	return &rpc.Measurement{
+		ID:                 m.ID,
+		StrainID:           m.StrainID,
+		CharacteristicID:   m.CharacteristicID,
+		Value:              m.Value(),
+		ConfidenceInterval: m.ConfidenceInterval.Float64,
+		UnitType:           m.UnitType.String,
+		TestMethod:         m.TestMethod.String,
+		Notes:              m.Notes.String,
+		CanEdit:            m.CanEdit,
+		CreatedAt:          rpcTime(m.CreatedAt),
+		UpdatedAt:          rpcTime(m.UpdatedAt),
+		CreatedBy:          m.CreatedBy,
+		UpdatedBy:          m.UpdatedBy,
+	}
+}
+
+func rpcTime(t types.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.UTC().Format(time.RFC3339Nano)
+}
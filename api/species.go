@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/url"
@@ -13,7 +14,9 @@ import (
 )
 
 // SpeciesService provides for CRUD operations
-type SpeciesService struct{}
+type SpeciesService struct {
+	*App
+}
 
 // Unmarshal satisfies interface Updater and interface Creater
 func (s SpeciesService) Unmarshal(b []byte) (types.Entity, error) {
@@ -23,87 +26,110 @@ func (s SpeciesService) Unmarshal(b []byte) (types.Entity, error) {
 }
 
 // List lists species
-func (s SpeciesService) List(val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+func (s SpeciesService) List(ctx context.Context, val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := s.Store.WithContext(ctx)
 	if val == nil {
-		return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusInternalServerError)
+		return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
 	}
+	payloadOpt := helpers.PayloadOptionsFromValues(val)
 	var opt helpers.ListOptions
 	if err := helpers.SchemaDecoder.Decode(&opt, *val); err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+		return nil, newJSONError(err, http.StatusBadRequest)
 	}
 
-	species, err := models.ListSpecies(opt, claims)
+	species, err := store.ListSpecies(opt, claims)
 	if err != nil {
 		return nil, newJSONError(err, http.StatusInternalServerError)
 	}
 
-	strainsOpt, err := models.StrainOptsFromSpecies(opt)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+	payload := payloads.ManySpecies{
+		Species: species,
 	}
 
-	strains, err := models.ListStrains(*strainsOpt, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
-	}
+	if payloadOpt.Includes("strains") {
+		strainsOpt, err := store.StrainOptsFromSpecies(opt)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
 
-	payload := payloads.ManySpecies{
-		Species: species,
-		Strains: strains,
+		strains, err := store.ListStrains(*strainsOpt, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+		payload.Strains = strains
 	}
 
 	return &payload, nil
 }
 
-// Get retrieves a single species
-func (s SpeciesService) Get(id int64, genus string, claims *types.Claims) (types.Entity, *types.AppError) {
-	species, err := models.GetSpecies(id, genus, claims)
+// Stream streams all species
+func (s SpeciesService) Stream(ctx context.Context, val *url.Values, claims *types.Claims, fn func(types.Entity) error) *types.AppError {
+	store := s.Store.WithContext(ctx)
+	if val == nil {
+		return newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
+	}
+	helpers.PayloadOptionsFromValues(val)
+	var opt helpers.ListOptions
+	if err := helpers.SchemaDecoder.Decode(&opt, *val); err != nil {
+		return newJSONError(err, http.StatusBadRequest)
+	}
+
+	err := store.StreamSpecies(opt, claims, func(species *models.Species) error {
+		return fn(&payloads.Species{Species: species})
+	})
 	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+		return newJSONError(err, http.StatusInternalServerError)
 	}
+	return nil
+}
 
-	strains, err := models.StrainsFromSpeciesID(id, genus, claims)
+// Get retrieves a single species
+func (s SpeciesService) Get(ctx context.Context, id int64, genus string, payloadOpt helpers.PayloadOptions, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := s.Store.WithContext(ctx)
+	species, err := store.GetSpecies(id, genus, claims)
 	if err != nil {
 		return nil, newJSONError(err, http.StatusInternalServerError)
 	}
 
 	payload := payloads.Species{
 		Species: species,
-		Strains: strains,
+	}
+
+	if payloadOpt.Includes("strains") {
+		strains, err := store.StrainsFromSpeciesID(id, genus, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+		payload.Strains = strains
 	}
 
 	return &payload, nil
 }
 
 // Update modifies an existing species
-func (s SpeciesService) Update(id int64, e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+func (s SpeciesService) Update(ctx context.Context, id int64, e *types.Entity, genus string, p types.Precondition, claims *types.Claims) *types.AppError {
+	store := s.Store.WithContext(ctx)
 	payload := (*e).(*payloads.Species)
 	payload.Species.UpdatedBy = claims.Sub
 	payload.Species.ID = id
 
-	genusID, err := models.GenusIDFromName(genus)
+	genusID, err := store.GenusIDFromName(genus)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 	payload.Species.SpeciesBase.GenusID = genusID
 
-	if err := models.Update(payload.Species.SpeciesBase); err != nil {
-		if err == errors.ErrSpeciesNotUpdated {
-			return newJSONError(err, http.StatusBadRequest)
-		}
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
-		}
+	if err := store.UpdateSpecies(payload.Species.SpeciesBase, p); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
 	// Reload to send back down the wire
-	species, err := models.GetSpecies(id, genus, claims)
+	species, err := store.GetSpecies(id, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	strains, err := models.StrainsFromSpeciesID(id, genus, claims)
+	strains, err := store.StrainsFromSpeciesID(id, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
@@ -111,30 +137,30 @@ func (s SpeciesService) Update(id int64, e *types.Entity, genus string, claims *
 	payload.Species = species
 	payload.Strains = strains
 
+	s.notifyWebhooks(ctx, genus, "species", "update", id, payload, claims)
+
 	return nil
 }
 
 // Create initializes a new species
-func (s SpeciesService) Create(e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+func (s SpeciesService) Create(ctx context.Context, e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+	store := s.Store.WithContext(ctx)
 	payload := (*e).(*payloads.Species)
 	payload.Species.CreatedBy = claims.Sub
 	payload.Species.UpdatedBy = claims.Sub
 
-	genusID, err := models.GenusIDFromName(genus)
+	genusID, err := store.GenusIDFromName(genus)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 	payload.Species.SpeciesBase.GenusID = genusID
 
-	if err := models.Create(payload.Species.SpeciesBase); err != nil {
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
-		}
+	if err := store.CreateSpecies(payload.Species.SpeciesBase); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
 	// Reload to send back down the wire
-	species, err := models.GetSpecies(payload.Species.ID, genus, claims)
+	species, err := store.GetSpecies(payload.Species.ID, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
@@ -142,12 +168,16 @@ func (s SpeciesService) Create(e *types.Entity, genus string, claims *types.Clai
 	// Note, no strains when new species
 
 	payload.Species = species
+
+	s.notifyWebhooks(ctx, genus, "species", "create", payload.Species.ID, payload, claims)
+
 	return nil
 }
 
 // Delete deletes a single species
-func (s SpeciesService) Delete(id int64, genus string, claims *types.Claims) *types.AppError {
-	species, err := models.GetSpecies(id, genus, claims)
+func (s SpeciesService) Delete(ctx context.Context, id int64, genus string, p types.Precondition, claims *types.Claims) *types.AppError {
+	store := s.Store.WithContext(ctx)
+	species, err := store.GetSpecies(id, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
@@ -156,9 +186,11 @@ func (s SpeciesService) Delete(id int64, genus string, claims *types.Claims) *ty
 		return newJSONError(errors.ErrSpeciesNotDeleted, http.StatusForbidden)
 	}
 
-	if err := models.Delete(species.SpeciesBase); err != nil {
+	if err := store.DeleteSpecies(species.SpeciesBase, claims.Sub, p); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
+	s.notifyWebhooks(ctx, genus, "species", "delete", id, nil, claims)
+
 	return nil
 }
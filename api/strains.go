@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/url"
@@ -13,7 +14,9 @@ import (
 )
 
 // StrainService provides for CRUD operations
-type StrainService struct{}
+type StrainService struct {
+	*App
+}
 
 // Unmarshal satisfies interface Updater and interface Creater
 func (s StrainService) Unmarshal(b []byte) (types.Entity, error) {
@@ -23,148 +26,179 @@ func (s StrainService) Unmarshal(b []byte) (types.Entity, error) {
 }
 
 // List lists all strains
-func (s StrainService) List(val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+func (s StrainService) List(ctx context.Context, val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := s.Store.WithContext(ctx)
 	if val == nil {
-		return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusInternalServerError)
+		return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
 	}
+	payloadOpt := helpers.PayloadOptionsFromValues(val)
 	var opt helpers.ListOptions
 	if err := helpers.SchemaDecoder.Decode(&opt, *val); err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+		return nil, newJSONError(err, http.StatusBadRequest)
 	}
 
-	strains, err := models.ListStrains(opt, claims)
+	strains, err := store.ListStrains(opt, claims)
 	if err != nil {
 		return nil, newJSONError(err, http.StatusInternalServerError)
 	}
 
-	speciesOpt, err := models.SpeciesOptsFromStrains(opt)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
-	}
-
-	species, err := models.ListSpecies(*speciesOpt, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+	payload := payloads.Strains{
+		Strains: strains,
 	}
 
-	characteristicsOpt, err := models.CharacteristicsOptsFromStrains(opt)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
-	}
+	if payloadOpt.Includes("species") {
+		speciesOpt, err := store.SpeciesOptsFromStrains(opt)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
 
-	characteristics, err := models.ListCharacteristics(*characteristicsOpt, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+		species, err := store.ListSpecies(*speciesOpt, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+		payload.Species = species
 	}
 
 	characteristicIDs := []int64{}
-	for _, c := range *characteristics {
-		characteristicIDs = append(characteristicIDs, c.ID)
-	}
+	if payloadOpt.Includes("characteristics") {
+		characteristicsOpt, err := store.CharacteristicsOptsFromStrains(opt)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
 
-	strainIDs := []int64{}
-	for _, s := range *strains {
-		strainIDs = append(strainIDs, s.ID)
-	}
+		characteristics, err := store.ListCharacteristics(*characteristicsOpt, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
 
-	measurementOpt := helpers.MeasurementListOptions{
-		ListOptions: helpers.ListOptions{
-			Genus: opt.Genus,
-		},
-		Strains:         strainIDs,
-		Characteristics: characteristicIDs,
+		for _, c := range *characteristics {
+			characteristicIDs = append(characteristicIDs, c.ID)
+		}
+		payload.Characteristics = characteristics
 	}
 
-	measurements, err := models.ListMeasurements(measurementOpt, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
-	}
+	if payloadOpt.Includes("measurements") {
+		strainIDs := []int64{}
+		for _, s := range *strains {
+			strainIDs = append(strainIDs, s.ID)
+		}
 
-	payload := payloads.Strains{
-		Strains:         strains,
-		Species:         species,
-		Measurements:    measurements,
-		Characteristics: characteristics,
+		measurementOpt := helpers.MeasurementListOptions{
+			ListOptions: helpers.ListOptions{
+				Genus: opt.Genus,
+			},
+			Strains:         strainIDs,
+			Characteristics: characteristicIDs,
+		}
+
+		measurements, err := store.ListMeasurements(measurementOpt, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+		payload.Measurements = measurements
 	}
 
 	return &payload, nil
 }
 
-// Get retrieves a single strain
-func (s StrainService) Get(id int64, genus string, claims *types.Claims) (types.Entity, *types.AppError) {
-	strain, err := models.GetStrain(id, genus, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+// Stream streams all strains
+func (s StrainService) Stream(ctx context.Context, val *url.Values, claims *types.Claims, fn func(types.Entity) error) *types.AppError {
+	store := s.Store.WithContext(ctx)
+	if val == nil {
+		return newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
 	}
-
-	species, err := models.GetSpecies(strain.SpeciesID, genus, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+	helpers.PayloadOptionsFromValues(val)
+	var opt helpers.ListOptions
+	if err := helpers.SchemaDecoder.Decode(&opt, *val); err != nil {
+		return newJSONError(err, http.StatusBadRequest)
 	}
 
-	opt := helpers.ListOptions{Genus: genus, IDs: []int64{id}}
-	characteristicsOpt, err := models.CharacteristicsOptsFromStrains(opt)
+	err := store.StreamStrains(opt, claims, func(strain *models.Strain) error {
+		return fn(&payloads.Strain{Strain: strain})
+	})
 	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+		return newJSONError(err, http.StatusInternalServerError)
 	}
+	return nil
+}
 
-	characteristics, err := models.ListCharacteristics(*characteristicsOpt, claims)
+// Get retrieves a single strain
+func (s StrainService) Get(ctx context.Context, id int64, genus string, payloadOpt helpers.PayloadOptions, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := s.Store.WithContext(ctx)
+	strain, err := store.GetStrain(id, genus, claims)
 	if err != nil {
 		return nil, newJSONError(err, http.StatusInternalServerError)
 	}
 
-	characteristicIDs := []int64{}
-	for _, c := range *characteristics {
-		characteristicIDs = append(characteristicIDs, c.ID)
+	payload := payloads.Strain{
+		Strain: strain,
 	}
 
-	measurementOpt := helpers.MeasurementListOptions{
-		ListOptions: helpers.ListOptions{
-			Genus: genus,
-		},
-		Strains:         []int64{id},
-		Characteristics: characteristicIDs,
+	if payloadOpt.Includes("species") {
+		species, err := store.GetSpecies(strain.SpeciesID, genus, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+
+		var manySpecies models.ManySpecies = []*models.Species{species}
+		payload.Species = &manySpecies
 	}
 
-	measurements, err := models.ListMeasurements(measurementOpt, claims)
-	if err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+	characteristicIDs := []int64{}
+	if payloadOpt.Includes("characteristics") {
+		opt := helpers.ListOptions{Genus: genus, IDs: []int64{id}}
+		characteristicsOpt, err := store.CharacteristicsOptsFromStrains(opt)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+
+		characteristics, err := store.ListCharacteristics(*characteristicsOpt, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+
+		for _, c := range *characteristics {
+			characteristicIDs = append(characteristicIDs, c.ID)
+		}
+		payload.Characteristics = characteristics
 	}
 
-	var manySpecies models.ManySpecies = []*models.Species{species}
+	if payloadOpt.Includes("measurements") {
+		measurementOpt := helpers.MeasurementListOptions{
+			ListOptions: helpers.ListOptions{
+				Genus: genus,
+			},
+			Strains:         []int64{id},
+			Characteristics: characteristicIDs,
+		}
 
-	payload := payloads.Strain{
-		Strain:          strain,
-		Species:         &manySpecies,
-		Characteristics: characteristics,
-		Measurements:    measurements,
+		measurements, err := store.ListMeasurements(measurementOpt, claims)
+		if err != nil {
+			return nil, newJSONError(err, http.StatusInternalServerError)
+		}
+		payload.Measurements = measurements
 	}
 
 	return &payload, nil
 }
 
 // Update modifies an existing strain
-func (s StrainService) Update(id int64, e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+func (s StrainService) Update(ctx context.Context, id int64, e *types.Entity, genus string, p types.Precondition, claims *types.Claims) *types.AppError {
+	store := s.Store.WithContext(ctx)
 	payload := (*e).(*payloads.Strain)
 	payload.Strain.UpdatedBy = claims.Sub
 	payload.Strain.ID = id
 
-	if err := models.Update(payload.Strain.StrainBase); err != nil {
-		if err == errors.ErrStrainNotUpdated {
-			return newJSONError(err, http.StatusBadRequest)
-		}
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
-		}
+	if err := store.UpdateStrain(payload.Strain.StrainBase, p); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	strain, err := models.GetStrain(id, genus, claims)
+	strain, err := store.GetStrain(id, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	species, err := models.GetSpecies(strain.SpeciesID, genus, claims)
+	species, err := store.GetSpecies(strain.SpeciesID, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
@@ -174,28 +208,28 @@ func (s StrainService) Update(id int64, e *types.Entity, genus string, claims *t
 	payload.Strain = strain
 	payload.Species = &manySpecies
 
+	s.notifyWebhooks(ctx, genus, "strains", "update", id, payload, claims)
+
 	return nil
 }
 
 // Create initializes a new strain
-func (s StrainService) Create(e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+func (s StrainService) Create(ctx context.Context, e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+	store := s.Store.WithContext(ctx)
 	payload := (*e).(*payloads.Strain)
 	payload.Strain.CreatedBy = claims.Sub
 	payload.Strain.UpdatedBy = claims.Sub
 
-	if err := models.Create(payload.Strain.StrainBase); err != nil {
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
-		}
+	if err := store.CreateStrain(payload.Strain.StrainBase); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	strain, err := models.GetStrain(payload.Strain.ID, genus, claims)
+	strain, err := store.GetStrain(payload.Strain.ID, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	species, err := models.GetSpecies(strain.SpeciesID, genus, claims)
+	species, err := store.GetSpecies(strain.SpeciesID, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
@@ -205,12 +239,15 @@ func (s StrainService) Create(e *types.Entity, genus string, claims *types.Claim
 	payload.Strain = strain
 	payload.Species = &manySpecies
 
+	s.notifyWebhooks(ctx, genus, "strains", "create", payload.Strain.ID, payload, claims)
+
 	return nil
 }
 
 // Delete deletes a single strain
-func (s StrainService) Delete(id int64, genus string, claims *types.Claims) *types.AppError {
-	strain, err := models.GetStrain(id, genus, claims)
+func (s StrainService) Delete(ctx context.Context, id int64, genus string, p types.Precondition, claims *types.Claims) *types.AppError {
+	store := s.Store.WithContext(ctx)
+	strain, err := store.GetStrain(id, genus, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
@@ -219,9 +256,11 @@ func (s StrainService) Delete(id int64, genus string, claims *types.Claims) *typ
 		return newJSONError(errors.ErrStrainNotDeleted, http.StatusForbidden)
 	}
 
-	if err := models.Delete(strain.StrainBase); err != nil {
+	if err := store.DeleteStrain(strain.StrainBase, claims.Sub, p); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
+	s.notifyWebhooks(ctx, genus, "strains", "delete", id, nil, claims)
+
 	return nil
 }
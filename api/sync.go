@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/thermokarst/bactdb/errors"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/payloads"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// syncOverlap is how far back from when it was issued a cursor goes. Writes
+// are stamped when they're made, not when they're committed, and by whichever
+// server made them, so a write can show up a little in the past. Clients get
+// those writes twice, which is fine, as long as they get them at all.
+const syncOverlap = time.Minute
+
+// syncBatch is how many entities are listed by ID at once.
+const syncBatch = 1000
+
+// HandleChanges is a HTTP handler for syncing a genus. Without a since
+// parameter, everything is sent.
+func (a *App) HandleChanges(w http.ResponseWriter, r *http.Request) *types.AppError {
+	var since time.Time
+	if cursor := r.FormValue("since"); cursor != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, cursor); err != nil {
+			return newJSONError(errors.ErrInvalidCursor, http.StatusBadRequest)
+		}
+	}
+
+	claims := helpers.GetClaims(r)
+	changes, appErr := a.ChangesSince(r.Context(), mux.Vars(r)["genus"], since, &claims)
+	if appErr != nil {
+		return appErr
+	}
+
+	data, err := changes.Marshal()
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+	w.Write(data)
+	return nil
+}
+
+// ChangesSince gathers everything in a genus written after since, along with
+// what's been deleted.
+func (a *App) ChangesSince(ctx context.Context, genus string, since time.Time, claims *types.Claims) (*payloads.Changes, *types.AppError) {
+	store := a.Store.WithContext(ctx)
+	if _, err := store.GetGenus(genus); err != nil {
+		return nil, newJSONError(err, http.StatusInternalServerError)
+	}
+
+	now := time.Now()
+	changes := payloads.Changes{
+		Cursor:          now.Add(-syncOverlap).UTC().Format(time.RFC3339Nano),
+		Species:         make(models.ManySpecies, 0),
+		Strains:         make(models.Strains, 0),
+		Characteristics: make(models.Characteristics, 0),
+		Measurements:    make(models.Measurements, 0),
+	}
+
+	err := changedSince(store, "species", genus, since, func(opt helpers.ListOptions) error {
+		species, err := store.ListSpecies(opt, claims)
+		if err == nil {
+			changes.Species = append(changes.Species, *species...)
+		}
+		return err
+	})
+	if err == nil {
+		err = changedSince(store, "strains", genus, since, func(opt helpers.ListOptions) error {
+			strains, err := store.ListStrains(opt, claims)
+			if err == nil {
+				changes.Strains = append(changes.Strains, *strains...)
+			}
+			return err
+		})
+	}
+	if err == nil {
+		err = changedSince(store, "characteristics", genus, since, func(opt helpers.ListOptions) error {
+			characteristics, err := store.ListCharacteristics(opt, claims)
+			if err == nil {
+				changes.Characteristics = append(changes.Characteristics, *characteristics...)
+			}
+			return err
+		})
+	}
+	if err == nil {
+		err = changedSince(store, "measurements", genus, since, func(opt helpers.ListOptions) error {
+			measurements, err := store.ListMeasurements(helpers.MeasurementListOptions{ListOptions: opt}, claims)
+			if err == nil {
+				changes.Measurements = append(changes.Measurements, *measurements...)
+			}
+			return err
+		})
+	}
+	if err == nil {
+		changes.Deleted, err = store.TombstonesSince(genus, since)
+	}
+	if err != nil {
+		return nil, newJSONError(err, http.StatusInternalServerError)
+	}
+
+	return &changes, nil
+}
+
+// changedSince lists an entity type's changes with list, a batch of IDs at a
+// time. Since the beginning of time is everything, which is one list.
+func changedSince(store models.Repository, entity, genus string, since time.Time, list func(helpers.ListOptions) error) error {
+	opt := helpers.ListOptions{Genus: genus}
+	if since.IsZero() {
+		return list(opt)
+	}
+
+	ids, err := store.ChangedIDs(entity, genus, since)
+	if err != nil {
+		return err
+	}
+	for len(ids) > 0 {
+		n := syncBatch
+		if n > len(ids) {
+			n = len(ids)
+		}
+		opt.IDs = ids[:n]
+		if err := list(opt); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/payloads"
+)
+
+// sync runs ChangesSince as an admin, since the cursor when it's set.
+func (bt *batchTest) sync(cursor string) *payloads.Changes {
+	bt.t.Helper()
+	var since time.Time
+	if cursor != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, cursor); err != nil {
+			bt.t.Fatal(err)
+		}
+	}
+	changes, appErr := bt.svc.ChangesSince(context.Background(), "hymenobacter", since, bt.admin)
+	if appErr != nil {
+		bt.t.Fatalf("sync failed: %v", appErr.Error)
+	}
+	return changes
+}
+
+// addStrain makes another strain of the species the batch test's strain is.
+func (bt *batchTest) addStrain(name string) *models.StrainBase {
+	bt.t.Helper()
+	s, err := bt.svc.Store.GetStrain(bt.strain, "hymenobacter", bt.admin)
+	if err != nil {
+		bt.t.Fatal(err)
+	}
+	strain := &models.StrainBase{SpeciesID: s.SpeciesID, StrainName: name, CreatedBy: bt.admin.Sub}
+	if err := bt.svc.Store.CreateStrain(strain); err != nil {
+		bt.t.Fatal(err)
+	}
+	return strain
+}
+
+func hasStrain(changes *payloads.Changes, id int64) bool {
+	for _, s := range changes.Strains {
+		if s.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTombstone(changes *payloads.Changes, entity string, id int64) bool {
+	for _, t := range changes.Deleted {
+		if t.Entity == entity && t.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSyncCursor(t *testing.T) {
+	bt := newBatchTest(t)
+
+	// The first sync is everything, and the cursor goes back syncOverlap from
+	// when it was issued
+	before := time.Now()
+	changes := bt.sync("")
+	after := time.Now()
+	if len(changes.Species) != 1 || len(changes.Strains) != 1 || len(changes.Characteristics) != 1 || len(changes.Measurements) != 1 {
+		t.Errorf("first sync got %+v", changes)
+	}
+	cursor, err := time.Parse(time.RFC3339Nano, changes.Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor.Before(before.Add(-syncOverlap)) || cursor.After(after.Add(-syncOverlap)) || cursor.Location() != time.UTC {
+		t.Errorf("cursor %s isn't %s before %s", cursor, syncOverlap, before)
+	}
+
+	// Writes from inside the overlap come again, along with anything new
+	strain := bt.addStrain("AA-2")
+	next := bt.sync(changes.Cursor)
+	if !hasStrain(next, bt.strain) || !hasStrain(next, strain.ID) {
+		t.Errorf("sync from the cursor got %+v", next.Strains)
+	}
+
+	// Nothing's written after a cursor from the future
+	later := time.Now().Add(time.Second).UTC().Format(time.RFC3339Nano)
+	if none := bt.sync(later); len(none.Species)+len(none.Strains)+len(none.Characteristics)+len(none.Measurements)+len(none.Deleted) != 0 {
+		t.Errorf("sync from %s got %+v", later, none)
+	}
+}
+
+func TestSyncTombstones(t *testing.T) {
+	bt := newBatchTest(t)
+	strain := bt.addStrain("AA-2")
+
+	// Deleted just before the sync, so inside the overlap of its cursor
+	if err := bt.svc.Store.DeleteStrain(strain, bt.admin.Sub, nil); err != nil {
+		t.Fatal(err)
+	}
+	changes := bt.sync("")
+	if hasStrain(changes, strain.ID) || !hasTombstone(changes, "strains", strain.ID) {
+		t.Errorf("first sync got %+v, deleted %+v", changes.Strains, changes.Deleted)
+	}
+
+	next := bt.sync(changes.Cursor)
+	if hasStrain(next, strain.ID) || !hasTombstone(next, "strains", strain.ID) {
+		t.Errorf("sync from the cursor got %+v, deleted %+v", next.Strains, next.Deleted)
+	}
+}
+
+func TestSyncBatches(t *testing.T) {
+	bt := newBatchTest(t)
+	since := time.Now().Add(-time.Second)
+	for i := 1; i < syncBatch; i++ {
+		bt.addStrain(fmt.Sprintf("AA-%d", i+1))
+	}
+
+	// Exactly a batch, and then one over
+	for _, want := range [][]int{{syncBatch}, {syncBatch, 1}} {
+		var batches []int
+		err := changedSince(bt.svc.Store, "strains", "hymenobacter", since, func(opt helpers.ListOptions) error {
+			batches = append(batches, len(opt.IDs))
+			return nil
+		})
+		if err != nil || fmt.Sprint(batches) != fmt.Sprint(want) {
+			t.Errorf("got batches %v, %v, want %v", batches, err, want)
+		}
+		bt.addStrain("AA-extra")
+	}
+
+	changes := bt.sync(since.UTC().Format(time.RFC3339Nano))
+	if len(changes.Strains) != syncBatch+2 {
+		t.Errorf("got %d strains, want %d", len(changes.Strains), syncBatch+2)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,24 +10,35 @@ import (
 	"strconv"
 
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
-	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/lib/pq"
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/mailgun/mailgun-go"
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/golang.org/x/crypto/bcrypt"
-	"github.com/thermokarst/bactdb/auth"
 	"github.com/thermokarst/bactdb/errors"
 	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/metrics"
 	"github.com/thermokarst/bactdb/models"
 	"github.com/thermokarst/bactdb/payloads"
 	"github.com/thermokarst/bactdb/types"
 )
 
 var (
-	// MgAccts is a map of Mailgun accounts.
-	MgAccts = make(map[string]mailgun.Mailgun)
+	emailsSent = metrics.NewCounter("bactdb_emails_sent_total",
+		"Emails sent through Mailgun, by kind and result.", "kind", "result")
 )
 
+// sendEmail sends a message, counting it.
+func sendEmail(mg mailgun.Mailgun, kind string, m *mailgun.Message) error {
+	if _, _, err := mg.Send(m); err != nil {
+		emailsSent.Inc(kind, "failed")
+		return err
+	}
+	emailsSent.Inc(kind, "sent")
+	return nil
+}
+
 // UserService provides for CRUD operations.
-type UserService struct{}
+type UserService struct {
+	*App
+}
 
 // Unmarshal satisfies interface Updater and interface Creater.
 func (u UserService) Unmarshal(b []byte) (types.Entity, error) {
@@ -36,13 +48,16 @@ func (u UserService) Unmarshal(b []byte) (types.Entity, error) {
 }
 
 // List lists all users.
-func (u UserService) List(val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+func (u UserService) List(ctx context.Context, val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := u.Store.WithContext(ctx)
 	if val == nil {
-		return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusInternalServerError)
+		return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
 	}
+	// Users have no related entities to sideload
+	helpers.PayloadOptionsFromValues(val)
 	var opt helpers.ListOptions
 	if err := helpers.SchemaDecoder.Decode(&opt, *val); err != nil {
-		return nil, newJSONError(err, http.StatusInternalServerError)
+		return nil, newJSONError(err, http.StatusBadRequest)
 	}
 
 	// Only Admins can view all users
@@ -50,7 +65,7 @@ func (u UserService) List(val *url.Values, claims *types.Claims) (types.Entity,
 		return nil, newJSONError(errors.ErrUserForbidden, http.StatusForbidden)
 	}
 
-	users, err := models.ListUsers(opt, claims)
+	users, err := store.ListUsers(opt, claims)
 	if err != nil {
 		return nil, newJSONError(err, http.StatusInternalServerError)
 	}
@@ -61,18 +76,45 @@ func (u UserService) List(val *url.Values, claims *types.Claims) (types.Entity,
 	return &payload, nil
 }
 
+// Stream streams all users
+func (u UserService) Stream(ctx context.Context, val *url.Values, claims *types.Claims, fn func(types.Entity) error) *types.AppError {
+	store := u.Store.WithContext(ctx)
+	if val == nil {
+		return newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
+	}
+	helpers.PayloadOptionsFromValues(val)
+	var opt helpers.ListOptions
+	if err := helpers.SchemaDecoder.Decode(&opt, *val); err != nil {
+		return newJSONError(err, http.StatusBadRequest)
+	}
+
+	// Only Admins can view all users
+	if claims.Role != "A" {
+		return newJSONError(errors.ErrUserForbidden, http.StatusForbidden)
+	}
+
+	err := store.StreamUsers(opt, claims, func(user *models.User) error {
+		return fn(&payloads.User{User: user})
+	})
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+	return nil
+}
+
 // Get retrieves a single user.
-func (u UserService) Get(id int64, dummy string, claims *types.Claims) (types.Entity, *types.AppError) {
+func (u UserService) Get(ctx context.Context, id int64, dummy string, payloadOpt helpers.PayloadOptions, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := u.Store.WithContext(ctx)
 	// Only Admins can view any users, otherwise users are limited to themselves
 	if claims.Role != "A" && claims.Sub != id {
 		return nil, newJSONError(errors.ErrUserForbidden, http.StatusForbidden)
 	}
 
-	user, err := models.GetUser(id, dummy, claims)
-	user.Password = ""
+	user, err := store.GetUser(id, dummy, claims)
 	if err != nil {
 		return nil, newJSONError(err, http.StatusInternalServerError)
 	}
+	user.Password = ""
 
 	payload := payloads.User{
 		User: user,
@@ -81,7 +123,8 @@ func (u UserService) Get(id int64, dummy string, claims *types.Claims) (types.En
 }
 
 // Update modifies an existing user.
-func (u UserService) Update(id int64, e *types.Entity, dummy string, claims *types.Claims) *types.AppError {
+func (u UserService) Update(ctx context.Context, id int64, e *types.Entity, dummy string, p types.Precondition, claims *types.Claims) *types.AppError {
+	store := u.Store.WithContext(ctx)
 	// Only Admins can view any users, otherwise users are limited to themselves
 	if claims.Role != "A" && claims.Sub != id {
 		return newJSONError(errors.ErrUserForbidden, http.StatusForbidden)
@@ -89,7 +132,7 @@ func (u UserService) Update(id int64, e *types.Entity, dummy string, claims *typ
 
 	user := (*e).(*payloads.User).User
 
-	originalUser, err := models.GetUser(id, dummy, claims)
+	originalUser, err := store.GetUser(id, dummy, claims)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
@@ -99,13 +142,7 @@ func (u UserService) Update(id int64, e *types.Entity, dummy string, claims *typ
 	user.Verified = originalUser.Verified
 	user.UpdatedAt = helpers.CurrentTime()
 
-	if err := models.Update(user.UserBase); err != nil {
-		if err == errors.ErrUserNotUpdated {
-			return newJSONError(err, http.StatusBadRequest)
-		}
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
-		}
+	if err := store.UpdateUser(user.UserBase, p); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
@@ -115,7 +152,8 @@ func (u UserService) Update(id int64, e *types.Entity, dummy string, claims *typ
 }
 
 // Create initializes a new user.
-func (u UserService) Create(e *types.Entity, dummy string, claims *types.Claims) *types.AppError {
+func (u UserService) Create(ctx context.Context, e *types.Entity, dummy string, claims *types.Claims) *types.AppError {
+	store := u.Store.WithContext(ctx)
 	user := (*e).(*payloads.User).User
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
@@ -126,35 +164,19 @@ func (u UserService) Create(e *types.Entity, dummy string, claims *types.Claims)
 	user.Role = "R"
 	user.Verified = false
 
-	if err := models.Create(user.UserBase); err != nil {
-		if err, ok := err.(*pq.Error); ok {
-			if err.Code == "23505" {
-				return newJSONError(errors.ErrEmailAddressTaken, http.StatusInternalServerError)
-			}
-		}
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
-		}
-		return newJSONError(err, http.StatusInternalServerError)
-	}
-
-	user.Password = "password" // don't want to send the hashed PW back to the client
-
-	q := `INSERT INTO verification (user_id, nonce, referer, created_at) VALUES ($1, $2, $3, $4);`
 	// TODO: move helpers.GenerateNonce
 	nonce, err := helpers.GenerateNonce()
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
-	// TODO: fix this
-	_, err = models.DBH.Exec(q, user.ID, nonce, claims.Ref, helpers.CurrentTime())
-	if err != nil {
+
+	if err := store.CreateUser(user.UserBase, nonce, claims.Ref); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
-	// Send out confirmation email
+	// Send out confirmation email, once the account is committed
 	// TODO: clean this up
-	mg, ok := MgAccts[claims.Ref]
+	mg, ok := u.Mail[claims.Ref]
 	if ok {
 		sender := fmt.Sprintf("%s Admin <admin@%s>", mg.Domain(), mg.Domain())
 		recipient := fmt.Sprintf("%s <%s>", user.Name, user.Email)
@@ -165,68 +187,42 @@ func (u UserService) Create(e *types.Entity, dummy string, claims *types.Claims)
 			"did not request an account, please disregard this message.",
 			mg.Domain(), claims.Ref, nonce)
 		m := mailgun.NewMessage(sender, subject, message, recipient)
-		_, _, err := mg.Send(m)
-		if err != nil {
+		if err := sendEmail(mg, "verification", m); err != nil {
 			log.Printf("%+v\n", err)
+			// The account can't be verified, take it back so that the
+			// signup can be retried
+			if err := store.DeleteUnverifiedUser(user.ID); err != nil {
+				log.Printf("Removing unverified user %d: %v", user.ID, err)
+			}
 			return newJSONError(err, http.StatusInternalServerError)
 		}
 	}
 
+	user.Password = "password" // don't want to send the hashed PW back to the client
+
 	return nil
 }
 
 // HandleUserVerify is a HTTP handler for verifiying a user.
-func HandleUserVerify(w http.ResponseWriter, r *http.Request) *types.AppError {
-	// TODO: clean this up
+func (a *App) HandleUserVerify(w http.ResponseWriter, r *http.Request) *types.AppError {
 	nonce := mux.Vars(r)["Nonce"]
-	q := `SELECT user_id AS userid, referer FROM verification WHERE nonce=$1;`
-
-	var ver struct {
-		UserID  int64
-		Referer string
-	}
-	if err := models.DBH.SelectOne(&ver, q, nonce); err != nil {
-		log.Print(err)
-		return newJSONError(err, http.StatusInternalServerError)
-	}
-
-	if ver.UserID == 0 {
-		return newJSONError(errors.ErrUserNotFound, http.StatusInternalServerError)
-	}
-
-	var user models.UserBase
-	if err := models.DBH.Get(&user, ver.UserID); err != nil {
-		return newJSONError(err, http.StatusInternalServerError)
-	}
-
-	user.Verified = true
-
-	if err := models.Update(&user); err != nil {
-		if err == errors.ErrUserNotUpdated {
-			return newJSONError(err, http.StatusBadRequest)
-		}
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
+	if err := a.Store.WithContext(r.Context()).VerifyUser(nonce); err != nil {
+		if err != errors.ErrUserNotFound {
+			log.Print(err)
 		}
 		return newJSONError(err, http.StatusInternalServerError)
 	}
-
-	q = `DELETE FROM verification WHERE user_id=$1;`
-	_, err := models.DBH.Exec(q, user.ID)
-	if err != nil {
-		return newJSONError(err, http.StatusInternalServerError)
-	}
 	fmt.Fprintln(w, `{"msg":"All set! Please log in."}`)
 	return nil
 }
 
 // HandleUserLockout is a HTTP handler for unlocking a user's account.
-func HandleUserLockout(w http.ResponseWriter, r *http.Request) *types.AppError {
+func (a *App) HandleUserLockout(w http.ResponseWriter, r *http.Request) *types.AppError {
 	email := r.FormValue("email")
 	if email == "" {
-		return newJSONError(errors.ErrUserMissingEmail, http.StatusInternalServerError)
+		return newJSONError(errors.ErrUserMissingEmail, http.StatusBadRequest)
 	}
-	token, err := auth.Middleware.CreateToken(email)
+	token, err := a.Auth.CreateToken(email)
 	if err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
@@ -242,7 +238,7 @@ func HandleUserLockout(w http.ResponseWriter, r *http.Request) *types.AppError {
 
 	// Send out email
 	// TODO: clean this up
-	mg, ok := MgAccts[origin]
+	mg, ok := a.Mail[origin]
 	if ok {
 		sender := fmt.Sprintf("%s Admin <admin@%s>", mg.Domain(), mg.Domain())
 		recipient := fmt.Sprintf("%s", email)
@@ -253,8 +249,7 @@ func HandleUserLockout(w http.ResponseWriter, r *http.Request) *types.AppError {
 			"If you did not request help with a lockout, please disregard this message.",
 			mg.Domain(), hostURL.String())
 		m := mailgun.NewMessage(sender, subject, message, recipient)
-		_, _, err := mg.Send(m)
-		if err != nil {
+		if err := sendEmail(mg, "lockout", m); err != nil {
 			log.Printf("%+v\n", err)
 			return newJSONError(err, http.StatusInternalServerError)
 		}
@@ -264,11 +259,11 @@ func HandleUserLockout(w http.ResponseWriter, r *http.Request) *types.AppError {
 	return nil
 }
 
-func HandleUserPasswordChange(w http.ResponseWriter, r *http.Request) *types.AppError {
+func (a *App) HandleUserPasswordChange(w http.ResponseWriter, r *http.Request) *types.AppError {
 	claims := helpers.GetClaims(r)
 	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
 	if err != nil {
-		return newJSONError(err, http.StatusInternalServerError)
+		return newJSONError(errors.ErrInvalidID, http.StatusBadRequest)
 	}
 
 	// Only a user can change their own password
@@ -276,10 +271,7 @@ func HandleUserPasswordChange(w http.ResponseWriter, r *http.Request) *types.App
 		return newJSONError(errors.ErrUserForbidden, http.StatusForbidden)
 	}
 
-	if err := models.UpdateUserPassword(&claims, r.FormValue("password")); err != nil {
-		if err, ok := err.(types.ValidationError); ok {
-			return &types.AppError{Error: err, Status: helpers.StatusUnprocessableEntity}
-		}
+	if err := a.Store.WithContext(r.Context()).UpdateUserPassword(&claims, r.FormValue("password")); err != nil {
 		return newJSONError(err, http.StatusInternalServerError)
 	}
 
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/mailgun/mailgun-go"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/payloads"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// testMail is a Mailgun account that keeps what it sends, or fails to send
+// anything.
+type testMail struct {
+	mailgun.Mailgun
+	sent []*mailgun.Message
+	down bool
+}
+
+func (m *testMail) Domain() string { return "example.com" }
+
+func (m *testMail) Send(msg *mailgun.Message) (string, string, error) {
+	if m.down {
+		return "", "", fmt.Errorf("mail is down")
+	}
+	m.sent = append(m.sent, msg)
+	return "", "", nil
+}
+
+func TestUserSignupEmail(t *testing.T) {
+	mem := models.NewMemory()
+	app := NewApp(mem, nil)
+	mail := &testMail{down: true}
+	app.Mail["https://example.com"] = mail
+	claims := &types.Claims{Ref: "https://example.com"}
+
+	signup := func() *types.AppError {
+		var e types.Entity = &payloads.User{User: &models.User{UserBase: &models.UserBase{
+			Email: "new@example.com", Name: "New", Password: "password",
+		}}}
+		return UserService{app}.Create(context.Background(), &e, "", claims)
+	}
+
+	// Without the email, the account can't be verified, so it isn't kept, and
+	// the signup can be retried
+	if appErr := signup(); appErr == nil {
+		t.Fatal("signed up without sending the email")
+	}
+	mail.down = false
+	if appErr := signup(); appErr != nil {
+		t.Fatalf("retrying got %v", appErr.Error)
+	}
+	if len(mail.sent) != 1 {
+		t.Errorf("sent %d emails", len(mail.sent))
+	}
+
+	mail.down = true
+	if appErr := signup(); appErr == nil || appErr.Status != http.StatusConflict {
+		t.Errorf("signing up twice got %+v", appErr)
+	}
+}
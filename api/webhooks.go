@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/thermokarst/bactdb/errors"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/payloads"
+	"github.com/thermokarst/bactdb/types"
+	"github.com/thermokarst/bactdb/webhooks"
+)
+
+// recordDelivery adds an attempt to the delivery log.
+func (a *App) recordDelivery(attempt webhooks.Attempt) {
+	delivery := models.WebhookDelivery{
+		WebhookID:  attempt.HookID,
+		Delivery:   attempt.Delivery,
+		Event:      attempt.Event,
+		Attempt:    int64(attempt.Attempt),
+		DurationMS: attempt.Duration.Nanoseconds() / 1e6,
+		CreatedAt:  helpers.CurrentTime(),
+	}
+	if attempt.StatusCode != 0 {
+		delivery.StatusCode = types.NullInt64{NullInt64: sql.NullInt64{Int64: int64(attempt.StatusCode), Valid: true}}
+	}
+	if attempt.Err != nil {
+		delivery.Error = types.NullString{NullString: sql.NullString{String: attempt.Err.Error(), Valid: true}}
+	}
+	if err := a.Store.CreateWebhookDelivery(&delivery); err != nil {
+		log.Printf("Logging webhook delivery %s: %v", attempt.Delivery, err)
+	}
+}
+
+// notifyWebhooks tells a genus' webhooks about a successful write. e is what
+// was written, nil for a delete. The write has already happened, so anything
+// going wrong here is only logged.
+func (a *App) notifyWebhooks(ctx context.Context, genus, entity, action string, id int64, e types.Entity, claims *types.Claims) {
+	hooks, err := a.Store.WithContext(ctx).ListWebhooks(genus, true)
+	if err != nil {
+		log.Printf("Listing webhooks for %s: %v", genus, err)
+		return
+	}
+	if len(*hooks) == 0 {
+		return
+	}
+
+	event := webhooks.NewEvent(genus, entity, action, id, claims.Sub)
+	if e != nil {
+		if event.Data, err = e.Marshal(); err != nil {
+			log.Printf("Webhook event %s %d: %v", event.Event, id, err)
+			return
+		}
+	}
+
+	targets := make([]webhooks.Hook, len(*hooks))
+	for i, h := range *hooks {
+		targets[i] = webhooks.Hook{ID: h.ID, URL: h.URL, Secret: h.Secret, Events: h.Events}
+	}
+	if err := a.Webhooks.Dispatch(targets, event); err != nil {
+		log.Printf("Webhook event %s %d: %v", event.Event, id, err)
+	}
+}
+
+// WebhookService provides for CRUD operations. Only admins can manage
+// webhooks.
+type WebhookService struct {
+	*App
+}
+
+// Unmarshal satisfies interface Updater and interface Creater.
+func (s WebhookService) Unmarshal(b []byte) (types.Entity, error) {
+	var wj payloads.Webhook
+	err := json.Unmarshal(b, &wj)
+	return &wj, err
+}
+
+// List lists a genus' webhooks.
+func (s WebhookService) List(ctx context.Context, val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := s.Store.WithContext(ctx)
+	if val == nil {
+		return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
+	}
+	if claims.Role != "A" {
+		return nil, newJSONError(errors.ErrWebhookForbidden, http.StatusForbidden)
+	}
+
+	hooks, err := store.ListWebhooks(val.Get("Genus"), false)
+	if err != nil {
+		return nil, newJSONError(err, http.StatusInternalServerError)
+	}
+	for _, h := range *hooks {
+		h.Secret = ""
+	}
+
+	return &payloads.Webhooks{Webhooks: hooks}, nil
+}
+
+// Get retrieves a single webhook.
+func (s WebhookService) Get(ctx context.Context, id int64, genus string, payloadOpt helpers.PayloadOptions, claims *types.Claims) (types.Entity, *types.AppError) {
+	store := s.Store.WithContext(ctx)
+	if claims.Role != "A" {
+		return nil, newJSONError(errors.ErrWebhookForbidden, http.StatusForbidden)
+	}
+
+	hook, err := store.GetWebhook(id, genus)
+	if err != nil {
+		return nil, newJSONError(err, http.StatusInternalServerError)
+	}
+	hook.Secret = ""
+
+	return &payloads.Webhook{Webhook: hook}, nil
+}
+
+// Update modifies an existing webhook. The secret is kept unless a new one is
+// given.
+func (s WebhookService) Update(ctx context.Context, id int64, e *types.Entity, genus string, p types.Precondition, claims *types.Claims) *types.AppError {
+	store := s.Store.WithContext(ctx)
+	if claims.Role != "A" {
+		return newJSONError(errors.ErrWebhookForbidden, http.StatusForbidden)
+	}
+
+	payload := (*e).(*payloads.Webhook)
+	if payload.Webhook == nil || payload.Webhook.WebhookBase == nil {
+		return newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
+	}
+	original, err := store.GetWebhook(id, genus)
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	hook := payload.Webhook
+	hook.ID = id
+	hook.GenusID = original.GenusID
+	hook.CreatedAt = original.CreatedAt
+	hook.CreatedBy = original.CreatedBy
+	hook.UpdatedBy = claims.Sub
+	if hook.Secret == "" {
+		hook.Secret = original.Secret
+	}
+
+	if err := store.UpdateWebhook(hook.WebhookBase, p); err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	payload.Webhook, err = store.GetWebhook(id, genus)
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+	payload.Webhook.Secret = ""
+
+	return nil
+}
+
+// Create registers a new webhook. When no secret is given, one is made up, and
+// either way it's only ever sent back here.
+func (s WebhookService) Create(ctx context.Context, e *types.Entity, genus string, claims *types.Claims) *types.AppError {
+	store := s.Store.WithContext(ctx)
+	if claims.Role != "A" {
+		return newJSONError(errors.ErrWebhookForbidden, http.StatusForbidden)
+	}
+
+	payload := (*e).(*payloads.Webhook)
+	hook := payload.Webhook
+	if hook == nil || hook.WebhookBase == nil {
+		return newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
+	}
+
+	genusID, err := store.GenusIDFromName(genus)
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+	hook.GenusID = genusID
+	hook.CreatedBy = claims.Sub
+	hook.UpdatedBy = claims.Sub
+	if hook.Secret == "" {
+		if hook.Secret, err = helpers.GenerateNonce(); err != nil {
+			return newJSONError(err, http.StatusInternalServerError)
+		}
+	}
+
+	if err := store.CreateWebhook(hook.WebhookBase); err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	secret := hook.Secret
+	payload.Webhook, err = store.GetWebhook(hook.ID, genus)
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+	payload.Webhook.Secret = secret
+
+	return nil
+}
+
+// Delete deletes a webhook, along with its delivery log.
+func (s WebhookService) Delete(ctx context.Context, id int64, genus string, p types.Precondition, claims *types.Claims) *types.AppError {
+	store := s.Store.WithContext(ctx)
+	if claims.Role != "A" {
+		return newJSONError(errors.ErrWebhookForbidden, http.StatusForbidden)
+	}
+
+	hook, err := store.GetWebhook(id, genus)
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	if err := store.DeleteWebhook(hook.WebhookBase, p); err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// HandleWebhookDeliveries is a HTTP handler for a webhook's delivery log, the
+// latest attempts first.
+func (a *App) HandleWebhookDeliveries(w http.ResponseWriter, r *http.Request) *types.AppError {
+	claims := helpers.GetClaims(r)
+	if claims.Role != "A" {
+		return newJSONError(errors.ErrWebhookForbidden, http.StatusForbidden)
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["ID"], 10, 64)
+	if err != nil {
+		return newJSONError(errors.ErrInvalidID, http.StatusBadRequest)
+	}
+	store := a.Store.WithContext(r.Context())
+	if _, err := store.GetWebhook(id, mux.Vars(r)["genus"]); err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	limit := int64(100)
+	if l := r.FormValue("limit"); l != "" {
+		if limit, err = strconv.ParseInt(l, 10, 64); err != nil || limit < 1 {
+			return newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
+		}
+	}
+
+	deliveries, err := store.ListWebhookDeliveries(id, limit)
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	data, err := (&payloads.WebhookDeliveries{WebhookDeliveries: deliveries}).Marshal()
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+	w.Write(data)
+	return nil
+}
@@ -0,0 +1,512 @@
+// Package config loads bactdb's settings, from a JSON file, then the
+// environment, and checks them before anything starts.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/lib/pq"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// redacted replaces secrets when printing a config.
+const redacted = "REDACTED"
+
+// Config is all of bactdb's settings.
+type Config struct {
+	// Secret signs tokens.
+	Secret string `json:"secret"`
+	// Domains are the origins allowed to make cross-origin requests, under
+	// every genus. They can have a wildcard for subdomains, like
+	// https://*.hymenobacter.info.
+	Domains []string `json:"domains"`
+	CORS    CORS     `json:"cors"`
+	// Accounts are the Mailgun accounts email is sent from, by referring
+	// origin.
+	Accounts       []Account `json:"accounts"`
+	Port           int       `json:"port"`
+	GRPCPort       int       `json:"grpcPort"`
+	Database       Database  `json:"database"`
+	MigrationsPath string    `json:"migrationsPath"`
+	Log            Log       `json:"log"`
+	Throttle       Throttle  `json:"throttle"`
+	Login          Login     `json:"login"`
+	Timeouts       Timeouts  `json:"timeouts"`
+	TLS            TLS       `json:"tls"`
+}
+
+// Account is a Mailgun account.
+type Account struct {
+	Ref     string `json:"ref"`
+	Domain  string `json:"domain"`
+	Public  string `json:"public"`
+	Private string `json:"private"`
+}
+
+// CORS is the rest of the cross-origin policy.
+type CORS struct {
+	// Genera are origins allowed under a genus, on top of the domains, by
+	// genus name.
+	Genera map[string][]string `json:"genera"`
+	// Methods and Headers are what cross-origin requests can use.
+	Methods []string `json:"methods"`
+	Headers []string `json:"headers"`
+	// Expose are the response headers cross-origin scripts can read.
+	Expose []string `json:"expose"`
+	// MaxAge is how long browsers cache preflight responses.
+	MaxAge      Duration `json:"maxAge"`
+	Credentials bool     `json:"credentials"`
+}
+
+// Policy is the CORS policy, allowing domains everywhere.
+func (c CORS) Policy(domains []string) types.CORSPolicy {
+	return types.CORSPolicy{
+		Origins:     domains,
+		Genera:      c.Genera,
+		Methods:     c.Methods,
+		Headers:     c.Headers,
+		Expose:      c.Expose,
+		MaxAge:      c.MaxAge.Duration,
+		Credentials: c.Credentials,
+	}
+}
+
+// Database is where PostgreSQL is, and how it's connected to. Anything not in
+// URL comes from the PG* environment variables, as usual.
+type Database struct {
+	URL     string `json:"url"`
+	SSLMode string `json:"sslMode"`
+	// MaxOpenConns is the most connections open at once, zero for no limit.
+	MaxOpenConns int `json:"maxOpenConns"`
+	// MaxIdleConns is the most idle connections kept for reuse.
+	MaxIdleConns int `json:"maxIdleConns"`
+	// ConnMaxLifetime is how long a connection is reused for, zero for ever.
+	ConnMaxLifetime Duration `json:"connMaxLifetime"`
+	// ConnectTimeout is how long to keep retrying the database at startup.
+	ConnectTimeout Duration `json:"connectTimeout"`
+}
+
+// Log is what's logged.
+type Log struct {
+	SQL       models.TraceLevel `json:"sql"`
+	SlowQuery Duration          `json:"slowQuery"`
+}
+
+// Throttle is the rate limiting of logins, signups and lockouts.
+type Throttle struct {
+	PerIP      types.Limit `json:"perIP"`
+	PerEmail   types.Limit `json:"perEmail"`
+	TrustProxy bool        `json:"trustProxy"`
+}
+
+// Login is the lockout after failed logins.
+type Login struct {
+	Attempts int      `json:"attempts"`
+	Lockout  Duration `json:"lockout"`
+}
+
+// Timeouts are the servers'.
+type Timeouts struct {
+	Read     Duration `json:"read"`
+	Write    Duration `json:"write"`
+	Idle     Duration `json:"idle"`
+	Shutdown Duration `json:"shutdown"`
+}
+
+// TLS is serving HTTPS directly, without a proxy in front. HTTPS is served on
+// the port when a certificate is set.
+type TLS struct {
+	// Cert and Key are PEM files, reloaded on SIGHUP.
+	Cert       string     `json:"cert"`
+	Key        string     `json:"key"`
+	MinVersion TLSVersion `json:"minVersion"`
+	// RedirectPort, when set, serves plain HTTP redirects to HTTPS.
+	RedirectPort int `json:"redirectPort"`
+	// HSTS is the Strict-Transport-Security max-age, not sent when zero.
+	HSTS Duration `json:"hsts"`
+}
+
+// Enabled is whether HTTPS is served.
+func (t TLS) Enabled() bool {
+	return t.Cert != ""
+}
+
+// TLSVersion is a TLS protocol version written like "1.2".
+type TLSVersion uint16
+
+var tlsVersions = map[string]TLSVersion{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// MarshalText satisfies encoding.TextMarshaler.
+func (v TLSVersion) MarshalText() ([]byte, error) {
+	for name, version := range tlsVersions {
+		if version == v {
+			return []byte(name), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown TLS version %#x", uint16(v))
+}
+
+// UnmarshalText satisfies encoding.TextUnmarshaler.
+func (v *TLSVersion) UnmarshalText(b []byte) error {
+	version, ok := tlsVersions[string(b)]
+	if !ok {
+		return fmt.Errorf("%q isn't 1.0, 1.1, 1.2 or 1.3", b)
+	}
+	*v = version
+	return nil
+}
+
+// Duration is a time.Duration written like "30s".
+type Duration struct {
+	time.Duration
+}
+
+// MarshalText satisfies encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText satisfies encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(b []byte) error {
+	parsed, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// Default is the config before any file or environment.
+func Default() *Config {
+	cors, throttle := types.DefaultCORS(), types.DefaultThrottling()
+	return &Config{
+		Port:     8901,
+		GRPCPort: 8902,
+		Database: Database{
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: Duration{30 * time.Minute},
+			ConnectTimeout:  Duration{30 * time.Second},
+		},
+		CORS: CORS{
+			Methods: cors.Methods,
+			Headers: cors.Headers,
+			Expose:  cors.Expose,
+			MaxAge:  Duration{cors.MaxAge},
+		},
+		MigrationsPath: "./migrations",
+		Log:            Log{SQL: models.SQLTrace.Level, SlowQuery: Duration{models.SQLTrace.Slow}},
+		Throttle: Throttle{
+			PerIP:    throttle.PerIP,
+			PerEmail: throttle.PerEmail,
+		},
+		Login: Login{Attempts: models.DefaultLogins.Attempts, Lockout: Duration{models.DefaultLogins.Period}},
+		Timeouts: Timeouts{
+			Read:     Duration{15 * time.Second},
+			Write:    Duration{time.Minute},
+			Idle:     Duration{2 * time.Minute},
+			Shutdown: Duration{30 * time.Second},
+		},
+		TLS: TLS{MinVersion: tls.VersionTLS12},
+	}
+}
+
+// Load reads the defaults, then the file at path if there is one, then the
+// environment.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		if err := c.LoadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.LoadEnv(os.Getenv); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadFile reads settings from a JSON file, over those already set.
+func (c *Config) LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	if err := d.Decode(c); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// LoadEnv reads settings from environment variables, over those already set.
+func (c *Config) LoadEnv(getenv func(string) string) error {
+	var errs Errors
+	str := func(name string, dst *string) {
+		if v := getenv(name); v != "" {
+			*dst = v
+		}
+	}
+	num := func(name string, dst *int) {
+		if v := getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %q isn't a number", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	text := func(name string, dst interface {
+		UnmarshalText([]byte) error
+	}) {
+		if v := getenv(name); v != "" {
+			if err := dst.UnmarshalText([]byte(v)); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+	}
+
+	list := func(name string, dst *[]string) {
+		if v := getenv(name); v != "" {
+			*dst = strings.Split(v, ",")
+		}
+	}
+
+	str("SECRET", &c.Secret)
+	list("DOMAINS", &c.Domains)
+	if v := getenv("CORS_GENERA"); v != "" {
+		// {"hymenobacter":["https://hymenobacter.info"]}
+		if err := json.Unmarshal([]byte(v), &c.CORS.Genera); err != nil {
+			errs = append(errs, fmt.Sprintf("CORS_GENERA: %v", err))
+		}
+	}
+	list("CORS_METHODS", &c.CORS.Methods)
+	list("CORS_HEADERS", &c.CORS.Headers)
+	list("CORS_EXPOSE", &c.CORS.Expose)
+	text("CORS_MAX_AGE", &c.CORS.MaxAge)
+	if v := getenv("CORS_CREDENTIALS"); v != "" {
+		c.CORS.Credentials = v == "true"
+	}
+	if v := getenv("ACCOUNT_KEYS"); v != "" {
+		// [{"ref":"hymenobacter","domain":"hymenobacter.info","public":"abc","private":"123"}]
+		if err := json.Unmarshal([]byte(v), &c.Accounts); err != nil {
+			errs = append(errs, fmt.Sprintf("ACCOUNT_KEYS: %v", err))
+		}
+	}
+	num("PORT", &c.Port)
+	num("GRPC_PORT", &c.GRPCPort)
+	// DATABASE_URL is Heroku's, and is only read there
+	if getenv("HEROKU") == "true" {
+		str("DATABASE_URL", &c.Database.URL)
+		c.Database.SSLMode = "require"
+	}
+	str("DATABASE_SSLMODE", &c.Database.SSLMode)
+	num("DATABASE_MAX_OPEN_CONNS", &c.Database.MaxOpenConns)
+	num("DATABASE_MAX_IDLE_CONNS", &c.Database.MaxIdleConns)
+	text("DATABASE_CONN_MAX_LIFETIME", &c.Database.ConnMaxLifetime)
+	text("DATABASE_CONNECT_TIMEOUT", &c.Database.ConnectTimeout)
+	str("MIGRATIONS_PATH", &c.MigrationsPath)
+	text("LOG_SQL", &c.Log.SQL)
+	text("SLOW_QUERY", &c.Log.SlowQuery)
+	text("THROTTLE_PER_IP", &c.Throttle.PerIP)
+	text("THROTTLE_PER_EMAIL", &c.Throttle.PerEmail)
+	if v := getenv("TRUST_PROXY"); v != "" {
+		c.Throttle.TrustProxy = v == "true"
+	}
+	num("LOGIN_ATTEMPTS", &c.Login.Attempts)
+	text("LOGIN_LOCKOUT", &c.Login.Lockout)
+	text("READ_TIMEOUT", &c.Timeouts.Read)
+	text("WRITE_TIMEOUT", &c.Timeouts.Write)
+	text("IDLE_TIMEOUT", &c.Timeouts.Idle)
+	text("SHUTDOWN_TIMEOUT", &c.Timeouts.Shutdown)
+	str("TLS_CERT", &c.TLS.Cert)
+	str("TLS_KEY", &c.TLS.Key)
+	text("TLS_MIN_VERSION", &c.TLS.MinVersion)
+	num("TLS_REDIRECT_PORT", &c.TLS.RedirectPort)
+	text("HSTS_MAX_AGE", &c.TLS.HSTS)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Errors are everything wrong with a config.
+type Errors []string
+
+func (e Errors) Error() string {
+	return strings.Join(e, "\n")
+}
+
+// Validate checks that the config can be run with, reporting everything
+// that's wrong at once.
+func (c *Config) Validate() error {
+	var errs Errors
+	fail := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, a...))
+	}
+
+	if c.Secret == "" {
+		fail("secret: must be set, with SECRET")
+	}
+	for _, d := range c.Domains {
+		if _, err := types.ParseOrigin(d); err != nil {
+			fail("domains: %v", err)
+		}
+		if d == "*" && c.CORS.Credentials {
+			fail("cors.credentials: can't be allowed for any origin, *")
+		}
+	}
+	for genus, origins := range c.CORS.Genera {
+		for _, o := range origins {
+			if _, err := types.ParseOrigin(o); err != nil {
+				fail("cors.genera.%s: %v", genus, err)
+			}
+			if o == "*" {
+				fail("cors.genera.%s: * is any origin, put it in domains", genus)
+			}
+		}
+	}
+	if len(c.CORS.Methods) == 0 {
+		fail("cors.methods: must be set")
+	}
+	if c.CORS.MaxAge.Duration < 0 {
+		fail("cors.maxAge: can't be negative")
+	}
+	for i, a := range c.Accounts {
+		if a.Ref == "" || a.Domain == "" || a.Private == "" {
+			fail("accounts[%d]: needs a ref, domain and private key", i)
+		}
+	}
+	for name, port := range map[string]int{"port": c.Port, "grpcPort": c.GRPCPort} {
+		if port < 1 || port > 65535 {
+			fail("%s: %d isn't a port", name, port)
+		}
+	}
+	if c.Port == c.GRPCPort {
+		fail("grpcPort: must differ from port")
+	}
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		fail("tls.cert, tls.key: must be set together")
+	}
+	if c.TLS.Enabled() {
+		switch port := c.TLS.RedirectPort; {
+		case port == 0:
+		case port < 1 || port > 65535:
+			fail("tls.redirectPort: %d isn't a port", port)
+		case port == c.Port || port == c.GRPCPort:
+			fail("tls.redirectPort: must differ from port and grpcPort")
+		}
+		if c.TLS.HSTS.Duration < 0 {
+			fail("tls.hsts: can't be negative")
+		}
+	} else if c.TLS.RedirectPort != 0 || c.TLS.HSTS.Duration != 0 {
+		fail("tls.redirectPort, tls.hsts: need tls.cert and tls.key")
+	}
+	if c.Database.URL != "" {
+		if _, err := pq.ParseURL(c.Database.URL); err != nil {
+			fail("database.url: %v", err)
+		}
+	}
+	switch c.Database.SSLMode {
+	case "disable", "require", "verify-ca", "verify-full":
+	default:
+		fail("database.sslMode: %q isn't disable, require, verify-ca or verify-full", c.Database.SSLMode)
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		fail("database.maxOpenConns, maxIdleConns: can't be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		fail("database.maxIdleConns: can't be more than maxOpenConns")
+	}
+	if c.Database.ConnMaxLifetime.Duration < 0 {
+		fail("database.connMaxLifetime: can't be negative")
+	}
+	if c.MigrationsPath == "" {
+		fail("migrationsPath: must be set")
+	}
+	if c.Login.Attempts < 0 {
+		fail("login.attempts: can't be negative")
+	}
+	if c.Login.Attempts > 0 && c.Login.Lockout.Duration <= 0 {
+		fail("login.lockout: must be positive")
+	}
+	for name, d := range map[string]Duration{
+		"database.connectTimeout": c.Database.ConnectTimeout,
+		"log.slowQuery":           c.Log.SlowQuery,
+		"timeouts.read":           c.Timeouts.Read,
+		"timeouts.write":          c.Timeouts.Write,
+		"timeouts.idle":           c.Timeouts.Idle,
+		"timeouts.shutdown":       c.Timeouts.Shutdown,
+	} {
+		if d.Duration <= 0 {
+			fail("%s: must be positive", name)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Conninfo is the PostgreSQL connection string.
+func (c *Config) Conninfo() (string, error) {
+	conninfo := "timezone=UTC"
+	if c.Database.URL != "" {
+		conn, err := pq.ParseURL(c.Database.URL)
+		if err != nil {
+			return "", err
+		}
+		conninfo += " " + conn
+	}
+	return conninfo + " sslmode=" + c.Database.SSLMode, nil
+}
+
+// Pool is how the database's connections are pooled.
+func (c *Config) Pool() models.Pool {
+	return models.Pool{
+		MaxOpen:     c.Database.MaxOpenConns,
+		MaxIdle:     c.Database.MaxIdleConns,
+		MaxLifetime: c.Database.ConnMaxLifetime.Duration,
+	}
+}
+
+// Redacted is a copy of the config with its secrets hidden, for printing.
+func (c *Config) Redacted() *Config {
+	r := *c
+	if r.Secret != "" {
+		r.Secret = redacted
+	}
+	r.Accounts = make([]Account, len(c.Accounts))
+	for i, a := range c.Accounts {
+		if a.Private != "" {
+			a.Private = redacted
+		}
+		r.Accounts[i] = a
+	}
+	if u, err := url.Parse(c.Database.URL); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		r.Database.URL = u.String()
+	}
+	return &r
+}
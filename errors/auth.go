@@ -1,10 +1,14 @@
 package errors
 
-import "errors"
+import "net/http"
 
 var (
 	// ErrExpiredToken when expired token.
-	ErrExpiredToken = errors.New("this token has expired")
+	ErrExpiredToken = newError(http.StatusUnauthorized, "token_expired", "this token has expired")
 	// ErrInvalidToken when the role doesn't match the DB
-	ErrInvalidToken = errors.New("this token needs to be reissued")
+	ErrInvalidToken = newError(http.StatusUnauthorized, "token_invalid", "this token needs to be reissued")
+	// ErrAccountLocked when there have been too many failed logins in a row.
+	ErrAccountLocked = newError(http.StatusTooManyRequests, "account_locked", "Too many failed logins, try again later")
+	// ErrTooManyRequests when a client is being rate limited.
+	ErrTooManyRequests = newError(http.StatusTooManyRequests, "rate_limited", "Too many requests, try again later")
 )
@@ -1,12 +1,12 @@
 package errors
 
-import "errors"
+import "net/http"
 
 var (
 	// ErrCharacteristicNotFound when not found.
-	ErrCharacteristicNotFound = errors.New("Characteristic not found")
+	ErrCharacteristicNotFound = newError(http.StatusNotFound, "characteristic_not_found", "Characteristic not found")
 	// ErrCharacteristicNotUpdated when not updated.
-	ErrCharacteristicNotUpdated = errors.New("Characteristic not updated")
+	ErrCharacteristicNotUpdated = newError(http.StatusNotFound, "characteristic_not_updated", "Characteristic not updated")
 	// ErrCharacteristicNotDeleted when not deleted.
-	ErrCharacteristicNotDeleted = errors.New("Characteristic not deleted")
+	ErrCharacteristicNotDeleted = newError(http.StatusForbidden, "characteristic_not_deleted", "Characteristic not deleted")
 )
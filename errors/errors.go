@@ -0,0 +1,34 @@
+package errors
+
+import "errors"
+
+// Error is an error that the API knows how to report: it carries the HTTP
+// status to respond with, and a stable, machine-readable code.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+// Error satisfies the error interface.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var registry = make(map[string]*Error)
+
+func newError(status int, code, message string) error {
+	e := &Error{Status: status, Code: code, Message: message}
+	registry[message] = e
+	return e
+}
+
+// FromMessage returns the registered error with a particular message, or a
+// plain error if there isn't one. This recovers errors that were flattened to
+// text by third-party middleware.
+func FromMessage(message string) error {
+	if e, ok := registry[message]; ok {
+		return e
+	}
+	return errors.New(message)
+}
@@ -1,8 +1,28 @@
 package errors
 
-import "errors"
+import "net/http"
 
 var (
 	// ErrMustProvideOptions when missing options.
-	ErrMustProvideOptions = errors.New("Must provide necessary options")
+	ErrMustProvideOptions = newError(http.StatusBadRequest, "options_required", "Must provide necessary options")
+	// ErrInvalidPatch when a merge patch isn't an object of entity attributes.
+	ErrInvalidPatch = newError(http.StatusBadRequest, "patch_invalid", "Patch must be an object of entity attributes")
+	// ErrInvalidID when a record ID can't be parsed.
+	ErrInvalidID = newError(http.StatusBadRequest, "id_invalid", "ID must be an integer")
+	// ErrInvalidCursor when a sync cursor can't be parsed.
+	ErrInvalidCursor = newError(http.StatusBadRequest, "cursor_invalid", "Cursor must be one returned by a previous sync")
+	// ErrInvalidIdempotencyKey when an Idempotency-Key is too long.
+	ErrInvalidIdempotencyKey = newError(http.StatusBadRequest, "idempotency_key_invalid", "Idempotency-Key must be at most 255 characters")
+	// ErrIdempotencyKeyInUse when a retry comes in before the first try is done.
+	ErrIdempotencyKeyInUse = newError(http.StatusConflict, "idempotency_key_in_use", "A request with this Idempotency-Key is still being handled")
+	// ErrIdempotencyKeyReused when an Idempotency-Key comes back with a different request.
+	ErrIdempotencyKeyReused = newError(http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was already used for a different request")
+	// ErrPreconditionFailed when a record has changed since the version in If-Match.
+	ErrPreconditionFailed = newError(http.StatusPreconditionFailed, "precondition_failed", "Record has changed since it was read")
+	// ErrRequestTooLarge when a request body is more than a route reads.
+	ErrRequestTooLarge = newError(http.StatusRequestEntityTooLarge, "request_too_large", "Request body is too large")
+	// ErrQueryTooDeep when a GraphQL query nests fields past graphql.MaxDepth.
+	ErrQueryTooDeep = newError(http.StatusBadRequest, "query_too_deep", "Query is nested too deeply")
+	// ErrGenusNotFound when not found.
+	ErrGenusNotFound = newError(http.StatusNotFound, "genus_not_found", "Genus not found")
 )
@@ -0,0 +1,12 @@
+package errors
+
+import "net/http"
+
+var (
+	// ErrInvalidDocument when a request body isn't a JSON:API document.
+	ErrInvalidDocument = newError(http.StatusBadRequest, "document_invalid", "Must provide a JSON:API document with a resource object as its data")
+	// ErrResourceTypeConflict when a resource object's type doesn't match the endpoint.
+	ErrResourceTypeConflict = newError(http.StatusConflict, "resource_type_conflict", "Resource type doesn't match the endpoint")
+	// ErrResourceIDConflict when a resource object's id doesn't match the URL.
+	ErrResourceIDConflict = newError(http.StatusConflict, "resource_id_conflict", "Resource id doesn't match the URL")
+)
@@ -1,12 +1,20 @@
 package errors
 
-import "errors"
+import "net/http"
 
 var (
 	// ErrMeasurementNotFound when not found.
-	ErrMeasurementNotFound = errors.New("Measurement not found")
+	ErrMeasurementNotFound = newError(http.StatusNotFound, "measurement_not_found", "Measurement not found")
 	// ErrMeasurementNotUpdated when not updated.
-	ErrMeasurementNotUpdated = errors.New("Measurement not updated")
+	ErrMeasurementNotUpdated = newError(http.StatusNotFound, "measurement_not_updated", "Measurement not updated")
 	// ErrMeasurementNotDeleted when not deleted.
-	ErrMeasurementNotDeleted = errors.New("Measurement not deleted")
+	ErrMeasurementNotDeleted = newError(http.StatusForbidden, "measurement_not_deleted", "Measurement not deleted")
+	// ErrMeasurementForbidden when someone other than its creator or an admin changes a measurement.
+	ErrMeasurementForbidden = newError(http.StatusForbidden, "measurement_forbidden", "Measurement can only be changed by its creator or an admin")
+	// ErrUnknownBatchOperation when a batch operation isn't create, update or delete.
+	ErrUnknownBatchOperation = newError(http.StatusBadRequest, "batch_operation_unknown", "Operation must be one of create, update or delete")
+	// ErrTooManyBatchOperations when a batch has more operations than are applied at once.
+	ErrTooManyBatchOperations = newError(http.StatusRequestEntityTooLarge, "batch_too_large", "Batch has too many operations")
+	// ErrBatchOperationNotApplied when another operation in an atomic batch failed.
+	ErrBatchOperationNotApplied = newError(http.StatusFailedDependency, "batch_operation_not_applied", "Not applied, another operation in the batch failed")
 )
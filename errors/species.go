@@ -1,12 +1,12 @@
 package errors
 
-import "errors"
+import "net/http"
 
 var (
 	// ErrSpeciesNotFound when not found.
-	ErrSpeciesNotFound = errors.New("Species not found")
+	ErrSpeciesNotFound = newError(http.StatusNotFound, "species_not_found", "Species not found")
 	// ErrSpeciesNotUpdated when not updated.
-	ErrSpeciesNotUpdated = errors.New("Species not updated")
+	ErrSpeciesNotUpdated = newError(http.StatusNotFound, "species_not_updated", "Species not updated")
 	// ErrSpeciesNotDeleted when not deleted.
-	ErrSpeciesNotDeleted = errors.New("Species not deleted")
+	ErrSpeciesNotDeleted = newError(http.StatusForbidden, "species_not_deleted", "Species not deleted")
 )
@@ -1,12 +1,12 @@
 package errors
 
-import "errors"
+import "net/http"
 
 var (
 	// ErrStrainNotFound when not found.
-	ErrStrainNotFound = errors.New("Strain not found")
+	ErrStrainNotFound = newError(http.StatusNotFound, "strain_not_found", "Strain not found")
 	// ErrStrainNotUpdated when not updated.
-	ErrStrainNotUpdated = errors.New("Strain not updated")
+	ErrStrainNotUpdated = newError(http.StatusNotFound, "strain_not_updated", "Strain not updated")
 	// ErrStrainNotDeleted when not deleted.
-	ErrStrainNotDeleted = errors.New("Strain not deleted")
+	ErrStrainNotDeleted = newError(http.StatusForbidden, "strain_not_deleted", "Strain not deleted")
 )
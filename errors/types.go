@@ -1,8 +1,8 @@
 package errors
 
-import "errors"
+import "net/http"
 
 var (
 	// ErrSourceNotByteSlice when not a byte-slice.
-	ErrSourceNotByteSlice = errors.New("Scan source was not []byte")
+	ErrSourceNotByteSlice = newError(http.StatusInternalServerError, "scan_source_invalid", "Scan source was not []byte")
 )
@@ -1,20 +1,20 @@
 package errors
 
-import "errors"
+import "net/http"
 
 var (
 	// ErrUserNotFound when not found.
-	ErrUserNotFound = errors.New("No user found")
+	ErrUserNotFound = newError(http.StatusNotFound, "user_not_found", "No user found")
 	// ErrUserNotUpdated when not updated.
-	ErrUserNotUpdated = errors.New("User not updated")
+	ErrUserNotUpdated = newError(http.StatusNotFound, "user_not_updated", "User not updated")
 	// ErrUserNotDeleted when not deleted.
-	ErrUserNotDeleted = errors.New("User not deleted")
+	ErrUserNotDeleted = newError(http.StatusForbidden, "user_not_deleted", "User not deleted")
 	// ErrUserMissingEmail when missing email.
-	ErrUserMissingEmail = errors.New("Missing email")
+	ErrUserMissingEmail = newError(http.StatusBadRequest, "email_required", "Missing email")
 	// ErrInvalidEmailOrPassword when invalid login credentials.
-	ErrInvalidEmailOrPassword = errors.New("Invalid email or password")
+	ErrInvalidEmailOrPassword = newError(http.StatusUnauthorized, "credentials_invalid", "Invalid email or password")
 	// ErrEmailAddressTaken when email already registered.
-	ErrEmailAddressTaken = errors.New("Email address is already registered")
+	ErrEmailAddressTaken = newError(http.StatusConflict, "email_taken", "Email address is already registered")
 	// ErrUserForbidden when user not allowed to view a resource
-	ErrUserForbidden = errors.New("User account not authorized")
+	ErrUserForbidden = newError(http.StatusForbidden, "user_forbidden", "User account not authorized")
 )
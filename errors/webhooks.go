@@ -0,0 +1,14 @@
+package errors
+
+import "net/http"
+
+var (
+	// ErrWebhookNotFound when not found.
+	ErrWebhookNotFound = newError(http.StatusNotFound, "webhook_not_found", "Webhook not found")
+	// ErrWebhookNotUpdated when not updated.
+	ErrWebhookNotUpdated = newError(http.StatusNotFound, "webhook_not_updated", "Webhook not updated")
+	// ErrWebhookNotDeleted when not deleted.
+	ErrWebhookNotDeleted = newError(http.StatusNotFound, "webhook_not_deleted", "Webhook not deleted")
+	// ErrWebhookForbidden when someone other than an admin manages webhooks.
+	ErrWebhookForbidden = newError(http.StatusForbidden, "webhook_forbidden", "Only admins can manage webhooks")
+)
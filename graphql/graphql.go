@@ -0,0 +1,608 @@
+// Package graphql is a small, read-only GraphQL executor.
+//
+// Resolvers are batched: a field is resolved once for every object at the
+// same level of the response, so that a nested selection takes one lookup
+// per level, rather than one per parent object.
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Resolver resolves a field for a batch of parent objects, returning one
+// value for each of them, in the same order. Scalars are returned as JSON
+// ready values, objects as anything their own type's resolvers accept, and
+// lists as []interface{}.
+type Resolver func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error)
+
+// Object is an object type.
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*FieldDef
+}
+
+// FieldDef is a field of an object type.
+type FieldDef struct {
+	Name        string
+	Description string
+	// Type is in schema language, e.g. [Strain!]!
+	Type    string
+	Args    []*ArgDef
+	Resolve Resolver
+}
+
+// ArgDef is an argument of a field.
+type ArgDef struct {
+	Name string
+	// Type is in schema language, and has to be a built-in scalar, or a list
+	// of them.
+	Type string
+}
+
+// MaxDepth is how deeply a query can nest selection sets, lists and objects,
+// and how many fields deep it can be executed, through fragments as well.
+// Queries are parsed and executed recursively, so deeper ones are turned down
+// before they can run out of stack.
+const MaxDepth = 32
+
+// ErrTooDeep is the error for a query nested deeper than MaxDepth. It's
+// reported without a location, as the response's only error.
+var ErrTooDeep = &Error{Message: fmt.Sprintf("Query is nested deeper than %d levels", MaxDepth)}
+
+// Schema is a set of object types, with Query as the root.
+type Schema struct {
+	Query   string
+	objects map[string]*Object
+	order   []string
+}
+
+// NewSchema creates a schema, where query is the name of the root type.
+func NewSchema(query string, objects ...*Object) *Schema {
+	s := &Schema{Query: query, objects: make(map[string]*Object)}
+	for _, o := range objects {
+		s.objects[o.Name] = o
+		s.order = append(s.order, o.Name)
+	}
+	return s
+}
+
+// Request is a GraphQL request, as sent over HTTP.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Response is a GraphQL response.
+type Response struct {
+	Data   *OrderedMap `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Error is a GraphQL error.
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+// Location is a position in a request document.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// OrderedMap is a JSON object that keeps the order of the query.
+type OrderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newOrderedMap() *OrderedMap {
+	return &OrderedMap{values: make(map[string]interface{})}
+}
+
+// Set sets a key's value, appending it when it's new.
+func (m *OrderedMap) Set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Get gets a key's value.
+func (m *OrderedMap) Get(key string) interface{} {
+	return m.values[key]
+}
+
+// MarshalJSON makes OrderedMap a json.Marshaler.
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		b.Write(key)
+		b.WriteByte(':')
+		val, err := json.Marshal(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(val)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// Execute runs a request. Errors in the request itself are reported in the
+// response, as is the first error from a resolver.
+func (s *Schema) Execute(ctx interface{}, req *Request) *Response {
+	doc, err := Parse(req.Query)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	op, err := doc.operation(req.OperationName)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if op.Type != "query" {
+		return errorResponse(&Error{Message: fmt.Sprintf("Only queries are supported, not %ss", op.Type)})
+	}
+
+	vars, err := variables(op, req.Variables)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	e := &executor{schema: s, doc: doc, vars: vars, ctx: ctx}
+	data, err := e.selectionSet(s.objects[s.Query], []interface{}{nil}, op.SelectionSet, nil)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return &Response{Data: data[0]}
+}
+
+func errorResponse(err error) *Response {
+	gqlErr, ok := err.(*Error)
+	if !ok {
+		gqlErr = &Error{Message: err.Error()}
+	}
+	return &Response{Errors: []*Error{gqlErr}}
+}
+
+func (d *Document) operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(d.Operations) > 1 {
+			return nil, &Error{Message: "Must provide operation name if query contains multiple operations"}
+		}
+		return d.Operations[0], nil
+	}
+	for _, op := range d.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("Unknown operation named %q", name)}
+}
+
+func variables(op *Operation, given map[string]interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	for _, def := range op.Variables {
+		v, ok := given[def.Name]
+		if !ok {
+			v = def.Default
+		}
+		if v == nil && strings.HasSuffix(def.Type, "!") {
+			return nil, &Error{Message: fmt.Sprintf("Variable $%s of required type %s was not provided", def.Name, def.Type)}
+		}
+		vars[def.Name] = v
+	}
+	return vars, nil
+}
+
+type executor struct {
+	schema *Schema
+	doc    *Document
+	vars   map[string]interface{}
+	ctx    interface{}
+}
+
+// selectionSet resolves a selection set on a batch of objects of one type.
+func (e *executor) selectionSet(obj *Object, parents []interface{}, sels []Selection, path []interface{}) ([]*OrderedMap, error) {
+	// Fragments can spread themselves inside their own fields, which the
+	// parser can't see
+	if len(path) >= MaxDepth {
+		return nil, ErrTooDeep
+	}
+
+	results := make([]*OrderedMap, len(parents))
+	for i := range results {
+		results[i] = newOrderedMap()
+	}
+
+	fields, keys, err := e.collect(obj, sels, nil, nil, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		f := fields[key][0]
+		fieldPath := append(append([]interface{}{}, path...), key)
+
+		if f.Name == "__typename" {
+			for _, r := range results {
+				r.Set(key, obj.Name)
+			}
+			continue
+		}
+
+		def := obj.field(f.Name)
+		if def == nil {
+			return nil, &Error{Message: fmt.Sprintf("Cannot query field %q on type %q", f.Name, obj.Name), Path: fieldPath}
+		}
+
+		args, err := e.arguments(def, f)
+		if err != nil {
+			return nil, &Error{Message: err.Error(), Path: fieldPath}
+		}
+
+		var sub []Selection
+		for _, f := range fields[key] {
+			sub = append(sub, f.SelectionSet...)
+		}
+
+		var values []interface{}
+		if len(parents) > 0 {
+			if values, err = def.Resolve(e.ctx, parents, args); err != nil {
+				return nil, &Error{Message: err.Error(), Path: fieldPath}
+			}
+			if len(values) != len(parents) {
+				return nil, &Error{Message: fmt.Sprintf("%s.%s resolved %d values for %d objects", obj.Name, def.Name, len(values), len(parents)), Path: fieldPath}
+			}
+		}
+
+		completed, err := e.complete(parseType(def.Type), values, sub, fieldPath)
+		if err != nil {
+			return nil, err
+		}
+		for i, r := range results {
+			r.Set(key, completed[i])
+		}
+	}
+
+	return results, nil
+}
+
+func (o *Object) field(name string) *FieldDef {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// collect gathers the fields selected on an object type, by response key,
+// expanding fragments and applying @skip and @include.
+func (e *executor) collect(obj *Object, sels []Selection, fields map[string][]*Field, keys []string, visited map[string]bool) (map[string][]*Field, []string, error) {
+	if fields == nil {
+		fields = make(map[string][]*Field)
+	}
+
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *Field:
+			if include, err := e.included(sel.Directives); err != nil || !include {
+				if err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			key := sel.Alias
+			if key == "" {
+				key = sel.Name
+			}
+			if _, ok := fields[key]; !ok {
+				keys = append(keys, key)
+			}
+			fields[key] = append(fields[key], sel)
+		case *FragmentSpread:
+			if include, err := e.included(sel.Directives); err != nil || !include || visited[sel.Name] {
+				if err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			frag, ok := e.doc.Fragments[sel.Name]
+			if !ok {
+				return nil, nil, &Error{Message: fmt.Sprintf("Unknown fragment %q", sel.Name)}
+			}
+			visited[sel.Name] = true
+			if frag.TypeCondition != obj.Name {
+				continue
+			}
+			var err error
+			if fields, keys, err = e.collect(obj, frag.SelectionSet, fields, keys, visited); err != nil {
+				return nil, nil, err
+			}
+		case *InlineFragment:
+			if include, err := e.included(sel.Directives); err != nil || !include {
+				if err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			if sel.TypeCondition != "" && sel.TypeCondition != obj.Name {
+				continue
+			}
+			var err error
+			if fields, keys, err = e.collect(obj, sel.SelectionSet, fields, keys, visited); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return fields, keys, nil
+}
+
+func (e *executor) included(directives []*Directive) (bool, error) {
+	for _, d := range directives {
+		if d.Name != "skip" && d.Name != "include" {
+			continue
+		}
+		v, err := e.value(d.Arguments["if"], "Boolean!")
+		if err != nil {
+			return false, err
+		}
+		if v.(bool) == (d.Name == "skip") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// complete shapes resolved values for the response, resolving the selection
+// set of object values as a single batch.
+func (e *executor) complete(typ typeRef, values []interface{}, sels []Selection, path []interface{}) ([]interface{}, error) {
+	completed := make([]interface{}, len(values))
+
+	if typ.list != nil {
+		var items []interface{}
+		var lengths []int
+		for i, v := range values {
+			if v == nil {
+				if typ.nonNull {
+					return nil, &Error{Message: "Cannot return null for non-nullable field", Path: path}
+				}
+				lengths = append(lengths, -1)
+				continue
+			}
+			list, ok := v.([]interface{})
+			if !ok {
+				return nil, &Error{Message: fmt.Sprintf("Expected a list, got %T", values[i]), Path: path}
+			}
+			items = append(items, list...)
+			lengths = append(lengths, len(list))
+		}
+
+		done, err := e.complete(*typ.list, items, sels, path)
+		if err != nil {
+			return nil, err
+		}
+		for i, n := range lengths {
+			if n < 0 {
+				continue
+			}
+			list := make([]interface{}, n)
+			copy(list, done)
+			completed[i], done = list, done[n:]
+		}
+		return completed, nil
+	}
+
+	obj, isObject := e.schema.objects[typ.name]
+	var objects []interface{}
+	var indices []int
+	for i, v := range values {
+		if v == nil {
+			if typ.nonNull {
+				return nil, &Error{Message: "Cannot return null for non-nullable field", Path: path}
+			}
+			continue
+		}
+		if !isObject {
+			completed[i] = v
+			continue
+		}
+		objects = append(objects, v)
+		indices = append(indices, i)
+	}
+
+	if !isObject {
+		if len(sels) > 0 {
+			return nil, &Error{Message: fmt.Sprintf("Field of type %s can't have a selection", typ.name), Path: path}
+		}
+		return completed, nil
+	}
+	if len(sels) == 0 {
+		return nil, &Error{Message: fmt.Sprintf("Field of type %s must have a selection of subfields", typ.name), Path: path}
+	}
+
+	maps, err := e.selectionSet(obj, objects, sels, path)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range indices {
+		completed[i] = maps[j]
+	}
+	return completed, nil
+}
+
+func (e *executor) arguments(def *FieldDef, f *Field) (map[string]interface{}, error) {
+	args := make(map[string]interface{})
+	for name := range f.Arguments {
+		known := false
+		for _, a := range def.Args {
+			known = known || a.Name == name
+		}
+		if !known {
+			return nil, fmt.Errorf("Unknown argument %q on field %q", name, def.Name)
+		}
+	}
+
+	for _, a := range def.Args {
+		raw, ok := f.Arguments[a.Name]
+		if !ok {
+			if strings.HasSuffix(a.Type, "!") {
+				return nil, fmt.Errorf("Argument %q of type %s is required", a.Name, a.Type)
+			}
+			continue
+		}
+		v, err := e.value(raw, a.Type)
+		if err != nil {
+			return nil, fmt.Errorf("Argument %q: %v", a.Name, err)
+		}
+		if v != nil {
+			args[a.Name] = v
+		}
+	}
+	return args, nil
+}
+
+// value resolves variables in an argument value, and coerces it to a type.
+// IDs are coerced to int64, since they're all database keys.
+func (e *executor) value(raw interface{}, typ string) (interface{}, error) {
+	if v, ok := raw.(Variable); ok {
+		raw = e.vars[string(v)]
+	}
+	t := parseType(typ)
+	if raw == nil {
+		if t.nonNull {
+			return nil, fmt.Errorf("Expected a non-null %s", typ)
+		}
+		return nil, nil
+	}
+
+	if t.list != nil {
+		elemType := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSuffix(typ, "!"), "["), "]")
+		list, ok := raw.([]interface{})
+		if !ok {
+			// A single value is coerced to a list of one
+			list = []interface{}{raw}
+		}
+		var values []interface{}
+		for _, item := range list {
+			v, err := e.value(item, elemType)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+
+	switch t.name {
+	case "ID", "Int":
+		switch v := raw.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			if v == float64(int64(v)) {
+				return int64(v), nil
+			}
+		case json.Number:
+			return v.Int64()
+		case string:
+			if t.name == "ID" {
+				if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+					return n, nil
+				}
+			}
+		}
+	case "Float":
+		switch v := raw.(type) {
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case "String":
+		if v, ok := raw.(string); ok {
+			return v, nil
+		}
+	case "Boolean":
+		if v, ok := raw.(bool); ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("Expected a %s, got %v", t.name, raw)
+}
+
+// typeRef is a parsed schema language type.
+type typeRef struct {
+	name    string
+	list    *typeRef
+	nonNull bool
+}
+
+func parseType(typ string) typeRef {
+	var t typeRef
+	if strings.HasSuffix(typ, "!") {
+		t.nonNull = true
+		typ = typ[:len(typ)-1]
+	}
+	if strings.HasPrefix(typ, "[") {
+		elem := parseType(typ[1 : len(typ)-1])
+		t.list = &elem
+		return t
+	}
+	t.name = typ
+	return t
+}
+
+// SDL describes the schema in the GraphQL schema language.
+func (s *Schema) SDL() string {
+	var b bytes.Buffer
+	names := append([]string{}, s.order...)
+	sort.SliceStable(names, func(i, j int) bool { return names[i] == s.Query && names[j] != s.Query })
+
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		o := s.objects[name]
+		if o.Description != "" {
+			fmt.Fprintf(&b, "%q\n", o.Description)
+		}
+		fmt.Fprintf(&b, "type %s {\n", o.Name)
+		for _, f := range o.Fields {
+			if f.Description != "" {
+				fmt.Fprintf(&b, "  %q\n", f.Description)
+			}
+			fmt.Fprintf(&b, "  %s", f.Name)
+			if len(f.Args) > 0 {
+				var args []string
+				for _, a := range f.Args {
+					args = append(args, a.Name+": "+a.Type)
+				}
+				fmt.Fprintf(&b, "(%s)", strings.Join(args, ", "))
+			}
+			fmt.Fprintf(&b, ": %s\n", f.Type)
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,226 @@
+package graphql
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type author struct {
+	id    int64
+	name  string
+	books []int64
+}
+
+type book struct {
+	id     int64
+	title  string
+	author int64
+}
+
+var (
+	authors = map[int64]author{
+		1: {1, "Ann", []int64{10, 11}},
+		2: {2, "Bob", []int64{12}},
+	}
+	books = map[int64]book{
+		10: {10, "First", 1},
+		11: {11, "Second", 1},
+		12: {12, "Third", 2},
+	}
+)
+
+// testSchema counts how often each resolver runs.
+func testSchema(calls map[string]int) *Schema {
+	return NewSchema("Query",
+		&Object{
+			Name: "Query",
+			Fields: []*FieldDef{
+				{
+					Name: "authors",
+					Type: "[Author!]!",
+					Args: []*ArgDef{{Name: "ids", Type: "[ID!]"}},
+					Resolve: func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+						calls["authors"]++
+						var list []interface{}
+						if ids, ok := args["ids"].([]interface{}); ok {
+							for _, id := range ids {
+								list = append(list, authors[id.(int64)])
+							}
+						} else {
+							list = []interface{}{authors[1], authors[2]}
+						}
+						return []interface{}{list}, nil
+					},
+				},
+			},
+		},
+		&Object{
+			Name: "Author",
+			Fields: []*FieldDef{
+				{Name: "id", Type: "ID!", Resolve: func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+					values := make([]interface{}, len(parents))
+					for i, p := range parents {
+						values[i] = p.(author).id
+					}
+					return values, nil
+				}},
+				{Name: "name", Type: "String", Resolve: func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+					values := make([]interface{}, len(parents))
+					for i, p := range parents {
+						values[i] = p.(author).name
+					}
+					return values, nil
+				}},
+				{Name: "books", Type: "[Book!]!", Resolve: func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+					calls["books"]++
+					values := make([]interface{}, len(parents))
+					for i, p := range parents {
+						var list []interface{}
+						for _, id := range p.(author).books {
+							list = append(list, books[id])
+						}
+						values[i] = list
+					}
+					return values, nil
+				}},
+			},
+		},
+		&Object{
+			Name: "Book",
+			Fields: []*FieldDef{
+				{Name: "title", Type: "String!", Resolve: func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+					values := make([]interface{}, len(parents))
+					for i, p := range parents {
+						values[i] = p.(book).title
+					}
+					return values, nil
+				}},
+				{Name: "author", Type: "Author", Resolve: func(ctx interface{}, parents []interface{}, args map[string]interface{}) ([]interface{}, error) {
+					calls["author"]++
+					values := make([]interface{}, len(parents))
+					for i, p := range parents {
+						values[i] = authors[p.(book).author]
+					}
+					return values, nil
+				}},
+			},
+		},
+	)
+}
+
+func TestExecuteBatches(t *testing.T) {
+	calls := make(map[string]int)
+	resp := testSchema(calls).Execute(nil, &Request{Query: `{
+		authors {
+			name
+			books { title author { name } }
+		}
+	}`})
+
+	want := `{"data":{"authors":[` +
+		`{"name":"Ann","books":[{"title":"First","author":{"name":"Ann"}},{"title":"Second","author":{"name":"Ann"}}]},` +
+		`{"name":"Bob","books":[{"title":"Third","author":{"name":"Bob"}}]}]}}`
+	if got := marshal(t, resp); got != want {
+		t.Errorf("got %s\nwant %s", got, want)
+	}
+
+	for field, n := range calls {
+		if n != 1 {
+			t.Errorf("%s resolved %d times, want once", field, n)
+		}
+	}
+}
+
+func TestExecuteFeatures(t *testing.T) {
+	tests := []struct {
+		query string
+		vars  map[string]interface{}
+		want  string
+	}{
+		{
+			`query Q($ids: [ID!]) { authors(ids: $ids) { ...f } } fragment f on Author { id }`,
+			map[string]interface{}{"ids": []interface{}{"2"}},
+			`{"data":{"authors":[{"id":2}]}}`,
+		},
+		{
+			`{ a: authors(ids: 1) { __typename n: name ... on Author { id } } }`,
+			nil,
+			`{"data":{"a":[{"__typename":"Author","n":"Ann","id":1}]}}`,
+		},
+		{
+			`query ($skip: Boolean = true) { authors(ids: [2]) { id name @skip(if: $skip) books @include(if: false) { title } } }`,
+			nil,
+			`{"data":{"authors":[{"id":2}]}}`,
+		},
+	}
+
+	for _, test := range tests {
+		resp := testSchema(make(map[string]int)).Execute(nil, &Request{Query: test.query, Variables: test.vars})
+		if got := marshal(t, resp); got != test.want {
+			t.Errorf("%s:\ngot %s\nwant %s", test.query, got, test.want)
+		}
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`{ authors { name `, `{"errors":[{"message":"Syntax Error: Unexpected \u003cEOF\u003e","locations":[{"line":1,"column":18}]}]}`},
+		{`mutation { authors { name } }`, `{"errors":[{"message":"Only queries are supported, not mutations"}]}`},
+		{`{ authors { age } }`, `{"errors":[{"message":"Cannot query field \"age\" on type \"Author\"","path":["authors","age"]}]}`},
+		{`{ authors }`, `{"errors":[{"message":"Field of type Author must have a selection of subfields","path":["authors"]}]}`},
+		{`{ authors(ids: "x") { id } }`, `{"errors":[{"message":"Argument \"ids\": Expected a ID, got x","path":["authors"]}]}`},
+	}
+
+	for _, test := range tests {
+		resp := testSchema(make(map[string]int)).Execute(nil, &Request{Query: test.query})
+		if got := marshal(t, resp); got != test.want {
+			t.Errorf("%s:\ngot %s\nwant %s", test.query, got, test.want)
+		}
+	}
+}
+
+func TestExecuteDepth(t *testing.T) {
+	deep := func(open, close string, n int) string {
+		return strings.Repeat(open, n) + strings.Repeat(close, n)
+	}
+	for name, query := range map[string]string{
+		"unclosed":  strings.Repeat("{a", 1<<20),
+		"selection": deep("{ authors ", "}", MaxDepth+1),
+		"list":      "{ authors(ids: " + deep("[", "]", MaxDepth) + ") { id } }",
+		"object":    "{ authors(ids: " + deep("{a:", "}", MaxDepth) + ") { id } }",
+		"type":      "query ($ids: " + deep("[", "]", MaxDepth) + ") { authors { id } }",
+		// Each fragment is shallow, but spreads itself until the data runs out,
+		// which it doesn't
+		"fragments": `{ authors { ...f } } fragment f on Author { books { author { ...f } } }`,
+	} {
+		resp := testSchema(make(map[string]int)).Execute(nil, &Request{Query: query})
+		if resp.Data != nil || len(resp.Errors) != 1 || resp.Errors[0] != ErrTooDeep {
+			t.Errorf("%s got %s", name, marshal(t, resp.Errors))
+		}
+	}
+
+	n := MaxDepth/2 - 2
+	query := "{ authors { " + strings.Repeat("books { author { ", n) + "name" + strings.Repeat(" } }", n) + " } }"
+	if resp := testSchema(make(map[string]int)).Execute(nil, &Request{Query: query}); len(resp.Errors) != 0 {
+		t.Errorf("%d fields deep got %s", 2*n+2, marshal(t, resp.Errors))
+	}
+}
+
+func TestSDL(t *testing.T) {
+	want := "type Query {\n  authors(ids: [ID!]): [Author!]!\n}\n"
+	if got := testSchema(nil).SDL(); got[:len(want)] != want {
+		t.Errorf("got %q", got)
+	}
+}
+
+func marshal(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -1,27 +1,142 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/thermokarst/bactdb/types"
 )
 
-func corsHandler(h http.Handler) http.Handler {
-	cors := func(w http.ResponseWriter, r *http.Request) {
-		domains := os.Getenv("DOMAINS")
-		allowedDomains := strings.Split(domains, ",")
-		if origin := r.Header.Get("Origin"); origin != "" {
-			for _, s := range allowedDomains {
-				if s == origin {
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-					w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
-					w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
-				}
+// cors is a CORSPolicy ready to be checked against requests.
+type cors struct {
+	origins []types.Origin
+	genera  map[string][]types.Origin
+	methods map[string]bool
+	headers map[string]bool
+
+	allowMethods, allowHeaders, expose, maxAge string
+	credentials                                bool
+}
+
+// newCORS compiles p. Origins that don't parse are logged and left out,
+// config.Validate reports them before the server starts.
+func newCORS(p types.CORSPolicy) *cors {
+	c := &cors{
+		genera:       make(map[string][]types.Origin),
+		methods:      make(map[string]bool),
+		headers:      make(map[string]bool),
+		allowMethods: strings.Join(p.Methods, ", "),
+		allowHeaders: strings.Join(p.Headers, ", "),
+		expose:       strings.Join(p.Expose, ", "),
+		maxAge:       strconv.FormatInt(int64(p.MaxAge/time.Second), 10),
+		credentials:  p.Credentials,
+	}
+	parse := func(origins []string) []types.Origin {
+		var ps []types.Origin
+		for _, o := range origins {
+			pattern, err := types.ParseOrigin(o)
+			if err != nil {
+				log.Print("CORS: ", err)
+				continue
 			}
+			ps = append(ps, pattern)
+		}
+		return ps
+	}
+
+	c.origins = parse(p.Origins)
+	for genus, origins := range p.Genera {
+		c.genera[strings.ToLower(genus)] = parse(origins)
+	}
+	for _, m := range p.Methods {
+		c.methods[strings.ToUpper(m)] = true
+	}
+	for _, h := range p.Headers {
+		c.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	return c
+}
+
+// allowed is whether origin can make requests to path.
+func (c *cors) allowed(origin, path string) bool {
+	for _, p := range c.origins {
+		if p.Matches(origin) {
+			return true
 		}
-		if r.Method != "OPTIONS" {
-			h.ServeHTTP(w, r)
+	}
+	for _, p := range c.genera[pathGenus(path)] {
+		if p.Matches(origin) {
+			return true
 		}
 	}
-	return http.HandlerFunc(cors)
+	return false
+}
+
+// preflightAllowed is whether everything a preflight request asks for is
+// allowed.
+func (c *cors) preflightAllowed(r *http.Request) bool {
+	if !c.methods[r.Header.Get("Access-Control-Request-Method")] {
+		return false
+	}
+	for _, hs := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, h := range strings.Split(hs, ",") {
+			if h = strings.TrimSpace(h); h != "" && !c.headers[http.CanonicalHeaderKey(h)] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// pathGenus is the genus a path is under, if it's under one.
+func pathGenus(path string) string {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if parts[0] == "v2" && len(parts) > 1 {
+		return strings.ToLower(parts[1])
+	}
+	return strings.ToLower(parts[0])
+}
+
+// corsHandler applies the CORS policy p. Preflight requests are answered here,
+// and never reach h.
+func corsHandler(p types.CORSPolicy, h http.Handler) http.Handler {
+	c := newCORS(p)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" {
+			// Responses differ by origin, caches have to keep them apart
+			w.Header().Add("Vary", "Origin")
+		}
+		allowed := origin != "" && c.allowed(origin, r.URL.Path)
+
+		if r.Method == "OPTIONS" {
+			if r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+			if allowed && c.preflightAllowed(r) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", c.allowMethods)
+				w.Header().Set("Access-Control-Allow-Headers", c.allowHeaders)
+				w.Header().Set("Access-Control-Max-Age", c.maxAge)
+				if c.credentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", c.expose)
+			if c.credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/thermokarst/bactdb/types"
+)
+
+// corsTest serves requests through a CORS policy, in front of a handler that
+// always answers 200.
+func corsTest(p types.CORSPolicy) func(r *http.Request) *httptest.ResponseRecorder {
+	h := corsHandler(p, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	return func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+}
+
+func preflight(path, origin, method, headers string) *http.Request {
+	r := httptest.NewRequest("OPTIONS", path, nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		r.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return r
+}
+
+func TestCORSPreflight(t *testing.T) {
+	policy := DefaultOptions().CORS
+	policy.Origins = []string{"https://hymenobacter.info", "https://*.bactdb.org"}
+	policy.Genera = map[string][]string{"Arthrobacter": {"http://localhost:4200"}}
+	serve := corsTest(policy)
+
+	for _, test := range []struct {
+		path, origin, method, headers string
+		allowed                       bool
+	}{
+		{"/hymenobacter/strains", "https://hymenobacter.info", "POST", "Content-Type, Authorization", true},
+		{"/hymenobacter/strains", "https://HYMENOBACTER.info", "GET", "", true},
+		{"/hymenobacter/strains", "https://lab.bactdb.org", "PATCH", "if-match, idempotency-key", true},
+		{"/hymenobacter/strains", "https://a.lab.bactdb.org", "DELETE", "", true},
+		{"/v2/arthrobacter/strains", "http://localhost:4200", "GET", "", true},
+		{"/arthrobacter/strains", "http://localhost:4200", "GET", "", true},
+		// Only the exact origin, the subdomains and the genus are allowed
+		{"/hymenobacter/strains", "http://hymenobacter.info", "GET", "", false},
+		{"/hymenobacter/strains", "https://hymenobacter.info:8443", "GET", "", false},
+		{"/hymenobacter/strains", "https://bactdb.org", "GET", "", false},
+		{"/hymenobacter/strains", "https://evilbactdb.org", "GET", "", false},
+		{"/hymenobacter/strains", "http://localhost:4200", "GET", "", false},
+		{"/authenticate", "http://localhost:4200", "POST", "", false},
+		// Only the methods and headers configured
+		{"/hymenobacter/strains", "https://hymenobacter.info", "TRACE", "", false},
+		{"/hymenobacter/strains", "https://hymenobacter.info", "POST", "Content-Type, X-Smuggled", false},
+	} {
+		w := serve(preflight(test.path, test.origin, test.method, test.headers))
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s from %s got %d, want 204", test.path, test.origin, w.Code)
+		}
+		got := w.Header().Get("Access-Control-Allow-Origin")
+		if test.allowed && (got != test.origin || w.Header().Get("Access-Control-Max-Age") != "600" ||
+			w.Header().Get("Access-Control-Allow-Methods") != "GET, POST, PUT, PATCH, DELETE") {
+			t.Errorf("%s %s from %s with %q not allowed: %v", test.method, test.path, test.origin, test.headers, w.Header())
+		}
+		if !test.allowed && got != "" {
+			t.Errorf("%s %s from %s with %q allowed", test.method, test.path, test.origin, test.headers)
+		}
+		if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("credentials allowed without being configured")
+		}
+		if vary := w.Header().Values("Vary"); len(vary) != 3 || vary[0] != "Origin" {
+			t.Errorf("varies by %v", vary)
+		}
+	}
+}
+
+func TestCORSRequests(t *testing.T) {
+	policy := DefaultOptions().CORS
+	policy.Origins = []string{"*"}
+	policy.Credentials = true
+	policy.MaxAge = time.Hour
+	serve := corsTest(policy)
+
+	r := httptest.NewRequest("GET", "/hymenobacter/strains", nil)
+	r.Header.Set("Origin", "https://anywhere.example.com")
+	w := serve(r)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "https://anywhere.example.com" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" || w.Header().Get("Access-Control-Expose-Headers") != "ETag, Idempotent-Replayed, Retry-After, X-Request-Id" {
+		t.Errorf("got %d %v", w.Code, w.Header())
+	}
+
+	w = serve(preflight("/hymenobacter/strains", "https://anywhere.example.com", "PUT", ""))
+	if w.Header().Get("Access-Control-Max-Age") != "3600" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("preflight got %v", w.Header())
+	}
+
+	r.Header.Set("Origin", "null")
+	if w = serve(r); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("null origin allowed")
+	}
+
+	// Same origin requests are left alone
+	if w = serve(httptest.NewRequest("GET", "/hymenobacter/strains", nil)); w.Code != http.StatusOK || len(w.Header()) != 0 {
+		t.Errorf("got %d %v", w.Code, w.Header())
+	}
+}
+
+func TestDefaultCORS(t *testing.T) {
+	// The headers the handlers read and send are allowed by default
+	p := DefaultOptions().CORS
+	for _, h := range []string{idempotencyHeader, requestIDHeader} {
+		if !contains(p.Headers, h) || h == requestIDHeader && !contains(p.Expose, h) {
+			t.Errorf("%s isn't allowed in %+v", h, p)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
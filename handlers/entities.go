@@ -1,31 +1,45 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"strconv"
 
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
 	"github.com/thermokarst/bactdb/api"
+	"github.com/thermokarst/bactdb/errors"
 	"github.com/thermokarst/bactdb/helpers"
 	"github.com/thermokarst/bactdb/types"
 )
 
-func handleGetter(g api.Getter) errorHandler {
+func handleGetter(g api.Getter, f format) errorHandler {
 	return func(w http.ResponseWriter, r *http.Request) *types.AppError {
+		w.Header().Set("Content-Type", f.contentType())
+
 		id, err := strconv.ParseInt(mux.Vars(r)["ID"], 10, 0)
 		if err != nil {
-			return newJSONError(err, http.StatusInternalServerError)
+			return newJSONError(errors.ErrInvalidID, http.StatusBadRequest)
 		}
 
 		claims := helpers.GetClaims(r)
+		payloadOpt := payloadOptions(r)
 
-		e, appErr := g.Get(id, mux.Vars(r)["genus"], &claims)
+		e, appErr := g.Get(r.Context(), id, mux.Vars(r)["genus"], payloadOpt, &claims)
 		if appErr != nil {
 			return appErr
 		}
 
-		data, err := e.Marshal()
+		if tag := etag(e, f, payloadOpt); tag != "" {
+			w.Header().Set("ETag", tag)
+			if etagMatches(r.Header.Get("If-None-Match"), tag) {
+				w.WriteHeader(http.StatusNotModified)
+				return nil
+			}
+		}
+
+		data, err := f.render(r, e, true, payloadOpt)
 		if err != nil {
 			return newJSONError(err, http.StatusInternalServerError)
 		}
@@ -34,18 +48,24 @@ func handleGetter(g api.Getter) errorHandler {
 	}
 }
 
-func handleLister(l api.Lister) errorHandler {
+func handleLister(l api.Lister, f format) errorHandler {
 	return func(w http.ResponseWriter, r *http.Request) *types.AppError {
+		w.Header().Set("Content-Type", f.contentType())
+
+		if s, ok := l.(api.Streamer); ok && acceptsNDJSON(r) {
+			return streamList(w, r, s, f)
+		}
+
 		opt := r.URL.Query()
 		opt.Add("Genus", mux.Vars(r)["genus"])
 
 		claims := helpers.GetClaims(r)
 
-		es, appErr := l.List(&opt, &claims)
+		es, appErr := l.List(r.Context(), &opt, &claims)
 		if appErr != nil {
 			return appErr
 		}
-		data, err := es.Marshal()
+		data, err := f.render(r, es, false, payloadOptions(r))
 		if err != nil {
 			return newJSONError(err, http.StatusInternalServerError)
 		}
@@ -54,59 +74,150 @@ func handleLister(l api.Lister) errorHandler {
 	}
 }
 
-func handleUpdater(u api.Updater) errorHandler {
+func handleUpdater(u api.Updater, f format) errorHandler {
 	return func(w http.ResponseWriter, r *http.Request) *types.AppError {
+		w.Header().Set("Content-Type", f.contentType())
+
 		id, err := strconv.ParseInt(mux.Vars(r)["ID"], 10, 0)
 		if err != nil {
-			return newJSONError(err, http.StatusInternalServerError)
+			return newJSONError(errors.ErrInvalidID, http.StatusBadRequest)
 		}
 
-		bodyBytes, err := ioutil.ReadAll(r.Body)
+		bodyBytes, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, helpers.MaxBody))
 		if err != nil {
 			return newJSONError(err, http.StatusInternalServerError)
 		}
 
+		bodyBytes, err = f.decode(r, bodyBytes)
+		if err != nil {
+			return newJSONError(err, http.StatusBadRequest)
+		}
+
 		e, err := u.Unmarshal(bodyBytes)
 		if err != nil {
-			return newJSONError(err, http.StatusInternalServerError)
+			return newJSONError(err, http.StatusBadRequest)
 		}
 
 		claims := helpers.GetClaims(r)
 
-		appErr := u.Update(id, &e, mux.Vars(r)["genus"], &claims)
+		appErr := u.Update(r.Context(), id, &e, mux.Vars(r)["genus"], ifMatch(r), &claims)
 		if appErr != nil {
+			if appErr.Status == http.StatusPreconditionFailed {
+				return preconditionFailed(w, r, f, u, id, mux.Vars(r)["genus"], &claims, appErr)
+			}
 			return appErr
 		}
 
-		data, err := e.Marshal()
+		payloadOpt := payloadOptions(r)
+		data, err := f.render(r, e, true, payloadOpt)
 		if err != nil {
 			return newJSONError(err, http.StatusInternalServerError)
 		}
+		if tag := etag(e, f, payloadOpt); tag != "" {
+			w.Header().Set("ETag", tag)
+		}
 		w.Write(data)
 		return nil
 	}
 }
 
-func handleCreater(c api.Creater) errorHandler {
+// handlePatcher applies a JSON Merge Patch (RFC 7396) on top of the stored
+// record, and then runs it through the regular update path.
+func handlePatcher(p api.Patcher, f format) errorHandler {
 	return func(w http.ResponseWriter, r *http.Request) *types.AppError {
-		bodyBytes, err := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", f.contentType())
+
+		id, err := strconv.ParseInt(mux.Vars(r)["ID"], 10, 0)
+		if err != nil {
+			return newJSONError(errors.ErrInvalidID, http.StatusBadRequest)
+		}
+
+		bodyBytes, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, helpers.MaxBody))
 		if err != nil {
 			return newJSONError(err, http.StatusInternalServerError)
 		}
 
-		e, err := c.Unmarshal(bodyBytes)
+		bodyBytes, err = f.decode(r, bodyBytes)
+		if err != nil {
+			return newJSONError(err, http.StatusBadRequest)
+		}
+
+		// Entity attributes are flat, so merging is just decoding the patch on
+		// top of the stored record. The entity itself can't be nulled out.
+		if !bytes.HasPrefix(bytes.TrimSpace(bodyBytes), []byte("{")) {
+			return newJSONError(errors.ErrInvalidPatch, http.StatusBadRequest)
+		}
+		var patch map[string]json.RawMessage
+		if err := json.Unmarshal(bodyBytes, &patch); err != nil {
+			return newJSONError(err, http.StatusBadRequest)
+		}
+		for _, attrs := range patch {
+			if !bytes.HasPrefix(bytes.TrimSpace(attrs), []byte("{")) {
+				return newJSONError(errors.ErrInvalidPatch, http.StatusBadRequest)
+			}
+		}
+
+		claims := helpers.GetClaims(r)
+
+		e, appErr := p.Get(r.Context(), id, mux.Vars(r)["genus"], helpers.PayloadOptions{Include: []string{}}, &claims)
+		if appErr != nil {
+			return appErr
+		}
+
+		if err := json.Unmarshal(bodyBytes, e); err != nil {
+			return newJSONError(err, http.StatusBadRequest)
+		}
+
+		// The patch only applies on top of the version in If-Match, which the
+		// update checks against the record as it's written
+		appErr = p.Update(r.Context(), id, &e, mux.Vars(r)["genus"], ifMatch(r), &claims)
+		if appErr != nil {
+			if appErr.Status == http.StatusPreconditionFailed {
+				return preconditionFailed(w, r, f, p, id, mux.Vars(r)["genus"], &claims, appErr)
+			}
+			return appErr
+		}
+
+		payloadOpt := payloadOptions(r)
+		data, err := f.render(r, e, true, payloadOpt)
 		if err != nil {
 			return newJSONError(err, http.StatusInternalServerError)
 		}
+		if tag := etag(e, f, payloadOpt); tag != "" {
+			w.Header().Set("ETag", tag)
+		}
+		w.Write(data)
+		return nil
+	}
+}
+
+func handleCreater(c api.Creater, f format) errorHandler {
+	create := func(w http.ResponseWriter, r *http.Request) *types.AppError {
+		w.Header().Set("Content-Type", f.contentType())
+
+		bodyBytes, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, helpers.MaxBody))
+		if err != nil {
+			return newJSONError(err, http.StatusInternalServerError)
+		}
+
+		bodyBytes, err = f.decode(r, bodyBytes)
+		if err != nil {
+			return newJSONError(err, http.StatusBadRequest)
+		}
+
+		e, err := c.Unmarshal(bodyBytes)
+		if err != nil {
+			return newJSONError(err, http.StatusBadRequest)
+		}
 
 		claims := helpers.GetClaims(r)
 
-		appErr := c.Create(&e, mux.Vars(r)["genus"], &claims)
+		appErr := c.Create(r.Context(), &e, mux.Vars(r)["genus"], &claims)
 		if appErr != nil {
 			return appErr
 		}
 
-		data, err := e.Marshal()
+		data, err := f.render(r, e, true, payloadOptions(r))
 		if err != nil {
 			return newJSONError(err, http.StatusInternalServerError)
 		}
@@ -116,19 +227,25 @@ func handleCreater(c api.Creater) errorHandler {
 
 		return nil
 	}
+	return create
 }
 
-func handleDeleter(d api.Deleter) errorHandler {
+func handleDeleter(d api.Deleter, f format) errorHandler {
 	return func(w http.ResponseWriter, r *http.Request) *types.AppError {
+		w.Header().Set("Content-Type", f.contentType())
+
 		id, err := strconv.ParseInt(mux.Vars(r)["ID"], 10, 0)
 		if err != nil {
-			return newJSONError(err, http.StatusInternalServerError)
+			return newJSONError(errors.ErrInvalidID, http.StatusBadRequest)
 		}
 
 		claims := helpers.GetClaims(r)
 
-		appErr := d.Delete(id, mux.Vars(r)["genus"], &claims)
+		appErr := d.Delete(r.Context(), id, mux.Vars(r)["genus"], ifMatch(r), &claims)
 		if appErr != nil {
+			if appErr.Status == http.StatusPreconditionFailed {
+				return preconditionFailed(w, r, f, d, id, mux.Vars(r)["genus"], &claims, appErr)
+			}
 			return appErr
 		}
 
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/thermokarst/bactdb/api"
+	"github.com/thermokarst/bactdb/models"
+)
+
+func TestPatchRoutes(t *testing.T) {
+	routes := make(map[string]bool)
+	for _, rt := range newServer(api.NewApp(models.NewMemory(), nil), DefaultOptions()).routes() {
+		routes[rt.method+" "+rt.path] = true
+	}
+	for _, entity := range []string{"species", "strains", "characteristics", "measurements", "users"} {
+		for _, path := range []string{"/{genus}/" + entity + "/{ID:.+}", "/v2/{genus}/" + entity + "/{ID:.+}"} {
+			if !routes["PATCH "+path] {
+				t.Errorf("no PATCH %s", path)
+			}
+		}
+	}
+}
+
+func TestPatch(t *testing.T) {
+	at := newAPITest(t)
+	const g = "/hymenobacter"
+
+	body := at.do("POST", g+"/species", at.admin, `{"species":{"speciesName":"H. roseus"}}`, http.StatusCreated)
+	species := at.id(body, "species")
+	body = at.do("POST", g+"/strains", at.admin, fmt.Sprintf(`{"strain":{"strainName":"AA-1","species":%d,"notes":"from a pond","genbank":"AB123"}}`, species), http.StatusCreated)
+	strain := fmt.Sprintf("%s/strains/%d", g, at.id(body, "strain"))
+
+	// null clears a field, and what's left out is kept
+	at.do("PATCH", strain, at.admin, `{"strain":{"notes":null}}`, http.StatusOK)
+	var got struct {
+		Strain map[string]interface{} `json:"strain"`
+	}
+	if err := json.Unmarshal(at.do("GET", strain, at.reader, nil, http.StatusOK), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Strain["notes"] != nil || got.Strain["genbank"] != "AB123" || got.Strain["strainName"] != "AA-1" {
+		t.Errorf("patched strain got %v", got.Strain)
+	}
+
+	// The patched record is validated like any other update
+	at.do("PATCH", strain, at.admin, `{"strain":{"strainName":""}}`, http.StatusUnprocessableEntity)
+
+	// Patches have to be an object of entity attributes
+	for _, patch := range []string{`[{"strain":{}}]`, `"AA-2"`, `null`, `{"strain":5}`, `{"strain":null}`} {
+		w := at.record(at.request("PATCH", strain, at.admin, patch), http.StatusBadRequest)
+		if e := envelope(t, w); e.Code != "patch_invalid" {
+			t.Errorf("%s got %+v", patch, e)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thermokarst/bactdb/api"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// etag derives an entity tag for a representation of an entity. It's the
+// version of the entity's primary record, its last modification time, then a
+// hash of everything else that changes the representation: the format, the
+// include and fields parameters, and the versions of the sideloaded records.
+// Entities that aren't versioned get no tag.
+func etag(e types.Entity, f format, opt helpers.PayloadOptions) string {
+	v, ok := e.(types.VersionedEntity)
+	if !ok {
+		return ""
+	}
+	t := v.LastModified()
+	if t.IsZero() {
+		return ""
+	}
+	// Postgres stores timestamps with microsecond precision.
+	version := strconv.FormatInt(t.UnixNano()/int64(time.Microsecond), 36)
+	return `"` + version + "-" + variant(e, f, opt) + `"`
+}
+
+// variant hashes the format and payload options of a representation, and
+// whatever it sideloads. Options that only differ in order, or by repeating
+// themselves, give the same hash.
+func variant(e types.Entity, f format, opt helpers.PayloadOptions) string {
+	h := fnv.New32a()
+	h.Write([]byte(f.contentType()))
+	if opt.Include == nil {
+		// Everything is included, which isn't the same as include=
+		h.Write([]byte("\x00*"))
+	} else {
+		h.Write([]byte("\x00include=" + strings.Join(normalize(opt.Include), ",")))
+	}
+	names := make([]string, 0, len(opt.Fields))
+	for typ := range opt.Fields {
+		names = append(names, typ)
+	}
+	sort.Strings(names)
+	for _, typ := range names {
+		h.Write([]byte("\x00fields[" + typ + "]=" + strings.Join(normalize(opt.Fields[typ]), ",")))
+	}
+	if inc, ok := e.(types.IncludingEntity); ok {
+		h.Write([]byte("\x00included="))
+		for _, t := range inc.IncludedModified() {
+			h.Write([]byte(strconv.FormatInt(t.UnixNano()/int64(time.Microsecond), 36) + ","))
+		}
+	}
+	return strconv.FormatUint(uint64(h.Sum32()), 36)
+}
+
+// normalize sorts a list of parameters, leaving out repeats.
+func normalize(params []string) []string {
+	sorted := append([]string(nil), params...)
+	sort.Strings(sorted)
+	n := 0
+	for i, p := range sorted {
+		if i == 0 || p != sorted[n-1] {
+			sorted[n] = p
+			n++
+		}
+	}
+	return sorted[:n]
+}
+
+// etagMatches reports whether a tag appears in an If-None-Match header value.
+func etagMatches(header, tag string) bool {
+	if tag == "" {
+		return false
+	}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch parses the If-Match header into a precondition on a write. Writes
+// replace records, not representations, so only the versions of the tags
+// count. Without the header, or with *, any version can be written. Tags that
+// weren't made by etag allow none.
+func ifMatch(r *http.Request) types.Precondition {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	p := types.Precondition{}
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return nil
+		}
+		t = strings.Trim(strings.TrimPrefix(t, "W/"), `"`)
+		version, _, _ := strings.Cut(t, "-")
+		micros, err := strconv.ParseInt(version, 36, 64)
+		if err != nil {
+			continue
+		}
+		p = append(p, time.Unix(0, micros*int64(time.Microsecond)))
+	}
+	return p
+}
+
+// preconditionFailed answers a write that was turned down because the record
+// changed since the version in If-Match. The current version is sent back with
+// the 412, when it can be read.
+func preconditionFailed(w http.ResponseWriter, r *http.Request, f format, svc interface{}, id int64, genus string, claims *types.Claims, appErr *types.AppError) *types.AppError {
+	g, isGetter := svc.(api.Getter)
+	if !isGetter {
+		return appErr
+	}
+
+	payloadOpt := payloadOptions(r)
+	current, getErr := g.Get(r.Context(), id, genus, payloadOpt, claims)
+	if getErr != nil {
+		return getErr
+	}
+
+	data, err := f.render(r, current, true, payloadOpt)
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+	if tag := etag(current, f, payloadOpt); tag != "" {
+		w.Header().Set("ETag", tag)
+	}
+	w.WriteHeader(http.StatusPreconditionFailed)
+	w.Write(data)
+	return nil
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thermokarst/bactdb/helpers"
+)
+
+// versioned is an entity that's only a version.
+type versioned time.Time
+
+func (v versioned) Marshal() ([]byte, error) { return []byte("{}"), nil }
+func (v versioned) LastModified() time.Time  { return time.Time(v) }
+
+func TestETag(t *testing.T) {
+	modified := time.Date(2016, 1, 2, 3, 4, 5, 6789000, time.UTC)
+	e := versioned(modified)
+
+	tag := etag(e, v1{}, helpers.PayloadOptions{Include: []string{"strains", "species"}, Fields: map[string][]string{"strains": {"strainName", "species"}}})
+	same := etag(e, v1{}, helpers.PayloadOptions{Include: []string{"species", "strains", "species"}, Fields: map[string][]string{"strains": {"species", "strainName"}}})
+	if tag == "" || tag != same {
+		t.Errorf("reordered options got %s and %s", tag, same)
+	}
+
+	// Every representation gets its own tag
+	seen := make(map[string]string)
+	for name, tag := range map[string]string{
+		"v1":            etag(e, v1{}, helpers.PayloadOptions{}),
+		"v2":            etag(e, jsonAPI{typ: "species"}, helpers.PayloadOptions{}),
+		"include=":      etag(e, v1{}, helpers.PayloadOptions{Include: []string{}}),
+		"include":       etag(e, v1{}, helpers.PayloadOptions{Include: []string{"strains"}}),
+		"fields":        etag(e, v1{}, helpers.PayloadOptions{Fields: map[string][]string{"species": {"speciesName"}}}),
+		"other fields":  etag(e, v1{}, helpers.PayloadOptions{Fields: map[string][]string{"strains": {"speciesName"}}}),
+		"later version": etag(versioned(modified.Add(time.Microsecond)), v1{}, helpers.PayloadOptions{}),
+	} {
+		if other, ok := seen[tag]; ok {
+			t.Errorf("%s and %s both got %s", name, other, tag)
+		}
+		seen[tag] = name
+	}
+
+	if tag := etag(versioned{}, v1{}, helpers.PayloadOptions{}); tag != "" {
+		t.Errorf("unversioned entity got %s", tag)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	modified := time.Date(2016, 1, 2, 3, 4, 5, 6789000, time.UTC)
+	v1Tag := etag(versioned(modified), v1{}, helpers.PayloadOptions{})
+	v2Tag := etag(versioned(modified), jsonAPI{typ: "species"}, helpers.PayloadOptions{Include: []string{}})
+
+	for _, test := range []struct {
+		header        string
+		allows, later bool
+	}{
+		{"", true, true},
+		{"*", true, true},
+		{v1Tag, true, false},
+		{"W/" + v2Tag, true, false},
+		{`"abc", ` + v2Tag, true, false},
+		{`"not a tag"`, false, false},
+		{`"-"`, false, false},
+	} {
+		r, _ := http.NewRequest("PUT", "/hymenobacter/species/1", nil)
+		if test.header != "" {
+			r.Header.Set("If-Match", test.header)
+		}
+		p := ifMatch(r)
+		if p.Allows(modified) != test.allows || p.Allows(modified.Add(time.Microsecond)) != test.later {
+			t.Errorf("If-Match: %s got %v", test.header, p)
+		}
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	at := newAPITest(t)
+	body := at.do("POST", "/hymenobacter/species", at.admin, `{"species":{"speciesName":"H. roseus"}}`, http.StatusCreated)
+	path := fmt.Sprintf("/hymenobacter/species/%d", at.id(body, "species"))
+	conditional := func(method, path, header, tag string, body interface{}, want int) string {
+		t.Helper()
+		r := at.request(method, path, at.admin, body)
+		r.Header.Set(header, tag)
+		return at.record(r, want).Header().Get("ETag")
+	}
+
+	tag := at.record(at.request("GET", path, at.reader, nil), http.StatusOK).Header().Get("ETag")
+	conditional("GET", path, "If-None-Match", tag, nil, http.StatusNotModified)
+	if other := conditional("GET", path+"?include=", "If-None-Match", tag, nil, http.StatusOK); other == tag || other == "" {
+		t.Errorf("include= got %s, like %s", other, tag)
+	}
+
+	updated := conditional("PUT", path, "If-Match", tag, `{"species":{"speciesName":"H. roseus","etymology":"rosy"}}`, http.StatusOK)
+	if updated == tag || updated == "" {
+		t.Errorf("update got %s, after %s", updated, tag)
+	}
+
+	// Writes based on the old version are turned down, with the current one
+	stale := at.request("PUT", path, at.admin, `{"species":{"speciesName":"H. ruber"}}`)
+	stale.Header.Set("If-Match", tag)
+	w := at.record(stale, http.StatusPreconditionFailed)
+	if w.Header().Get("ETag") != updated || !strings.Contains(w.Body.String(), "rosy") {
+		t.Errorf("stale update got %s %s", w.Header().Get("ETag"), w.Body)
+	}
+	conditional("PATCH", path, "If-Match", tag, `{"species":{"typeSpecies":true}}`, http.StatusPreconditionFailed)
+	conditional("DELETE", path, "If-Match", tag, nil, http.StatusPreconditionFailed)
+
+	// A tag for any representation of the current version will do
+	v2Path := "/v2" + path
+	v2Tag := conditional("GET", v2Path, "If-None-Match", updated, nil, http.StatusOK)
+	if v2Tag == updated {
+		t.Errorf("v1 and v2 both got %s", v2Tag)
+	}
+	conditional("DELETE", path, "If-Match", v2Tag, nil, http.StatusNoContent)
+}
+
+func TestETagSideloads(t *testing.T) {
+	at := newAPITest(t)
+	body := at.do("POST", "/hymenobacter/species", at.admin, `{"species":{"speciesName":"H. roseus"}}`, http.StatusCreated)
+	species := at.id(body, "species")
+	path := fmt.Sprintf("/hymenobacter/species/%d", species)
+	get := func() string {
+		t.Helper()
+		return at.record(at.request("GET", path, at.reader, nil), http.StatusOK).Header().Get("ETag")
+	}
+
+	// The species' strains are sent along with it, so their changes are
+	// changes to the species' representation, but not to the species
+	tag := get()
+	body = at.do("POST", "/hymenobacter/strains", at.admin, fmt.Sprintf(`{"strain":{"strainName":"AA-1","species":%d}}`, species), http.StatusCreated)
+	created := get()
+	at.do("PATCH", fmt.Sprintf("/hymenobacter/strains/%d", at.id(body, "strain")), at.admin, `{"strain":{"notes":"from a pond"}}`, http.StatusOK)
+	patched := get()
+	if created == tag || patched == created {
+		t.Errorf("tags %s, %s and %s after changing strains", tag, created, patched)
+	}
+
+	r := at.request("PATCH", path, at.admin, `{"species":{"typeSpecies":true}}`)
+	r.Header.Set("If-Match", tag)
+	at.record(r, http.StatusOK)
+}
+
+func TestConcurrentConditionalWrites(t *testing.T) {
+	at := newAPITest(t)
+	body := at.do("POST", "/hymenobacter/species", at.admin, `{"species":{"speciesName":"H. roseus"}}`, http.StatusCreated)
+	path := fmt.Sprintf("/hymenobacter/species/%d", at.id(body, "species"))
+	tag := at.record(at.request("GET", path, at.reader, nil), http.StatusOK).Header().Get("ETag")
+
+	// Everyone read the same version, only one of them gets to write over it
+	const writers = 8
+	codes := make(chan int, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		r := at.request("PUT", path, at.admin, fmt.Sprintf(`{"species":{"speciesName":"H. roseus","etymology":"writer %d"}}`, i))
+		r.Header.Set("If-Match", tag)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			at.h.ServeHTTP(w, r)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusPreconditionFailed] != writers-1 {
+		t.Errorf("got %v", counts)
+	}
+}
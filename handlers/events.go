@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/nytimes/gziphandler"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// keepAlive is how often an idle event stream gets a comment, to keep proxies
+// from timing it out.
+var keepAlive = 30 * time.Second
+
+// eventWriteTimeout is how long each write to an event stream gets. Streams
+// outlast the server's write timeout, so they get their own deadline for each
+// write instead.
+const eventWriteTimeout = time.Minute
+
+// handleEvents streams a genus' changes as server-sent events, until the client
+// goes away. A change event's data is the change; a reset event means changes
+// may have been missed, and anything cached should be reloaded. Browsers can't
+// set headers on an EventSource, so the token can be given as ?token= instead.
+func (s *server) handleEvents(w http.ResponseWriter, r *http.Request) *types.AppError {
+	genus := mux.Vars(r)["genus"]
+	if _, err := s.app.Store.WithContext(r.Context()).GetGenus(genus); err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	changes, cancel := s.app.Changes.Subscribe(genus)
+	defer cancel()
+
+	rc := http.NewResponseController(serverWriter(w))
+	// Not every ResponseWriter has deadlines, tests' don't
+	rc.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flush(w)
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				// Fell behind, or the server's shutting down, either way the
+				// client will reconnect and reload
+				return nil
+			}
+			if change == nil {
+				fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+				break
+			}
+			data, err := json.Marshal(change)
+			if err != nil {
+				return nil
+			}
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return nil
+		}
+		rc.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+		flush(w)
+	}
+}
+
+// flush sends whatever has been written so far, through any gzipping.
+func flush(w http.ResponseWriter) {
+	switch w := w.(type) {
+	case gziphandler.GzipResponseWriter:
+		if f, ok := w.Writer.(interface {
+			Flush() error
+		}); ok {
+			f.Flush()
+		}
+		flush(w.ResponseWriter)
+	case *jwtErrorWriter:
+		flush(w.ResponseWriter)
+	case *statusWriter:
+		flush(w.ResponseWriter)
+	case http.Flusher:
+		w.Flush()
+	}
+}
+
+// serverWriter finds the server's own ResponseWriter, under any of ours and
+// gzip's, which don't unwrap.
+func serverWriter(w http.ResponseWriter) http.ResponseWriter {
+	for {
+		switch ww := w.(type) {
+		case gziphandler.GzipResponseWriter:
+			w = ww.ResponseWriter
+		case *jwtErrorWriter:
+			w = ww.ResponseWriter
+		case *statusWriter:
+			w = ww.ResponseWriter
+		default:
+			return w
+		}
+	}
+}
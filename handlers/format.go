@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/payloads"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// format is how a version of the API represents entities. The services only
+// speak the v1 payloads, so other formats translate to and from those.
+type format interface {
+	contentType() string
+	// render serializes an entity, which is either a single record or a list
+	render(r *http.Request, e types.Entity, single bool, opt helpers.PayloadOptions) ([]byte, error)
+	// decode translates a request body into a v1 payload
+	decode(r *http.Request, body []byte) ([]byte, error)
+	// line renders a single entity payload as a line of a NDJSON stream
+	line(r *http.Request, data []byte) ([]byte, error)
+}
+
+// v1 is the original API, served under /{genus}. It's frozen.
+type v1 struct{}
+
+func (v1) contentType() string {
+	return "application/json; charset=UTF-8"
+}
+
+func (v1) render(r *http.Request, e types.Entity, single bool, opt helpers.PayloadOptions) ([]byte, error) {
+	return marshalPayload(e, opt)
+}
+
+func (v1) decode(r *http.Request, body []byte) ([]byte, error) {
+	return body, nil
+}
+
+// line is the bare entity, without the payload's root key.
+func (v1) line(r *http.Request, data []byte) ([]byte, error) {
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	for _, entity := range root {
+		return entity, nil
+	}
+	return nil, nil
+}
+
+// jsonAPI is the JSON:API format of the v2 API, served under /v2/{genus}.
+type jsonAPI struct {
+	// typ is the type of the route's primary resources
+	typ string
+}
+
+func (jsonAPI) contentType() string {
+	return payloads.JSONAPIMediaType
+}
+
+func (j jsonAPI) render(r *http.Request, e types.Entity, single bool, opt helpers.PayloadOptions) ([]byte, error) {
+	data, err := marshalPayload(e, opt)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := payloads.NewDocument(data, j.typ, single, "/api/v2/"+mux.Vars(r)["genus"])
+	if err != nil {
+		return nil, err
+	}
+	doc.Links = map[string]string{"self": "/api" + r.URL.RequestURI()}
+	return doc.Marshal()
+}
+
+func (j jsonAPI) decode(r *http.Request, body []byte) ([]byte, error) {
+	return payloads.ParseDocument(body, j.typ, mux.Vars(r)["ID"])
+}
+
+// line is the entity's resource object.
+func (j jsonAPI) line(r *http.Request, data []byte) ([]byte, error) {
+	doc, err := payloads.NewDocument(data, j.typ, true, "/api/v2/"+mux.Vars(r)["genus"])
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc.Data)
+}
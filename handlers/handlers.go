@@ -1,72 +1,346 @@
 package handlers
 
 import (
+	"log/slog"
 	"net/http"
+	"sync"
 
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/nytimes/gziphandler"
 	"github.com/thermokarst/bactdb/api"
-	"github.com/thermokarst/bactdb/auth"
+	"github.com/thermokarst/bactdb/graphql"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/payloads"
+	"github.com/thermokarst/bactdb/types"
 )
 
-// Handler is the root HTTP handler for bactdb.
-func Handler() http.Handler {
-	m := mux.NewRouter()
-	userService := api.UserService{}
-	strainService := api.StrainService{}
-	speciesService := api.SpeciesService{}
-	characteristicService := api.CharacteristicService{}
-	measurementService := api.MeasurementService{}
+// route is an entry in the route table, which is both what gets served and
+// what the OpenAPI document is generated from.
+type route struct {
+	method  string
+	path    string
+	handler http.Handler
+	// secure routes require a valid token
+	secure bool
+	doc    operation
+}
 
-	m.Handle("/authenticate", tokenHandler(auth.Middleware.Authenticate())).Methods("POST")
-	m.Handle("/refresh", auth.Middleware.Secure(errorHandler(tokenRefresh(auth.Middleware)), verifyClaims)).Methods("POST")
+// server serves an app's API.
+type server struct {
+	app *api.App
+	opt Options
+	// keys are the Idempotency-Keys sent with creates
+	keys models.KeyRepository
 
-	// Everything past here is lumped under a genus
-	s := m.PathPrefix("/{genus}").Subrouter()
+	openAPIOnce sync.Once
+	openAPIData []byte
+	openAPIErr  error
+}
 
-	s.Handle("/users", errorHandler(handleCreater(userService))).Methods("POST")
-	s.Handle("/users/verify/{Nonce}", errorHandler(api.HandleUserVerify)).Methods("GET")
-	s.Handle("/users/lockout", errorHandler(api.HandleUserLockout)).Methods("POST")
+func newServer(app *api.App, opt Options) *server {
+	return &server{
+		app:  app,
+		opt:  opt,
+		keys: app.Store.Keys(idempotencyPeriod),
+	}
+}
 
-	s.Handle("/compare", auth.Middleware.Secure(errorHandler(api.HandleCompare), verifyClaims)).Methods("GET")
+// Options are how a Handler serves, beyond the app behind it.
+type Options struct {
+	// CORS is the cross-origin policy.
+	CORS types.CORSPolicy
+	// Throttle is the rate limiting of the routes that don't take a token.
+	Throttle types.Throttling
+	// AccessLog is where requests are logged, slog's default when nil.
+	AccessLog *slog.Logger
+	// Version is the API version reported in the OpenAPI document.
+	Version string
+}
 
-	type r struct {
-		f errorHandler
-		m string
-		p string
+// DefaultOptions are the options a Handler has unless they're changed.
+func DefaultOptions() Options {
+	return Options{
+		CORS:     types.DefaultCORS(),
+		Throttle: types.DefaultThrottling(),
+		Version:  "0.1.0",
 	}
+}
 
-	// Everything past this point requires a valid token
-	routes := []r{
-		r{handleLister(userService), "GET", "/users"},
-		r{api.HandleUserPasswordChange, "POST", "/users/password"},
-		r{handleGetter(userService), "GET", "/users/{ID:.+}"},
-		r{handleUpdater(userService), "PUT", "/users/{ID:.+}"},
-		r{handleLister(speciesService), "GET", "/species"},
-		r{handleCreater(speciesService), "POST", "/species"},
-		r{handleGetter(speciesService), "GET", "/species/{ID:.+}"},
-		r{handleUpdater(speciesService), "PUT", "/species/{ID:.+}"},
-		r{handleDeleter(speciesService), "DELETE", "/species/{ID:.+}"},
-		r{handleLister(strainService), "GET", "/strains"},
-		r{handleCreater(strainService), "POST", "/strains"},
-		r{handleGetter(strainService), "GET", "/strains/{ID:.+}"},
-		r{handleUpdater(strainService), "PUT", "/strains/{ID:.+}"},
-		r{handleDeleter(strainService), "DELETE", "/strains/{ID:.+}"},
-		r{handleLister(characteristicService), "GET", "/characteristics"},
-		r{handleCreater(characteristicService), "POST", "/characteristics"},
-		r{handleGetter(characteristicService), "GET", "/characteristics/{ID:.+}"},
-		r{handleUpdater(characteristicService), "PUT", "/characteristics/{ID:.+}"},
-		r{handleDeleter(characteristicService), "DELETE", "/characteristics/{ID:.+}"},
-		r{handleLister(measurementService), "GET", "/measurements"},
-		r{handleCreater(measurementService), "POST", "/measurements"},
-		r{handleGetter(measurementService), "GET", "/measurements/{ID:.+}"},
-		r{handleUpdater(measurementService), "PUT", "/measurements/{ID:.+}"},
-		r{handleDeleter(measurementService), "DELETE", "/measurements/{ID:.+}"},
+// Handler is the root HTTP handler for bactdb, serving app.
+func Handler(app *api.App, opt Options) http.Handler {
+	s := newServer(app, opt)
+	return accessLogger(opt.AccessLog, opt.Throttle.TrustProxy, jsonHandler(gziphandler.GzipHandler(corsHandler(opt.CORS, s.router()))))
+}
+
+func (s *server) router() *mux.Router {
+	m := mux.NewRouter()
+
+	for _, rt := range s.routes() {
+		h := rt.handler
+		if rt.doc.idempotent {
+			h = idempotent(s.keys, h)
+		}
+		if rt.secure {
+			h = s.secure(h)
+		}
+		m.Handle(rt.path, instrument(rt.path, h)).Methods(rt.method)
+	}
+	m.NotFoundHandler = instrument("unmatched", http.NotFoundHandler())
+
+	return m
+}
+
+func (s *server) routes() []route {
+	a := s.app
+	userService := api.UserService{App: a}
+	strainService := api.StrainService{App: a}
+	speciesService := api.SpeciesService{App: a}
+	characteristicService := api.CharacteristicService{App: a}
+	measurementService := api.MeasurementService{App: a}
+
+	// The v2 routes come first, so that v2 is never taken for a genus
+	users := resource{userService, "/v2/{genus}/users", "users", jsonAPI{"users"}, payloads.Document{}, payloads.Document{}}
+	rs := []route{
+		users.list(),
+		s.signup(users.create()),
+		users.get(),
+		users.patch(),
 	}
+	rs = append(rs, resource{speciesService, "/v2/{genus}/species", "species", jsonAPI{"species"}, payloads.Document{}, payloads.Document{}}.jsonAPI()...)
+	rs = append(rs, resource{strainService, "/v2/{genus}/strains", "strains", jsonAPI{"strains"}, payloads.Document{}, payloads.Document{}}.jsonAPI()...)
+	rs = append(rs, resource{characteristicService, "/v2/{genus}/characteristics", "characteristics", jsonAPI{"characteristics"}, payloads.Document{}, payloads.Document{}}.jsonAPI()...)
+	measurementsV2 := resource{measurementService, "/v2/{genus}/measurements", "measurements", jsonAPI{"measurements"}, payloads.Document{}, payloads.Document{}}.jsonAPI()
+	measurementsV2[0].doc.query = helpers.MeasurementListOptions{}
+	rs = append(rs, measurementsV2...)
+
+	rs = append(rs, []route{
+		{"GET", "/openapi.json", errorHandler(s.handleOpenAPI), false, operation{
+			summary:  "This document",
+			tag:      "meta",
+			response: map[string]interface{}{},
+		}},
+		{"POST", "/authenticate", throttled(s.opt.Throttle, tokenHandler(a.Auth.Authenticate()), a.Store.LockedUntil), false, operation{
+			summary:   "Log in",
+			tag:       "auth",
+			throttled: true,
+			form:      credentials{},
+			response:  accessToken{},
+		}},
+		{"POST", "/refresh", errorHandler(s.tokenRefresh), true, operation{
+			summary:  "Reissue a token",
+			tag:      "auth",
+			response: accessToken{},
+		}},
+	}...)
+
+	// Everything past here is lumped under a genus
+	users = resource{userService, "/{genus}/users", "users", v1{}, payloads.User{}, payloads.Users{}}
+	rs = append(rs, []route{
+		s.signup(users.create()),
+		{"GET", "/{genus}/users/verify/{Nonce}", errorHandler(a.HandleUserVerify), false, operation{
+			summary:  "Verify a new account",
+			tag:      "users",
+			response: message{},
+		}},
+		{"POST", "/{genus}/users/lockout", throttled(s.opt.Throttle, errorHandler(a.HandleUserLockout), nil), false, operation{
+			summary:   "Email a password reset link",
+			tag:       "users",
+			throttled: true,
+			form:      lockout{},
+			response:  struct{}{},
+		}},
+		{"GET", "/{genus}/compare", errorHandler(a.HandleCompare), true, operation{
+			summary:  "Compare measurements across strains",
+			tag:      "measurements",
+			query:    compareOptions{},
+			response: [][]string{},
+		}},
+	}...)
+
+	// Everything past this point requires a valid token
+	rs = append(rs, []route{
+		users.list(),
+		{"POST", "/{genus}/users/password", errorHandler(a.HandleUserPasswordChange), true, operation{
+			summary: "Change your password",
+			tag:     "users",
+			form:    passwordChange{},
+			status:  http.StatusNoContent,
+		}},
+		users.get(),
+		users.update(),
+		users.patch(),
+	}...)
+	rs = append(rs, []route{
+		{"GET", "/{genus}/graphql", errorHandler(a.HandleGraphQL), true, operation{
+			summary:  "Run a GraphQL query",
+			tag:      "graphql",
+			query:    graphQLQuery{},
+			response: graphql.Response{},
+		}},
+		{"POST", "/{genus}/graphql", errorHandler(a.HandleGraphQL), true, operation{
+			summary:  "Run a GraphQL query",
+			tag:      "graphql",
+			request:  graphql.Request{},
+			response: graphql.Response{},
+		}},
+		{"GET", "/{genus}/graphql/schema", errorHandler(api.HandleGraphQLSchema), true, operation{
+			summary:   "The GraphQL schema, in the schema language",
+			tag:       "graphql",
+			mediaType: "text/plain",
+			response:  "",
+		}},
+		{"GET", "/{genus}/changes", errorHandler(a.HandleChanges), true, operation{
+			summary:  "Everything created, updated or deleted since a sync",
+			tag:      "sync",
+			query:    syncOptions{},
+			response: payloads.Changes{},
+		}},
+		{"GET", "/{genus}/events", errorHandler(s.handleEvents), true, operation{
+			summary:   "Stream changes as server-sent events",
+			tag:       "events",
+			mediaType: "text/event-stream",
+			response:  "",
+		}},
+	}...)
+	rs = append(rs, resource{speciesService, "/{genus}/species", "species", v1{}, payloads.Species{}, payloads.ManySpecies{}}.crud()...)
+	rs = append(rs, resource{strainService, "/{genus}/strains", "strains", v1{}, payloads.Strain{}, payloads.Strains{}}.crud()...)
+	rs = append(rs, resource{characteristicService, "/{genus}/characteristics", "characteristics", v1{}, payloads.Characteristic{}, payloads.Characteristics{}}.crud()...)
+
+	measurements := resource{measurementService, "/{genus}/measurements", "measurements", v1{}, payloads.Measurement{}, payloads.Measurements{}}.crud()
+	measurements[0].doc.query = helpers.MeasurementListOptions{}
+	// The batch route has to come before the routes matching an ID
+	rs = append(rs, measurements[:2]...)
+	rs = append(rs, route{"POST", "/{genus}/measurements/batch", errorHandler(a.HandleMeasurementBatch), true, operation{
+		summary:  "Create, update and delete many measurements",
+		tag:      "measurements",
+		request:  payloads.MeasurementBatch{},
+		response: payloads.MeasurementBatchResults{},
+	}})
+	rs = append(rs, measurements[2:]...)
 
-	for _, route := range routes {
-		s.Handle(route.p, auth.Middleware.Secure(errorHandler(route.f), verifyClaims)).Methods(route.m)
+	webhooks := resource{api.WebhookService{App: a}, "/{genus}/webhooks", "webhooks", v1{}, payloads.Webhook{}, payloads.Webhooks{}}.crud()
+	rs = append(rs, webhooks[:2]...)
+	rs = append(rs, route{"GET", "/{genus}/webhooks/{ID:[0-9]+}/deliveries", errorHandler(a.HandleWebhookDeliveries), true, operation{
+		summary:  "The latest attempts at delivering to a webhook",
+		tag:      "webhooks",
+		query:    deliveryOptions{},
+		response: payloads.WebhookDeliveries{},
+	}})
+	rs = append(rs, webhooks[2:]...)
+
+	return rs
+}
+
+func withSecure(rt route, secure bool) route {
+	rt.secure = secure
+	return rt
+}
+
+// signup opens up a users create route, rate limited, as it sends email.
+func (s *server) signup(rt route) route {
+	rt = withSecure(rt, false)
+	rt.handler = throttled(s.opt.Throttle, rt.handler, nil)
+	rt.doc.throttled = true
+	return rt
+}
+
+// resource is an entity type's service, mounted at a collection path in one
+// of the API's formats. one and many are the documented payloads.
+type resource struct {
+	svc       interface{}
+	path      string
+	tag       string
+	f         format
+	one, many interface{}
+}
+
+// crud is the standard set of v1 routes for an entity type.
+func (res resource) crud() []route {
+	return []route{res.list(), res.create(), res.get(), res.update(), res.patch(), res.delete()}
+}
+
+// jsonAPI is the standard set of v2 routes for an entity type. JSON:API
+// updates are always partial, so there's no PUT.
+func (res resource) jsonAPI() []route {
+	return []route{res.list(), res.create(), res.get(), res.patch(), res.delete()}
+}
+
+func (res resource) member() string {
+	return res.path + "/{ID:.+}"
+}
+
+func (res resource) list() route {
+	_, stream := res.svc.(api.Streamer)
+	return route{"GET", res.path, errorHandler(handleLister(res.svc.(api.Lister), res.f)), true, operation{
+		summary:   "List " + res.tag,
+		tag:       res.tag,
+		query:     helpers.ListOptions{},
+		payload:   true,
+		ndjson:    stream,
+		mediaType: res.f.contentType(),
+		response:  res.many,
+	}}
+}
+
+func (res resource) create() route {
+	return route{"POST", res.path, errorHandler(handleCreater(res.svc.(api.Creater), res.f)), true, operation{
+		summary:    "Create one of the " + res.tag,
+		tag:        res.tag,
+		payload:    true,
+		idempotent: true,
+		mediaType:  res.f.contentType(),
+		request:    res.one,
+		response:   res.one,
+		status:     http.StatusCreated,
+	}}
+}
+
+func (res resource) get() route {
+	return route{"GET", res.member(), errorHandler(handleGetter(res.svc.(api.Getter), res.f)), true, operation{
+		summary:   "Get one of the " + res.tag,
+		tag:       res.tag,
+		payload:   true,
+		etag:      true,
+		mediaType: res.f.contentType(),
+		response:  res.one,
+	}}
+}
+
+func (res resource) update() route {
+	return route{"PUT", res.member(), errorHandler(handleUpdater(res.svc.(api.Updater), res.f)), true, operation{
+		summary:   "Replace one of the " + res.tag,
+		tag:       res.tag,
+		payload:   true,
+		etag:      true,
+		mediaType: res.f.contentType(),
+		request:   res.one,
+		response:  res.one,
+	}}
+}
+
+func (res resource) patch() route {
+	requestType := "application/merge-patch+json"
+	if _, ok := res.f.(jsonAPI); ok {
+		requestType = res.f.contentType()
 	}
+	return route{"PATCH", res.member(), errorHandler(handlePatcher(res.svc.(api.Patcher), res.f)), true, operation{
+		summary:     "Update some attributes of one of the " + res.tag,
+		tag:         res.tag,
+		payload:     true,
+		etag:        true,
+		mediaType:   res.f.contentType(),
+		request:     res.one,
+		contentType: requestType,
+		response:    res.one,
+	}}
+}
 
-	return jsonHandler(gziphandler.GzipHandler(corsHandler(m)))
+func (res resource) delete() route {
+	return route{"DELETE", res.member(), errorHandler(handleDeleter(res.svc.(api.Deleter), res.f)), true, operation{
+		summary: "Delete one of the " + res.tag,
+		tag:     res.tag,
+		etag:    true,
+		status:  http.StatusNoContent,
+	}}
 }
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/thermokarst/bactdb/api"
+	"github.com/thermokarst/bactdb/auth"
+	"github.com/thermokarst/bactdb/graphql"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/models"
+)
+
+// routeKey stands in for a route's handler, so that a request can be matched
+// back to its entry in the route table.
+type routeKey string
+
+func (routeKey) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// apiTest runs requests through the whole handler, against memory, keeping
+// track of which routes they hit.
+type apiTest struct {
+	t       *testing.T
+	h       http.Handler
+	routes  *mux.Router
+	covered map[routeKey]bool
+	// tokens are by user
+	admin, reader string
+	// IDs are too
+	adminID, readerID int64
+}
+
+func newAPITest(t *testing.T) *apiTest {
+	mem := models.NewMemory()
+	mem.AddGenus("Hymenobacter")
+	mem.AddTextMeasurementType("pink")
+	mem.AddTextMeasurementType("red")
+	admin, err := mem.AddUser(models.UserBase{Email: "admin@example.com", Name: "Admin", Role: "A"}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := mem.AddUser(models.UserBase{Email: "reader@example.com", Name: "Reader", Role: "R"}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mw, err := auth.New("test secret", mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := api.NewApp(mem, mw)
+	opt := DefaultOptions()
+	opt.AccessLog = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	at := &apiTest{
+		t:        t,
+		h:        Handler(app, opt),
+		routes:   mux.NewRouter(),
+		covered:  make(map[routeKey]bool),
+		adminID:  admin.ID,
+		readerID: reader.ID,
+	}
+	for _, rt := range newServer(app, opt).routes() {
+		at.routes.Handle(rt.path, routeKey(rt.method+" "+rt.path)).Methods(rt.method)
+	}
+	if at.admin, err = mw.CreateToken("admin@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if at.reader, err = mw.CreateToken("reader@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	return at
+}
+
+// request builds a request, sent as token when it's set. Bodies are JSON
+// unless they're url.Values, which are sent as a form.
+func (at *apiTest) request(method, path, token string, body interface{}) *http.Request {
+	var r *http.Request
+	switch b := body.(type) {
+	case nil:
+		r = httptest.NewRequest(method, path, nil)
+	case url.Values:
+		r = httptest.NewRequest(method, path, strings.NewReader(b.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	case string:
+		r = httptest.NewRequest(method, path, strings.NewReader(b))
+		r.Header.Set("Content-Type", "application/json")
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			at.t.Fatal(err)
+		}
+		r = httptest.NewRequest(method, path, strings.NewReader(string(data)))
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+// serve sends r, and fails unless the response has the status wanted.
+func (at *apiTest) serve(r *http.Request, want int) []byte {
+	at.t.Helper()
+	body, _ := ioutil.ReadAll(at.record(r, want).Body)
+	return body
+}
+
+// record is serve for when the response headers matter.
+func (at *apiTest) record(r *http.Request, want int) *httptest.ResponseRecorder {
+	at.t.Helper()
+	var match mux.RouteMatch
+	if at.routes.Match(r, &match) {
+		at.covered[match.Handler.(routeKey)] = true
+	}
+
+	w := httptest.NewRecorder()
+	at.h.ServeHTTP(w, r)
+	if w.Code != want {
+		at.t.Fatalf("%s %s got %d, want %d: %s", r.Method, r.URL, w.Code, want, w.Body)
+	}
+	return w
+}
+
+func (at *apiTest) do(method, path, token string, body interface{}, want int) []byte {
+	at.t.Helper()
+	return at.serve(at.request(method, path, token, body), want)
+}
+
+// id is the ID of the entity under key in a v1 payload.
+func (at *apiTest) id(body []byte, key string) int64 {
+	at.t.Helper()
+	var payload map[string]json.RawMessage
+	var entity struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		at.t.Fatalf("%v: %s", err, body)
+	}
+	if err := json.Unmarshal(payload[key], &entity); err != nil || entity.ID == 0 {
+		at.t.Fatalf("no %s ID in %s", key, body)
+	}
+	return entity.ID
+}
+
+// documentID is the ID of the primary resource of a JSON:API document.
+func (at *apiTest) documentID(body []byte) string {
+	at.t.Helper()
+	var doc struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil || doc.Data.ID == "" {
+		at.t.Fatalf("no resource ID in %s", body)
+	}
+	return doc.Data.ID
+}
+
+func TestHandlerRoutes(t *testing.T) {
+	at := newAPITest(t)
+	admin, reader := at.admin, at.reader
+	const g = "/hymenobacter"
+
+	at.do("GET", "/openapi.json", "", nil, http.StatusOK)
+
+	body := at.do("POST", "/authenticate", "", url.Values{"username": {"admin@example.com"}, "password": {"password"}}, http.StatusOK)
+	if !strings.Contains(string(body), "access_token") {
+		t.Errorf("authenticate got %s", body)
+	}
+	at.do("POST", "/authenticate", "", url.Values{"username": {"admin@example.com"}, "password": {"wrong password"}}, http.StatusUnauthorized)
+	at.do("POST", "/refresh", admin, nil, http.StatusOK)
+
+	// v1
+	body = at.do("POST", g+"/species", admin, `{"species":{"speciesName":"H. roseus"}}`, http.StatusCreated)
+	species := at.id(body, "species")
+	at.do("GET", g+"/species", reader, nil, http.StatusOK)
+	at.do("GET", fmt.Sprintf("%s/species/%d", g, species), reader, nil, http.StatusOK)
+	at.do("PUT", fmt.Sprintf("%s/species/%d", g, species), admin, `{"species":{"speciesName":"H. roseus","etymology":"rosy"}}`, http.StatusOK)
+	at.do("PATCH", fmt.Sprintf("%s/species/%d", g, species), admin, `{"species":{"typeSpecies":true}}`, http.StatusOK)
+
+	body = at.do("POST", g+"/strains", admin, fmt.Sprintf(`{"strain":{"strainName":"AA-1","species":%d}}`, species), http.StatusCreated)
+	strain := at.id(body, "strain")
+	at.do("GET", g+"/strains", reader, nil, http.StatusOK)
+	at.do("GET", fmt.Sprintf("%s/strains/%d", g, strain), reader, nil, http.StatusOK)
+	at.do("PUT", fmt.Sprintf("%s/strains/%d", g, strain), admin, fmt.Sprintf(`{"strain":{"strainName":"AA-1","species":%d,"typeStrain":true}}`, species), http.StatusOK)
+	at.do("PATCH", fmt.Sprintf("%s/strains/%d", g, strain), admin, `{"strain":{"notes":"from a pond"}}`, http.StatusOK)
+
+	body = at.do("POST", g+"/characteristics", admin, `{"characteristic":{"characteristicName":"Colony color","characteristicTypeName":"Phenotypic"}}`, http.StatusCreated)
+	characteristic := at.id(body, "characteristic")
+	at.do("GET", g+"/characteristics", reader, nil, http.StatusOK)
+	at.do("GET", fmt.Sprintf("%s/characteristics/%d", g, characteristic), reader, nil, http.StatusOK)
+	at.do("PUT", fmt.Sprintf("%s/characteristics/%d", g, characteristic), admin, `{"characteristic":{"characteristicName":"Colony colour","characteristicTypeName":"Phenotypic"}}`, http.StatusOK)
+	at.do("PATCH", fmt.Sprintf("%s/characteristics/%d", g, characteristic), admin, `{"characteristic":{"characteristicName":"Colony color"}}`, http.StatusOK)
+
+	body = at.do("POST", g+"/measurements", admin, fmt.Sprintf(`{"measurement":{"strain":%d,"characteristic":%d,"value":"pink"}}`, strain, characteristic), http.StatusCreated)
+	measurement := at.id(body, "measurement")
+	at.do("GET", g+"/measurements", reader, nil, http.StatusOK)
+	body = at.do("GET", fmt.Sprintf("%s/measurements/%d", g, measurement), reader, nil, http.StatusOK)
+	if !strings.Contains(string(body), `"value":"pink"`) {
+		t.Errorf("measurement got %s", body)
+	}
+	at.do("PUT", fmt.Sprintf("%s/measurements/%d", g, measurement), admin, fmt.Sprintf(`{"measurement":{"strain":%d,"characteristic":%d,"value":"red"}}`, strain, characteristic), http.StatusOK)
+	at.do("PATCH", fmt.Sprintf("%s/measurements/%d", g, measurement), admin, `{"measurement":{"notes":"after a week"}}`, http.StatusOK)
+	body = at.do("POST", g+"/measurements/batch", admin, fmt.Sprintf(`{"atomic":true,"operations":[{"op":"update","id":%d,"measurement":{"value":"pink"}}]}`, measurement), http.StatusOK)
+	if !strings.Contains(string(body), `"status":200`) {
+		t.Errorf("batch got %s", body)
+	}
+	at.do("POST", g+"/strains", admin, `{"strain":{"notes":"`+strings.Repeat("x", helpers.MaxBody)+`"}}`, http.StatusRequestEntityTooLarge)
+	at.do("PATCH", fmt.Sprintf("%s/strains/%d", g, strain), admin, `{"strain":{"notes":"`+strings.Repeat("x", helpers.MaxBody)+`"}}`, http.StatusRequestEntityTooLarge)
+	at.do("POST", g+"/measurements/batch", admin, `{"operations":[`+strings.Repeat(`{"op":"delete","id":0},`, helpers.MaxBody/20)+`]}`, http.StatusRequestEntityTooLarge)
+	body = at.do("GET", fmt.Sprintf("%s/compare?strain_ids=%d&characteristic_ids=%d", g, strain, characteristic), reader, nil, http.StatusOK)
+	if !strings.Contains(string(body), "pink") {
+		t.Errorf("compare got %s", body)
+	}
+
+	body = at.do("GET", g+"/graphql?query="+url.QueryEscape("{ species { speciesName } }"), reader, nil, http.StatusOK)
+	if !strings.Contains(string(body), "H. roseus") {
+		t.Errorf("graphql got %s", body)
+	}
+	at.do("POST", g+"/graphql", reader, `{"query":"{ genus { genusName } }"}`, http.StatusOK)
+	at.do("GET", g+"/graphql?query="+url.QueryEscape(strings.Repeat("{ species ", graphql.MaxDepth+1)), reader, nil, http.StatusBadRequest)
+	at.do("POST", g+"/graphql", reader, `{"query":"`+strings.Repeat(" ", helpers.MaxBody)+`"}`, http.StatusRequestEntityTooLarge)
+	at.do("GET", g+"/graphql/schema", reader, nil, http.StatusOK)
+	body = at.do("GET", g+"/changes", reader, nil, http.StatusOK)
+	if !strings.Contains(string(body), "AA-1") {
+		t.Errorf("changes got %s", body)
+	}
+
+	// The event stream runs until the client goes away.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	at.serve(at.request("GET", g+"/events", reader, nil).WithContext(ctx), http.StatusOK)
+
+	// v1 users. New accounts can't be seen until they're verified.
+	body = at.do("POST", g+"/users", "", `{"user":{"email":"new@example.com","name":"New","password":"new password"}}`, http.StatusCreated)
+	at.do("GET", fmt.Sprintf("%s/users/%d", g, at.id(body, "user")), admin, nil, http.StatusNotFound)
+	user := at.readerID
+	at.do("GET", g+"/users/verify/nonsense", "", nil, http.StatusNotFound)
+	at.do("POST", g+"/users/lockout", "", url.Values{"email": {"reader@example.com"}}, http.StatusOK)
+	at.do("GET", g+"/users", admin, nil, http.StatusOK)
+	at.do("GET", g+"/users", reader, nil, http.StatusForbidden)
+	at.do("GET", fmt.Sprintf("%s/users/%d", g, user), admin, nil, http.StatusOK)
+	at.do("PUT", fmt.Sprintf("%s/users/%d", g, user), admin, `{"user":{"email":"reader@example.com","name":"Reader","role":"R"}}`, http.StatusOK)
+	at.do("PATCH", fmt.Sprintf("%s/users/%d", g, user), admin, `{"user":{"name":"Rita Reader"}}`, http.StatusOK)
+	at.do("POST", g+"/users/password", reader, url.Values{"id": {fmt.Sprint(at.adminID)}, "password": {"a new password"}}, http.StatusForbidden)
+	at.do("POST", g+"/users/password", admin, url.Values{"id": {fmt.Sprint(at.adminID)}, "password": {"a new password"}}, http.StatusNoContent)
+	at.do("POST", "/authenticate", "", url.Values{"username": {"admin@example.com"}, "password": {"a new password"}}, http.StatusOK)
+
+	// v2
+	const v2 = "/v2/hymenobacter"
+	body = at.do("POST", v2+"/users", "", `{"data":{"type":"users","attributes":{"email":"v2@example.com","name":"Two","password":"v2 password"}}}`, http.StatusCreated)
+	at.do("GET", v2+"/users/"+at.documentID(body), admin, nil, http.StatusNotFound)
+	v2User := fmt.Sprint(at.readerID)
+	at.do("GET", v2+"/users", admin, nil, http.StatusOK)
+	at.do("GET", v2+"/users/"+v2User, admin, nil, http.StatusOK)
+	at.do("PATCH", v2+"/users/"+v2User, admin, fmt.Sprintf(`{"data":{"type":"users","id":%q,"attributes":{"name":"Reader"}}}`, v2User), http.StatusOK)
+
+	body = at.do("POST", v2+"/species", admin, `{"data":{"type":"species","attributes":{"speciesName":"H. ruber"}}}`, http.StatusCreated)
+	v2Species := at.documentID(body)
+	at.do("GET", v2+"/species", reader, nil, http.StatusOK)
+	at.do("GET", v2+"/species/"+v2Species, reader, nil, http.StatusOK)
+	at.do("PATCH", v2+"/species/"+v2Species, admin, fmt.Sprintf(`{"data":{"type":"species","id":%q,"attributes":{"etymology":"red"}}}`, v2Species), http.StatusOK)
+
+	body = at.do("POST", v2+"/strains", admin, fmt.Sprintf(`{"data":{"type":"strains","attributes":{"strainName":"BB-2"},"relationships":{"species":{"data":{"type":"species","id":%q}}}}}`, v2Species), http.StatusCreated)
+	v2Strain := at.documentID(body)
+	at.do("GET", v2+"/strains", reader, nil, http.StatusOK)
+	at.do("GET", v2+"/strains/"+v2Strain, reader, nil, http.StatusOK)
+	at.do("PATCH", v2+"/strains/"+v2Strain, admin, fmt.Sprintf(`{"data":{"type":"strains","id":%q,"attributes":{"typeStrain":true}}}`, v2Strain), http.StatusOK)
+
+	body = at.do("POST", v2+"/characteristics", admin, `{"data":{"type":"characteristics","attributes":{"characteristicName":"Motility","characteristicTypeName":"Phenotypic"}}}`, http.StatusCreated)
+	v2Characteristic := at.documentID(body)
+	at.do("GET", v2+"/characteristics", reader, nil, http.StatusOK)
+	at.do("GET", v2+"/characteristics/"+v2Characteristic, reader, nil, http.StatusOK)
+	at.do("PATCH", v2+"/characteristics/"+v2Characteristic, admin, fmt.Sprintf(`{"data":{"type":"characteristics","id":%q,"attributes":{"characteristicName":"Gliding motility"}}}`, v2Characteristic), http.StatusOK)
+
+	body = at.do("POST", v2+"/measurements", admin, fmt.Sprintf(`{"data":{"type":"measurements","attributes":{"value":"red"},"relationships":{"strain":{"data":{"type":"strains","id":%q}},"characteristic":{"data":{"type":"characteristics","id":%q}}}}}`, v2Strain, v2Characteristic), http.StatusCreated)
+	v2Measurement := at.documentID(body)
+	at.do("GET", v2+"/measurements", reader, nil, http.StatusOK)
+	at.do("GET", v2+"/measurements/"+v2Measurement, reader, nil, http.StatusOK)
+	at.do("PATCH", v2+"/measurements/"+v2Measurement, admin, fmt.Sprintf(`{"data":{"type":"measurements","id":%q,"attributes":{"notes":"faint"}}}`, v2Measurement), http.StatusOK)
+
+	// Webhooks are inactive, so nothing is delivered.
+	body = at.do("POST", g+"/webhooks", admin, `{"webhook":{"url":"https://example.com/hook","events":["*"],"active":false}}`, http.StatusCreated)
+	webhook := at.id(body, "webhook")
+	at.do("GET", g+"/webhooks", reader, nil, http.StatusForbidden)
+	at.do("GET", g+"/webhooks", admin, nil, http.StatusOK)
+	at.do("GET", fmt.Sprintf("%s/webhooks/%d", g, webhook), admin, nil, http.StatusOK)
+	at.do("PUT", fmt.Sprintf("%s/webhooks/%d", g, webhook), admin, `{"webhook":{"url":"https://example.com/hooks","events":["strains.create"],"active":false}}`, http.StatusOK)
+	at.do("PATCH", fmt.Sprintf("%s/webhooks/%d", g, webhook), admin, `{"webhook":{"events":["*"]}}`, http.StatusOK)
+	at.do("GET", fmt.Sprintf("%s/webhooks/%d/deliveries", g, webhook), admin, nil, http.StatusOK)
+	at.do("DELETE", fmt.Sprintf("%s/webhooks/%d", g, webhook), admin, nil, http.StatusNoContent)
+
+	// Everything goes in the reverse order it was created.
+	at.do("DELETE", v2+"/measurements/"+v2Measurement, admin, nil, http.StatusNoContent)
+	at.do("DELETE", v2+"/characteristics/"+v2Characteristic, admin, nil, http.StatusNoContent)
+	at.do("DELETE", v2+"/strains/"+v2Strain, admin, nil, http.StatusNoContent)
+	at.do("DELETE", v2+"/species/"+v2Species, admin, nil, http.StatusNoContent)
+	at.do("DELETE", fmt.Sprintf("%s/measurements/%d", g, measurement), admin, nil, http.StatusNoContent)
+	at.do("GET", fmt.Sprintf("%s/measurements/%d", g, measurement), reader, nil, http.StatusNotFound)
+	at.do("DELETE", fmt.Sprintf("%s/characteristics/%d", g, characteristic), admin, nil, http.StatusNoContent)
+	at.do("DELETE", fmt.Sprintf("%s/strains/%d", g, strain), admin, nil, http.StatusNoContent)
+	at.do("DELETE", fmt.Sprintf("%s/species/%d", g, species), admin, nil, http.StatusNoContent)
+
+	body = at.do("GET", g+"/changes?since="+url.QueryEscape(time.Now().Add(-time.Minute).Format(time.RFC3339Nano)), reader, nil, http.StatusOK)
+	if !strings.Contains(string(body), "deleted") {
+		t.Errorf("changes got %s", body)
+	}
+
+	for _, rt := range newServer(api.NewApp(models.NewMemory(), nil), DefaultOptions()).routes() {
+		if key := routeKey(rt.method + " " + rt.path); !at.covered[key] {
+			t.Errorf("%s isn't tested", key)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/thermokarst/bactdb/models"
+)
+
+// readyTimeout is how long the readiness checks get, together.
+const readyTimeout = 5 * time.Second
+
+// readyChecker is what's checked for readiness, a store, but for tests.
+type readyChecker interface {
+	Ping(ctx context.Context) error
+	GetMigrationStatus(ctx context.Context, path string) (*models.MigrationStatus, error)
+}
+
+// Health reports that the process is up, without checking anything else.
+func Health() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(`{"status":"ok"}` + "\n"))
+	})
+}
+
+// readiness is the readiness report.
+type readiness struct {
+	Status     string          `json:"status"`
+	Database   check           `json:"database"`
+	Migrations migrationsCheck `json:"migrations"`
+}
+
+type check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type migrationsCheck struct {
+	check
+	*models.MigrationStatus
+}
+
+// Ready reports whether the server can take traffic: the store has to be
+// reachable, and every migration in migrationsPath applied. It responds 503
+// otherwise, with the report saying why.
+func Ready(store *models.Store, migrationsPath string) http.Handler {
+	return ready(store, migrationsPath)
+}
+
+func ready(db readyChecker, migrationsPath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		report := readiness{Status: "ready"}
+		if err := db.Ping(ctx); err != nil {
+			report.Database.Error = err.Error()
+			report.Migrations.Error = "database unavailable"
+		} else {
+			report.Database.OK = true
+			status, err := db.GetMigrationStatus(ctx, migrationsPath)
+			switch {
+			case err != nil:
+				report.Migrations.Error = err.Error()
+			case !status.Current():
+				report.Migrations.Error = "migrations pending, run bactdb migrate"
+			default:
+				report.Migrations.OK = true
+			}
+			report.Migrations.MigrationStatus = status
+		}
+
+		code := http.StatusOK
+		if !report.Database.OK || !report.Migrations.OK {
+			report.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thermokarst/bactdb/models"
+)
+
+// fakeDB is a database that's reachable unless pingErr is set, and at status.
+type fakeDB struct {
+	t       *testing.T
+	pingErr error
+	status  *models.MigrationStatus
+}
+
+func (db *fakeDB) Ping(context.Context) error { return db.pingErr }
+
+func (db *fakeDB) GetMigrationStatus(ctx context.Context, path string) (*models.MigrationStatus, error) {
+	if path != "./migrations" {
+		db.t.Errorf("path %q", path)
+	}
+	return db.status, nil
+}
+
+func TestReady(t *testing.T) {
+	db := &fakeDB{t: t, status: &models.MigrationStatus{Applied: 17, Latest: 17, Pending: []uint64{}}}
+
+	get := func() (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		ready(db, "./migrations").ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		var report map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, report
+	}
+
+	if code, report := get(); code != http.StatusOK || report["status"] != "ready" {
+		t.Errorf("got %d %v", code, report)
+	}
+
+	db.status = &models.MigrationStatus{Applied: 16, Latest: 17, Pending: []uint64{17}}
+	code, report := get()
+	migrations := report["migrations"].(map[string]interface{})
+	if code != http.StatusServiceUnavailable || migrations["ok"] != false || migrations["latest"] != 17.0 {
+		t.Errorf("pending migrations got %d %v", code, report)
+	}
+
+	db.pingErr = fmt.Errorf("connection refused")
+	code, report = get()
+	database := report["database"].(map[string]interface{})
+	if code != http.StatusServiceUnavailable || database["error"] != "connection refused" {
+		t.Errorf("no database got %d %v", code, report)
+	}
+}
@@ -1,10 +1,30 @@
 package handlers
 
-import "github.com/thermokarst/bactdb/types"
+import (
+	"net/http"
 
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/payloads"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// newJSONError wraps err in the JSON error envelope. status is only a
+// fallback, for errors that don't carry one of their own.
 func newJSONError(err error, status int) *types.AppError {
-	return &types.AppError{
-		Error:  types.ErrorJSON{Err: err},
-		Status: status,
+	return types.NewAppError(err, status)
+}
+
+func payloadOptions(r *http.Request) helpers.PayloadOptions {
+	opt := r.URL.Query()
+	return helpers.PayloadOptionsFromValues(&opt)
+}
+
+// marshalPayload serializes an entity, trimmed down to any sparse fieldsets
+// requested by the client, see payloads.ApplyFieldsets.
+func marshalPayload(e types.Entity, opt helpers.PayloadOptions) ([]byte, error) {
+	data, err := e.Marshal()
+	if err != nil {
+		return nil, err
 	}
+	return payloads.ApplyFieldsets(data, opt.Fields)
 }
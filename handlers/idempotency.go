@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/thermokarst/bactdb/errors"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/types"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+
+// idempotencyPeriod is how long a key's response is kept.
+const idempotencyPeriod = 24 * time.Hour
+
+// storedHeaders are the response headers replayed along with the body.
+var storedHeaders = []string{"Content-Type", "Etag", "Location"}
+
+// idempotent makes a request safe to retry, when it has an Idempotency-Key.
+// The first response is kept, and sent back again for any retry with the same
+// key, instead of handling the request again. Server errors aren't kept, so
+// that those can be retried for real. Keys are per user, so requests without a
+// token aren't covered.
+//
+// Request bodies are read up to helpers.MaxBody, like the handlers it wraps.
+// The response is held whole to be kept, which is fine for the single
+// entities that creates send back, but not for lists or streams.
+func idempotent(keys models.KeyRepository, h http.Handler) errorHandler {
+	return func(w http.ResponseWriter, r *http.Request) *types.AppError {
+		key := r.Header.Get(idempotencyHeader)
+		claims := helpers.GetClaims(r)
+		if key == "" || claims.Sub == 0 {
+			h.ServeHTTP(w, r)
+			return nil
+		}
+		if len(key) > 255 {
+			return newJSONError(errors.ErrInvalidIdempotencyKey, http.StatusBadRequest)
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, helpers.MaxBody))
+		if err != nil {
+			return newJSONError(err, http.StatusInternalServerError)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		fingerprint := sha256.New()
+		fingerprint.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+		fingerprint.Write(body)
+
+		stored, err := keys.Acquire(claims.Sub, key, hex.EncodeToString(fingerprint.Sum(nil)))
+		if err != nil {
+			return newJSONError(err, http.StatusInternalServerError)
+		}
+		if stored != nil {
+			writeStored(w, stored)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return nil
+		}
+
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+
+		resp := &models.StoredResponse{Status: recorder.Code, Header: make(http.Header), Body: recorder.Body.Bytes()}
+		for _, name := range storedHeaders {
+			if v, ok := recorder.Header()[name]; ok {
+				resp.Header[name] = v
+			}
+		}
+
+		if resp.Status >= http.StatusInternalServerError {
+			err = keys.Release(claims.Sub, key)
+		} else {
+			err = keys.Save(claims.Sub, key, resp)
+		}
+		if err != nil {
+			log.Printf("Idempotency-Key %q: %v", key, err)
+		}
+
+		writeStored(w, resp)
+		w.WriteHeader(resp.Status)
+		w.Write(resp.Body)
+		return nil
+	}
+}
+
+func writeStored(w http.ResponseWriter, resp *models.StoredResponse) {
+	for name, v := range resp.Header {
+		w.Header()[name] = v
+	}
+}
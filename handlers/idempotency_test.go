@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/context"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/types"
+)
+
+func TestIdempotent(t *testing.T) {
+	created := 0
+	status := http.StatusCreated
+	h := idempotent(models.NewMemory().Keys(idempotencyPeriod), errorHandler(func(w http.ResponseWriter, r *http.Request) *types.AppError {
+		created++
+		if status != http.StatusCreated {
+			return newJSONError(fmt.Errorf("down"), status)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"strain":{"id":%d}}`, created)
+		return nil
+	}))
+
+	post := func(user int64, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/hymenobacter/strains", strings.NewReader(body))
+		r.Header.Set(idempotencyHeader, key)
+		context.Set(r, "claims", types.Claims{Sub: user})
+		defer context.Clear(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	first := post(1, "abc", `{"strain":{}}`)
+	replay := post(1, "abc", `{"strain":{}}`)
+	if created != 1 {
+		t.Fatalf("created %d times", created)
+	}
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() ||
+		replay.Header().Get("Content-Type") != "application/json" || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay got %d %v %q", replay.Code, replay.Header(), replay.Body.String())
+	}
+
+	if w := post(1, "abc", `{"strain":{"strainName":"x"}}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key got %d", w.Code)
+	}
+	if post(2, "abc", `{"strain":{}}`); created != 2 {
+		t.Errorf("keys aren't per user")
+	}
+	if post(1, "", `{"strain":{}}`); created != 3 {
+		t.Errorf("no key, but not created")
+	}
+
+	status = http.StatusInternalServerError
+	if w := post(1, "def", `{}`); w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d", w.Code)
+	}
+	status = http.StatusCreated
+	if w := post(1, "def", `{}`); w.Code != http.StatusCreated || created != 5 {
+		t.Errorf("retry after a server error got %d, created %d times", w.Code, created)
+	}
+
+	if w := post(1, "ghi", `{"strain":{"notes":"`+strings.Repeat("x", helpers.MaxBody)+`"}}`); w.Code != http.StatusRequestEntityTooLarge || created != 5 {
+		t.Errorf("too large got %d, created %d times", w.Code, created)
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/thermokarst/bactdb/models"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// A request ID passed in by a proxy is kept, if it looks like one.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestLogKey struct{}
+
+// requestLog is what's found out about a request while handling it, for the
+// access log.
+type requestLog struct {
+	id    string
+	route string
+	user  int64
+}
+
+// loggedRequest returns the request's log entry, nil when it isn't logged.
+func loggedRequest(r *http.Request) *requestLog {
+	rl, _ := r.Context().Value(requestLogKey{}).(*requestLog)
+	return rl
+}
+
+// accessLogger gives each request an ID, sent back in X-Request-Id and
+// attached to the SQL it runs, and logs it to logger once it's handled,
+// slog's default when it's nil. Client addresses are taken from
+// X-Forwarded-For when trustProxy is set.
+func accessLogger(logger *slog.Logger, trustProxy bool, h http.Handler) http.Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	l := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rl := &requestLog{id: r.Header.Get(requestIDHeader)}
+		if !requestIDPattern.MatchString(rl.id) {
+			rl.id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, rl.id)
+
+		sw := &statusWriter{ResponseWriter: w}
+		ctx := context.WithValue(r.Context(), requestLogKey{}, rl)
+		h.ServeHTTP(sw, r.WithContext(models.WithRequestID(ctx, rl.id)))
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := []interface{}{
+			slog.String("request_id", rl.id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", rl.route),
+			slog.Int("status", status),
+			slog.Int64("bytes", sw.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start))/float64(time.Millisecond)),
+			slog.String("remote", clientIP(r, trustProxy)),
+		}
+		if rl.user != 0 {
+			attrs = append(attrs, slog.Int64("user", rl.user))
+		}
+		logger.Info("request", attrs...)
+	}
+	return http.HandlerFunc(l)
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thermokarst/bactdb/models"
+)
+
+func TestAccessLogger(t *testing.T) {
+	var b bytes.Buffer
+	var traced string
+	h := accessLogger(slog.New(slog.NewJSONHandler(&b, nil)), false, instrument("/{genus}/strains", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// As verifyClaims would
+		loggedRequest(r).user = 3
+		traced = models.RequestID(r.Context())
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	})))
+
+	r := httptest.NewRequest("POST", "/hymenobacter/strains", nil)
+	r.Header.Set(requestIDHeader, "abc-123")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Header().Get(requestIDHeader) != "abc-123" || traced != "abc-123" {
+		t.Errorf("request ID %q, SQL traced as %q", w.Header().Get(requestIDHeader), traced)
+	}
+
+	var entry struct {
+		Msg       string
+		RequestID string `json:"request_id"`
+		Route     string
+		Status    int
+		Bytes     int
+		User      int64
+	}
+	if err := json.Unmarshal(b.Bytes(), &entry); err != nil {
+		t.Fatal(err, b.String())
+	}
+	if entry.Msg != "request" || entry.RequestID != "abc-123" || entry.Route != "/{genus}/strains" ||
+		entry.Status != http.StatusCreated || entry.Bytes != 2 || entry.User != 3 {
+		t.Errorf("got %s", b.String())
+	}
+
+	r.Header.Set(requestIDHeader, "not a request ID")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if id := w.Header().Get(requestIDHeader); !requestIDPattern.MatchString(id) || id == "not a request ID" {
+		t.Errorf("request ID %q", id)
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/thermokarst/bactdb/metrics"
+)
+
+var (
+	requestsTotal = metrics.NewCounter("bactdb_http_requests_total",
+		"HTTP requests, by route and status.", "method", "route", "status")
+	requestDuration = metrics.NewHistogram("bactdb_http_request_duration_seconds",
+		"HTTP request durations, by route and status. Streams are timed to their end.",
+		metrics.DefBuckets, "method", "route", "status")
+	authFailures = metrics.NewCounter("bactdb_auth_failures_total",
+		"Failed logins, lockouts, and rejected tokens, by error code.", "reason")
+)
+
+// instrument counts and times requests to a route, labelled by its path
+// template, rather than the path asked for.
+func instrument(path string, h http.Handler) http.Handler {
+	i := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if rl := loggedRequest(r); rl != nil {
+			rl.route = path
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		h.ServeHTTP(sw, r)
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := []string{r.Method, path, strconv.Itoa(status)}
+		requestsTotal.Inc(labels...)
+		requestDuration.Observe(time.Since(start).Seconds(), labels...)
+	}
+	return http.HandlerFunc(i)
+}
+
+// statusWriter keeps the status and number of bytes written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
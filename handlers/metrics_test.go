@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInstrument(t *testing.T) {
+	h := instrument("/{genus}/teapots/{ID:.+}", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hymenobacter/teapots/2" {
+			w.WriteHeader(http.StatusTeapot)
+		}
+		w.Write([]byte("{}"))
+	}))
+	for _, path := range []string{"/hymenobacter/teapots/1", "/hymenobacter/teapots/2", "/hymenobacter/teapots/3"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if n := requestsTotal.Value("GET", "/{genus}/teapots/{ID:.+}", "200"); n != 2 {
+		t.Errorf("counted %v OKs", n)
+	}
+	if n := requestsTotal.Value("GET", "/{genus}/teapots/{ID:.+}", "418"); n != 1 {
+		t.Errorf("counted %v teapots", n)
+	}
+}
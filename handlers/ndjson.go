@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/thermokarst/bactdb/api"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/types"
+)
+
+const ndjsonMediaType = "application/x-ndjson"
+
+// streamWriteTimeout is how long each line of a stream gets. Long lists
+// outlast the server's write timeout, so like event streams, they get their
+// own deadline for each write instead.
+const streamWriteTimeout = time.Minute
+
+// acceptsNDJSON reports whether a list was asked for as a NDJSON stream.
+func acceptsNDJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == ndjsonMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// streamList writes a list one entity per line, as the entities are read from
+// the database, so that the list is never all in memory. Nothing is sideloaded.
+// Once the first line is out, it's too late for an error response, so the
+// stream is just cut short.
+func streamList(w http.ResponseWriter, r *http.Request, s api.Streamer, f format) *types.AppError {
+	opt := r.URL.Query()
+	opt.Add("Genus", mux.Vars(r)["genus"])
+
+	claims := helpers.GetClaims(r)
+	payloadOpt := payloadOptions(r)
+
+	rc := http.NewResponseController(serverWriter(w))
+	// Not every ResponseWriter has deadlines, tests' don't
+	rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+
+	started := false
+	appErr := s.Stream(r.Context(), &opt, &claims, func(e types.Entity) error {
+		data, err := marshalPayload(e, payloadOpt)
+		if err != nil {
+			return err
+		}
+		if data, err = f.line(r, data); err != nil {
+			return err
+		}
+		if !started {
+			w.Header().Set("Content-Type", ndjsonMediaType)
+			started = true
+		}
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		_, err = w.Write(append(data, '\n'))
+		return err
+	})
+
+	if appErr != nil {
+		if started {
+			log.Printf("Stream of %s cut short: %v", r.URL.Path, appErr.Error)
+			return nil
+		}
+		return appErr
+	}
+	w.Header().Set("Content-Type", ndjsonMediaType)
+	return nil
+}
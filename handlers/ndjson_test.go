@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/thermokarst/bactdb/errors"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/payloads"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// fakeStreamer streams two strains, each taking delay to read, or fails before
+// the first one.
+type fakeStreamer struct {
+	fail  bool
+	delay time.Duration
+}
+
+func (s fakeStreamer) List(ctx context.Context, val *url.Values, claims *types.Claims) (types.Entity, *types.AppError) {
+	return nil, newJSONError(errors.ErrMustProvideOptions, http.StatusBadRequest)
+}
+
+func (s fakeStreamer) Stream(ctx context.Context, val *url.Values, claims *types.Claims, fn func(types.Entity) error) *types.AppError {
+	if s.fail {
+		return newJSONError(errors.ErrUserForbidden, http.StatusForbidden)
+	}
+	for _, id := range []int64{1, 2} {
+		time.Sleep(s.delay)
+		strain := &models.Strain{StrainBase: &models.StrainBase{ID: id, StrainName: "S", SpeciesID: 9}}
+		if err := fn(&payloads.Strain{Strain: strain}); err != nil {
+			return newJSONError(err, http.StatusInternalServerError)
+		}
+	}
+	return nil
+}
+
+func serveStream(s fakeStreamer, f format, target string) *httptest.ResponseRecorder {
+	m := mux.NewRouter()
+	m.Handle("/{genus}/strains", errorHandler(handleLister(s, f)))
+
+	r := httptest.NewRequest("GET", target, nil)
+	r.Header.Set("Accept", "application/x-ndjson, application/json;q=0.5")
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	return w
+}
+
+func TestStreamList(t *testing.T) {
+	w := serveStream(fakeStreamer{}, v1{}, "/hymenobacter/strains?fields[strains]=strainName")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != ndjsonMediaType {
+		t.Fatalf("got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	want := "{\"id\":1,\"strainName\":\"S\"}\n{\"id\":2,\"strainName\":\"S\"}\n"
+	if w.Body.String() != want {
+		t.Errorf("got %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestStreamListJSONAPI(t *testing.T) {
+	w := serveStream(fakeStreamer{}, jsonAPI{"strains"}, "/hymenobacter/strains?fields[strains]=species")
+	want := `{"type":"strains","id":"1","attributes":{},"relationships":{"species":{"data":{"type":"species","id":"9"}}},"links":{"self":"/api/v2/hymenobacter/strains/1"}}` + "\n" +
+		`{"type":"strains","id":"2","attributes":{},"relationships":{"species":{"data":{"type":"species","id":"9"}}},"links":{"self":"/api/v2/hymenobacter/strains/2"}}` + "\n"
+	if w.Body.String() != want {
+		t.Errorf("got %q, want %q", w.Body.String(), want)
+	}
+}
+
+func TestStreamListError(t *testing.T) {
+	w := serveStream(fakeStreamer{fail: true}, v1{}, "/hymenobacter/strains")
+	if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") == ndjsonMediaType {
+		t.Errorf("got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestStreamListOutlastsWriteTimeout(t *testing.T) {
+	m := mux.NewRouter()
+	m.Handle("/{genus}/strains", errorHandler(handleLister(fakeStreamer{delay: 100 * time.Millisecond}, v1{})))
+	srv := httptest.NewUnstartedServer(m)
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	r, _ := http.NewRequest("GET", srv.URL+"/hymenobacter/strains", nil)
+	r.Header.Set("Accept", ndjsonMediaType)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || strings.Count(string(body), "\n") != 2 {
+		t.Errorf("got %q, %v", body, err)
+	}
+}
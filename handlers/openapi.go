@@ -0,0 +1,475 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// operation documents a route in the OpenAPI document.
+type operation struct {
+	summary string
+	tag     string
+	// query is an options struct, decoded from the query string
+	query interface{}
+	// payload routes take the include and fields[type] parameters
+	payload bool
+	// etag routes take conditional request headers
+	etag bool
+	// ndjson routes also stream, one entity per line
+	ndjson bool
+	// idempotent routes take an Idempotency-Key
+	idempotent bool
+	// throttled routes are rate limited
+	throttled bool
+	// form is a url-encoded request body
+	form    interface{}
+	request interface{}
+	// contentType is the request's, when it differs from mediaType
+	contentType string
+	// mediaType is the response's, JSON when not set
+	mediaType string
+	response  interface{}
+	// status is the status on success, 200 when not set
+	status int
+}
+
+// credentials is the body of an authentication request.
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// accessToken is the response to a successful authentication.
+type accessToken struct {
+	Token string `json:"access_token"`
+}
+
+type message struct {
+	Msg string `json:"msg"`
+}
+
+type lockout struct {
+	Email string `json:"email"`
+}
+
+type passwordChange struct {
+	ID       int64  `json:"id"`
+	Password string `json:"password"`
+}
+
+type compareOptions struct {
+	Strains         []int64 `schema:"strain_ids"`
+	Characteristics []int64 `schema:"characteristic_ids"`
+	MimeType        string  `schema:"mimeType"`
+}
+
+type syncOptions struct {
+	// Since is the cursor from the last sync, everything when not set
+	Since string `schema:"since"`
+}
+
+type deliveryOptions struct {
+	// Limit is how many attempts to return, 100 when not set
+	Limit int64 `schema:"limit"`
+}
+
+type graphQLQuery struct {
+	Query         string `schema:"query"`
+	OperationName string `schema:"operationName"`
+	// Variables are JSON encoded
+	Variables string `schema:"variables"`
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)(:[^}]*)?\}`)
+
+func (s *server) handleOpenAPI(w http.ResponseWriter, r *http.Request) *types.AppError {
+	s.openAPIOnce.Do(func() {
+		s.openAPIData, s.openAPIErr = json.Marshal(openAPIDocument(s.routes(), s.opt.Version))
+	})
+	if s.openAPIErr != nil {
+		return newJSONError(s.openAPIErr, http.StatusInternalServerError)
+	}
+	w.Write(s.openAPIData)
+	return nil
+}
+
+// openAPIDocument describes every route in the route table, as version of the
+// API, with schemas generated from the Go types that are sent and received.
+func openAPIDocument(rs []route, version string) map[string]interface{} {
+	s := newSchemas()
+	paths := make(map[string]interface{})
+
+	for _, rt := range rs {
+		path := openAPIPath(rt.path)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(rt.method)] = s.operation(rt)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "bactdb",
+			"version": version,
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": "/api"},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": s.components,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+				"token": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "query",
+					"name": "token",
+				},
+			},
+		},
+	}
+}
+
+// openAPIPath strips the regular expressions out of a route's variables.
+func openAPIPath(path string) string {
+	return pathParam.ReplaceAllString(path, "{$1}")
+}
+
+func (s *schemas) operation(rt route) map[string]interface{} {
+	doc := rt.doc
+	op := map[string]interface{}{
+		"summary": doc.summary,
+		"tags":    []string{doc.tag},
+	}
+
+	var params []interface{}
+	for _, m := range pathParam.FindAllStringSubmatch(rt.path, -1) {
+		schema := map[string]interface{}{"type": "string"}
+		if m[1] == "ID" {
+			schema = map[string]interface{}{"type": "integer", "format": "int64"}
+		}
+		params = append(params, map[string]interface{}{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   schema,
+		})
+	}
+	if doc.query != nil {
+		params = append(params, s.queryParams(reflect.TypeOf(doc.query))...)
+	}
+	if doc.payload {
+		params = append(params,
+			map[string]interface{}{
+				"name":        "include",
+				"in":          "query",
+				"description": "Comma separated related entity types to sideload, all of them when not given",
+				"schema":      map[string]interface{}{"type": "string"},
+			},
+			map[string]interface{}{
+				"name":        "fields",
+				"in":          "query",
+				"style":       "deepObject",
+				"description": "Comma separated attributes to return, per entity type",
+				"schema": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": map[string]interface{}{"type": "string"},
+				},
+			})
+	}
+	if doc.etag {
+		header := "If-Match"
+		if rt.method == "GET" {
+			header = "If-None-Match"
+		}
+		params = append(params, map[string]interface{}{
+			"name":   header,
+			"in":     "header",
+			"schema": map[string]interface{}{"type": "string"},
+		})
+	}
+	if doc.idempotent {
+		params = append(params, map[string]interface{}{
+			"name":        idempotencyHeader,
+			"in":          "header",
+			"description": "Retries with the same key get the first response back, rather than creating again",
+			"schema":      map[string]interface{}{"type": "string", "maxLength": 255},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if doc.request != nil || doc.form != nil {
+		content := make(map[string]interface{})
+		if doc.request != nil {
+			contentType := doc.contentType
+			if contentType == "" {
+				contentType = mediaType(doc)
+			}
+			content[contentType] = map[string]interface{}{"schema": s.schema(reflect.TypeOf(doc.request))}
+		}
+		if doc.form != nil {
+			schema := s.schema(reflect.TypeOf(doc.form))
+			content["application/x-www-form-urlencoded"] = map[string]interface{}{"schema": schema}
+			if rt.path == "/authenticate" {
+				content["application/json"] = map[string]interface{}{"schema": schema}
+			}
+		}
+		op["requestBody"] = map[string]interface{}{"required": true, "content": content}
+	}
+
+	status := doc.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if doc.response != nil {
+		success["content"] = map[string]interface{}{
+			mediaType(doc): map[string]interface{}{"schema": s.schema(reflect.TypeOf(doc.response))},
+		}
+	}
+	if doc.ndjson {
+		success["content"].(map[string]interface{})[ndjsonMediaType] = map[string]interface{}{
+			"schema": map[string]interface{}{
+				"type":        "string",
+				"description": "One entity per line, without anything sideloaded, when asked for with Accept",
+			},
+		}
+	}
+	if doc.etag && rt.method != "DELETE" {
+		success["headers"] = map[string]interface{}{
+			"ETag": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+		}
+	}
+	responses := map[string]interface{}{
+		strconv.Itoa(status): success,
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content": map[string]interface{}{
+				mediaType(doc): map[string]interface{}{"schema": s.errors()},
+			},
+		},
+	}
+	if doc.etag {
+		if rt.method == "GET" {
+			responses["304"] = map[string]interface{}{"description": http.StatusText(http.StatusNotModified)}
+		} else {
+			responses["412"] = map[string]interface{}{"description": "The record has changed, the current version is returned"}
+		}
+	}
+	if doc.throttled {
+		responses["429"] = map[string]interface{}{
+			"description": "Rate limited, or locked out after too many failed logins",
+			"headers": map[string]interface{}{
+				"Retry-After": map[string]interface{}{
+					"description": "Seconds until trying again",
+					"schema":      map[string]interface{}{"type": "integer"},
+				},
+			},
+		}
+	}
+	op["responses"] = responses
+
+	if rt.secure {
+		op["security"] = []interface{}{
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{"token": []string{}},
+		}
+	}
+
+	return op
+}
+
+// mediaType is an operation's response media type, without parameters.
+func mediaType(doc operation) string {
+	if doc.mediaType == "" {
+		return "application/json"
+	}
+	return strings.TrimSpace(strings.Split(doc.mediaType, ";")[0])
+}
+
+// queryParams describes an options struct decoded by helpers.SchemaDecoder.
+func (s *schemas) queryParams(t reflect.Type) []interface{} {
+	var params []interface{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			params = append(params, s.queryParams(f.Type)...)
+			continue
+		}
+		// The genus always comes from the path
+		if f.Name == "Genus" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("schema"), ",")[0]
+		if name == "" {
+			name = f.Name
+		}
+		params = append(params, map[string]interface{}{
+			"name":   name,
+			"in":     "query",
+			"schema": s.schema(f.Type),
+		})
+	}
+	return params
+}
+
+// schemas generates JSON schemas from Go types, the way encoding/json would
+// serialize them. Named structs become components.
+type schemas struct {
+	components map[string]interface{}
+}
+
+func newSchemas() *schemas {
+	return &schemas{components: make(map[string]interface{})}
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	rawType       = reflect.TypeOf(json.RawMessage{})
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+	// nullTypes serialize as their value, or null
+	nullTypes = map[reflect.Type]map[string]interface{}{
+		reflect.TypeOf(types.NullBool{}):       {"type": "boolean"},
+		reflect.TypeOf(types.NullFloat64{}):    {"type": "number"},
+		reflect.TypeOf(types.NullInt64{}):      {"type": "integer", "format": "int64"},
+		reflect.TypeOf(types.NullString{}):     {"type": "string"},
+		reflect.TypeOf(types.NullTime{}):       {"type": "string", "format": "date-time"},
+		reflect.TypeOf(types.NullSliceInt64{}): {"type": "array", "items": map[string]interface{}{"type": "integer", "format": "int64"}},
+	}
+
+	// extraProperties are added by custom JSON marshalling
+	extraProperties = map[reflect.Type]map[string]interface{}{
+		reflect.TypeOf(models.Measurement{}): {
+			"value": map[string]interface{}{
+				"type":        "string",
+				"description": "Numeric values are accepted as numbers on writes",
+			},
+		},
+	}
+)
+
+func (s *schemas) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if n, ok := nullTypes[t]; ok {
+		schema := map[string]interface{}{"nullable": true}
+		for k, v := range n {
+			schema[k] = v
+		}
+		return schema
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawType, t == interfaceType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		name := componentName(t)
+		if _, ok := s.components[name]; !ok {
+			// Claim the name first, in case the type refers to itself
+			s.components[name] = nil
+			s.components[name] = s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+
+	return map[string]interface{}{}
+}
+
+func (s *schemas) object(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	s.properties(t, props)
+	for name, prop := range extraProperties[t] {
+		props[name] = prop
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+func (s *schemas) properties(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			s.properties(ft, props)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = s.schema(f.Type)
+	}
+}
+
+// componentName is the type's exported name, with payloads marked as such so
+// that they don't collide with the models they carry.
+func componentName(t reflect.Type) string {
+	name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	if strings.HasSuffix(t.PkgPath(), "/payloads") {
+		return name + "Payload"
+	}
+	return name
+}
+
+// errors is the schema for the JSON error envelope.
+func (s *schemas) errors() map[string]interface{} {
+	if _, ok := s.components["Errors"]; !ok {
+		s.components["Errors"] = map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"errors": s.schema(reflect.TypeOf(types.ValidationError{})),
+			},
+		}
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/Errors"}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/mux"
+	"github.com/thermokarst/bactdb/api"
+	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// testServer has an empty store behind it, which is enough for the route
+// table.
+func testServer() *server {
+	return newServer(api.NewApp(models.NewMemory(), nil), DefaultOptions())
+}
+
+// document round trips the OpenAPI document through JSON, the way clients
+// see it.
+func document(t *testing.T) map[string]interface{} {
+	data, err := json.Marshal(openAPIDocument(testServer().routes(), DefaultOptions().Version))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	doc := document(t)
+	paths := doc["paths"].(map[string]interface{})
+
+	documented := 0
+	for path, item := range paths {
+		documented += len(item.(map[string]interface{}))
+		if !strings.HasPrefix(path, "/") {
+			t.Errorf("path %q is not absolute", path)
+		}
+	}
+
+	rs := testServer().routes()
+	if documented != len(rs) {
+		t.Errorf("%d operations documented, want %d", documented, len(rs))
+	}
+
+	for _, rt := range rs {
+		item, ok := paths[openAPIPath(rt.path)].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s %s not documented", rt.method, rt.path)
+			continue
+		}
+		if _, ok := item[strings.ToLower(rt.method)]; !ok {
+			t.Errorf("%s %s not documented", rt.method, rt.path)
+		}
+	}
+}
+
+// Every documented operation has to be reachable through the router.
+func TestOpenAPIPathsAreRouted(t *testing.T) {
+	doc := document(t)
+	m := testServer().router()
+	sample := strings.NewReplacer("{genus}", "hymenobacter", "{ID}", "1", "{Nonce}", "abc")
+
+	for path, item := range doc["paths"].(map[string]interface{}) {
+		for method := range item.(map[string]interface{}) {
+			r, _ := http.NewRequest(strings.ToUpper(method), sample.Replace(path), nil)
+			var match mux.RouteMatch
+			if !m.Match(r, &match) {
+				t.Errorf("%s %s doesn't match any route", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	doc := document(t)
+	components := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if ref, ok := v["$ref"].(string); ok {
+				name := strings.TrimPrefix(ref, "#/components/schemas/")
+				if _, ok := components[name]; !ok {
+					t.Errorf("%s doesn't resolve", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+}
+
+// The schemas are generated from struct fields, which misses anything added
+// by custom marshalling, so compare them with what actually gets serialized.
+func TestOpenAPISchemasMatchModels(t *testing.T) {
+	// omitempty attributes need a value to show up
+	entities := []interface{}{
+		&models.Species{SpeciesBase: &models.SpeciesBase{}},
+		&models.Strain{StrainBase: &models.StrainBase{}},
+		&models.Characteristic{CharacteristicBase: &models.CharacteristicBase{ID: 1}},
+		&models.Measurement{MeasurementBase: &models.MeasurementBase{ID: 1}},
+		&models.User{UserBase: &models.UserBase{ID: 1, Password: "password"}},
+		&types.ErrorDetail{Status: "400", Code: "bad_request", Source: &types.Source{}},
+	}
+
+	doc := document(t)
+	components := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	for _, e := range entities {
+		name := reflect.TypeOf(e).Elem().Name()
+		schema, ok := components[name].(map[string]interface{})
+		if !ok {
+			t.Errorf("%s has no schema", name)
+			continue
+		}
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var attrs map[string]interface{}
+		if err := json.Unmarshal(data, &attrs); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := keys(schema["properties"].(map[string]interface{})), keys(attrs); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s schema has properties %v, serialized as %v", name, got, want)
+		}
+	}
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	// Each handler has its own options, even in the same process
+	opt := DefaultOptions()
+	opt.Version = "2.0.0"
+	other := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/openapi.json", nil)
+	Handler(api.NewApp(models.NewMemory(), nil), opt).ServeHTTP(other, r)
+
+	w := httptest.NewRecorder()
+	Handler(api.NewApp(models.NewMemory(), nil), DefaultOptions()).ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusOK)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Errorf("Content-Type %q", got)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["openapi"] != "3.0.3" {
+		t.Errorf("openapi %v", doc["openapi"])
+	}
+	if v := doc["info"].(map[string]interface{})["version"]; v != DefaultOptions().Version || !strings.Contains(other.Body.String(), `"version":"2.0.0"`) {
+		t.Errorf("versions %v and %s", v, other.Body)
+	}
+}
+
+func keys(m map[string]interface{}) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
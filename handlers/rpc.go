@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/context"
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/thermokarst/jwt"
+	"github.com/thermokarst/bactdb/api"
+	"github.com/thermokarst/bactdb/rpc"
+)
+
+// RPCHandler is the root handler for the gRPC service. Calls take the same
+// tokens as the REST API, as authorization: Bearer metadata.
+func RPCHandler(app *api.App) http.Handler {
+	rs := app.NewRPCServer()
+	rs.Authenticate = newServer(app, Options{}).authenticateRPC
+	return context.ClearHandler(rs)
+}
+
+func (s *server) authenticateRPC(r *http.Request) error {
+	parts := strings.Fields(r.Header.Get("Authorization"))
+	if len(parts) == 0 {
+		return rpc.Errorf(rpc.Unauthenticated, "%v", jwt.ErrMissingToken)
+	}
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return rpc.Errorf(rpc.Unauthenticated, "%v", jwt.ErrMalformedToken)
+	}
+
+	if _, _, err := s.app.Auth.VerifyToken(parts[1], s.verifyClaims, r); err != nil {
+		return rpc.Errorf(rpc.Unauthenticated, "%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thermokarst/bactdb/errors"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// maxThrottledBody is the most of a request body read to find its email
+// address. Logins, lockouts and signups are far smaller.
+const maxThrottledBody = 64 << 10
+
+// throttled rate limits h as th has it. locked, when given, reports when an
+// email address is locked out until, so that the lockout can be reported with
+// Retry-After.
+func throttled(th types.Throttling, h http.Handler, locked func(email string) (time.Time, error)) http.Handler {
+	byIP, byEmail := newLimiter(th.PerIP), newLimiter(th.PerEmail)
+	trustProxy := th.TrustProxy
+	t := func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		if wait := byIP.wait(clientIP(r, trustProxy), now); wait > 0 {
+			writeThrottled(w, r, errors.ErrTooManyRequests, wait)
+			return
+		}
+
+		email, err := requestEmail(w, r)
+		if _, ok := err.(*http.MaxBytesError); ok {
+			errorHandler(func(w http.ResponseWriter, r *http.Request) *types.AppError {
+				return newJSONError(errors.ErrRequestTooLarge, http.StatusRequestEntityTooLarge)
+			}).ServeHTTP(w, r)
+			return
+		}
+		email = strings.ToLower(email)
+		if email == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if wait := byEmail.wait(email, now); wait > 0 {
+			writeThrottled(w, r, errors.ErrTooManyRequests, wait)
+			return
+		}
+		if locked != nil {
+			until, err := locked(email)
+			if err != nil {
+				// DbAuthenticate will have another go
+				log.Printf("Checking lockout of %s: %v", email, err)
+			} else if until.After(now) {
+				authFailures.Inc(errors.ErrAccountLocked.(*errors.Error).Code)
+				writeThrottled(w, r, errors.ErrAccountLocked, until.Sub(now))
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(t)
+}
+
+func writeThrottled(w http.ResponseWriter, r *http.Request, err error, wait time.Duration) {
+	// Rounded up, so that trying again then works
+	w.Header().Set("Retry-After", strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10))
+	errorHandler(func(w http.ResponseWriter, r *http.Request) *types.AppError {
+		return newJSONError(err, http.StatusTooManyRequests)
+	}).ServeHTTP(w, r)
+}
+
+// clientIP is the address a request came from.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		// The last address is the one added by our proxy, the rest could be
+		// made up by the client.
+		if f := r.Header.Get("X-Forwarded-For"); f != "" {
+			addrs := strings.Split(f, ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestEmail finds the email address a request is about: the username when
+// logging in, the email when locking out, or the new user's email when
+// signing up, in either API version. The body is left to be read again, and
+// is an error when it's over maxThrottledBody.
+func requestEmail(w http.ResponseWriter, r *http.Request) (string, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxThrottledBody))
+	if err != nil {
+		return "", err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var b struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+		User     struct {
+			Email string `json:"email"`
+		} `json:"user"`
+		Data struct {
+			Attributes struct {
+				Email string `json:"email"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(body, &b) == nil {
+		for _, email := range []string{b.Username, b.Email, b.User.Email, b.Data.Attributes.Email} {
+			if email != "" {
+				return email, nil
+			}
+		}
+	}
+
+	// Forms are parsed once and kept, so reading them here is fine
+	email := r.FormValue("username")
+	if email == "" {
+		email = r.FormValue("email")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return email, nil
+}
+
+// limiter counts requests by key, in fixed windows.
+type limiter struct {
+	limit types.Limit
+
+	mu      sync.Mutex
+	windows map[string]*window
+	swept   time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+func newLimiter(l types.Limit) *limiter {
+	return &limiter{limit: l, windows: make(map[string]*window)}
+}
+
+// wait counts a request, returning how long until it would be allowed, zero
+// if it is.
+func (l *limiter) wait(key string, now time.Time) time.Duration {
+	if l.limit.Requests == 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget finished windows every so often, rather than on every request
+	if now.Sub(l.swept) > l.limit.Per {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.limit.Per {
+				delete(l.windows, k)
+			}
+		}
+		l.swept = now
+	}
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.limit.Per {
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit.Requests {
+		return w.start.Add(l.limit.Per).Sub(now)
+	}
+	w.count++
+	return 0
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thermokarst/bactdb/types"
+)
+
+func TestThrottled(t *testing.T) {
+	th := types.Throttling{
+		PerIP:    types.Limit{Requests: 3, Per: time.Minute},
+		PerEmail: types.Limit{Requests: 2, Per: time.Minute},
+	}
+
+	var locked time.Time
+	var got []string
+	h := throttled(th, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email, _ := requestEmail(w, r)
+		got = append(got, email)
+	}), func(string) (time.Time, error) { return locked, nil })
+
+	post := func(ip, contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/authenticate", strings.NewReader(body))
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	post("10.0.0.1", "application/json", `{"username":"A@example.com","password":"x"}`)
+	post("10.0.0.2", "application/x-www-form-urlencoded", "username=a%40example.com&password=x")
+	w := post("10.0.0.3", "application/json", `{"user":{"email":"a@example.com"}}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("third try for an email got %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if len(got) != 2 || got[0] != "A@example.com" || got[1] != "a@example.com" {
+		t.Errorf("bodies weren't left to read again, got %q", got)
+	}
+
+	post("10.0.0.1", "application/json", `{"data":{"attributes":{"email":"b@example.com"}}}`)
+	post("10.0.0.1", "application/json", `{}`)
+	if w := post("10.0.0.1", "application/json", `{}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("fourth try from an address got %d", w.Code)
+	}
+
+	// Bodies are only read so far, and never reach h when they're longer
+	w = post("10.0.0.5", "application/json", `{"username":"d@example.com","password":"`+strings.Repeat("x", maxThrottledBody)+`"}`)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "request_too_large") || len(got) != 4 {
+		t.Errorf("large body got %d %q", w.Code, w.Body.String())
+	}
+
+	locked = time.Now().Add(90 * time.Second)
+	w = post("10.0.0.4", "application/json", `{"username":"c@example.com"}`)
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "account_locked") {
+		t.Errorf("locked out got %d %q", w.Code, w.Body.String())
+	}
+	if ra := w.Header().Get("Retry-After"); ra != "90" && ra != "89" {
+		t.Errorf("locked out Retry-After %q", ra)
+	}
+}
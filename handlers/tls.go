@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate is a TLS certificate and key loaded from files, which can be
+// reloaded while serving.
+type Certificate struct {
+	certFile, keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// LoadCertificate loads a PEM encoded certificate and key.
+func LoadCertificate(certFile, keyFile string) (*Certificate, error) {
+	c := &Certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the files again. The certificate being served is kept when
+// they can't be read, so that a botched renewal doesn't take the site down.
+func (c *Certificate) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	return nil
+}
+
+// GetCertificate is for tls.Config, serving whatever was loaded last.
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// HSTS tells browsers to only ever use HTTPS, for maxAge.
+func HSTS(h http.Handler, maxAge time.Duration) http.Handler {
+	value := fmt.Sprintf("max-age=%d", int64(maxAge/time.Second))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RedirectHTTPS sends plain HTTP requests to the same URL over HTTPS, on
+// port. Redirects are permanent, and keep the method and body.
+func RedirectHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = net.JoinHostPort((&url.URL{Host: r.Host}).Hostname(), strconv.Itoa(port))
+		if port == 443 {
+			u.Host = strings.TrimSuffix(u.Host, ":443")
+		}
+		http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+	})
+}
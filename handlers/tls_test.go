@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for name, and its key.
+func writeCertificate(t *testing.T, certFile, keyFile, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, "old.example.com")
+
+	c, err := LoadCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := func() string {
+		cert, _ := c.GetCertificate(nil)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	writeCertificate(t, certFile, keyFile, "new.example.com")
+	if name := served(); name != "old.example.com" {
+		t.Errorf("serving %s before reloading", name)
+	}
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if name := served(); name != "new.example.com" {
+		t.Errorf("serving %s after reloading", name)
+	}
+
+	if err := os.WriteFile(keyFile, []byte("half written"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reload(); err == nil {
+		t.Error("reloaded a broken key")
+	}
+	if name := served(); name != "new.example.com" {
+		t.Errorf("serving %s after a failed reload", name)
+	}
+
+	if _, err := LoadCertificate(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("loaded a missing certificate")
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	for _, test := range []struct {
+		port       int
+		host, want string
+	}{
+		{443, "bactdb.example.com", "https://bactdb.example.com/api/hymenobacter/strains?page=2"},
+		{443, "bactdb.example.com:80", "https://bactdb.example.com/api/hymenobacter/strains?page=2"},
+		{8443, "bactdb.example.com:8080", "https://bactdb.example.com:8443/api/hymenobacter/strains?page=2"},
+		{8443, "[::1]:8080", "https://[::1]:8443/api/hymenobacter/strains?page=2"},
+	} {
+		r := httptest.NewRequest("POST", "/api/hymenobacter/strains?page=2", nil)
+		r.Host = test.host
+		w := httptest.NewRecorder()
+		RedirectHTTPS(test.port).ServeHTTP(w, r)
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != test.want {
+			t.Errorf("%s to port %d got %d %q, want %q", test.host, test.port, w.Code, w.Header().Get("Location"), test.want)
+		}
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	w := httptest.NewRecorder()
+	HSTS(Health(), 365*24*time.Hour).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=31536000" {
+		t.Errorf("got %q", got)
+	}
+}
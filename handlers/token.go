@@ -1,21 +1,21 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"time"
 
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/context"
-	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/thermokarst/jwt"
-	"github.com/thermokarst/bactdb/auth"
 	"github.com/thermokarst/bactdb/errors"
 	"github.com/thermokarst/bactdb/helpers"
-	"github.com/thermokarst/bactdb/models"
 	"github.com/thermokarst/bactdb/types"
 )
 
-func verifyClaims(claims []byte, r *http.Request) error {
+func (s *server) verifyClaims(claims []byte, r *http.Request) error {
 	currentTime := time.Now()
 	var c types.Claims
 	err := json.Unmarshal(claims, &c)
@@ -27,7 +27,10 @@ func verifyClaims(claims []byte, r *http.Request) error {
 		return errors.ErrExpiredToken
 	}
 
-	user, err := models.GetUser(c.Sub, "", &c)
+	user, err := s.app.Store.WithContext(r.Context()).GetUser(c.Sub, "", &c)
+	if err == errors.ErrUserNotFound {
+		return errors.ErrInvalidToken
+	}
 	if err != nil {
 		return err
 	}
@@ -37,6 +40,9 @@ func verifyClaims(claims []byte, r *http.Request) error {
 	}
 
 	context.Set(r, "claims", c)
+	if rl := loggedRequest(r); rl != nil {
+		rl.user = c.Sub
+	}
 	return nil
 }
 
@@ -50,55 +56,88 @@ func tokenHandler(h http.Handler) http.Handler {
 		}
 
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		w.WriteHeader(recorder.Code)
 
 		tokenData := string(recorder.Body.Bytes())
 
-		var data []byte
-
-		if recorder.Code != 200 {
-			data, _ = json.Marshal(struct {
-				Error string `json:"error"`
-			}{
-				Error: tokenData,
-			})
-		} else {
-			data, _ = json.Marshal(struct {
-				Token string `json:"access_token"`
-			}{
-				Token: tokenData,
-			})
+		if recorder.Code != http.StatusOK {
+			writeJWTError(w, recorder.Code, tokenData)
+			return
 		}
 
-		w.Write(data)
-		return
+		data, _ := json.Marshal(accessToken{Token: tokenData})
 
+		w.WriteHeader(recorder.Code)
+		w.Write(data)
 	}
 	return http.HandlerFunc(token)
 }
 
-func tokenRefresh(j *jwt.Middleware) errorHandler {
-	t := func(w http.ResponseWriter, r *http.Request) *types.AppError {
-		claims := helpers.GetClaims(r)
-		user, err := models.GetUser(claims.Sub, "", &claims)
-		if err != nil {
-			return newJSONError(err, http.StatusInternalServerError)
+// secure requires a valid token, and reports token failures in the JSON error
+// envelope rather than the middleware's plain text.
+func (s *server) secure(h http.Handler) http.Handler {
+	sh := s.app.Auth.Secure(h, s.verifyClaims)
+	j := func(w http.ResponseWriter, r *http.Request) {
+		jw := &jwtErrorWriter{ResponseWriter: w}
+		sh.ServeHTTP(jw, r)
+		if jw.status != 0 {
+			writeJWTError(w, jw.status, jw.body.String())
 		}
+	}
+	return http.HandlerFunc(j)
+}
 
-		user.Password = ""
-		token, err := auth.Middleware.CreateToken(user.Email)
-		if err != nil {
-			return newJSONError(err, http.StatusInternalServerError)
-		}
+// writeJWTError translates one of the jwt middleware's plain text errors. The
+// middleware only has the error's message, so the status comes from our own
+// errors where possible.
+func writeJWTError(w http.ResponseWriter, status int, message string) {
+	appErr := newJSONError(errors.FromMessage(strings.TrimSpace(message)), status)
+	if e, ok := appErr.Error.(types.ErrorJSON); ok {
+		authFailures.Inc(e.Code)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Del("X-Content-Type-Options")
+	w.WriteHeader(appErr.Status)
+	fmt.Fprintln(w, appErr.Error.Error())
+}
 
-		data, _ := json.Marshal(struct {
-			Token string `json:"access_token"`
-		}{
-			Token: token,
-		})
+// jwtErrorWriter holds back any plain text error response, which can only
+// have come from the jwt middleware, everything else passes straight through.
+type jwtErrorWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
 
-		w.Write(data)
-		return nil
+func (w *jwtErrorWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *jwtErrorWriter) Write(b []byte) (int, error) {
+	if w.status != 0 {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (s *server) tokenRefresh(w http.ResponseWriter, r *http.Request) *types.AppError {
+	claims := helpers.GetClaims(r)
+	user, err := s.app.Store.WithContext(r.Context()).GetUser(claims.Sub, "", &claims)
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
+	}
+
+	user.Password = ""
+	token, err := s.app.Auth.CreateToken(user.Email)
+	if err != nil {
+		return newJSONError(err, http.StatusInternalServerError)
 	}
-	return t
+
+	data, _ := json.Marshal(accessToken{Token: token})
+
+	w.Write(data)
+	return nil
 }
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/thermokarst/bactdb/types"
+)
+
+// envelope reads the one error out of a JSON error envelope.
+func envelope(t *testing.T, w *httptest.ResponseRecorder) types.ErrorDetail {
+	t.Helper()
+	var body struct {
+		Errors []types.ErrorDetail `json:"errors"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || len(body.Errors) != 1 {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	return body.Errors[0]
+}
+
+func TestWriteJWTError(t *testing.T) {
+	for _, test := range []struct {
+		status  int
+		message string
+		want    int
+		code    string
+	}{
+		// Our own errors, returned by verifyClaims, keep their status
+		{http.StatusUnauthorized, "this token has expired\n", http.StatusUnauthorized, "token_expired"},
+		{http.StatusInternalServerError, "this token needs to be reissued", http.StatusUnauthorized, "token_invalid"},
+		// The middleware's own take the status it sent
+		{http.StatusUnauthorized, "Authorization header must be a bearer token", http.StatusUnauthorized, "unauthorized"},
+	} {
+		w := httptest.NewRecorder()
+		writeJWTError(w, test.status, test.message)
+		if w.Code != test.want || w.Header().Get("Content-Type") != "application/json; charset=UTF-8" {
+			t.Errorf("%q got %d %s", test.message, w.Code, w.Header().Get("Content-Type"))
+		}
+		if e := envelope(t, w); e.Code != test.code || e.Status != strconv.Itoa(test.want) {
+			t.Errorf("%q got %+v", test.message, e)
+		}
+	}
+}
+
+func TestJWTErrorWriter(t *testing.T) {
+	// Plain text errors are held back
+	w := httptest.NewRecorder()
+	jw := &jwtErrorWriter{ResponseWriter: w}
+	jw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	jw.WriteHeader(http.StatusUnauthorized)
+	jw.Write([]byte("this token has expired\n"))
+	if jw.status != http.StatusUnauthorized || jw.body.String() != "this token has expired\n" || w.Body.Len() != 0 {
+		t.Errorf("got %d %q, wrote %q", jw.status, jw.body.String(), w.Body)
+	}
+
+	// Everything else passes through
+	w = httptest.NewRecorder()
+	jw = &jwtErrorWriter{ResponseWriter: w}
+	jw.Header().Set("Content-Type", "application/json")
+	jw.WriteHeader(http.StatusNotFound)
+	jw.Write([]byte(`{}`))
+	if jw.status != 0 || w.Code != http.StatusNotFound || w.Body.String() != `{}` {
+		t.Errorf("got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestSecureEnvelope(t *testing.T) {
+	at := newAPITest(t)
+
+	for _, token := range []string{"", "not a token"} {
+		w := at.record(at.request("GET", "/hymenobacter/species", token, nil), http.StatusUnauthorized)
+		if e := envelope(t, w); e.Status != "401" || e.Code == "" {
+			t.Errorf("token %q got %+v", token, e)
+		}
+	}
+}
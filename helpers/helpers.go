@@ -5,6 +5,8 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/gorilla/context"
@@ -22,6 +24,9 @@ var (
 	SchemaDecoder = schema.NewDecoder()
 )
 
+// MaxBody is the most of a request body read by the routes that decode one.
+const MaxBody = 1 << 20
+
 // ListOptions specifies general pagination options for fetching a list of results
 type ListOptions struct {
 	PerPage int64   `url:",omitempty" json:",omitempty"`
@@ -37,6 +42,59 @@ type MeasurementListOptions struct {
 	Characteristics []int64 `schema:"characteristic_ids"`
 }
 
+// PayloadOptions specifies which related entities to sideload, and which
+// attributes to return for each entity type.
+type PayloadOptions struct {
+	Include []string
+	Fields  map[string][]string
+}
+
+// PayloadOptionsFromValues pulls the include and fields[type] parameters out
+// of a set of query values. The parameters are removed from val, so that the
+// remaining values can be decoded into ListOptions.
+func PayloadOptionsFromValues(val *url.Values) PayloadOptions {
+	var opt PayloadOptions
+	for key, values := range *val {
+		if key == "include" {
+			opt.Include = splitParams(values)
+			val.Del(key)
+		} else if strings.HasPrefix(key, "fields[") && strings.HasSuffix(key, "]") {
+			if opt.Fields == nil {
+				opt.Fields = make(map[string][]string)
+			}
+			opt.Fields[key[len("fields["):len(key)-1]] = splitParams(values)
+			val.Del(key)
+		}
+	}
+	return opt
+}
+
+// Includes reports whether a related entity type should be sideloaded.
+// Everything is sideloaded when no include parameter was given.
+func (p PayloadOptions) Includes(name string) bool {
+	if p.Include == nil {
+		return true
+	}
+	for _, i := range p.Include {
+		if i == name {
+			return true
+		}
+	}
+	return false
+}
+
+func splitParams(values []string) []string {
+	params := make([]string, 0)
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				params = append(params, p)
+			}
+		}
+	}
+	return params
+}
+
 // ValsIn emits X IN (A, B, C) SQL statements
 func ValsIn(attribute string, values []int64, vals *[]interface{}, counter *int64) string {
 	if len(values) == 1 {
@@ -53,11 +111,12 @@ func ValsIn(attribute string, values []int64, vals *[]interface{}, counter *int6
 	return m
 }
 
-// CurrentTime returns current time
+// CurrentTime returns current time, truncated to the precision that Postgres
+// stores (so that ETags match before and after a round-trip).
 func CurrentTime() types.NullTime {
 	return types.NullTime{
 		pq.NullTime{
-			Time:  time.Now(),
+			Time:  time.Now().Truncate(time.Microsecond),
 			Valid: true,
 		},
 	}
@@ -0,0 +1,38 @@
+package helpers
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestPayloadOptionsFromValues(t *testing.T) {
+	val, err := url.ParseQuery("include=species,+measurements&include=&fields[strains]=strainName,notes&fields[species]=&fields[=x&page=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := PayloadOptionsFromValues(&val)
+
+	if want := []string{"species", "measurements"}; !reflect.DeepEqual(opt.Include, want) {
+		t.Errorf("include %v, want %v", opt.Include, want)
+	}
+	want := map[string][]string{"strains": {"strainName", "notes"}, "species": {}}
+	if !reflect.DeepEqual(opt.Fields, want) {
+		t.Errorf("fields %v, want %v", opt.Fields, want)
+	}
+	// Everything else is left for the list options
+	if want := (url.Values{"page": {"2"}, "fields[": {"x"}}); !reflect.DeepEqual(val, want) {
+		t.Errorf("left %v, want %v", val, want)
+	}
+
+	if !opt.Includes("species") || opt.Includes("strains") {
+		t.Errorf("includes wrong with %v", opt.Include)
+	}
+	var none PayloadOptions
+	if !none.Includes("strains") || none.Fields != nil {
+		t.Error("no parameters should include everything")
+	}
+	if empty := PayloadOptionsFromValues(&url.Values{"include": {""}}); empty.Includes("strains") {
+		t.Error("an empty include should include nothing")
+	}
+}
@@ -1,46 +1,57 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/DavidHuie/gomigrate"
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/codegangsta/cli"
-	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/jmoiron/sqlx"
-	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/lib/pq"
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/mailgun/mailgun-go"
 	"github.com/thermokarst/bactdb/api"
+	"github.com/thermokarst/bactdb/auth"
+	"github.com/thermokarst/bactdb/config"
 	"github.com/thermokarst/bactdb/handlers"
+	"github.com/thermokarst/bactdb/metrics"
 	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/types"
 )
 
-func init() {
-	var connectOnce sync.Once
-	connectOnce.Do(func() {
-		var err error
-		connection := "timezone=UTC "
-		if heroku := os.Getenv("HEROKU"); heroku == "true" {
-			url := os.Getenv("DATABASE_URL")
-			conn, _ := pq.ParseURL(url)
-			connection += conn
-			connection += " sslmode=require"
-		} else {
-			connection += " sslmode=disable"
-		}
-		models.DB.Dbx, err = sqlx.Open("postgres", connection)
-		if err != nil {
-			log.Fatal("Error connecting to PostgreSQL database (using PG* environment variables): ", err)
-		}
-		models.DB.TraceOn("[modl]", log.New(os.Stdout, "bactdb:", log.Lmicroseconds))
-		models.DB.Db = models.DB.Dbx.DB
-	})
+// cfg is the configuration, loaded before any command runs.
+var cfg *config.Config
+
+// openStore opens the database, with the configuration checked first. The
+// connection string is returned too, for listening on.
+func openStore() (*models.Store, string) {
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("Invalid configuration, see bactdb config check:\n", err)
+	}
+
+	conninfo, err := cfg.Conninfo()
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := models.Open(conninfo, cfg.Pool(), cfg.Database.ConnectTimeout.Duration)
+	if err != nil {
+		log.Fatal("Error connecting to PostgreSQL database (using PG* environment variables): ", err)
+	}
+	store.Logins = models.LoginLockout{Attempts: cfg.Login.Attempts, Period: cfg.Login.Lockout.Duration}
+	return store, conninfo
 }
 
 func main() {
+	// Everything is logged as JSON, including what goes through log
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
 	app := cli.NewApp()
 	app.Name = "bactdb"
 	app.Usage = "a database for bacteria"
@@ -50,14 +61,57 @@ func main() {
 			Email: "mrdillon@alaska.edu",
 		},
 	}
-	app.Version = "0.1.0"
+	app.Version = handlers.DefaultOptions().Version
+
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Path to a JSON config file, read before the environment",
+			EnvVar: "BACTDB_CONFIG",
+		},
+		cli.IntFlag{
+			Name:  "port",
+			Usage: "Port to serve HTTP on",
+		},
+		cli.IntFlag{
+			Name:  "grpc-port",
+			Usage: "Port to serve gRPC on",
+		},
+		cli.StringFlag{
+			Name:  "log-sql",
+			Usage: "SQL to log: off, slow, statements or args",
+		},
+	}
+	app.Before = loadConfig
 
 	app.Commands = []cli.Command{
 		{
 			Name:      "serve",
 			ShortName: "s",
 			Usage:     "Start web server",
-			Action:    cmdServe,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "tls-cert",
+					Usage: "PEM certificate to serve HTTPS with, reloaded on SIGHUP",
+				},
+				cli.StringFlag{
+					Name:  "tls-key",
+					Usage: "PEM key for the certificate",
+				},
+				cli.StringFlag{
+					Name:  "tls-min-version",
+					Usage: "Oldest TLS version accepted: 1.0, 1.1, 1.2 or 1.3",
+				},
+				cli.IntFlag{
+					Name:  "tls-redirect-port",
+					Usage: "Port to redirect plain HTTP to HTTPS from",
+				},
+				cli.DurationFlag{
+					Name:  "hsts",
+					Usage: "Strict-Transport-Security max-age, like 8760h",
+				},
+			},
+			Action: cmdServe,
 		},
 		{
 			Name:      "migrate",
@@ -70,54 +124,280 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "migration_path",
-					Usage: "Path to migration DDL",
-					Value: "./migrations",
+					Usage: "Path to migration DDL, instead of the configured one",
 				},
 			},
 			Action: cmdMigrateDb,
 		},
+		{
+			Name:  "config",
+			Usage: "Configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:   "check",
+					Usage:  "Print the configuration, with secrets redacted, and check it",
+					Action: cmdConfigCheck,
+				},
+			},
+		},
 	}
-	app.Run(os.Args)
+	app.RunAndExitOnError()
 }
 
-func cmdServe(c *cli.Context) {
+// loadConfig loads the config file, the environment, then the flags.
+func loadConfig(c *cli.Context) error {
 	var err error
+	if cfg, err = config.Load(c.GlobalString("config")); err != nil {
+		return err
+	}
+	if c.GlobalIsSet("port") {
+		cfg.Port = c.GlobalInt("port")
+	}
+	if c.GlobalIsSet("grpc-port") {
+		cfg.GRPCPort = c.GlobalInt("grpc-port")
+	}
+	if c.GlobalIsSet("log-sql") {
+		if err := cfg.Log.SQL.UnmarshalText([]byte(c.GlobalString("log-sql"))); err != nil {
+			return fmt.Errorf("--log-sql: %v", err)
+		}
+	}
+
+	models.SQLTrace.Level = cfg.Log.SQL
+	models.SQLTrace.Slow = cfg.Log.SlowQuery.Duration
+	return nil
+}
 
-	// Set up Mailgun handlers:
-	// [{"ref":"hymenobacter","domain":"hymenobacter.info","public":"abc","private":"123"}]
-	type account struct {
-		Ref     string
-		Domain  string
-		Public  string
-		Private string
+func cmdConfigCheck(c *cli.Context) {
+	b, _ := json.MarshalIndent(cfg.Redacted(), "", "  ")
+	fmt.Println(string(b))
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	var accounts []account
-	json.Unmarshal([]byte(os.Getenv("ACCOUNT_KEYS")), &accounts)
-	log.Printf("Mailgun: %+v", accounts)
+	fmt.Fprintln(os.Stderr, "Configuration OK")
+}
 
-	for _, a := range accounts {
-		api.MgAccts[a.Ref] = mailgun.NewMailgun(a.Domain, a.Private, a.Public)
+// serveFlags sets the serve command's flags over the config.
+func serveFlags(c *cli.Context) error {
+	if c.IsSet("tls-cert") {
+		cfg.TLS.Cert = c.String("tls-cert")
+	}
+	if c.IsSet("tls-key") {
+		cfg.TLS.Key = c.String("tls-key")
+	}
+	if c.IsSet("tls-min-version") {
+		if err := cfg.TLS.MinVersion.UnmarshalText([]byte(c.String("tls-min-version"))); err != nil {
+			return fmt.Errorf("--tls-min-version: %v", err)
+		}
+	}
+	if c.IsSet("tls-redirect-port") {
+		cfg.TLS.RedirectPort = c.Int("tls-redirect-port")
+	}
+	if c.IsSet("hsts") {
+		cfg.TLS.HSTS.Duration = c.Duration("hsts")
 	}
+	return nil
+}
 
-	addr := os.Getenv("PORT")
-	if addr == "" {
-		addr = "8901"
+func cmdServe(c *cli.Context) {
+	if err := serveFlags(c); err != nil {
+		log.Fatal(err)
 	}
-	httpAddr := fmt.Sprintf(":%v", addr)
+	store, conninfo := openStore()
 
-	m := http.NewServeMux()
-	m.Handle("/api/", http.StripPrefix("/api", handlers.Handler()))
+	mw, err := auth.New(cfg.Secret, store)
+	if err != nil {
+		log.Fatal("Error setting up authentication: ", err)
+	}
+	app := api.NewApp(store, mw)
+
+	for _, a := range cfg.Accounts {
+		app.Mail[a.Ref] = mailgun.NewMailgun(a.Domain, a.Private, a.Public)
+	}
+	log.Printf("Mailgun: %d accounts", len(cfg.Accounts))
+
+	opt := handlers.DefaultOptions()
+	opt.CORS = cfg.CORS.Policy(cfg.Domains)
+	opt.Throttle = types.Throttling{
+		PerIP:      cfg.Throttle.PerIP,
+		PerEmail:   cfg.Throttle.PerEmail,
+		TrustProxy: cfg.Throttle.TrustProxy,
+	}
 
-	log.Print("Listening on ", httpAddr)
-	err = http.ListenAndServe(httpAddr, m)
+	httpAddr := fmt.Sprintf(":%d", cfg.Port)
+
+	// Changes made through any instance are passed on to the event streams
+	listener, err := models.ListenChanges(conninfo, app.Changes.Publish)
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		log.Fatal("Error listening for changes: ", err)
+	}
+
+	m := http.NewServeMux()
+	m.Handle("/api/", http.StripPrefix("/api", handlers.Handler(app, opt)))
+	m.Handle("/metrics", metrics.Handler())
+
+	// Ready once the schema is up to date with the migrations shipped
+	m.Handle("/healthz", handlers.Health())
+	m.Handle("/readyz", handlers.Ready(store, cfg.MigrationsPath))
+
+	// gRPC is served on a port of its own, over cleartext HTTP/2
+	rpcAddr := fmt.Sprintf(":%d", cfg.GRPCPort)
+	timeout := cfg.Timeouts
+
+	// No write timeout for gRPC, its streams can run as long as they like
+	rpcServer := &http.Server{
+		Addr:              rpcAddr,
+		Handler:           handlers.RPCHandler(app),
+		Protocols:         new(http.Protocols),
+		ReadHeaderTimeout: timeout.Read.Duration,
+		IdleTimeout:       timeout.Idle.Duration,
+	}
+	rpcServer.Protocols.SetUnencryptedHTTP2(true)
+
+	// Event streams outlast the write timeout, they set deadlines of their own
+	server := &http.Server{
+		Addr:              httpAddr,
+		Handler:           m,
+		ReadHeaderTimeout: timeout.Read.Duration,
+		ReadTimeout:       timeout.Read.Duration,
+		WriteTimeout:      timeout.Write.Duration,
+		IdleTimeout:       timeout.Idle.Duration,
+	}
+	// Shutdown doesn't end streams, so they're ended here
+	server.RegisterOnShutdown(app.Changes.Close)
+	servers := []*http.Server{server, rpcServer}
+
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer cancel()
+
+	errs := make(chan error, 3)
+	go func() {
+		log.Print("gRPC listening on ", rpcServer.Addr)
+		errs <- fmt.Errorf("gRPC ListenAndServe: %v", rpcServer.ListenAndServe())
+	}()
+
+	if cfg.TLS.Enabled() {
+		cert, err := handlers.LoadCertificate(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			log.Fatal("Error loading the TLS certificate: ", err)
+		}
+		go reloadOnHangup(stop, cert)
+
+		server.TLSConfig = &tls.Config{
+			MinVersion:     uint16(cfg.TLS.MinVersion),
+			GetCertificate: cert.GetCertificate,
+		}
+		if cfg.TLS.HSTS.Duration > 0 {
+			server.Handler = handlers.HSTS(m, cfg.TLS.HSTS.Duration)
+		}
+		go func() {
+			log.Print("Listening for HTTPS on ", httpAddr)
+			errs <- fmt.Errorf("ListenAndServeTLS: %v", server.ListenAndServeTLS("", ""))
+		}()
+
+		if cfg.TLS.RedirectPort != 0 {
+			redirect := &http.Server{
+				Addr:              fmt.Sprintf(":%d", cfg.TLS.RedirectPort),
+				Handler:           handlers.RedirectHTTPS(cfg.Port),
+				ReadHeaderTimeout: timeout.Read.Duration,
+				ReadTimeout:       timeout.Read.Duration,
+				WriteTimeout:      timeout.Write.Duration,
+				IdleTimeout:       timeout.Idle.Duration,
+			}
+			servers = append(servers, redirect)
+			go func() {
+				log.Print("Redirecting to HTTPS from ", redirect.Addr)
+				errs <- fmt.Errorf("redirect ListenAndServe: %v", redirect.ListenAndServe())
+			}()
+		}
+	} else {
+		go func() {
+			log.Print("Listening on ", httpAddr)
+			errs <- fmt.Errorf("ListenAndServe: %v", server.ListenAndServe())
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		log.Fatal(err)
+	case <-stop.Done():
+	}
+	cancel()
+
+	log.Printf("Shutting down, waiting up to %v for requests to finish", timeout.Shutdown.Duration)
+	ctx, done := context.WithTimeout(context.Background(), timeout.Shutdown.Duration)
+	defer done()
+	drain(ctx, app, store, listener, servers)
+	log.Print("Shut down")
+}
+
+// reloadOnHangup reloads the certificate on every SIGHUP, until ctx is done,
+// so that a renewed certificate is picked up without a restart.
+func reloadOnHangup(ctx context.Context, cert *handlers.Certificate) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			if err := cert.Reload(); err != nil {
+				log.Print("Reloading the TLS certificate, keeping the old one: ", err)
+				continue
+			}
+			log.Print("Reloaded the TLS certificate")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drain stops taking requests, and waits for the ones under way, then for
+// webhook deliveries, before closing the database. Whatever's left at the
+// deadline is cut off.
+func drain(ctx context.Context, app *api.App, store io.Closer, listener io.Closer, servers []*http.Server) {
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *http.Server) {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				log.Printf("Shutting down %s: %v", s.Addr, err)
+				s.Close()
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	if err := listener.Close(); err != nil {
+		log.Print("Closing the change listener: ", err)
+	}
+
+	delivered := make(chan struct{})
+	go func() {
+		app.Webhooks.Wait()
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+	case <-ctx.Done():
+		log.Print("Gave up waiting for webhook deliveries")
+	}
+
+	if err := store.Close(); err != nil {
+		log.Print("Closing the database: ", err)
 	}
 }
 
 func cmdMigrateDb(c *cli.Context) {
+	store, _ := openStore()
+	defer store.Close()
+
 	migrationsPath := c.String("migration_path")
-	migrator, err := gomigrate.NewMigrator(models.DB.Dbx.DB, gomigrate.Postgres{}, migrationsPath)
+	if migrationsPath == "" {
+		migrationsPath = cfg.MigrationsPath
+	}
+	migrator, err := gomigrate.NewMigrator(store.Dbx.DB, gomigrate.Postgres{}, migrationsPath)
 	if err != nil {
 		log.Fatal("Error initializing migrations: ", err)
 	}
@@ -127,7 +407,7 @@ func cmdMigrateDb(c *cli.Context) {
 	if c.Bool("drop") {
 		// Back up users table
 		// TODO: look into this
-		if err := models.DBH.Select(&users, `SELECT * FROM users;`); err != nil {
+		if err := store.Select(&users, `SELECT * FROM users;`); err != nil {
 			log.Printf("Couldn't back up identity tables: %+v", err)
 		}
 		log.Printf("%+v Users", len(users))
@@ -150,7 +430,7 @@ func cmdMigrateDb(c *cli.Context) {
 			// varargs don't seem to work here, loop instead
 			for _, user := range users {
 				// TODO: look into this
-				if err := models.DBH.Insert(user.UserBase); err != nil {
+				if err := store.Insert(user.UserBase); err != nil {
 					log.Fatal("Couldn't restore user: ", err)
 				}
 			}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests.", "route", "status")
+	c.Inc("/a", "200")
+	c.Add(2, "/a\"b", "500")
+	h := NewHistogram("test_duration_seconds", "Durations.", []float64{.1, 1}, "route")
+	h.Observe(.05, "/a")
+	h.Observe(.5, "/a")
+	h.Observe(5, "/a")
+	NewGaugeFunc("test_open", "Open things.", func() float64 { return 3 })
+
+	var b bytes.Buffer
+	WriteTo(&b)
+	want := `# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/a",le="0.1"} 1
+test_duration_seconds_bucket{route="/a",le="1"} 2
+test_duration_seconds_bucket{route="/a",le="+Inf"} 3
+test_duration_seconds_sum{route="/a"} 5.55
+test_duration_seconds_count{route="/a"} 3
+# HELP test_open Open things.
+# TYPE test_open gauge
+test_open 3
+# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"b",status="500"} 2
+test_requests_total{route="/a",status="200"} 1
+`
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
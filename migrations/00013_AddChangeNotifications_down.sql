@@ -0,0 +1,9 @@
+-- bactdb
+-- Matthew R Dillon
+
+DROP TRIGGER notify_change ON measurements;
+DROP TRIGGER notify_change ON characteristics;
+DROP TRIGGER notify_change ON strains;
+DROP TRIGGER notify_change ON species;
+
+DROP FUNCTION notify_change();
@@ -0,0 +1,71 @@
+-- bactdb
+-- Matthew R Dillon
+
+-- Notify the bactdb_changes channel of every write to the curated tables, so
+-- that every server instance listening can pass the change on to its clients.
+-- The payload is the change as JSON: genus, entity, id, action and user.
+--
+-- The user is the one set for the transaction with
+--   SELECT set_config('bactdb.user_id', '<id>', true)
+-- falling back to the row's updated_by, which is all there is for inserts and
+-- updates anyway.
+--
+-- Characteristics are shared between genera, so their changes have no genus.
+-- Neither do measurements deleted along with their strain, which is already
+-- gone by the time the cascade gets to them.
+
+CREATE OR REPLACE FUNCTION notify_change() RETURNS TRIGGER AS $body$
+DECLARE
+    r RECORD;
+    genus TEXT;
+    user_id BIGINT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        r = OLD;
+    ELSE
+        r = NEW;
+    END IF;
+
+    IF TG_TABLE_NAME = 'species' THEN
+        SELECT LOWER(g.genus_name) INTO genus
+        FROM genera g
+        WHERE g.id = r.genus_id;
+    ELSIF TG_TABLE_NAME = 'strains' THEN
+        SELECT LOWER(g.genus_name) INTO genus
+        FROM species sp
+        INNER JOIN genera g ON g.id = sp.genus_id
+        WHERE sp.id = r.species_id;
+    ELSIF TG_TABLE_NAME = 'measurements' THEN
+        SELECT LOWER(g.genus_name) INTO genus
+        FROM strains st
+        INNER JOIN species sp ON sp.id = st.species_id
+        INNER JOIN genera g ON g.id = sp.genus_id
+        WHERE st.id = r.strain_id;
+    END IF;
+
+    user_id = COALESCE(NULLIF(current_setting('bactdb.user_id', true), '')::BIGINT, r.updated_by);
+
+    PERFORM pg_notify('bactdb_changes', json_build_object(
+        'genus', genus,
+        'entity', TG_TABLE_NAME,
+        'id', r.id,
+        'action', LOWER(TG_OP),
+        'user', user_id
+    )::TEXT);
+
+    RETURN NULL;
+END;
+$body$
+LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON species
+    FOR EACH ROW EXECUTE PROCEDURE notify_change();
+
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON strains
+    FOR EACH ROW EXECUTE PROCEDURE notify_change();
+
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON characteristics
+    FOR EACH ROW EXECUTE PROCEDURE notify_change();
+
+CREATE TRIGGER notify_change AFTER INSERT OR UPDATE OR DELETE ON measurements
+    FOR EACH ROW EXECUTE PROCEDURE notify_change();
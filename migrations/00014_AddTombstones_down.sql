@@ -0,0 +1,11 @@
+-- bactdb
+-- Matthew R Dillon
+
+DROP TRIGGER record_tombstone ON measurements;
+DROP TRIGGER record_tombstone ON characteristics;
+DROP TRIGGER record_tombstone ON strains;
+DROP TRIGGER record_tombstone ON species;
+
+DROP FUNCTION record_tombstone();
+
+DROP TABLE tombstones;
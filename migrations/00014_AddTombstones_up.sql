@@ -0,0 +1,59 @@
+-- bactdb
+-- Matthew R Dillon
+
+-- A tombstone is left behind for every species, strain, characteristic and
+-- measurement deleted, so that clients keeping a copy can find out about the
+-- deletion. genus_id is NULL for characteristics, which are shared between
+-- genera, and for anything deleted along with its parent.
+
+CREATE TABLE tombstones (
+    id BIGSERIAL NOT NULL,
+    genus_id BIGINT NULL,
+    entity TEXT NOT NULL,
+    entity_id BIGINT NOT NULL,
+
+    deleted_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    deleted_by BIGINT NOT NULL,
+
+    CONSTRAINT tombstones_pkey PRIMARY KEY (id)
+);
+
+CREATE INDEX tombstones_deleted_at_idx ON tombstones (deleted_at);
+
+CREATE OR REPLACE FUNCTION record_tombstone() RETURNS TRIGGER AS $body$
+DECLARE
+    genus BIGINT;
+BEGIN
+    IF TG_TABLE_NAME = 'species' THEN
+        genus = OLD.genus_id;
+    ELSIF TG_TABLE_NAME = 'strains' THEN
+        SELECT sp.genus_id INTO genus
+        FROM species sp
+        WHERE sp.id = OLD.species_id;
+    ELSIF TG_TABLE_NAME = 'measurements' THEN
+        SELECT sp.genus_id INTO genus
+        FROM strains st
+        INNER JOIN species sp ON sp.id = st.species_id
+        WHERE st.id = OLD.strain_id;
+    END IF;
+
+    INSERT INTO tombstones (genus_id, entity, entity_id, deleted_at, deleted_by)
+    VALUES (genus, TG_TABLE_NAME, OLD.id, now(),
+        COALESCE(NULLIF(current_setting('bactdb.user_id', true), '')::BIGINT, OLD.updated_by));
+
+    RETURN NULL;
+END;
+$body$
+LANGUAGE plpgsql;
+
+CREATE TRIGGER record_tombstone AFTER DELETE ON species
+    FOR EACH ROW EXECUTE PROCEDURE record_tombstone();
+
+CREATE TRIGGER record_tombstone AFTER DELETE ON strains
+    FOR EACH ROW EXECUTE PROCEDURE record_tombstone();
+
+CREATE TRIGGER record_tombstone AFTER DELETE ON characteristics
+    FOR EACH ROW EXECUTE PROCEDURE record_tombstone();
+
+CREATE TRIGGER record_tombstone AFTER DELETE ON measurements
+    FOR EACH ROW EXECUTE PROCEDURE record_tombstone();
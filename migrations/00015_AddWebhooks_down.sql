@@ -0,0 +1,6 @@
+-- bactdb
+-- Matthew R Dillon
+
+DROP TABLE webhook_deliveries;
+
+DROP TABLE webhooks;
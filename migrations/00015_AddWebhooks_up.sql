@@ -0,0 +1,47 @@
+-- bactdb
+-- Matthew R Dillon
+
+-- Webhooks are called when a genus' data changes, for any of the events in
+-- the comma-separated list, like strains.create, strains.* or *.
+
+CREATE TABLE webhooks (
+    id BIGSERIAL NOT NULL,
+    genus_id BIGINT NOT NULL,
+    url TEXT NOT NULL,
+    events TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    active BOOLEAN NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    created_by BIGINT NOT NULL,
+    updated_by BIGINT NOT NULL,
+
+    CONSTRAINT webhooks_pkey PRIMARY KEY (id),
+    FOREIGN KEY (genus_id) REFERENCES genera(id),
+    FOREIGN KEY (created_by) REFERENCES users(id),
+    FOREIGN KEY (updated_by) REFERENCES users(id)
+);
+
+CREATE INDEX webhooks_genus_id_idx ON webhooks (genus_id);
+
+-- Every attempt at a delivery is logged. Retries share the delivery ID.
+
+CREATE TABLE webhook_deliveries (
+    id BIGSERIAL NOT NULL,
+    webhook_id BIGINT NOT NULL,
+    delivery TEXT NOT NULL,
+    event TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER NULL,
+    error TEXT NULL,
+    duration_ms BIGINT NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    CONSTRAINT webhook_deliveries_pkey PRIMARY KEY (id),
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+);
+
+CREATE INDEX webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id);
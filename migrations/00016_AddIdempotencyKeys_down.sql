@@ -0,0 +1,4 @@
+-- bactdb
+-- Matthew R Dillon
+
+DROP TABLE idempotency_keys;
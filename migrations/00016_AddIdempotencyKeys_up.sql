@@ -0,0 +1,22 @@
+-- bactdb
+-- Matthew R Dillon
+
+-- The first response to a create with an Idempotency-Key, kept for a while so
+-- that retries get the same response, rather than creating again. A row with
+-- no status is a request still being handled.
+
+CREATE TABLE idempotency_keys (
+    user_id BIGINT NOT NULL,
+    key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    status INTEGER NULL,
+    header TEXT NULL,
+    body BYTEA NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    CONSTRAINT idempotency_keys_pkey PRIMARY KEY (user_id, key),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
@@ -0,0 +1,17 @@
+-- bactdb
+-- Matthew R Dillon
+
+-- Failed logins in a row, by email address, for locking accounts out for a
+-- while. Addresses without an account are counted too, so that a lockout
+-- doesn't give away which addresses have one.
+
+CREATE TABLE login_failures (
+    email TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    failed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE NULL,
+
+    CONSTRAINT login_failures_pkey PRIMARY KEY (email)
+);
+
+CREATE INDEX login_failures_failed_at_idx ON login_failures (failed_at);
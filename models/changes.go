@@ -0,0 +1,76 @@
+package models
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/jmoiron/modl"
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/lib/pq"
+)
+
+// ChangesChannel is what the change triggers notify on.
+const ChangesChannel = "bactdb_changes"
+
+// Change is a write to one of the species, strains, characteristics or
+// measurements, as notified by the change triggers.
+type Change struct {
+	// Genus is empty for changes that can't be tied to a genus, like those to
+	// characteristics, which are shared.
+	Genus  string `json:"genus,omitempty"`
+	Entity string `json:"entity"`
+	ID     int64  `json:"id"`
+	Action string `json:"action"`
+	User   int64  `json:"user"`
+}
+
+// SetChangedBy records who is making the changes in a transaction, for the
+// change triggers. It's only needed for deletes, otherwise the triggers can
+// go by updated_by.
+func SetChangedBy(e modl.SqlExecutor, userID int64) error {
+	_, err := e.Exec(`SELECT set_config('bactdb.user_id', $1, true);`, strconv.FormatInt(userID, 10))
+	return err
+}
+
+// ListenChanges passes every change notified on to fn, until the listener is
+// closed. Every server instance listening gets every change, wherever it was
+// made. Changes made while the connection is down are lost, so fn is given a
+// nil change after reconnecting.
+func ListenChanges(conninfo string, fn func(*Change)) (*pq.Listener, error) {
+	l := pq.NewListener(conninfo, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Change listener: %v", err)
+		}
+	})
+	if err := l.Listen(ChangesChannel); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			select {
+			case n, ok := <-l.Notify:
+				if !ok {
+					return
+				}
+				if n == nil {
+					fn(nil)
+					continue
+				}
+				var c Change
+				if err := json.Unmarshal([]byte(n.Extra), &c); err != nil {
+					log.Printf("Change listener: bad notification %q: %v", n.Extra, err)
+					continue
+				}
+				fn(&c)
+			case <-time.After(90 * time.Second):
+				// A quiet connection might be a dead one
+				go l.Ping()
+			}
+		}
+	}()
+
+	return l, nil
+}
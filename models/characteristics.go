@@ -11,7 +11,7 @@ import (
 )
 
 func init() {
-	DB.AddTableWithName(CharacteristicBase{}, "characteristics").SetKeys(true, "ID")
+	addTable(CharacteristicBase{}, "characteristics")
 }
 
 // PreInsert is a modl hook
@@ -86,7 +86,25 @@ type Characteristic struct {
 type Characteristics []*Characteristic
 
 // ListCharacteristics returns all characteristics
-func ListCharacteristics(opt helpers.ListOptions, claims *types.Claims) (*Characteristics, error) {
+func (db *Store) ListCharacteristics(opt helpers.ListOptions, claims *types.Claims) (*Characteristics, error) {
+	q, vals := listCharacteristicsQuery(opt)
+
+	var characteristics Characteristics
+	err := db.Select(&characteristics, q, vals...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range characteristics {
+		c.CanEdit = helpers.CanEdit(claims, c.CreatedBy)
+	}
+
+	return &characteristics, nil
+}
+
+// listCharacteristicsQuery builds the query for a list of characteristics,
+// shared by ListCharacteristics and StreamCharacteristics.
+func listCharacteristicsQuery(opt helpers.ListOptions) (string, []interface{}) {
 	var vals []interface{}
 
 	q := `SELECT c.*, ct.characteristic_type_name,
@@ -108,23 +126,23 @@ func ListCharacteristics(opt helpers.ListOptions, claims *types.Claims) (*Charac
 
 	q += ` GROUP BY c.id, ct.characteristic_type_name
 			ORDER BY ct.characteristic_type_name, c.sort_order ASC;`
+	return q, vals
+}
 
-	var characteristics Characteristics
-	err := DBH.Select(&characteristics, q, vals...)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, c := range characteristics {
-		c.CanEdit = helpers.CanEdit(claims, c.CreatedBy)
-	}
-
-	return &characteristics, nil
+// StreamCharacteristics passes each of the characteristics to fn as it's read
+// from the database, rather than returning them all at once.
+func (db *Store) StreamCharacteristics(opt helpers.ListOptions, claims *types.Claims, fn func(*Characteristic) error) error {
+	q, vals := listCharacteristicsQuery(opt)
+	return db.stream(q, vals, func() interface{} { return &Characteristic{} }, func(row interface{}) error {
+		e := row.(*Characteristic)
+		e.CanEdit = helpers.CanEdit(claims, e.CreatedBy)
+		return fn(e)
+	})
 }
 
 // StrainOptsFromCharacteristics returns the options for finding all related strains
 // for a set of characteristics.
-func StrainOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.ListOptions, error) {
+func (db *Store) StrainOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.ListOptions, error) {
 	var relatedStrainIDs []int64
 	baseQ := `SELECT DISTINCT m.strain_id
 		FROM measurements m
@@ -133,7 +151,7 @@ func StrainOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.ListOption
 		INNER JOIN genera g ON g.id=sp.genus_id AND LOWER(g.genus_name)=LOWER($1)`
 	if opt.IDs == nil {
 		q := fmt.Sprintf("%s;", baseQ)
-		if err := DBH.Select(&relatedStrainIDs, q, opt.Genus); err != nil {
+		if err := db.Select(&relatedStrainIDs, q, opt.Genus); err != nil {
 			return nil, err
 		}
 	} else {
@@ -142,7 +160,7 @@ func StrainOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.ListOption
 		vals = append(vals, opt.Genus)
 		q := fmt.Sprintf("%s WHERE %s ", baseQ, helpers.ValsIn("m.characteristic_id", opt.IDs, &vals, &count))
 
-		if err := DBH.Select(&relatedStrainIDs, q, vals...); err != nil {
+		if err := db.Select(&relatedStrainIDs, q, vals...); err != nil {
 			return nil, err
 		}
 	}
@@ -152,7 +170,7 @@ func StrainOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.ListOption
 
 // MeasurementOptsFromCharacteristics returns the options for finding all related
 // measurements for a set of characteristics.
-func MeasurementOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.MeasurementListOptions, error) {
+func (db *Store) MeasurementOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.MeasurementListOptions, error) {
 	var relatedMeasurementIDs []int64
 	baseQ := `SELECT m.id
 		FROM measurements m
@@ -162,7 +180,7 @@ func MeasurementOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.Measu
 
 	if opt.IDs == nil {
 		q := fmt.Sprintf("%s;", baseQ)
-		if err := DBH.Select(&relatedMeasurementIDs, q, opt.Genus); err != nil {
+		if err := db.Select(&relatedMeasurementIDs, q, opt.Genus); err != nil {
 			return nil, err
 		}
 	} else {
@@ -171,7 +189,7 @@ func MeasurementOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.Measu
 		vals = append(vals, opt.Genus)
 		q := fmt.Sprintf("%s WHERE %s;", baseQ, helpers.ValsIn("characteristic_id", opt.IDs, &vals, &count))
 
-		if err := DBH.Select(&relatedMeasurementIDs, q, vals...); err != nil {
+		if err := db.Select(&relatedMeasurementIDs, q, vals...); err != nil {
 			return nil, err
 		}
 	}
@@ -181,18 +199,18 @@ func MeasurementOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.Measu
 
 // StrainsFromCharacteristicID returns a set of strains (as well as the options for
 // finding those strains) for a particular characteristic.
-func StrainsFromCharacteristicID(id int64, genus string, claims *types.Claims) (*Strains, *helpers.ListOptions, error) {
+func (db *Store) StrainsFromCharacteristicID(id int64, genus string, claims *types.Claims) (*Strains, *helpers.ListOptions, error) {
 	opt := helpers.ListOptions{
 		Genus: genus,
 		IDs:   []int64{id},
 	}
 
-	strainsOpt, err := StrainOptsFromCharacteristics(opt)
+	strainsOpt, err := db.StrainOptsFromCharacteristics(opt)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	strains, err := ListStrains(*strainsOpt, claims)
+	strains, err := db.ListStrains(*strainsOpt, claims)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -202,18 +220,18 @@ func StrainsFromCharacteristicID(id int64, genus string, claims *types.Claims) (
 
 // MeasurementsFromCharacteristicID returns a set of measurements (as well as the
 // options for finding those measurements) for a particular characteristic.
-func MeasurementsFromCharacteristicID(id int64, genus string, claims *types.Claims) (*Measurements, *helpers.MeasurementListOptions, error) {
+func (db *Store) MeasurementsFromCharacteristicID(id int64, genus string, claims *types.Claims) (*Measurements, *helpers.MeasurementListOptions, error) {
 	opt := helpers.ListOptions{
 		Genus: genus,
 		IDs:   []int64{id},
 	}
 
-	measurementOpt, err := MeasurementOptsFromCharacteristics(opt)
+	measurementOpt, err := db.MeasurementOptsFromCharacteristics(opt)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	measurements, err := ListMeasurements(*measurementOpt, claims)
+	measurements, err := db.ListMeasurements(*measurementOpt, claims)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -222,7 +240,7 @@ func MeasurementsFromCharacteristicID(id int64, genus string, claims *types.Clai
 }
 
 // GetCharacteristic returns a particular characteristic.
-func GetCharacteristic(id int64, genus string, claims *types.Claims) (*Characteristic, error) {
+func (db *Store) GetCharacteristic(id int64, genus string, claims *types.Claims) (*Characteristic, error) {
 	var characteristic Characteristic
 	q := `SELECT c.*, ct.characteristic_type_name,
 		array_agg(DISTINCT st.id) AS strains, array_agg(DISTINCT m.id) AS measurements
@@ -234,7 +252,7 @@ func GetCharacteristic(id int64, genus string, claims *types.Claims) (*Character
 		INNER JOIN characteristic_types ct ON ct.id=c.characteristic_type_id
 		WHERE c.id=$2
 		GROUP BY c.id, ct.characteristic_type_name;`
-	if err := DBH.SelectOne(&characteristic, q, genus, id); err != nil {
+	if err := db.SelectOne(&characteristic, q, genus, id); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.ErrCharacteristicNotFound
 		}
@@ -248,16 +266,16 @@ func GetCharacteristic(id int64, genus string, claims *types.Claims) (*Character
 
 // InsertOrGetCharacteristicType performs an UPSERT operation on the database
 // for a characteristic type.
-func InsertOrGetCharacteristicType(val string, claims *types.Claims) (int64, error) {
+func InsertOrGetCharacteristicType(e modl.SqlExecutor, val string, claims *types.Claims) (int64, error) {
 	var id int64
 	q := `SELECT id FROM characteristic_types WHERE characteristic_type_name=$1;`
-	if err := DBH.SelectOne(&id, q, val); err != nil {
+	if err := e.SelectOne(&id, q, val); err != nil {
 		if err == sql.ErrNoRows {
 			i := `INSERT INTO characteristic_types
 				(characteristic_type_name, created_at, updated_at, created_by, updated_by)
 				VALUES ($1, $2, $3, $4, $5) RETURNING id;`
 			ct := helpers.CurrentTime()
-			if err := DB.Db.QueryRow(i, val, ct, ct, claims.Sub, claims.Sub).Scan(&id); err != nil {
+			if err := e.SelectOne(&id, i, val, ct, ct, claims.Sub, claims.Sub); err != nil {
 				return 0, err
 			}
 		} else {
@@ -266,3 +284,49 @@ func InsertOrGetCharacteristicType(val string, claims *types.Claims) (int64, err
 	}
 	return id, nil
 }
+
+// CreateCharacteristic inserts a new characteristic, creating its type first
+// if that's new too.
+func (db *Store) CreateCharacteristic(c *Characteristic, claims *types.Claims) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		typeID, err := InsertOrGetCharacteristicType(tx, c.CharacteristicType, claims)
+		if err != nil {
+			return err
+		}
+		c.CharacteristicTypeID = typeID
+
+		return Create(tx, c.CharacteristicBase)
+	})
+}
+
+// UpdateCharacteristic writes a characteristic's changes, if p allows its
+// current version, creating its type first if that's new.
+func (db *Store) UpdateCharacteristic(c *Characteristic, claims *types.Claims, p types.Precondition) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := CheckVersion(tx, "characteristics", c.ID, p); err != nil {
+			return err
+		}
+
+		typeID, err := InsertOrGetCharacteristicType(tx, c.CharacteristicType, claims)
+		if err != nil {
+			return err
+		}
+		c.CharacteristicTypeID = typeID
+
+		return Update(tx, c.CharacteristicBase)
+	})
+}
+
+// DeleteCharacteristic deletes a characteristic, tombstoned as deleted by
+// userID, if p allows its current version.
+func (db *Store) DeleteCharacteristic(c *CharacteristicBase, userID int64, p types.Precondition) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := CheckVersion(tx, "characteristics", c.ID, p); err != nil {
+			return err
+		}
+		if err := SetChangedBy(tx, userID); err != nil {
+			return err
+		}
+		return Delete(tx, c)
+	})
+}
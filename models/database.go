@@ -1,10 +1,234 @@
 package models
 
-import "github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/jmoiron/modl"
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
 
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/jmoiron/modl"
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/jmoiron/sqlx"
+)
+
+// Store is a bactdb database, which the models are read from and written to.
+// More than one can be open at once.
+type Store struct {
+	*modl.DbMap
+	// Logins is the lockout applied by DbAuthenticate.
+	Logins LoginLockout
+	// requestID is the request that statements are traced as, for a store
+	// from WithContext.
+	requestID string
+}
+
+// Pool is how a store's connections are pooled.
+type Pool struct {
+	// MaxOpen is the most connections open at once, zero for no limit.
+	MaxOpen int
+	// MaxIdle is the most idle connections kept for reuse.
+	MaxIdle int
+	// MaxLifetime is how long a connection is reused for, zero for ever.
+	MaxLifetime time.Duration
+}
+
+// table is a model's table, mapped by every store.
+type table struct {
+	model interface{}
+	name  string
+}
+
+var tables []table
+
+// addTable maps a model to its table, keyed by an ID assigned on insert.
+func addTable(model interface{}, name string) {
+	tables = append(tables, table{model, name})
+}
+
+// stores are those open, whose pools are reported as one.
+var stores sync.Map
+
+// The first retry connecting waits connectBackoff, doubling each time up to
+// maxConnectBackoff.
 var (
-	// DB is a sqlx/modl database map.
-	DB = &modl.DbMap{Dialect: modl.PostgresDialect{}}
-	// DBH is a global database handler.
-	DBH modl.SqlExecutor = DB
+	connectBackoff    = 500 * time.Millisecond
+	maxConnectBackoff = 10 * time.Second
 )
+
+// Open connects to PostgreSQL, with statements timed and traced. The database
+// is often still starting up along with bactdb, so it's retried with backoff
+// for up to wait before giving up.
+func Open(conninfo string, pool Pool, wait time.Duration) (*Store, error) {
+	db, err := sql.Open(DriverName, conninfo)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(pool.MaxOpen)
+	db.SetMaxIdleConns(pool.MaxIdle)
+	db.SetConnMaxLifetime(pool.MaxLifetime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	if err := retry(ctx, db.PingContext); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("gave up connecting after %v: %v", wait, err)
+	}
+	return NewStore(db), nil
+}
+
+// retry calls fn until it succeeds or ctx is done, returning its last error.
+func retry(ctx context.Context, fn func(context.Context) error) error {
+	backoff := connectBackoff
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		default:
+		}
+
+		log.Printf("Database isn't answering, retrying in %v: %v", backoff, err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// NewStore is a store for a database that's already open, which has to be
+// PostgreSQL.
+func NewStore(db *sql.DB) *Store {
+	dbx := sqlx.NewDb(db, "postgres")
+	m := &modl.DbMap{Db: dbx.DB, Dbx: dbx, Dialect: modl.PostgresDialect{}}
+	for _, t := range tables {
+		m.AddTableWithName(t.model, t.name).SetKeys(true, "ID")
+	}
+	s := &Store{DbMap: m, Logins: DefaultLogins}
+	stores.Store(s, true)
+	return s
+}
+
+// Close closes the database.
+func (db *Store) Close() error {
+	stores.Delete(db)
+	return db.Dbx.Close()
+}
+
+// WithContext returns the store for the request that ctx is for, which traces
+// its statements as the request's.
+func (db *Store) WithContext(ctx context.Context) Repository {
+	s := *db
+	s.requestID = RequestID(ctx)
+	return &s
+}
+
+// executor runs statements outside of a transaction. Store's own Select,
+// SelectOne, Exec, Get, Insert, Update and Delete go through it, so they're
+// traced.
+func (db *Store) executor() tracedExecutor {
+	return tracedExecutor{db.DbMap, db.DbMap, db.requestID}
+}
+
+// Select is modl's Select, traced.
+func (db *Store) Select(dest interface{}, query string, args ...interface{}) error {
+	return db.executor().Select(dest, query, args...)
+}
+
+// SelectOne is modl's SelectOne, traced.
+func (db *Store) SelectOne(dest interface{}, query string, args ...interface{}) error {
+	return db.executor().SelectOne(dest, query, args...)
+}
+
+// Exec is modl's Exec, traced.
+func (db *Store) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.executor().Exec(query, args...)
+}
+
+// Get is modl's Get, traced.
+func (db *Store) Get(dest interface{}, keys ...interface{}) error {
+	return db.executor().Get(dest, keys...)
+}
+
+// Insert is modl's Insert, traced.
+func (db *Store) Insert(list ...interface{}) error {
+	return db.executor().Insert(list...)
+}
+
+// Update is modl's Update, traced.
+func (db *Store) Update(list ...interface{}) (int64, error) {
+	return db.executor().Update(list...)
+}
+
+// Delete is modl's Delete, traced.
+func (db *Store) Delete(list ...interface{}) (int64, error) {
+	return db.executor().Delete(list...)
+}
+
+// Begin starts a new transaction. The caller is responsible for calling Commit
+// or Rollback.
+func (db *Store) Begin() (*Transaction, error) {
+	tx, err := db.DbMap.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{tracedExecutor{tx, db.DbMap, db.requestID}, tx}, nil
+}
+
+// Transact runs fn as a single unit of work. The transaction is committed when
+// fn returns nil, and rolled back otherwise.
+func (db *Store) Transact(fn func(modl.SqlExecutor) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// stream runs a query, scanning each row into a new value from newRow and
+// passing it to fn as soon as it's read, so that the results are never all in
+// memory at once. Streaming stops at fn's first error.
+func (db *Store) stream(q string, vals []interface{}, newRow func() interface{}, fn func(interface{}) error) error {
+	var rows *sqlx.Rows
+	err := db.executor().traced(q, vals, func() (err error) {
+		rows, err = db.Dbx.Queryx(q, vals...)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := newRow()
+		if err := rows.StructScan(row); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
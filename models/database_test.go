@@ -0,0 +1,31 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	defer func(b, m time.Duration) { connectBackoff, maxConnectBackoff = b, m }(connectBackoff, maxConnectBackoff)
+	connectBackoff, maxConnectBackoff = time.Millisecond, 2*time.Millisecond
+
+	tries := 0
+	up := func(context.Context) error {
+		if tries++; tries < 3 {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+	if err := retry(context.Background(), up); err != nil || tries != 3 {
+		t.Errorf("got %v after %d tries", err, tries)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	down := func(context.Context) error { return fmt.Errorf("connection refused") }
+	if err := retry(ctx, down); err == nil || err.Error() != "connection refused" {
+		t.Errorf("got %v, want the last error", err)
+	}
+}
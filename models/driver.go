@@ -0,0 +1,143 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"time"
+
+	"github.com/thermokarst/bactdb/metrics"
+)
+
+// DriverName is the PostgreSQL driver with every statement timed, which stores
+// are opened with.
+const DriverName = "bactdb-postgres"
+
+var (
+	queryDuration = metrics.NewHistogram("bactdb_sql_query_duration_seconds",
+		"SQL statement durations, by statement. Queries are timed to their first row.",
+		metrics.DefBuckets, "statement")
+)
+
+func init() {
+	// pq only registers its driver, opening doesn't connect.
+	db, _ := sql.Open("postgres", "")
+	sql.Register(DriverName, timedDriver{db.Driver()})
+
+	metrics.NewGaugeFunc("bactdb_db_open_connections", "Database connections, in use or idle.", func() float64 {
+		return float64(dbStats().OpenConnections)
+	})
+	metrics.NewGaugeFunc("bactdb_db_in_use_connections", "Database connections in use.", func() float64 {
+		return float64(dbStats().InUse)
+	})
+	metrics.NewGaugeFunc("bactdb_db_idle_connections", "Idle database connections.", func() float64 {
+		return float64(dbStats().Idle)
+	})
+	metrics.NewGaugeFunc("bactdb_db_max_open_connections", "Most database connections allowed open, 0 for no limit.", func() float64 {
+		return float64(dbStats().MaxOpenConnections)
+	})
+	metrics.NewCounterFunc("bactdb_db_wait_total", "Waits for a free database connection.", func() float64 {
+		return float64(dbStats().WaitCount)
+	})
+	metrics.NewCounterFunc("bactdb_db_wait_seconds_total", "Time spent waiting for a free database connection.", func() float64 {
+		return dbStats().WaitDuration.Seconds()
+	})
+}
+
+// dbStats adds up the pools of every open store.
+func dbStats() sql.DBStats {
+	var total sql.DBStats
+	stores.Range(func(k, _ interface{}) bool {
+		s := k.(*Store).Dbx.Stats()
+		total.MaxOpenConnections += s.MaxOpenConnections
+		total.OpenConnections += s.OpenConnections
+		total.InUse += s.InUse
+		total.Idle += s.Idle
+		total.WaitCount += s.WaitCount
+		total.WaitDuration += s.WaitDuration
+		return true
+	})
+	return total
+}
+
+// observeQuery times a statement. The timings are labelled by the
+// statement's first word, since the statements themselves are too many to
+// label by. Statements that the driver skips, to be prepared instead, are
+// timed as prepared statements.
+func observeQuery(query string, start time.Time) {
+	d := time.Since(start)
+	statement := "other"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		switch s := strings.ToLower(fields[0]); s {
+		case "select", "insert", "update", "delete", "with":
+			statement = s
+		}
+	}
+	queryDuration.Observe(d.Seconds(), statement)
+}
+
+// timedDriver wraps pq, timing statements.
+type timedDriver struct {
+	driver.Driver
+}
+
+func (d timedDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{c}, nil
+}
+
+type timedConn struct {
+	driver.Conn
+}
+
+func (c *timedConn) Prepare(query string) (driver.Stmt, error) {
+	s, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{s, query}, nil
+}
+
+func (c *timedConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	e, ok := c.Conn.(driver.Execer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := e.Exec(query, args)
+	if err != driver.ErrSkip {
+		observeQuery(query, start)
+	}
+	return res, err
+}
+
+func (c *timedConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.Queryer)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := q.Query(query, args)
+	if err != driver.ErrSkip {
+		observeQuery(query, start)
+	}
+	return rows, err
+}
+
+type timedStmt struct {
+	driver.Stmt
+	query string
+}
+
+func (s *timedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	defer observeQuery(s.query, time.Now())
+	return s.Stmt.Exec(args)
+}
+
+func (s *timedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	defer observeQuery(s.query, time.Now())
+	return s.Stmt.Query(args)
+}
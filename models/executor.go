@@ -0,0 +1,105 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/jmoiron/modl"
+)
+
+// tracedExecutor times and traces the statements run through an executor, as
+// the request's it's for. modl doesn't take a context, so the request is
+// carried along with the executor instead.
+//
+// modl writes the statements for Insert, Update, Delete and Get itself, and
+// doesn't say what they are, so they're traced by table, along with the
+// statements run by the models' hooks. Their arguments are whole models, users
+// and their password hashes among them, so only Get's keys are traced.
+type tracedExecutor struct {
+	modl.SqlExecutor
+	dbmap     *modl.DbMap
+	requestID string
+}
+
+// traced runs a statement and traces it.
+func (t tracedExecutor) traced(statement string, args []interface{}, run func() error) error {
+	start := time.Now()
+	err := run()
+	SQLTrace.trace(t.requestID, statement, args, time.Since(start))
+	return err
+}
+
+// table names the table of the models in a list, for tracing modl's
+// statements.
+func (t tracedExecutor) table(list ...interface{}) string {
+	if len(list) > 0 {
+		if table := t.dbmap.TableFor(list[0]); table != nil {
+			return table.TableName
+		}
+	}
+	return "?"
+}
+
+func (t tracedExecutor) Select(dest interface{}, query string, args ...interface{}) error {
+	return t.traced(query, args, func() error {
+		return t.SqlExecutor.Select(dest, query, args...)
+	})
+}
+
+func (t tracedExecutor) SelectOne(dest interface{}, query string, args ...interface{}) error {
+	return t.traced(query, args, func() error {
+		return t.SqlExecutor.SelectOne(dest, query, args...)
+	})
+}
+
+func (t tracedExecutor) Exec(query string, args ...interface{}) (res sql.Result, err error) {
+	err = t.traced(query, args, func() error {
+		res, err = t.SqlExecutor.Exec(query, args...)
+		return err
+	})
+	return res, err
+}
+
+func (t tracedExecutor) Get(dest interface{}, keys ...interface{}) error {
+	return t.traced("SELECT FROM "+t.table(dest), keys, func() error {
+		return t.SqlExecutor.Get(dest, keys...)
+	})
+}
+
+func (t tracedExecutor) Insert(list ...interface{}) error {
+	return t.traced("INSERT INTO "+t.table(list...), nil, func() error {
+		return t.SqlExecutor.Insert(list...)
+	})
+}
+
+func (t tracedExecutor) Update(list ...interface{}) (n int64, err error) {
+	err = t.traced("UPDATE "+t.table(list...), nil, func() error {
+		n, err = t.SqlExecutor.Update(list...)
+		return err
+	})
+	return n, err
+}
+
+func (t tracedExecutor) Delete(list ...interface{}) (n int64, err error) {
+	err = t.traced("DELETE FROM "+t.table(list...), nil, func() error {
+		n, err = t.SqlExecutor.Delete(list...)
+		return err
+	})
+	return n, err
+}
+
+// Transaction is a transaction whose statements are traced as a request's.
+type Transaction struct {
+	tracedExecutor
+	tx *modl.Transaction
+}
+
+// Commit commits the transaction.
+func (t *Transaction) Commit() error {
+	return t.tx.Commit()
+}
+
+// Rollback rolls back the transaction.
+func (t *Transaction) Rollback() error {
+	return t.tx.Rollback()
+}
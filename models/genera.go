@@ -0,0 +1,31 @@
+package models
+
+import (
+	"database/sql"
+
+	"github.com/thermokarst/bactdb/errors"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// Genus is a genus, which every other entity is lumped under.
+type Genus struct {
+	ID        int64          `db:"id" json:"id"`
+	GenusName string         `db:"genus_name" json:"genusName"`
+	CreatedAt types.NullTime `db:"created_at" json:"createdAt"`
+	UpdatedAt types.NullTime `db:"updated_at" json:"updatedAt"`
+}
+
+// GetGenus returns a particular genus, by name.
+func (db *Store) GetGenus(genusName string) (*Genus, error) {
+	var genus Genus
+	q := `SELECT id, genus_name, created_at, updated_at
+		FROM genera
+		WHERE LOWER(genus_name)=LOWER($1);`
+	if err := db.SelectOne(&genus, q, genusName); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.ErrGenusNotFound
+		}
+		return nil, err
+	}
+	return &genus, nil
+}
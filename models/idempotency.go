@@ -0,0 +1,109 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/thermokarst/bactdb/errors"
+)
+
+// idempotencyLock is how long a request holds its Idempotency-Key. A key held
+// any longer than this was abandoned, by a server that went away mid-request.
+const idempotencyLock = time.Minute
+
+// StoredResponse is the response first given for an Idempotency-Key.
+type StoredResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// IdempotencyKeys keeps responses by user and Idempotency-Key.
+type IdempotencyKeys struct {
+	Store *Store
+	// Period is how long a response is kept.
+	Period time.Duration
+}
+
+// Keys are the store's Idempotency-Keys, with responses kept for period.
+func (db *Store) Keys(period time.Duration) KeyRepository {
+	return IdempotencyKeys{Store: db, Period: period}
+}
+
+// Acquire claims a key for a request. If the key has already been used for
+// the same request, its stored response is returned instead. Otherwise, the
+// request should go ahead, and then Save or Release the key. fingerprint
+// identifies the request, so that a key can't be reused for another.
+func (k IdempotencyKeys) Acquire(userID int64, key, fingerprint string) (*StoredResponse, error) {
+	now := time.Now()
+	q := `DELETE FROM idempotency_keys
+		WHERE user_id=$1 AND key=$2
+		AND (created_at < $3 OR (status IS NULL AND created_at < $4));`
+	if _, err := k.Store.Exec(q, userID, key, now.Add(-k.Period), now.Add(-idempotencyLock)); err != nil {
+		return nil, err
+	}
+
+	q = `INSERT INTO idempotency_keys (user_id, key, fingerprint, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING;`
+	res, err := k.Store.Exec(q, userID, key, fingerprint, now)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 1 {
+		return nil, nil
+	}
+
+	var stored struct {
+		Fingerprint string         `db:"fingerprint"`
+		Status      sql.NullInt64  `db:"status"`
+		Header      sql.NullString `db:"header"`
+		Body        []byte         `db:"body"`
+	}
+	q = `SELECT fingerprint, status, header, body FROM idempotency_keys
+		WHERE user_id=$1 AND key=$2;`
+	if err := k.Store.SelectOne(&stored, q, userID, key); err != nil {
+		if err == sql.ErrNoRows {
+			// Expired in the meantime
+			return k.Acquire(userID, key, fingerprint)
+		}
+		return nil, err
+	}
+
+	if stored.Fingerprint != fingerprint {
+		return nil, errors.ErrIdempotencyKeyReused
+	}
+	if !stored.Status.Valid {
+		return nil, errors.ErrIdempotencyKeyInUse
+	}
+
+	resp := StoredResponse{Status: int(stored.Status.Int64), Body: stored.Body}
+	if err := json.Unmarshal([]byte(stored.Header.String), &resp.Header); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Save stores the response to a request holding a key.
+func (k IdempotencyKeys) Save(userID int64, key string, resp *StoredResponse) error {
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+	q := `UPDATE idempotency_keys SET status=$3, header=$4, body=$5
+		WHERE user_id=$1 AND key=$2;`
+	_, err = k.Store.Exec(q, userID, key, resp.Status, string(header), resp.Body)
+	return err
+}
+
+// Release gives up a key without storing a response, so that the request can
+// be tried again.
+func (k IdempotencyKeys) Release(userID int64, key string) error {
+	q := `DELETE FROM idempotency_keys WHERE user_id=$1 AND key=$2 AND status IS NULL;`
+	_, err := k.Store.Exec(q, userID, key)
+	return err
+}
@@ -1,7 +1,11 @@
 package models
 
 import (
+	"database/sql"
+	"fmt"
+
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/jmoiron/modl"
+	"github.com/thermokarst/bactdb/errors"
 	"github.com/thermokarst/bactdb/types"
 )
 
@@ -13,25 +17,33 @@ type base interface {
 	validate() types.ValidationError
 }
 
+// Validate checks a model without writing it to the DB.
+func Validate(b base) error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
 // Create will create a new DB record of a model.
-func Create(b base) error {
+func Create(e modl.SqlExecutor, b base) error {
 	if err := b.validate(); err != nil {
 		return err
 	}
 
-	if err := DBH.Insert(b); err != nil {
-		return nil
+	if err := e.Insert(b); err != nil {
+		return err
 	}
 	return nil
 }
 
 // Update runs a DB update on a model.
-func Update(b base) error {
+func Update(e modl.SqlExecutor, b base) error {
 	if err := b.validate(); err != nil {
 		return err
 	}
 
-	count, err := DBH.Update(b)
+	count, err := e.Update(b)
 	if err != nil {
 		return err
 	}
@@ -43,8 +55,8 @@ func Update(b base) error {
 }
 
 // Delete runs a DB delete on a model.
-func Delete(b base) error {
-	count, err := DBH.Delete(b)
+func Delete(e modl.SqlExecutor, b base) error {
+	count, err := e.Delete(b)
 	if err != nil {
 		return err
 	}
@@ -54,3 +66,26 @@ func Delete(b base) error {
 
 	return nil
 }
+
+// CheckVersion locks a record until the end of the transaction, and checks
+// that p allows its current version, so that nothing can change it between
+// the check and the write. A missing record is left for the write to report.
+func CheckVersion(e modl.SqlExecutor, table string, id int64, p types.Precondition) error {
+	if p == nil {
+		return nil
+	}
+
+	var updatedAt types.NullTime
+	q := fmt.Sprintf(`SELECT updated_at FROM %s WHERE id=$1 FOR UPDATE;`, table)
+	if err := e.SelectOne(&updatedAt, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	if !p.Allows(updatedAt.Time) {
+		return errors.ErrPreconditionFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/thermokarst/bactdb/types"
+)
+
+// LoginLockout locks an email address out of logging in for a while, after too
+// many failed logins in a row.
+type LoginLockout struct {
+	// Attempts is how many failures in a row lock an address out, zero for
+	// never.
+	Attempts int
+	// Period is how long a lockout lasts, and how long failures are counted.
+	Period time.Duration
+}
+
+// DefaultLogins is a store's lockout, unless it's given another.
+var DefaultLogins = LoginLockout{Attempts: 5, Period: 15 * time.Minute}
+
+// LockedUntil returns when an address' lockout ends, which is in the past
+// when it isn't locked out.
+func (db *Store) LockedUntil(email string) (time.Time, error) {
+	if db.Logins.Attempts == 0 {
+		return time.Time{}, nil
+	}
+	var until types.NullTime
+	q := `SELECT locked_until FROM login_failures WHERE email=lower($1);`
+	if err := db.SelectOne(&until, q, email); err != nil && err != sql.ErrNoRows {
+		return time.Time{}, err
+	}
+	return until.Time, nil
+}
+
+// countFailedLogin counts a failed login, locking the address out if that's
+// too many. Failures older than the period are forgotten.
+func (db *Store) countFailedLogin(email string) error {
+	l := db.Logins
+	if l.Attempts == 0 {
+		return nil
+	}
+	now := time.Now()
+	q := `DELETE FROM login_failures
+		WHERE failed_at < $1 AND (locked_until IS NULL OR locked_until < $2);`
+	if _, err := db.Exec(q, now.Add(-l.Period), now); err != nil {
+		return err
+	}
+
+	var failures int
+	q = `INSERT INTO login_failures (email, failures, failed_at)
+		VALUES (lower($1), 1, $2)
+		ON CONFLICT (email) DO UPDATE SET failures=login_failures.failures + 1, failed_at=$2
+		RETURNING failures;`
+	if err := db.SelectOne(&failures, q, email, now); err != nil {
+		return err
+	}
+	if failures < l.Attempts {
+		return nil
+	}
+
+	q = `UPDATE login_failures SET failures=0, locked_until=$2 WHERE email=lower($1);`
+	_, err := db.Exec(q, email, now.Add(l.Period))
+	return err
+}
+
+// loginSucceeded clears an address' failed logins.
+func (db *Store) loginSucceeded(email string) error {
+	if db.Logins.Attempts == 0 {
+		return nil
+	}
+	q := `DELETE FROM login_failures WHERE email=lower($1);`
+	_, err := db.Exec(q, email)
+	return err
+}
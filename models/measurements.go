@@ -12,7 +12,7 @@ import (
 )
 
 func init() {
-	DB.AddTableWithName(MeasurementBase{}, "measurements").SetKeys(true, "ID")
+	addTable(MeasurementBase{}, "measurements")
 }
 
 // PreInsert is a modl hook.
@@ -20,12 +20,30 @@ func (m *MeasurementBase) PreInsert(e modl.SqlExecutor) error {
 	ct := helpers.CurrentTime()
 	m.CreatedAt = ct
 	m.UpdatedAt = ct
-	return nil
+	return m.lookupTextValue(e)
 }
 
 // PreUpdate is a modl hook.
 func (m *MeasurementBase) PreUpdate(e modl.SqlExecutor) error {
 	m.UpdatedAt = helpers.CurrentTime()
+	return m.lookupTextValue(e)
+}
+
+// lookupTextValue turns a text value into a text measurement type, if it's
+// the name of one.
+func (m *MeasurementBase) lookupTextValue(e modl.SqlExecutor) error {
+	if !m.TxtValue.Valid {
+		return nil
+	}
+	id, err := GetTextMeasurementTypeID(e, m.TxtValue.String)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.TextMeasurementTypeID = types.NullInt64{NullInt64: sql.NullInt64{Int64: id, Valid: true}}
+	m.TxtValue = types.NullString{}
 	return nil
 }
 
@@ -113,30 +131,37 @@ func (m *Measurement) MarshalJSON() ([]byte, error) {
 	})
 }
 
-// UnmarshalJSON is custom JSON deserialization to handle multi-type "Value"
+// UnmarshalJSON is custom JSON deserialization to handle multi-type "Value".
+// Attributes are decoded on top of the existing measurement, so that partial
+// updates only touch the attributes that are present.
 func (m *Measurement) UnmarshalJSON(b []byte) error {
 	var measurement struct {
 		FakeMeasurement
 		Value interface{} `json:"value"`
 	}
+	measurement.FakeMeasurement = FakeMeasurement(*m)
 	if err := json.Unmarshal(b, &measurement); err != nil {
 		return err
 	}
 
+	var attrs map[string]json.RawMessage
+	if err := json.Unmarshal(b, &attrs); err != nil {
+		return err
+	}
+
+	if _, ok := attrs["value"]; ok {
+		// The value types are mutually exclusive, start from a clean slate.
+		measurement.TextMeasurementType = types.NullString{}
+		measurement.TextMeasurementTypeID = types.NullInt64{}
+		measurement.TxtValue = types.NullString{}
+		measurement.NumValue = types.NullFloat64{}
+	}
+
 	switch v := measurement.Value.(type) {
 	case string:
-		// Test if actually a lookup
-		id, err := GetTextMeasurementTypeID(v)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				if v != "" {
-					measurement.TxtValue = types.NullString{sql.NullString{String: v, Valid: true}}
-				}
-			} else {
-				return err
-			}
-		} else {
-			measurement.TextMeasurementTypeID = types.NullInt64{sql.NullInt64{Int64: id, Valid: true}}
+		// Looked up as a text measurement type when written
+		if v != "" {
+			measurement.TxtValue = types.NullString{NullString: sql.NullString{String: v, Valid: true}}
 		}
 	case int64:
 		measurement.NumValue = types.NullFloat64{sql.NullFloat64{Float64: float64(v), Valid: true}}
@@ -167,7 +192,25 @@ func (m *Measurement) Value() string {
 type Measurements []*Measurement
 
 // ListMeasurements returns all measurements
-func ListMeasurements(opt helpers.MeasurementListOptions, claims *types.Claims) (*Measurements, error) {
+func (db *Store) ListMeasurements(opt helpers.MeasurementListOptions, claims *types.Claims) (*Measurements, error) {
+	q, vals := listMeasurementsQuery(opt)
+
+	measurements := make(Measurements, 0)
+	err := db.Select(&measurements, q, vals...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range measurements {
+		m.CanEdit = helpers.CanEdit(claims, m.CreatedBy)
+	}
+
+	return &measurements, nil
+}
+
+// listMeasurementsQuery builds the query for a list of measurements, shared by
+// ListMeasurements and StreamMeasurements.
+func listMeasurementsQuery(opt helpers.MeasurementListOptions) (string, []interface{}) {
 	var vals []interface{}
 
 	q := `SELECT m.*, t.text_measurement_name AS text_measurement_type_name,
@@ -215,22 +258,22 @@ func ListMeasurements(opt helpers.MeasurementListOptions, claims *types.Claims)
 		q += ")"
 	}
 	q += ";"
+	return q, vals
+}
 
-	measurements := make(Measurements, 0)
-	err := DBH.Select(&measurements, q, vals...)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, m := range measurements {
-		m.CanEdit = helpers.CanEdit(claims, m.CreatedBy)
-	}
-
-	return &measurements, nil
+// StreamMeasurements passes each of the measurements to fn as it's read from
+// the database, rather than returning them all at once.
+func (db *Store) StreamMeasurements(opt helpers.MeasurementListOptions, claims *types.Claims, fn func(*Measurement) error) error {
+	q, vals := listMeasurementsQuery(opt)
+	return db.stream(q, vals, func() interface{} { return &Measurement{} }, func(row interface{}) error {
+		e := row.(*Measurement)
+		e.CanEdit = helpers.CanEdit(claims, e.CreatedBy)
+		return fn(e)
+	})
 }
 
 // GetMeasurement returns a particular measurement.
-func GetMeasurement(id int64, genus string, claims *types.Claims) (*Measurement, error) {
+func (db *Store) GetMeasurement(id int64, genus string, claims *types.Claims) (*Measurement, error) {
 	var measurement Measurement
 
 	q := `SELECT m.*, t.text_measurement_name AS text_measurement_type_name,
@@ -244,7 +287,7 @@ func GetMeasurement(id int64, genus string, claims *types.Claims) (*Measurement,
 		LEFT OUTER JOIN unit_types u ON u.id=m.unit_type_id
 		LEFT OUTER JOIN test_methods te ON te.id=m.test_method_id
 		WHERE m.id=$2;`
-	if err := DBH.SelectOne(&measurement, q, genus, id); err != nil {
+	if err := db.SelectOne(&measurement, q, genus, id); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.ErrMeasurementNotFound
 		}
@@ -269,12 +312,125 @@ func StrainOptsFromMeasurements(opt helpers.MeasurementListOptions) (*helpers.Li
 }
 
 // GetTextMeasurementTypeID returns the ID for a particular text measurement type
-func GetTextMeasurementTypeID(val string) (int64, error) {
+func GetTextMeasurementTypeID(e modl.SqlExecutor, val string) (int64, error) {
 	var id int64
 	q := `SELECT id FROM text_measurement_types WHERE text_measurement_name=$1;`
 
-	if err := DBH.SelectOne(&id, q, val); err != nil {
+	if err := e.SelectOne(&id, q, val); err != nil {
 		return 0, err
 	}
 	return id, nil
 }
+
+// MeasurementWrite is one of the writes in a batch.
+type MeasurementWrite struct {
+	// Op is create, update or delete.
+	Op          string
+	Measurement *MeasurementBase
+}
+
+// CreateMeasurement inserts a new measurement.
+func (db *Store) CreateMeasurement(m *MeasurementBase) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		return Create(tx, m)
+	})
+}
+
+// UpdateMeasurement writes a measurement's changes, if p allows its current
+// version. A text measurement type given by name is looked up.
+func (db *Store) UpdateMeasurement(m *Measurement, p types.Precondition) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := CheckVersion(tx, "measurements", m.ID, p); err != nil {
+			return err
+		}
+		if m.TextMeasurementType.Valid {
+			id, err := GetTextMeasurementTypeID(tx, m.TextMeasurementType.String)
+			if err != nil {
+				return err
+			}
+			m.TextMeasurementTypeID.Int64 = id
+			m.TextMeasurementTypeID.Valid = true
+		}
+
+		return Update(tx, m.MeasurementBase)
+	})
+}
+
+// DeleteMeasurement deletes a measurement, tombstoned as deleted by userID,
+// if p allows its current version.
+func (db *Store) DeleteMeasurement(m *MeasurementBase, userID int64, p types.Precondition) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := CheckVersion(tx, "measurements", m.ID, p); err != nil {
+			return err
+		}
+		if err := SetChangedBy(tx, userID); err != nil {
+			return err
+		}
+		return Delete(tx, m)
+	})
+}
+
+// WriteMeasurements makes a batch of writes in a single transaction, returning
+// each write's error at its index. A write that fails is rolled back on its
+// own, unless the batch is atomic, in which case nothing is written, and the
+// writes after it aren't tried.
+func (db *Store) WriteMeasurements(writes []MeasurementWrite, atomic bool, userID int64) ([]error, error) {
+	errs := make([]error, len(writes))
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := SetChangedBy(tx, userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for i, w := range writes {
+		if !atomic {
+			if _, err := tx.Exec("SAVEPOINT batch_operation;"); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+
+		errs[i] = writeMeasurement(tx, w)
+
+		if errs[i] == nil {
+			if !atomic {
+				if _, err := tx.Exec("RELEASE SAVEPOINT batch_operation;"); err != nil {
+					tx.Rollback()
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if atomic {
+			tx.Rollback()
+			return errs, nil
+		}
+
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT batch_operation;"); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+func writeMeasurement(e modl.SqlExecutor, w MeasurementWrite) error {
+	switch w.Op {
+	case "create":
+		return Create(e, w.Measurement)
+	case "update":
+		return Update(e, w.Measurement)
+	case "delete":
+		return Delete(e, w.Measurement)
+	}
+	return errors.ErrUnknownBatchOperation
+}
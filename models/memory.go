@@ -0,0 +1,1430 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/golang.org/x/crypto/bcrypt"
+	"github.com/thermokarst/bactdb/errors"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/types"
+)
+
+var (
+	_ Repository = (*Store)(nil)
+	_ Repository = (*Memory)(nil)
+)
+
+// Memory is a Repository kept in memory, for tests. It reads and writes like a
+// Store, but starts out empty, and genera, users and text measurement types
+// have to be added by hand.
+type Memory struct {
+	// Logins is the lockout applied by DbAuthenticate.
+	Logins LoginLockout
+
+	mu     sync.Mutex
+	lastID int64
+
+	genera               map[int64]*Genus
+	species              map[int64]*SpeciesBase
+	strains              map[int64]*StrainBase
+	characteristics      map[int64]*CharacteristicBase
+	characteristicTypes  map[int64]string
+	measurements         map[int64]*MeasurementBase
+	textMeasurementTypes map[int64]string
+	users                map[int64]*UserBase
+	nonces               map[string]int64
+	failedLogins         map[string]*failedLogins
+	webhooks             map[int64]*WebhookBase
+	deliveries           []*WebhookDelivery
+	tombstones           []memoryTombstone
+	keys                 map[string]*memoryKey
+}
+
+type failedLogins struct {
+	failures    int
+	failedAt    time.Time
+	lockedUntil time.Time
+}
+
+// memoryTombstone is a tombstone, and the genus it's tied to, if any.
+type memoryTombstone struct {
+	Tombstone
+	genusID int64
+}
+
+type memoryKey struct {
+	fingerprint string
+	resp        *StoredResponse
+	createdAt   time.Time
+}
+
+// NewMemory creates an empty Memory.
+func NewMemory() *Memory {
+	return &Memory{
+		Logins:               DefaultLogins,
+		genera:               make(map[int64]*Genus),
+		species:              make(map[int64]*SpeciesBase),
+		strains:              make(map[int64]*StrainBase),
+		characteristics:      make(map[int64]*CharacteristicBase),
+		characteristicTypes:  make(map[int64]string),
+		measurements:         make(map[int64]*MeasurementBase),
+		textMeasurementTypes: make(map[int64]string),
+		users:                make(map[int64]*UserBase),
+		nonces:               make(map[string]int64),
+		failedLogins:         make(map[string]*failedLogins),
+		webhooks:             make(map[int64]*WebhookBase),
+		keys:                 make(map[string]*memoryKey),
+	}
+}
+
+// nextID returns a new ID. IDs are unique across every type of entity.
+func (db *Memory) nextID() int64 {
+	db.lastID++
+	return db.lastID
+}
+
+// sortedIDs returns a map's IDs, in the order they were created.
+func sortedIDs[T any](rows map[int64]T) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// contains reports whether id is in ids. No ids at all contains everything.
+func contains(ids []int64, id int64) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// appendUnique appends id to ids, unless it's there already.
+func appendUnique(ids []int64, id int64) []int64 {
+	for _, i := range ids {
+		if i == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
+
+// AddGenus adds a genus.
+func (db *Memory) AddGenus(genusName string) *Genus {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ct := helpers.CurrentTime()
+	g := &Genus{ID: db.nextID(), GenusName: genusName, CreatedAt: ct, UpdatedAt: ct}
+	db.genera[g.ID] = g
+	c := *g
+	return &c
+}
+
+// AddUser adds a verified user with a password. The password is hashed with
+// bcrypt's minimum cost, to keep tests quick.
+func (db *Memory) AddUser(u UserBase, password string) (*User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return nil, err
+	}
+	u.Password = string(hash)
+	u.Verified = true
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.insertUser(&u); err != nil {
+		return nil, err
+	}
+	c := u
+	return &User{UserBase: &c}, nil
+}
+
+// AddTextMeasurementType adds a text measurement type, returning its ID.
+func (db *Memory) AddTextMeasurementType(name string) int64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	id := db.nextID()
+	db.textMeasurementTypes[id] = name
+	return id
+}
+
+// Genera
+
+// GetGenus returns a particular genus, by name.
+func (db *Memory) GetGenus(genusName string) (*Genus, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	id := db.genusID(genusName)
+	if id == 0 {
+		return nil, errors.ErrGenusNotFound
+	}
+	g := *db.genera[id]
+	return &g, nil
+}
+
+// GenusIDFromName looks up the genus' ID.
+func (db *Memory) GenusIDFromName(genusName string) (int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	id := db.genusID(genusName)
+	if id == 0 {
+		return 0, errors.ErrGenusNotFound
+	}
+	return id, nil
+}
+
+func (db *Memory) genusID(genusName string) int64 {
+	for id, g := range db.genera {
+		if strings.EqualFold(g.GenusName, genusName) {
+			return id
+		}
+	}
+	return 0
+}
+
+// strainGenus is the ID of the genus a strain is in.
+func (db *Memory) strainGenus(strainID int64) int64 {
+	st, ok := db.strains[strainID]
+	if !ok {
+		return 0
+	}
+	sp, ok := db.species[st.SpeciesID]
+	if !ok {
+		return 0
+	}
+	return sp.GenusID
+}
+
+// measurementGenus is the ID of the genus a measurement is in.
+func (db *Memory) measurementGenus(measurementID int64) int64 {
+	m, ok := db.measurements[measurementID]
+	if !ok {
+		return 0
+	}
+	return db.strainGenus(m.StrainID)
+}
+
+// Species
+
+func (db *Memory) speciesView(s *SpeciesBase, claims *types.Claims) *Species {
+	b := *s
+	sp := &Species{
+		SpeciesBase: &b,
+		GenusName:   db.genera[s.GenusID].GenusName,
+		CanEdit:     helpers.CanEdit(claims, s.CreatedBy),
+	}
+	for _, id := range sortedIDs(db.strains) {
+		if db.strains[id].SpeciesID == s.ID {
+			sp.Strains = append(sp.Strains, id)
+		}
+	}
+	sp.TotalStrains = int64(len(sp.Strains))
+	return sp
+}
+
+// ListSpecies returns all species
+func (db *Memory) ListSpecies(opt helpers.ListOptions, claims *types.Claims) (*ManySpecies, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	genusID := db.genusID(opt.Genus)
+	species := make(ManySpecies, 0)
+	for _, id := range sortedIDs(db.species) {
+		s := db.species[id]
+		if genusID != 0 && s.GenusID == genusID && contains(opt.IDs, id) {
+			species = append(species, db.speciesView(s, claims))
+		}
+	}
+
+	sorted := append(ManySpecies{}, species...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].SpeciesName < sorted[j].SpeciesName })
+	for i, s := range sorted {
+		s.SortOrder = int64(i + 1)
+	}
+	return &species, nil
+}
+
+// StreamSpecies passes each of the species to fn.
+func (db *Memory) StreamSpecies(opt helpers.ListOptions, claims *types.Claims, fn func(*Species) error) error {
+	species, err := db.ListSpecies(opt, claims)
+	if err != nil {
+		return err
+	}
+	for _, s := range *species {
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSpecies returns a particular species.
+func (db *Memory) GetSpecies(id int64, genus string, claims *types.Claims) (*Species, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	s, ok := db.species[id]
+	if !ok || s.GenusID != db.genusID(genus) {
+		return nil, errors.ErrSpeciesNotFound
+	}
+	return db.speciesView(s, claims), nil
+}
+
+// StrainOptsFromSpecies returns the options for finding all related strains for
+// a set of species.
+func (db *Memory) StrainOptsFromSpecies(opt helpers.ListOptions) (*helpers.ListOptions, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	genusID := db.genusID(opt.Genus)
+	var ids []int64
+	for _, id := range sortedIDs(db.strains) {
+		st := db.strains[id]
+		if opt.IDs == nil && db.strainGenus(id) == genusID || opt.IDs != nil && contains(opt.IDs, st.SpeciesID) {
+			ids = append(ids, id)
+		}
+	}
+	return &helpers.ListOptions{Genus: opt.Genus, IDs: ids}, nil
+}
+
+// StrainsFromSpeciesID returns the strains of a particular species.
+func (db *Memory) StrainsFromSpeciesID(id int64, genus string, claims *types.Claims) (*Strains, error) {
+	strainsOpt, err := db.StrainOptsFromSpecies(helpers.ListOptions{Genus: genus, IDs: []int64{id}})
+	if err != nil {
+		return nil, err
+	}
+	return db.ListStrains(*strainsOpt, claims)
+}
+
+// CreateSpecies inserts a new species.
+func (db *Memory) CreateSpecies(s *SpeciesBase) error {
+	if err := Validate(s); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.genera[s.GenusID]; !ok {
+		return fmt.Errorf("no genus %d", s.GenusID)
+	}
+	s.PreInsert(nil)
+	s.ID = db.nextID()
+	b := *s
+	db.species[s.ID] = &b
+	return nil
+}
+
+// UpdateSpecies writes a species' changes, if p allows its current version.
+func (db *Memory) UpdateSpecies(s *SpeciesBase, p types.Precondition) error {
+	if err := Validate(s); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.species[s.ID]
+	if !ok {
+		return s.UpdateError()
+	}
+	if err := checkVersion(stored.UpdatedAt, p); err != nil {
+		return err
+	}
+	s.PreUpdate(nil)
+	b := *s
+	db.species[s.ID] = &b
+	return nil
+}
+
+// DeleteSpecies deletes a species, tombstoned as deleted by userID, if p
+// allows its current version.
+func (db *Memory) DeleteSpecies(s *SpeciesBase, userID int64, p types.Precondition) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.species[s.ID]
+	if !ok {
+		return s.DeleteError()
+	}
+	if err := checkVersion(stored.UpdatedAt, p); err != nil {
+		return err
+	}
+	db.tombstone("species", s.ID, stored.GenusID, userID)
+	delete(db.species, s.ID)
+	return nil
+}
+
+// Strains
+
+func (db *Memory) strainView(s *StrainBase, claims *types.Claims) *Strain {
+	b := *s
+	st := &Strain{StrainBase: &b, CanEdit: helpers.CanEdit(claims, s.CreatedBy)}
+	for _, id := range sortedIDs(db.measurements) {
+		m := db.measurements[id]
+		if m.StrainID == s.ID {
+			st.Measurements = append(st.Measurements, id)
+			st.Characteristics = types.NullSliceInt64(appendUnique(st.Characteristics, m.CharacteristicID))
+		}
+	}
+	st.TotalMeasurements = int64(len(st.Measurements))
+	return st
+}
+
+// SpeciesName returns a strain's species name.
+func (db *Memory) SpeciesName(s *StrainBase) string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if sp, ok := db.species[s.SpeciesID]; ok {
+		return sp.SpeciesName
+	}
+	return ""
+}
+
+// ListStrains returns all strains.
+func (db *Memory) ListStrains(opt helpers.ListOptions, claims *types.Claims) (*Strains, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	genusID := db.genusID(opt.Genus)
+	strains := make(Strains, 0)
+	for _, id := range sortedIDs(db.strains) {
+		if genusID != 0 && db.strainGenus(id) == genusID && contains(opt.IDs, id) {
+			strains = append(strains, db.strainView(db.strains[id], claims))
+		}
+	}
+
+	sorted := append(Strains{}, strains...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		an, bn := db.species[a.SpeciesID].SpeciesName, db.species[b.SpeciesID].SpeciesName
+		if an != bn {
+			return an < bn
+		}
+		if a.TypeStrain != b.TypeStrain {
+			return !a.TypeStrain
+		}
+		return a.StrainName < b.StrainName
+	})
+	for i, s := range sorted {
+		s.SortOrder = int64(i + 1)
+	}
+	return &strains, nil
+}
+
+// StreamStrains passes each of the strains to fn.
+func (db *Memory) StreamStrains(opt helpers.ListOptions, claims *types.Claims, fn func(*Strain) error) error {
+	strains, err := db.ListStrains(opt, claims)
+	if err != nil {
+		return err
+	}
+	for _, s := range *strains {
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetStrain returns a particular strain.
+func (db *Memory) GetStrain(id int64, genus string, claims *types.Claims) (*Strain, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	s, ok := db.strains[id]
+	if !ok || db.strainGenus(id) != db.genusID(genus) {
+		return nil, errors.ErrStrainNotFound
+	}
+	return db.strainView(s, claims), nil
+}
+
+// SpeciesOptsFromStrains returns the options for finding all related species for a
+// set of strains.
+func (db *Memory) SpeciesOptsFromStrains(opt helpers.ListOptions) (*helpers.ListOptions, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	genusID := db.genusID(opt.Genus)
+	var ids []int64
+	for _, id := range sortedIDs(db.strains) {
+		if len(opt.IDs) == 0 && db.strainGenus(id) == genusID || len(opt.IDs) != 0 && contains(opt.IDs, id) {
+			ids = appendUnique(ids, db.strains[id].SpeciesID)
+		}
+	}
+	return &helpers.ListOptions{Genus: opt.Genus, IDs: ids}, nil
+}
+
+// CharacteristicsOptsFromStrains returns the options for finding all related
+// characteristics for a set of strains.
+func (db *Memory) CharacteristicsOptsFromStrains(opt helpers.ListOptions) (*helpers.ListOptions, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	genusID := db.genusID(opt.Genus)
+	var ids []int64
+	for _, id := range sortedIDs(db.measurements) {
+		m := db.measurements[id]
+		if len(opt.IDs) == 0 && db.strainGenus(m.StrainID) == genusID || len(opt.IDs) != 0 && contains(opt.IDs, m.StrainID) {
+			ids = appendUnique(ids, m.CharacteristicID)
+		}
+	}
+	return &helpers.ListOptions{Genus: opt.Genus, IDs: ids}, nil
+}
+
+// CreateStrain inserts a new strain.
+func (db *Memory) CreateStrain(s *StrainBase) error {
+	if err := Validate(s); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.species[s.SpeciesID]; !ok {
+		return fmt.Errorf("no species %d", s.SpeciesID)
+	}
+	s.PreInsert(nil)
+	s.ID = db.nextID()
+	b := *s
+	db.strains[s.ID] = &b
+	return nil
+}
+
+// UpdateStrain writes a strain's changes, if p allows its current version.
+func (db *Memory) UpdateStrain(s *StrainBase, p types.Precondition) error {
+	if err := Validate(s); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.strains[s.ID]
+	if !ok {
+		return s.UpdateError()
+	}
+	if err := checkVersion(stored.UpdatedAt, p); err != nil {
+		return err
+	}
+	if _, ok := db.species[s.SpeciesID]; !ok {
+		return fmt.Errorf("no species %d", s.SpeciesID)
+	}
+	s.PreUpdate(nil)
+	b := *s
+	db.strains[s.ID] = &b
+	return nil
+}
+
+// DeleteStrain deletes a strain, tombstoned as deleted by userID, if p allows
+// its current version.
+func (db *Memory) DeleteStrain(s *StrainBase, userID int64, p types.Precondition) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.strains[s.ID]
+	if !ok {
+		return s.DeleteError()
+	}
+	if err := checkVersion(stored.UpdatedAt, p); err != nil {
+		return err
+	}
+	db.tombstone("strains", s.ID, db.strainGenus(s.ID), userID)
+	delete(db.strains, s.ID)
+	return nil
+}
+
+// Characteristics
+
+// characteristicView is a characteristic, with the strains and measurements
+// of a genus'.
+func (db *Memory) characteristicView(c *CharacteristicBase, genusID int64, claims *types.Claims) *Characteristic {
+	b := *c
+	ch := &Characteristic{
+		CharacteristicBase: &b,
+		CharacteristicType: db.characteristicTypes[c.CharacteristicTypeID],
+		CanEdit:            helpers.CanEdit(claims, c.CreatedBy),
+	}
+	for _, id := range sortedIDs(db.measurements) {
+		m := db.measurements[id]
+		if m.CharacteristicID == c.ID && db.strainGenus(m.StrainID) == genusID {
+			ch.Measurements = append(ch.Measurements, id)
+			ch.Strains = types.NullSliceInt64(appendUnique(ch.Strains, m.StrainID))
+		}
+	}
+	return ch
+}
+
+// ListCharacteristics returns all characteristics
+func (db *Memory) ListCharacteristics(opt helpers.ListOptions, claims *types.Claims) (*Characteristics, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	genusID := db.genusID(opt.Genus)
+	var characteristics Characteristics
+	for _, id := range sortedIDs(db.characteristics) {
+		if contains(opt.IDs, id) {
+			characteristics = append(characteristics, db.characteristicView(db.characteristics[id], genusID, claims))
+		}
+	}
+
+	sort.SliceStable(characteristics, func(i, j int) bool {
+		a, b := characteristics[i], characteristics[j]
+		if a.CharacteristicType != b.CharacteristicType {
+			return a.CharacteristicType < b.CharacteristicType
+		}
+		return a.SortOrder.Int64 < b.SortOrder.Int64
+	})
+	return &characteristics, nil
+}
+
+// StreamCharacteristics passes each of the characteristics to fn.
+func (db *Memory) StreamCharacteristics(opt helpers.ListOptions, claims *types.Claims, fn func(*Characteristic) error) error {
+	characteristics, err := db.ListCharacteristics(opt, claims)
+	if err != nil {
+		return err
+	}
+	for _, c := range *characteristics {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCharacteristic returns a particular characteristic.
+func (db *Memory) GetCharacteristic(id int64, genus string, claims *types.Claims) (*Characteristic, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c, ok := db.characteristics[id]
+	if !ok {
+		return nil, errors.ErrCharacteristicNotFound
+	}
+	return db.characteristicView(c, db.genusID(genus), claims), nil
+}
+
+// StrainOptsFromCharacteristics returns the options for finding all related strains
+// for a set of characteristics.
+func (db *Memory) StrainOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.ListOptions, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	genusID := db.genusID(opt.Genus)
+	var ids []int64
+	for _, id := range sortedIDs(db.measurements) {
+		m := db.measurements[id]
+		if db.strainGenus(m.StrainID) == genusID && (opt.IDs == nil || contains(opt.IDs, m.CharacteristicID)) {
+			ids = appendUnique(ids, m.StrainID)
+		}
+	}
+	return &helpers.ListOptions{Genus: opt.Genus, IDs: ids}, nil
+}
+
+// MeasurementOptsFromCharacteristics returns the options for finding all related
+// measurements for a set of characteristics.
+func (db *Memory) MeasurementOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.MeasurementListOptions, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	genusID := db.genusID(opt.Genus)
+	var ids []int64
+	for _, id := range sortedIDs(db.measurements) {
+		m := db.measurements[id]
+		if db.strainGenus(m.StrainID) == genusID && (opt.IDs == nil || contains(opt.IDs, m.CharacteristicID)) {
+			ids = append(ids, id)
+		}
+	}
+	return &helpers.MeasurementListOptions{ListOptions: helpers.ListOptions{Genus: opt.Genus, IDs: ids}}, nil
+}
+
+// StrainsFromCharacteristicID returns a set of strains (as well as the options for
+// finding those strains) for a particular characteristic.
+func (db *Memory) StrainsFromCharacteristicID(id int64, genus string, claims *types.Claims) (*Strains, *helpers.ListOptions, error) {
+	strainsOpt, err := db.StrainOptsFromCharacteristics(helpers.ListOptions{Genus: genus, IDs: []int64{id}})
+	if err != nil {
+		return nil, nil, err
+	}
+	strains, err := db.ListStrains(*strainsOpt, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	return strains, strainsOpt, nil
+}
+
+// MeasurementsFromCharacteristicID returns a set of measurements (as well as the
+// options for finding those measurements) for a particular characteristic.
+func (db *Memory) MeasurementsFromCharacteristicID(id int64, genus string, claims *types.Claims) (*Measurements, *helpers.MeasurementListOptions, error) {
+	measurementOpt, err := db.MeasurementOptsFromCharacteristics(helpers.ListOptions{Genus: genus, IDs: []int64{id}})
+	if err != nil {
+		return nil, nil, err
+	}
+	measurements, err := db.ListMeasurements(*measurementOpt, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	return measurements, measurementOpt, nil
+}
+
+// characteristicType returns a characteristic type's ID, adding it if it's new.
+func (db *Memory) characteristicType(name string) int64 {
+	for id, n := range db.characteristicTypes {
+		if n == name {
+			return id
+		}
+	}
+	id := db.nextID()
+	db.characteristicTypes[id] = name
+	return id
+}
+
+// CreateCharacteristic inserts a new characteristic, creating its type first
+// if that's new too.
+func (db *Memory) CreateCharacteristic(c *Characteristic, claims *types.Claims) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	c.CharacteristicTypeID = db.characteristicType(c.CharacteristicType)
+	if err := Validate(c.CharacteristicBase); err != nil {
+		return err
+	}
+	c.PreInsert(nil)
+	c.ID = db.nextID()
+	b := *c.CharacteristicBase
+	db.characteristics[c.ID] = &b
+	return nil
+}
+
+// UpdateCharacteristic writes a characteristic's changes, if p allows its
+// current version, creating its type first if that's new.
+func (db *Memory) UpdateCharacteristic(c *Characteristic, claims *types.Claims, p types.Precondition) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.characteristics[c.ID]
+	if ok {
+		if err := checkVersion(stored.UpdatedAt, p); err != nil {
+			return err
+		}
+	}
+	c.CharacteristicTypeID = db.characteristicType(c.CharacteristicType)
+	if err := Validate(c.CharacteristicBase); err != nil {
+		return err
+	}
+	if !ok {
+		return c.UpdateError()
+	}
+	c.PreUpdate(nil)
+	b := *c.CharacteristicBase
+	db.characteristics[c.ID] = &b
+	return nil
+}
+
+// DeleteCharacteristic deletes a characteristic, tombstoned as deleted by
+// userID, if p allows its current version.
+func (db *Memory) DeleteCharacteristic(c *CharacteristicBase, userID int64, p types.Precondition) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.characteristics[c.ID]
+	if !ok {
+		return c.DeleteError()
+	}
+	if err := checkVersion(stored.UpdatedAt, p); err != nil {
+		return err
+	}
+	// Characteristics are shared, so they aren't tied to a genus
+	db.tombstone("characteristics", c.ID, 0, userID)
+	delete(db.characteristics, c.ID)
+	return nil
+}
+
+// Measurements
+
+func (db *Memory) measurementView(m *MeasurementBase, claims *types.Claims) *Measurement {
+	b := *m
+	measurement := &Measurement{MeasurementBase: &b, CanEdit: helpers.CanEdit(claims, m.CreatedBy)}
+	if m.TextMeasurementTypeID.Valid {
+		measurement.TextMeasurementType = types.NullString{NullString: sql.NullString{String: db.textMeasurementTypes[m.TextMeasurementTypeID.Int64], Valid: true}}
+	}
+	return measurement
+}
+
+// ListMeasurements returns all measurements
+func (db *Memory) ListMeasurements(opt helpers.MeasurementListOptions, claims *types.Claims) (*Measurements, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	genusID := db.genusID(opt.Genus)
+	measurements := make(Measurements, 0)
+	for _, id := range sortedIDs(db.measurements) {
+		m := db.measurements[id]
+		if genusID != 0 && db.strainGenus(m.StrainID) == genusID &&
+			contains(opt.Strains, m.StrainID) &&
+			contains(opt.Characteristics, m.CharacteristicID) &&
+			contains(opt.IDs, id) {
+			measurements = append(measurements, db.measurementView(m, claims))
+		}
+	}
+	return &measurements, nil
+}
+
+// StreamMeasurements passes each of the measurements to fn.
+func (db *Memory) StreamMeasurements(opt helpers.MeasurementListOptions, claims *types.Claims, fn func(*Measurement) error) error {
+	measurements, err := db.ListMeasurements(opt, claims)
+	if err != nil {
+		return err
+	}
+	for _, m := range *measurements {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMeasurement returns a particular measurement.
+func (db *Memory) GetMeasurement(id int64, genus string, claims *types.Claims) (*Measurement, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	m, ok := db.measurements[id]
+	if !ok || db.measurementGenus(id) != db.genusID(genus) {
+		return nil, errors.ErrMeasurementNotFound
+	}
+	return db.measurementView(m, claims), nil
+}
+
+// textMeasurementType returns a text measurement type's ID, by name.
+func (db *Memory) textMeasurementType(name string) (int64, bool) {
+	for id, n := range db.textMeasurementTypes {
+		if n == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// checkMeasurement checks what a measurement refers to, and turns a text
+// value into a text measurement type, if it's the name of one.
+func (db *Memory) checkMeasurement(m *MeasurementBase) error {
+	if _, ok := db.strains[m.StrainID]; !ok {
+		return fmt.Errorf("no strain %d", m.StrainID)
+	}
+	if _, ok := db.characteristics[m.CharacteristicID]; !ok {
+		return fmt.Errorf("no characteristic %d", m.CharacteristicID)
+	}
+	if m.TxtValue.Valid {
+		if id, ok := db.textMeasurementType(m.TxtValue.String); ok {
+			m.TextMeasurementTypeID = types.NullInt64{NullInt64: sql.NullInt64{Int64: id, Valid: true}}
+			m.TxtValue = types.NullString{}
+		}
+	}
+	return nil
+}
+
+func (db *Memory) createMeasurement(m *MeasurementBase) error {
+	if err := Validate(m); err != nil {
+		return err
+	}
+	if err := db.checkMeasurement(m); err != nil {
+		return err
+	}
+	ct := helpers.CurrentTime()
+	m.CreatedAt = ct
+	m.UpdatedAt = ct
+	m.ID = db.nextID()
+	b := *m
+	db.measurements[m.ID] = &b
+	return nil
+}
+
+func (db *Memory) updateMeasurement(m *MeasurementBase) error {
+	if err := Validate(m); err != nil {
+		return err
+	}
+	if _, ok := db.measurements[m.ID]; !ok {
+		return m.UpdateError()
+	}
+	if err := db.checkMeasurement(m); err != nil {
+		return err
+	}
+	m.UpdatedAt = helpers.CurrentTime()
+	b := *m
+	db.measurements[m.ID] = &b
+	return nil
+}
+
+func (db *Memory) deleteMeasurement(m *MeasurementBase, userID int64) error {
+	if _, ok := db.measurements[m.ID]; !ok {
+		return m.DeleteError()
+	}
+	db.tombstone("measurements", m.ID, db.measurementGenus(m.ID), userID)
+	delete(db.measurements, m.ID)
+	return nil
+}
+
+// CreateMeasurement inserts a new measurement.
+func (db *Memory) CreateMeasurement(m *MeasurementBase) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.createMeasurement(m)
+}
+
+// UpdateMeasurement writes a measurement's changes, if p allows its current
+// version. A text measurement type given by name is looked up.
+func (db *Memory) UpdateMeasurement(m *Measurement, p types.Precondition) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if stored, ok := db.measurements[m.ID]; ok {
+		if err := checkVersion(stored.UpdatedAt, p); err != nil {
+			return err
+		}
+	}
+	if m.TextMeasurementType.Valid {
+		id, ok := db.textMeasurementType(m.TextMeasurementType.String)
+		if !ok {
+			return sql.ErrNoRows
+		}
+		m.TextMeasurementTypeID = types.NullInt64{NullInt64: sql.NullInt64{Int64: id, Valid: true}}
+	}
+	return db.updateMeasurement(m.MeasurementBase)
+}
+
+// DeleteMeasurement deletes a measurement, tombstoned as deleted by userID,
+// if p allows its current version.
+func (db *Memory) DeleteMeasurement(m *MeasurementBase, userID int64, p types.Precondition) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if stored, ok := db.measurements[m.ID]; ok {
+		if err := checkVersion(stored.UpdatedAt, p); err != nil {
+			return err
+		}
+	}
+	return db.deleteMeasurement(m, userID)
+}
+
+// WriteMeasurements makes a batch of writes all at once, returning each
+// write's error at its index. A write that fails is skipped, unless the batch
+// is atomic, in which case nothing is written, and the writes after it aren't
+// tried.
+func (db *Memory) WriteMeasurements(writes []MeasurementWrite, atomic bool, userID int64) ([]error, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	measurements := make(map[int64]*MeasurementBase, len(db.measurements))
+	for id, m := range db.measurements {
+		measurements[id] = m
+	}
+	tombstones := len(db.tombstones)
+
+	errs := make([]error, len(writes))
+	for i, w := range writes {
+		switch w.Op {
+		case "create":
+			errs[i] = db.createMeasurement(w.Measurement)
+		case "update":
+			errs[i] = db.updateMeasurement(w.Measurement)
+		case "delete":
+			errs[i] = db.deleteMeasurement(w.Measurement, userID)
+		default:
+			errs[i] = errors.ErrUnknownBatchOperation
+		}
+
+		if errs[i] != nil && atomic {
+			db.measurements = measurements
+			db.tombstones = db.tombstones[:tombstones]
+			return errs, nil
+		}
+	}
+	return errs, nil
+}
+
+// Users
+
+// LockedUntil returns when an address' lockout ends, which is in the past
+// when it isn't locked out.
+func (db *Memory) LockedUntil(email string) (time.Time, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if f, ok := db.failedLogins[strings.ToLower(email)]; ok && db.Logins.Attempts != 0 {
+		return f.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+// DbAuthenticate authenticates a user, counting failures towards a lockout.
+func (db *Memory) DbAuthenticate(email string, password string) error {
+	until, err := db.LockedUntil(email)
+	if err != nil {
+		return err
+	}
+	if until.After(time.Now()) {
+		return errors.ErrAccountLocked
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u := db.userByEmail(email)
+	if u == nil || bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) != nil {
+		db.countFailedLogin(email)
+		return errors.ErrInvalidEmailOrPassword
+	}
+	delete(db.failedLogins, strings.ToLower(email))
+	return nil
+}
+
+// countFailedLogin counts a failed login, locking the address out if that's
+// too many. Failures older than the period are forgotten.
+func (db *Memory) countFailedLogin(email string) {
+	l := db.Logins
+	if l.Attempts == 0 {
+		return
+	}
+	now := time.Now()
+	for e, f := range db.failedLogins {
+		if f.failedAt.Before(now.Add(-l.Period)) && f.lockedUntil.Before(now) {
+			delete(db.failedLogins, e)
+		}
+	}
+
+	email = strings.ToLower(email)
+	f, ok := db.failedLogins[email]
+	if !ok {
+		f = &failedLogins{}
+		db.failedLogins[email] = f
+	}
+	f.failures++
+	f.failedAt = now
+	if f.failures >= l.Attempts {
+		f.failures = 0
+		f.lockedUntil = now.Add(l.Period)
+	}
+}
+
+// userByEmail returns a verified user, by email.
+func (db *Memory) userByEmail(email string) *UserBase {
+	for _, id := range sortedIDs(db.users) {
+		u := db.users[id]
+		if u.Verified && strings.EqualFold(u.Email, email) {
+			return u
+		}
+	}
+	return nil
+}
+
+// GetUser returns a specific user record by ID.
+func (db *Memory) GetUser(id int64, dummy string, claims *types.Claims) (*User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u, ok := db.users[id]
+	if !ok || !u.Verified {
+		return nil, errors.ErrUserNotFound
+	}
+	b := *u
+	return &User{UserBase: &b, CanEdit: claims.Role == "A" || id == claims.Sub}, nil
+}
+
+// DbGetUserByEmail returns a specific user record by email.
+func (db *Memory) DbGetUserByEmail(email string) (*User, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u := db.userByEmail(email)
+	if u == nil {
+		return nil, errors.ErrUserNotFound
+	}
+	b := *u
+	return &User{UserBase: &b}, nil
+}
+
+// ListUsers returns all users, without their password hashes.
+func (db *Memory) ListUsers(opt helpers.ListOptions, claims *types.Claims) (*Users, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	users := make(Users, 0)
+	for _, id := range sortedIDs(db.users) {
+		u := db.users[id]
+		if !u.Verified || !contains(opt.IDs, id) {
+			continue
+		}
+		b := *u
+		b.Password = "password"
+		users = append(users, &User{UserBase: &b, CanEdit: claims.Role == "A" || id == claims.Sub})
+	}
+	return &users, nil
+}
+
+// StreamUsers passes each of the users to fn.
+func (db *Memory) StreamUsers(opt helpers.ListOptions, claims *types.Claims, fn func(*User) error) error {
+	users, err := db.ListUsers(opt, claims)
+	if err != nil {
+		return err
+	}
+	for _, u := range *users {
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *Memory) insertUser(u *UserBase) error {
+	if err := Validate(u); err != nil {
+		return err
+	}
+	for _, other := range db.users {
+		if strings.EqualFold(other.Email, u.Email) {
+			return errors.ErrEmailAddressTaken
+		}
+	}
+	u.PreInsert(nil)
+	u.ID = db.nextID()
+	b := *u
+	db.users[u.ID] = &b
+	return nil
+}
+
+// CreateUser inserts a new, unverified user, along with the nonce that
+// verifies them.
+func (db *Memory) CreateUser(u *UserBase, nonce, referer string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.insertUser(u); err != nil {
+		return err
+	}
+	db.nonces[nonce] = u.ID
+	return nil
+}
+
+// DeleteUnverifiedUser takes back a signup whose nonce never went out.
+// Verified users are left alone.
+func (db *Memory) DeleteUnverifiedUser(id int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for n, userID := range db.nonces {
+		if userID == id {
+			delete(db.nonces, n)
+		}
+	}
+	if u, ok := db.users[id]; ok && !u.Verified {
+		delete(db.users, id)
+	}
+	return nil
+}
+
+// VerifyUser verifies the user a nonce was sent to.
+func (db *Memory) VerifyUser(nonce string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	id, ok := db.nonces[nonce]
+	if !ok {
+		return errors.ErrUserNotFound
+	}
+	u, ok := db.users[id]
+	if !ok {
+		return errors.ErrUserNotFound
+	}
+	u.Verified = true
+	u.PreUpdate(nil)
+	for n, userID := range db.nonces {
+		if userID == id {
+			delete(db.nonces, n)
+		}
+	}
+	return nil
+}
+
+// UpdateUser writes a user's changes, if p allows their current version.
+func (db *Memory) UpdateUser(u *UserBase, p types.Precondition) error {
+	if err := Validate(u); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.users[u.ID]
+	if !ok {
+		return u.UpdateError()
+	}
+	if err := checkVersion(stored.UpdatedAt, p); err != nil {
+		return err
+	}
+	u.PreUpdate(nil)
+	b := *u
+	db.users[u.ID] = &b
+	return nil
+}
+
+// UpdateUserPassword hashes and stores a new password for the current user.
+func (db *Memory) UpdateUserPassword(claims *types.Claims, password string) error {
+	user, err := db.GetUser(claims.Sub, "", claims)
+	if err != nil {
+		return err
+	}
+	if err := setPassword(user.UserBase, password); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.users[user.ID]; !ok {
+		return errors.ErrUserNotUpdated
+	}
+	user.PreUpdate(nil)
+	db.users[user.ID] = user.UserBase
+	return nil
+}
+
+// Webhooks
+
+func (db *Memory) webhookView(w *WebhookBase) *Webhook {
+	b := *w
+	b.Events = append(types.StringList{}, w.Events...)
+	return &Webhook{WebhookBase: &b, GenusName: db.genera[w.GenusID].GenusName}
+}
+
+// ListWebhooks returns a genus' webhooks. Only active ones are returned when
+// active is set.
+func (db *Memory) ListWebhooks(genus string, active bool) (*Webhooks, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	genusID := db.genusID(genus)
+	webhooks := make(Webhooks, 0)
+	for _, id := range sortedIDs(db.webhooks) {
+		w := db.webhooks[id]
+		if genusID != 0 && w.GenusID == genusID && (w.Active || !active) {
+			webhooks = append(webhooks, db.webhookView(w))
+		}
+	}
+	return &webhooks, nil
+}
+
+// GetWebhook returns a particular webhook.
+func (db *Memory) GetWebhook(id int64, genus string) (*Webhook, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	w, ok := db.webhooks[id]
+	if !ok || w.GenusID != db.genusID(genus) {
+		return nil, errors.ErrWebhookNotFound
+	}
+	return db.webhookView(w), nil
+}
+
+// CreateWebhook registers a new webhook.
+func (db *Memory) CreateWebhook(w *WebhookBase) error {
+	if err := Validate(w); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	w.PreInsert(nil)
+	w.ID = db.nextID()
+	b := *w
+	db.webhooks[w.ID] = &b
+	return nil
+}
+
+// UpdateWebhook writes a webhook's changes, if p allows its current version.
+func (db *Memory) UpdateWebhook(w *WebhookBase, p types.Precondition) error {
+	if err := Validate(w); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.webhooks[w.ID]
+	if !ok {
+		return w.UpdateError()
+	}
+	if err := checkVersion(stored.UpdatedAt, p); err != nil {
+		return err
+	}
+	w.PreUpdate(nil)
+	b := *w
+	db.webhooks[w.ID] = &b
+	return nil
+}
+
+// DeleteWebhook deletes a webhook, along with its delivery log, if p allows
+// its current version.
+func (db *Memory) DeleteWebhook(w *WebhookBase, p types.Precondition) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	stored, ok := db.webhooks[w.ID]
+	if !ok {
+		return w.DeleteError()
+	}
+	if err := checkVersion(stored.UpdatedAt, p); err != nil {
+		return err
+	}
+	delete(db.webhooks, w.ID)
+	deliveries := db.deliveries[:0]
+	for _, d := range db.deliveries {
+		if d.WebhookID != w.ID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	db.deliveries = deliveries
+	return nil
+}
+
+// ListWebhookDeliveries returns the latest attempts at calling a webhook,
+// newest first.
+func (db *Memory) ListWebhookDeliveries(webhookID int64, limit int64) (*WebhookDeliveries, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	deliveries := make(WebhookDeliveries, 0)
+	for i := len(db.deliveries) - 1; i >= 0 && int64(len(deliveries)) < limit; i-- {
+		if d := db.deliveries[i]; d.WebhookID == webhookID {
+			c := *d
+			deliveries = append(deliveries, &c)
+		}
+	}
+	return &deliveries, nil
+}
+
+// CreateWebhookDelivery logs an attempt at calling a webhook.
+func (db *Memory) CreateWebhookDelivery(d *WebhookDelivery) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.webhooks[d.WebhookID]; !ok {
+		return fmt.Errorf("no webhook %d", d.WebhookID)
+	}
+	d.ID = db.nextID()
+	c := *d
+	db.deliveries = append(db.deliveries, &c)
+	return nil
+}
+
+// Changes
+
+// checkVersion is CheckVersion for a record kept in memory, last modified at
+// updatedAt. The caller holds the lock.
+func checkVersion(updatedAt types.NullTime, p types.Precondition) error {
+	if !p.Allows(updatedAt.Time) {
+		return errors.ErrPreconditionFailed
+	}
+	return nil
+}
+
+func (db *Memory) tombstone(entity string, id, genusID, userID int64) {
+	db.tombstones = append(db.tombstones, memoryTombstone{
+		Tombstone: Tombstone{Entity: entity, ID: id, DeletedAt: helpers.CurrentTime(), DeletedBy: userID},
+		genusID:   genusID,
+	})
+}
+
+// ChangedIDs returns the IDs of a genus' entities of one type, created or
+// updated after since.
+func (db *Memory) ChangedIDs(entity, genus string, since time.Time) ([]int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	genusID := db.genusID(genus)
+	var ids []int64
+	switch entity {
+	case "species":
+		for _, id := range sortedIDs(db.species) {
+			if s := db.species[id]; s.GenusID == genusID && s.UpdatedAt.Time.After(since) {
+				ids = append(ids, id)
+			}
+		}
+	case "strains":
+		for _, id := range sortedIDs(db.strains) {
+			if db.strainGenus(id) == genusID && db.strains[id].UpdatedAt.Time.After(since) {
+				ids = append(ids, id)
+			}
+		}
+	case "characteristics":
+		for _, id := range sortedIDs(db.characteristics) {
+			if db.characteristics[id].UpdatedAt.Time.After(since) {
+				ids = append(ids, id)
+			}
+		}
+	case "measurements":
+		for _, id := range sortedIDs(db.measurements) {
+			if db.measurementGenus(id) == genusID && db.measurements[id].UpdatedAt.Time.After(since) {
+				ids = append(ids, id)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("no changes kept for %s", entity)
+	}
+	return ids, nil
+}
+
+// TombstonesSince returns what's been deleted from a genus after since, oldest
+// first. Deletions that can't be tied to a genus are included for every genus.
+func (db *Memory) TombstonesSince(genus string, since time.Time) ([]*Tombstone, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	genusID := db.genusID(genus)
+	tombstones := make([]*Tombstone, 0)
+	for _, t := range db.tombstones {
+		if (t.genusID == 0 || t.genusID == genusID) && t.DeletedAt.Time.After(since) {
+			c := t.Tombstone
+			tombstones = append(tombstones, &c)
+		}
+	}
+	return tombstones, nil
+}
+
+// WithContext is the memory itself, which has no statements to trace.
+func (db *Memory) WithContext(ctx context.Context) Repository {
+	return db
+}
+
+// Idempotency-Keys
+
+// Keys are the Idempotency-Keys, with responses kept for period.
+func (db *Memory) Keys(period time.Duration) KeyRepository {
+	return memoryKeys{db, period}
+}
+
+type memoryKeys struct {
+	db     *Memory
+	period time.Duration
+}
+
+func keyName(userID int64, key string) string {
+	return fmt.Sprintf("%d %s", userID, key)
+}
+
+// Acquire claims a key for a request, or returns its stored response.
+func (k memoryKeys) Acquire(userID int64, key, fingerprint string) (*StoredResponse, error) {
+	k.db.mu.Lock()
+	defer k.db.mu.Unlock()
+
+	name := keyName(userID, key)
+	now := time.Now()
+	stored, ok := k.db.keys[name]
+	if ok && (stored.createdAt.Before(now.Add(-k.period)) || stored.resp == nil && stored.createdAt.Before(now.Add(-idempotencyLock))) {
+		ok = false
+	}
+	if !ok {
+		k.db.keys[name] = &memoryKey{fingerprint: fingerprint, createdAt: now}
+		return nil, nil
+	}
+
+	if stored.fingerprint != fingerprint {
+		return nil, errors.ErrIdempotencyKeyReused
+	}
+	if stored.resp == nil {
+		return nil, errors.ErrIdempotencyKeyInUse
+	}
+	return stored.resp, nil
+}
+
+// Save stores the response to a request holding a key.
+func (k memoryKeys) Save(userID int64, key string, resp *StoredResponse) error {
+	k.db.mu.Lock()
+	defer k.db.mu.Unlock()
+	if stored, ok := k.db.keys[keyName(userID, key)]; ok {
+		stored.resp = resp
+	}
+	return nil
+}
+
+// Release gives up a key without storing a response.
+func (k memoryKeys) Release(userID int64, key string) error {
+	k.db.mu.Lock()
+	defer k.db.mu.Unlock()
+	name := keyName(userID, key)
+	if stored, ok := k.db.keys[name]; ok && stored.resp == nil {
+		delete(k.db.keys, name)
+	}
+	return nil
+}
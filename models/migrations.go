@@ -0,0 +1,117 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/lib/pq"
+)
+
+// upMigration is gomigrate's name for a migration's up file.
+var upMigration = regexp.MustCompile(`^(\d+)_([\w-]+)_up\.sql$`)
+
+// MigrationStatus compares the migrations applied by gomigrate with those on
+// disk.
+type MigrationStatus struct {
+	// Applied is the latest migration applied.
+	Applied uint64 `json:"applied"`
+	// Latest is the latest migration on disk.
+	Latest uint64 `json:"latest"`
+	// Pending are on disk but not applied.
+	Pending []uint64 `json:"pending"`
+	// Unknown are applied but not on disk, from a newer version of bactdb.
+	Unknown []uint64 `json:"unknown,omitempty"`
+}
+
+// Current is whether every migration on disk has been applied.
+func (s *MigrationStatus) Current() bool {
+	return len(s.Pending) == 0
+}
+
+// Ping checks that the database can be reached.
+func (db *Store) Ping(ctx context.Context) error {
+	return db.Dbx.PingContext(ctx)
+}
+
+// GetMigrationStatus looks up which of the migrations in path have been
+// applied. Without gomigrate's table, none have.
+func (db *Store) GetMigrationStatus(ctx context.Context, path string) (*MigrationStatus, error) {
+	files, err := filepath.Glob(filepath.Join(path, "*_up.sql"))
+	if err != nil {
+		return nil, err
+	}
+	onDisk := make(map[uint64]bool)
+	for _, f := range files {
+		m := upMigration.FindStringSubmatch(filepath.Base(f))
+		if m == nil {
+			continue
+		}
+		id, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		onDisk[id] = true
+	}
+	if len(onDisk) == 0 {
+		return nil, fmt.Errorf("no migrations found in %s", path)
+	}
+
+	applied, err := db.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := MigrationStatus{Pending: []uint64{}}
+	for id := range onDisk {
+		if id > status.Latest {
+			status.Latest = id
+		}
+		if !applied[id] {
+			status.Pending = append(status.Pending, id)
+		}
+	}
+	for id := range applied {
+		if id > status.Applied {
+			status.Applied = id
+		}
+		if !onDisk[id] {
+			status.Unknown = append(status.Unknown, id)
+		}
+	}
+	sort.Slice(status.Pending, func(i, j int) bool { return status.Pending[i] < status.Pending[j] })
+	sort.Slice(status.Unknown, func(i, j int) bool { return status.Unknown[i] < status.Unknown[j] })
+
+	return &status, nil
+}
+
+func (db *Store) appliedMigrations(ctx context.Context) (map[uint64]bool, error) {
+	applied := make(map[uint64]bool)
+	q := `SELECT migration_id FROM gomigrate;`
+	var rows *sql.Rows
+	err := db.executor().traced(q, nil, func() (err error) {
+		rows, err = db.Dbx.QueryContext(ctx, q)
+		return err
+	})
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "42P01" {
+		// undefined_table, nothing's been migrated
+		return applied, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		applied[id] = true
+	}
+	return applied, rows.Err()
+}
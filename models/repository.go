@@ -0,0 +1,123 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/types"
+)
+
+// Repository is everything bactdb keeps. Store keeps it in PostgreSQL, and
+// Memory keeps it in memory, for tests.
+type Repository interface {
+	GenusRepository
+	SpeciesRepository
+	StrainRepository
+	CharacteristicRepository
+	MeasurementRepository
+	UserRepository
+	WebhookRepository
+	ChangeRepository
+	// Keys are the Idempotency-Keys, with responses kept for period.
+	Keys(period time.Duration) KeyRepository
+	// WithContext is the repository for the request that ctx is for.
+	WithContext(ctx context.Context) Repository
+}
+
+// GenusRepository looks up genera.
+type GenusRepository interface {
+	GetGenus(genusName string) (*Genus, error)
+	GenusIDFromName(genusName string) (int64, error)
+}
+
+// SpeciesRepository reads and writes species.
+type SpeciesRepository interface {
+	ListSpecies(opt helpers.ListOptions, claims *types.Claims) (*ManySpecies, error)
+	StreamSpecies(opt helpers.ListOptions, claims *types.Claims, fn func(*Species) error) error
+	GetSpecies(id int64, genus string, claims *types.Claims) (*Species, error)
+	StrainOptsFromSpecies(opt helpers.ListOptions) (*helpers.ListOptions, error)
+	StrainsFromSpeciesID(id int64, genus string, claims *types.Claims) (*Strains, error)
+	CreateSpecies(s *SpeciesBase) error
+	UpdateSpecies(s *SpeciesBase, p types.Precondition) error
+	DeleteSpecies(s *SpeciesBase, userID int64, p types.Precondition) error
+}
+
+// StrainRepository reads and writes strains.
+type StrainRepository interface {
+	ListStrains(opt helpers.ListOptions, claims *types.Claims) (*Strains, error)
+	StreamStrains(opt helpers.ListOptions, claims *types.Claims, fn func(*Strain) error) error
+	GetStrain(id int64, genus string, claims *types.Claims) (*Strain, error)
+	SpeciesName(s *StrainBase) string
+	SpeciesOptsFromStrains(opt helpers.ListOptions) (*helpers.ListOptions, error)
+	CharacteristicsOptsFromStrains(opt helpers.ListOptions) (*helpers.ListOptions, error)
+	CreateStrain(s *StrainBase) error
+	UpdateStrain(s *StrainBase, p types.Precondition) error
+	DeleteStrain(s *StrainBase, userID int64, p types.Precondition) error
+}
+
+// CharacteristicRepository reads and writes characteristics. Characteristic
+// types are created as they're first used.
+type CharacteristicRepository interface {
+	ListCharacteristics(opt helpers.ListOptions, claims *types.Claims) (*Characteristics, error)
+	StreamCharacteristics(opt helpers.ListOptions, claims *types.Claims, fn func(*Characteristic) error) error
+	GetCharacteristic(id int64, genus string, claims *types.Claims) (*Characteristic, error)
+	StrainOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.ListOptions, error)
+	MeasurementOptsFromCharacteristics(opt helpers.ListOptions) (*helpers.MeasurementListOptions, error)
+	StrainsFromCharacteristicID(id int64, genus string, claims *types.Claims) (*Strains, *helpers.ListOptions, error)
+	MeasurementsFromCharacteristicID(id int64, genus string, claims *types.Claims) (*Measurements, *helpers.MeasurementListOptions, error)
+	CreateCharacteristic(c *Characteristic, claims *types.Claims) error
+	UpdateCharacteristic(c *Characteristic, claims *types.Claims, p types.Precondition) error
+	DeleteCharacteristic(c *CharacteristicBase, userID int64, p types.Precondition) error
+}
+
+// MeasurementRepository reads and writes measurements.
+type MeasurementRepository interface {
+	ListMeasurements(opt helpers.MeasurementListOptions, claims *types.Claims) (*Measurements, error)
+	StreamMeasurements(opt helpers.MeasurementListOptions, claims *types.Claims, fn func(*Measurement) error) error
+	GetMeasurement(id int64, genus string, claims *types.Claims) (*Measurement, error)
+	CreateMeasurement(m *MeasurementBase) error
+	UpdateMeasurement(m *Measurement, p types.Precondition) error
+	DeleteMeasurement(m *MeasurementBase, userID int64, p types.Precondition) error
+	WriteMeasurements(writes []MeasurementWrite, atomic bool, userID int64) ([]error, error)
+}
+
+// UserRepository reads and writes users, and authenticates them.
+type UserRepository interface {
+	DbAuthenticate(email string, password string) error
+	LockedUntil(email string) (time.Time, error)
+	GetUser(id int64, dummy string, claims *types.Claims) (*User, error)
+	DbGetUserByEmail(email string) (*User, error)
+	ListUsers(opt helpers.ListOptions, claims *types.Claims) (*Users, error)
+	StreamUsers(opt helpers.ListOptions, claims *types.Claims, fn func(*User) error) error
+	CreateUser(u *UserBase, nonce, referer string) error
+	DeleteUnverifiedUser(id int64) error
+	VerifyUser(nonce string) error
+	UpdateUser(u *UserBase, p types.Precondition) error
+	UpdateUserPassword(claims *types.Claims, password string) error
+}
+
+// WebhookRepository reads and writes webhooks, and their delivery logs.
+type WebhookRepository interface {
+	ListWebhooks(genus string, active bool) (*Webhooks, error)
+	GetWebhook(id int64, genus string) (*Webhook, error)
+	CreateWebhook(w *WebhookBase) error
+	UpdateWebhook(w *WebhookBase, p types.Precondition) error
+	DeleteWebhook(w *WebhookBase, p types.Precondition) error
+	ListWebhookDeliveries(webhookID int64, limit int64) (*WebhookDeliveries, error)
+	CreateWebhookDelivery(d *WebhookDelivery) error
+}
+
+// ChangeRepository finds what's changed since a sync.
+type ChangeRepository interface {
+	ChangedIDs(entity, genus string, since time.Time) ([]int64, error)
+	TombstonesSince(genus string, since time.Time) ([]*Tombstone, error)
+}
+
+// KeyRepository keeps the first response to each of a user's
+// Idempotency-Keys.
+type KeyRepository interface {
+	Acquire(userID int64, key, fingerprint string) (*StoredResponse, error)
+	Save(userID int64, key string, resp *StoredResponse) error
+	Release(userID int64, key string) error
+}
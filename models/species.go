@@ -12,7 +12,7 @@ import (
 )
 
 func init() {
-	DB.AddTableWithName(SpeciesBase{}, "species").SetKeys(true, "ID")
+	addTable(SpeciesBase{}, "species")
 }
 
 // PreInsert is a modl hook.
@@ -90,10 +90,13 @@ type Species struct {
 type ManySpecies []*Species
 
 // GenusIDFromName looks up the genus' ID.
-func GenusIDFromName(genusName string) (int64, error) {
+func (db *Store) GenusIDFromName(genusName string) (int64, error) {
 	var genusID struct{ ID int64 }
 	q := `SELECT id FROM genera WHERE LOWER(genus_name) = LOWER($1);`
-	if err := DBH.SelectOne(&genusID, q, genusName); err != nil {
+	if err := db.SelectOne(&genusID, q, genusName); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.ErrGenusNotFound
+		}
 		return 0, err
 	}
 	return genusID.ID, nil
@@ -101,7 +104,7 @@ func GenusIDFromName(genusName string) (int64, error) {
 
 // StrainOptsFromSpecies returns the options for finding all related strains for
 // a set of species.
-func StrainOptsFromSpecies(opt helpers.ListOptions) (*helpers.ListOptions, error) {
+func (db *Store) StrainOptsFromSpecies(opt helpers.ListOptions) (*helpers.ListOptions, error) {
 	var relatedStrainIDs []int64
 
 	if opt.IDs == nil {
@@ -109,7 +112,7 @@ func StrainOptsFromSpecies(opt helpers.ListOptions) (*helpers.ListOptions, error
 			FROM strains st
 			INNER JOIN species sp ON sp.id=st.species_id
 			INNER JOIN genera g ON g.id=sp.genus_id AND LOWER(g.genus_name)=LOWER($1);`
-		if err := DBH.Select(&relatedStrainIDs, q, opt.Genus); err != nil {
+		if err := db.Select(&relatedStrainIDs, q, opt.Genus); err != nil {
 			return nil, err
 		}
 	} else {
@@ -117,7 +120,7 @@ func StrainOptsFromSpecies(opt helpers.ListOptions) (*helpers.ListOptions, error
 		var count int64 = 1
 		q := fmt.Sprintf("SELECT DISTINCT id FROM strains WHERE %s;", helpers.ValsIn("species_id", opt.IDs, &vals, &count))
 
-		if err := DBH.Select(&relatedStrainIDs, q, vals...); err != nil {
+		if err := db.Select(&relatedStrainIDs, q, vals...); err != nil {
 			return nil, err
 		}
 	}
@@ -127,18 +130,18 @@ func StrainOptsFromSpecies(opt helpers.ListOptions) (*helpers.ListOptions, error
 
 // StrainsFromSpeciesID returns the options for finding all related strains for a
 // particular species.
-func StrainsFromSpeciesID(id int64, genus string, claims *types.Claims) (*Strains, error) {
+func (db *Store) StrainsFromSpeciesID(id int64, genus string, claims *types.Claims) (*Strains, error) {
 	opt := helpers.ListOptions{
 		Genus: genus,
 		IDs:   []int64{id},
 	}
 
-	strainsOpt, err := StrainOptsFromSpecies(opt)
+	strainsOpt, err := db.StrainOptsFromSpecies(opt)
 	if err != nil {
 		return nil, err
 	}
 
-	strains, err := ListStrains(*strainsOpt, claims)
+	strains, err := db.ListStrains(*strainsOpt, claims)
 	if err != nil {
 		return nil, err
 	}
@@ -147,7 +150,25 @@ func StrainsFromSpeciesID(id int64, genus string, claims *types.Claims) (*Strain
 }
 
 // ListSpecies returns all species
-func ListSpecies(opt helpers.ListOptions, claims *types.Claims) (*ManySpecies, error) {
+func (db *Store) ListSpecies(opt helpers.ListOptions, claims *types.Claims) (*ManySpecies, error) {
+	q, vals := listSpeciesQuery(opt)
+
+	species := make(ManySpecies, 0)
+	err := db.Select(&species, q, vals...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range species {
+		s.CanEdit = helpers.CanEdit(claims, s.CreatedBy)
+	}
+
+	return &species, nil
+}
+
+// listSpeciesQuery builds the query for a list of species, shared by
+// ListSpecies and StreamSpecies.
+func listSpeciesQuery(opt helpers.ListOptions) (string, []interface{}) {
 	var vals []interface{}
 
 	q := `SELECT sp.*, g.genus_name, array_agg(st.id) AS strains,
@@ -171,22 +192,22 @@ func ListSpecies(opt helpers.ListOptions, claims *types.Claims) (*ManySpecies, e
 	}
 
 	q += " GROUP BY sp.id, g.genus_name;"
+	return q, vals
+}
 
-	species := make(ManySpecies, 0)
-	err := DBH.Select(&species, q, vals...)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, s := range species {
-		s.CanEdit = helpers.CanEdit(claims, s.CreatedBy)
-	}
-
-	return &species, nil
+// StreamSpecies passes each of the species to fn as it's read from the
+// database, rather than returning them all at once.
+func (db *Store) StreamSpecies(opt helpers.ListOptions, claims *types.Claims, fn func(*Species) error) error {
+	q, vals := listSpeciesQuery(opt)
+	return db.stream(q, vals, func() interface{} { return &Species{} }, func(row interface{}) error {
+		e := row.(*Species)
+		e.CanEdit = helpers.CanEdit(claims, e.CreatedBy)
+		return fn(e)
+	})
 }
 
 // GetSpecies returns a particular species.
-func GetSpecies(id int64, genus string, claims *types.Claims) (*Species, error) {
+func (db *Store) GetSpecies(id int64, genus string, claims *types.Claims) (*Species, error) {
 	var species Species
 	q := `SELECT sp.*, g.genus_name, array_agg(st.id) AS strains,
 		COUNT(st) AS total_strains, 0 AS sort_order
@@ -195,7 +216,7 @@ func GetSpecies(id int64, genus string, claims *types.Claims) (*Species, error)
 		LEFT OUTER JOIN strains st ON st.species_id=sp.id
 		WHERE sp.id=$2
 		GROUP BY sp.id, g.genus_name;`
-	if err := DBH.SelectOne(&species, q, genus, id); err != nil {
+	if err := db.SelectOne(&species, q, genus, id); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.ErrSpeciesNotFound
 		}
@@ -206,3 +227,34 @@ func GetSpecies(id int64, genus string, claims *types.Claims) (*Species, error)
 
 	return &species, nil
 }
+
+// CreateSpecies inserts a new species.
+func (db *Store) CreateSpecies(s *SpeciesBase) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		return Create(tx, s)
+	})
+}
+
+// UpdateSpecies writes a species' changes, if p allows its current version.
+func (db *Store) UpdateSpecies(s *SpeciesBase, p types.Precondition) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := CheckVersion(tx, "species", s.ID, p); err != nil {
+			return err
+		}
+		return Update(tx, s)
+	})
+}
+
+// DeleteSpecies deletes a species, tombstoned as deleted by userID, if p
+// allows its current version.
+func (db *Store) DeleteSpecies(s *SpeciesBase, userID int64, p types.Precondition) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := CheckVersion(tx, "species", s.ID, p); err != nil {
+			return err
+		}
+		if err := SetChangedBy(tx, userID); err != nil {
+			return err
+		}
+		return Delete(tx, s)
+	})
+}
@@ -12,7 +12,7 @@ import (
 )
 
 func init() {
-	DB.AddTableWithName(StrainBase{}, "strains").SetKeys(true, "ID")
+	addTable(StrainBase{}, "strains")
 }
 
 // PreInsert is a modl hook.
@@ -93,16 +93,34 @@ type Strain struct {
 type Strains []*Strain
 
 // SpeciesName returns a strain's species name.
-func (s StrainBase) SpeciesName() string {
+func (db *Store) SpeciesName(s *StrainBase) string {
 	var species SpeciesBase
-	if err := DBH.Get(&species, s.SpeciesID); err != nil {
+	if err := db.Get(&species, s.SpeciesID); err != nil {
 		return ""
 	}
 	return species.SpeciesName
 }
 
 // ListStrains returns all strains.
-func ListStrains(opt helpers.ListOptions, claims *types.Claims) (*Strains, error) {
+func (db *Store) ListStrains(opt helpers.ListOptions, claims *types.Claims) (*Strains, error) {
+	q, vals := listStrainsQuery(opt)
+
+	strains := make(Strains, 0)
+	err := db.Select(&strains, q, vals...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range strains {
+		s.CanEdit = helpers.CanEdit(claims, s.CreatedBy)
+	}
+
+	return &strains, nil
+}
+
+// listStrainsQuery builds the query for a list of strains, shared by
+// ListStrains and StreamStrains.
+func listStrainsQuery(opt helpers.ListOptions) (string, []interface{}) {
 	var vals []interface{}
 
 	q := `SELECT st.*, array_agg(m.id) AS measurements,
@@ -128,22 +146,22 @@ func ListStrains(opt helpers.ListOptions, claims *types.Claims) (*Strains, error
 	}
 
 	q += " GROUP BY st.id, st.species_id, sp.species_name;"
+	return q, vals
+}
 
-	strains := make(Strains, 0)
-	err := DBH.Select(&strains, q, vals...)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, s := range strains {
-		s.CanEdit = helpers.CanEdit(claims, s.CreatedBy)
-	}
-
-	return &strains, nil
+// StreamStrains passes each of the strains to fn as it's read from the
+// database, rather than returning them all at once.
+func (db *Store) StreamStrains(opt helpers.ListOptions, claims *types.Claims, fn func(*Strain) error) error {
+	q, vals := listStrainsQuery(opt)
+	return db.stream(q, vals, func() interface{} { return &Strain{} }, func(row interface{}) error {
+		e := row.(*Strain)
+		e.CanEdit = helpers.CanEdit(claims, e.CreatedBy)
+		return fn(e)
+	})
 }
 
 // GetStrain returns a particular strain.
-func GetStrain(id int64, genus string, claims *types.Claims) (*Strain, error) {
+func (db *Store) GetStrain(id int64, genus string, claims *types.Claims) (*Strain, error) {
 	var strain Strain
 	q := `SELECT st.*, array_agg(DISTINCT m.id) AS measurements,
 		array_agg(DISTINCT m.characteristic_id) AS characteristics,
@@ -154,7 +172,7 @@ func GetStrain(id int64, genus string, claims *types.Claims) (*Strain, error) {
 		LEFT OUTER JOIN measurements m ON m.strain_id=st.id
 		WHERE st.id=$2
 		GROUP BY st.id;`
-	if err := DBH.SelectOne(&strain, q, genus, id); err != nil {
+	if err := db.SelectOne(&strain, q, genus, id); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.ErrStrainNotFound
 		}
@@ -168,7 +186,7 @@ func GetStrain(id int64, genus string, claims *types.Claims) (*Strain, error) {
 
 // SpeciesOptsFromStrains returns the options for finding all related species for a
 // set of strains.
-func SpeciesOptsFromStrains(opt helpers.ListOptions) (*helpers.ListOptions, error) {
+func (db *Store) SpeciesOptsFromStrains(opt helpers.ListOptions) (*helpers.ListOptions, error) {
 	var relatedSpeciesIDs []int64
 
 	if opt.IDs == nil || len(opt.IDs) == 0 {
@@ -176,14 +194,14 @@ func SpeciesOptsFromStrains(opt helpers.ListOptions) (*helpers.ListOptions, erro
 			FROM strains st
 			INNER JOIN species sp ON sp.id=st.species_id
 			INNER JOIN genera g ON g.id=sp.genus_id AND LOWER(g.genus_name)=LOWER($1);`
-		if err := DBH.Select(&relatedSpeciesIDs, q, opt.Genus); err != nil {
+		if err := db.Select(&relatedSpeciesIDs, q, opt.Genus); err != nil {
 			return nil, err
 		}
 	} else {
 		var vals []interface{}
 		var count int64 = 1
 		q := fmt.Sprintf("SELECT DISTINCT species_id FROM strains WHERE %s;", helpers.ValsIn("id", opt.IDs, &vals, &count))
-		if err := DBH.Select(&relatedSpeciesIDs, q, vals...); err != nil {
+		if err := db.Select(&relatedSpeciesIDs, q, vals...); err != nil {
 			return nil, err
 		}
 	}
@@ -193,7 +211,7 @@ func SpeciesOptsFromStrains(opt helpers.ListOptions) (*helpers.ListOptions, erro
 
 // CharacteristicsOptsFromStrains returns the options for finding all related
 // characteristics for a set of strains.
-func CharacteristicsOptsFromStrains(opt helpers.ListOptions) (*helpers.ListOptions, error) {
+func (db *Store) CharacteristicsOptsFromStrains(opt helpers.ListOptions) (*helpers.ListOptions, error) {
 	var relatedCharacteristicsIDs []int64
 
 	if opt.IDs == nil || len(opt.IDs) == 0 {
@@ -202,17 +220,48 @@ func CharacteristicsOptsFromStrains(opt helpers.ListOptions) (*helpers.ListOptio
 				INNER JOIN strains st ON st.id=m.strain_id
 				INNER JOIN species sp ON sp.id=st.species_id
 				INNER JOIN genera g ON g.id=sp.genus_id AND LOWER(g.genus_name)=LOWER($1);`
-		if err := DBH.Select(&relatedCharacteristicsIDs, q, opt.Genus); err != nil {
+		if err := db.Select(&relatedCharacteristicsIDs, q, opt.Genus); err != nil {
 			return nil, err
 		}
 	} else {
 		var vals []interface{}
 		var count int64 = 1
 		q := fmt.Sprintf("SELECT DISTINCT characteristic_id FROM measurements WHERE %s;", helpers.ValsIn("strain_id", opt.IDs, &vals, &count))
-		if err := DBH.Select(&relatedCharacteristicsIDs, q, vals...); err != nil {
+		if err := db.Select(&relatedCharacteristicsIDs, q, vals...); err != nil {
 			return nil, err
 		}
 	}
 
 	return &helpers.ListOptions{Genus: opt.Genus, IDs: relatedCharacteristicsIDs}, nil
 }
+
+// CreateStrain inserts a new strain.
+func (db *Store) CreateStrain(s *StrainBase) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		return Create(tx, s)
+	})
+}
+
+// UpdateStrain writes a strain's changes, if p allows its current version.
+func (db *Store) UpdateStrain(s *StrainBase, p types.Precondition) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := CheckVersion(tx, "strains", s.ID, p); err != nil {
+			return err
+		}
+		return Update(tx, s)
+	})
+}
+
+// DeleteStrain deletes a strain, tombstoned as deleted by userID, if p allows
+// its current version.
+func (db *Store) DeleteStrain(s *StrainBase, userID int64, p types.Precondition) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := CheckVersion(tx, "strains", s.ID, p); err != nil {
+			return err
+		}
+		if err := SetChangedBy(tx, userID); err != nil {
+			return err
+		}
+		return Delete(tx, s)
+	})
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thermokarst/bactdb/types"
+)
+
+// Tombstone is what's left of a deleted species, strain, characteristic or
+// measurement.
+type Tombstone struct {
+	Entity    string         `db:"entity" json:"entity"`
+	ID        int64          `db:"entity_id" json:"id"`
+	DeletedAt types.NullTime `db:"deleted_at" json:"deletedAt"`
+	DeletedBy int64          `db:"deleted_by" json:"deletedBy"`
+}
+
+// changedQueries find the IDs of each entity type written since $1, in the
+// genus $2. Characteristics are shared, so they're all fair game.
+var changedQueries = map[string]string{
+	"species": `SELECT sp.id
+		FROM species sp
+		INNER JOIN genera g ON g.id=sp.genus_id AND LOWER(g.genus_name)=LOWER($2)
+		WHERE sp.updated_at > $1;`,
+	"strains": `SELECT st.id
+		FROM strains st
+		INNER JOIN species sp ON sp.id=st.species_id
+		INNER JOIN genera g ON g.id=sp.genus_id AND LOWER(g.genus_name)=LOWER($2)
+		WHERE st.updated_at > $1;`,
+	"characteristics": `SELECT c.id
+		FROM characteristics c
+		WHERE c.updated_at > $1;`,
+	"measurements": `SELECT m.id
+		FROM measurements m
+		INNER JOIN strains st ON st.id=m.strain_id
+		INNER JOIN species sp ON sp.id=st.species_id
+		INNER JOIN genera g ON g.id=sp.genus_id AND LOWER(g.genus_name)=LOWER($2)
+		WHERE m.updated_at > $1;`,
+}
+
+// ChangedIDs returns the IDs of a genus' entities of one type, created or
+// updated after since.
+func (db *Store) ChangedIDs(entity, genus string, since time.Time) ([]int64, error) {
+	q, ok := changedQueries[entity]
+	if !ok {
+		return nil, fmt.Errorf("no changes kept for %s", entity)
+	}
+
+	vals := []interface{}{since}
+	if strings.Contains(q, "$2") {
+		vals = append(vals, genus)
+	}
+
+	var ids []int64
+	if err := db.Select(&ids, q, vals...); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// TombstonesSince returns what's been deleted from a genus after since, oldest
+// first. Deletions that can't be tied to a genus are included for every genus.
+func (db *Store) TombstonesSince(genus string, since time.Time) ([]*Tombstone, error) {
+	tombstones := make([]*Tombstone, 0)
+	q := `SELECT t.entity, t.entity_id, t.deleted_at, t.deleted_by
+		FROM tombstones t
+		LEFT OUTER JOIN genera g ON g.id=t.genus_id
+		WHERE (t.genus_id IS NULL OR LOWER(g.genus_name)=LOWER($1))
+		AND t.deleted_at > $2
+		ORDER BY t.deleted_at, t.id;`
+	if err := db.Select(&tombstones, q, genus, since); err != nil {
+		return nil, err
+	}
+	return tombstones, nil
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// TraceLevel is how much SQL is logged.
+type TraceLevel int
+
+// Trace levels, each logging everything the one before does.
+const (
+	// TraceOff logs nothing.
+	TraceOff TraceLevel = iota
+	// TraceSlow warns about statements slower than the threshold.
+	TraceSlow
+	// TraceStatements logs every statement.
+	TraceStatements
+	// TraceArgs logs every statement, with its arguments.
+	TraceArgs
+)
+
+var traceLevels = []string{"off", "slow", "statements", "args"}
+
+func (l TraceLevel) String() string {
+	return traceLevels[l]
+}
+
+// ParseTraceLevel reads a trace level by name.
+func ParseTraceLevel(s string) (TraceLevel, error) {
+	for i, name := range traceLevels {
+		if s == name {
+			return TraceLevel(i), nil
+		}
+	}
+	return TraceOff, fmt.Errorf("SQL trace level %q isn't one of %s", s, strings.Join(traceLevels, ", "))
+}
+
+// MarshalText satisfies encoding.TextMarshaler.
+func (l TraceLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText satisfies encoding.TextUnmarshaler.
+func (l *TraceLevel) UnmarshalText(b []byte) error {
+	parsed, err := ParseTraceLevel(string(b))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// Tracer logs SQL statements as they're run.
+type Tracer struct {
+	Level TraceLevel
+	// Slow is how long a statement can take before it's warned about.
+	Slow time.Duration
+	// Logger is slog's default when nil.
+	Logger *slog.Logger
+}
+
+// SQLTrace is how statements are logged.
+var SQLTrace = Tracer{Level: TraceSlow, Slow: 200 * time.Millisecond}
+
+func (t Tracer) trace(requestID, query string, args []interface{}, d time.Duration) {
+	slow := d >= t.Slow
+	if t.Level == TraceOff || (t.Level == TraceSlow && !slow) {
+		return
+	}
+
+	logger := t.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	attrs := []interface{}{
+		slog.String("statement", strings.Join(strings.Fields(query), " ")),
+		slog.Float64("duration_ms", float64(d)/float64(time.Millisecond)),
+	}
+	if requestID != "" {
+		attrs = append(attrs, slog.String("request_id", requestID))
+	}
+	if t.Level >= TraceArgs {
+		attrs = append(attrs, slog.String("args", fmt.Sprint(args)))
+	}
+	if slow {
+		logger.Warn("slow query", attrs...)
+	} else {
+		logger.Info("query", attrs...)
+	}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context for the request with the ID. Statements run
+// by a store from WithContext are traced with it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID is the ID of the request that ctx is for, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
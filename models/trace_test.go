@@ -0,0 +1,98 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrace(t *testing.T) {
+	var b bytes.Buffer
+	tr := Tracer{Level: TraceSlow, Slow: time.Second, Logger: slog.New(slog.NewJSONHandler(&b, nil))}
+
+	tr.trace("", "SELECT 1", nil, time.Millisecond)
+	if b.Len() != 0 {
+		t.Errorf("fast query logged: %s", b.String())
+	}
+
+	tr.trace("abc", "SELECT *\n\t\tFROM strains", []interface{}{"secret"}, 2*time.Second)
+	got := b.String()
+	for _, want := range []string{`"level":"WARN"`, `"msg":"slow query"`, `"statement":"SELECT * FROM strains"`, `"request_id":"abc"`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%s missing from %s", want, got)
+		}
+	}
+	if strings.Contains(got, "secret") {
+		t.Errorf("args logged: %s", got)
+	}
+
+	b.Reset()
+	tr.Level = TraceArgs
+	tr.trace("", "SELECT $1", []interface{}{"x"}, time.Millisecond)
+	if got := b.String(); !strings.Contains(got, `"msg":"query"`) || !strings.Contains(got, `"args":"[x]"`) || strings.Contains(got, "request_id") {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestTraceRequest(t *testing.T) {
+	defer func(tr Tracer) { SQLTrace = tr }(SQLTrace)
+	var b bytes.Buffer
+	SQLTrace = Tracer{Level: TraceStatements, Logger: slog.New(slog.NewJSONHandler(&b, nil))}
+
+	// Nothing's listening, so the statements fail, and are traced all the same
+	db, err := sql.Open(DriverName, "host=/nonexistent sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(db)
+	defer store.Close()
+
+	// The request's store can be passed to another goroutine
+	request := store.WithContext(WithRequestID(context.Background(), "abc"))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		request.GetGenus("hymenobacter")
+	}()
+	<-done
+	store.GetGenus("arthrobacter")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"request_id":"abc"`) || !strings.Contains(lines[0], "FROM genera") ||
+		strings.Contains(lines[1], "request_id") {
+		t.Errorf("got %s", b.String())
+	}
+}
+
+func TestTraceModels(t *testing.T) {
+	defer func(tr Tracer) { SQLTrace = tr }(SQLTrace)
+	var b bytes.Buffer
+	SQLTrace = Tracer{Level: TraceArgs, Logger: slog.New(slog.NewJSONHandler(&b, nil))}
+
+	db, err := sql.Open(DriverName, "host=/nonexistent sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewStore(db)
+	defer store.Close()
+
+	const hash = "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+	store.executor().Update(&UserBase{ID: 1, Email: "admin@example.com", Password: hash, Name: "Admin", Role: "A"})
+	got := b.String()
+	if !strings.Contains(got, `"statement":"UPDATE users"`) || !strings.Contains(got, `"args":"[]"`) || strings.Contains(got, hash) {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestParseTraceLevel(t *testing.T) {
+	if l, err := ParseTraceLevel("statements"); err != nil || l != TraceStatements {
+		t.Errorf("got %v, %v", l, err)
+	}
+	if _, err := ParseTraceLevel("loud"); err == nil {
+		t.Error("parsed loud")
+	}
+}
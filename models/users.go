@@ -3,8 +3,10 @@ package models
 import (
 	"database/sql"
 	"regexp"
+	"time"
 
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/jmoiron/modl"
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/lib/pq"
 	"github.com/thermokarst/bactdb/Godeps/_workspace/src/golang.org/x/crypto/bcrypt"
 	"github.com/thermokarst/bactdb/errors"
 	"github.com/thermokarst/bactdb/helpers"
@@ -12,7 +14,7 @@ import (
 )
 
 func init() {
-	DB.AddTableWithName(UserBase{}, "users").SetKeys(true, "ID")
+	addTable(UserBase{}, "users")
 }
 
 // PreInsert is a modl hook.
@@ -104,31 +106,46 @@ type UserValidation struct {
 // Users are multiple user entities.
 type Users []*User
 
-// DbAuthenticate authenticates a user.
+// DbAuthenticate authenticates a user, counting failures towards a lockout.
 // For thermokarst/jwt: authentication callback
-func DbAuthenticate(email string, password string) error {
+func (db *Store) DbAuthenticate(email string, password string) error {
+	until, err := db.LockedUntil(email)
+	if err != nil {
+		return err
+	}
+	if until.After(time.Now()) {
+		return errors.ErrAccountLocked
+	}
+
 	var user User
 	q := `SELECT *
 		FROM users
 		WHERE lower(email)=lower($1)
 		AND verified IS TRUE;`
-	if err := DBH.SelectOne(&user, q, email); err != nil {
-		return errors.ErrInvalidEmailOrPassword
+	if err := db.SelectOne(&user, q, email); err != nil {
+		return db.loginFailed(email)
 	}
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return errors.ErrInvalidEmailOrPassword
+		return db.loginFailed(email)
 	}
-	return nil
+	return db.loginSucceeded(email)
+}
+
+func (db *Store) loginFailed(email string) error {
+	if err := db.countFailedLogin(email); err != nil {
+		return err
+	}
+	return errors.ErrInvalidEmailOrPassword
 }
 
 // GetUser returns a specific user record by ID.
-func GetUser(id int64, dummy string, claims *types.Claims) (*User, error) {
+func (db *Store) GetUser(id int64, dummy string, claims *types.Claims) (*User, error) {
 	var user User
 	q := `SELECT *
 		FROM users
 		WHERE id=$1
 		AND verified IS TRUE;`
-	if err := DBH.SelectOne(&user, q, id); err != nil {
+	if err := db.SelectOne(&user, q, id); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.ErrUserNotFound
 		}
@@ -142,13 +159,13 @@ func GetUser(id int64, dummy string, claims *types.Claims) (*User, error) {
 
 // DbGetUserByEmail returns a specific user record by email.
 // For thermokarst/jwt: setting user in claims bundle
-func DbGetUserByEmail(email string) (*User, error) {
+func (db *Store) DbGetUserByEmail(email string) (*User, error) {
 	var user User
 	q := `SELECT *
 		FROM users
 		WHERE lower(email)=lower($1)
 		AND verified IS TRUE;`
-	if err := DBH.SelectOne(&user, q, email); err != nil {
+	if err := db.SelectOne(&user, q, email); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, errors.ErrUserNotFound
 		}
@@ -157,14 +174,28 @@ func DbGetUserByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// ListUsers returns all users.
-func ListUsers(opt helpers.ListOptions, claims *types.Claims) (*Users, error) {
+// listUsersQuery builds the query for a list of verified users, shared by
+// ListUsers and StreamUsers. It leaves out the password hashes.
+func listUsersQuery(opt helpers.ListOptions) (string, []interface{}) {
+	var vals []interface{}
+
 	q := `SELECT id, email, 'password' AS password, name, role, created_at, updated_at
-		FROM users
-		WHERE verified IS TRUE;`
+	FROM users
+	WHERE verified IS TRUE`
+
+	if len(opt.IDs) != 0 {
+		var counter int64 = 1
+		q += " AND " + helpers.ValsIn("id", opt.IDs, &vals, &counter)
+	}
+
+	return q + ";", vals
+}
 
+// ListUsers returns all users, or those in opt.IDs.
+func (db *Store) ListUsers(opt helpers.ListOptions, claims *types.Claims) (*Users, error) {
 	users := make(Users, 0)
-	if err := DBH.Select(&users, q); err != nil {
+	q, vals := listUsersQuery(opt)
+	if err := db.Select(&users, q, vals...); err != nil {
 		return nil, err
 	}
 
@@ -175,32 +206,135 @@ func ListUsers(opt helpers.ListOptions, claims *types.Claims) (*Users, error) {
 	return &users, nil
 }
 
-func UpdateUserPassword(claims *types.Claims, password string) error {
-	user, err := GetUser(claims.Sub, "", claims)
-	if err != nil {
+// StreamUsers passes each of the users to fn as it's read from the database,
+// rather than returning them all at once.
+func (db *Store) StreamUsers(opt helpers.ListOptions, claims *types.Claims, fn func(*User) error) error {
+	q, vals := listUsersQuery(opt)
+	return db.stream(q, vals, func() interface{} { return &User{} }, func(row interface{}) error {
+		u := row.(*User)
+		u.CanEdit = claims.Role == "A" || u.ID == claims.Sub
+		return fn(u)
+	})
+}
+
+// CreateUser inserts a new, unverified user, along with the nonce that
+// verifies them.
+func (db *Store) CreateUser(u *UserBase, nonce, referer string) error {
+	err := db.Transact(func(tx modl.SqlExecutor) error {
+		if err := Create(tx, u); err != nil {
+			return err
+		}
+
+		q := `INSERT INTO verification (user_id, nonce, referer, created_at) VALUES ($1, $2, $3, $4);`
+		_, err := tx.Exec(q, u.ID, nonce, referer, helpers.CurrentTime())
 		return err
+	})
+	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+		return errors.ErrEmailAddressTaken
 	}
+	return err
+}
 
-	// Temporarily set PW as plaintext, for validation purposes
-	user.Password = password
+// DeleteUnverifiedUser takes back a signup whose nonce never went out, so
+// that the email address can sign up again. Verified users are left alone.
+func (db *Store) DeleteUnverifiedUser(id int64) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		q := `DELETE FROM verification WHERE user_id=$1;`
+		if _, err := tx.Exec(q, id); err != nil {
+			return err
+		}
 
-	if err := user.validate(); err != nil {
+		q = `DELETE FROM users WHERE id=$1 AND verified=false;`
+		_, err := tx.Exec(q, id)
+		return err
+	})
+}
+
+// VerifyUser verifies the user a nonce was sent to.
+func (db *Store) VerifyUser(nonce string) error {
+	q := `SELECT user_id AS userid, referer FROM verification WHERE nonce=$1;`
+
+	var ver struct {
+		UserID  int64
+		Referer string
+	}
+	if err := db.SelectOne(&ver, q, nonce); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.ErrUserNotFound
+		}
 		return err
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
-	if err != nil {
+	if ver.UserID == 0 {
+		return errors.ErrUserNotFound
+	}
+
+	var user UserBase
+	if err := db.Get(&user, ver.UserID); err != nil {
 		return err
 	}
 
-	user.Password = string(hash)
+	user.Verified = true
+
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := Update(tx, &user); err != nil {
+			return err
+		}
 
-	count, err := DBH.Update(user.UserBase)
+		q := `DELETE FROM verification WHERE user_id=$1;`
+		_, err := tx.Exec(q, user.ID)
+		return err
+	})
+}
+
+// UpdateUser writes a user's changes, if p allows their current version.
+func (db *Store) UpdateUser(u *UserBase, p types.Precondition) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := CheckVersion(tx, "users", u.ID, p); err != nil {
+			return err
+		}
+		return Update(tx, u)
+	})
+}
+
+// UpdateUserPassword hashes and stores a new password for the current user.
+func (db *Store) UpdateUserPassword(claims *types.Claims, password string) error {
+	user, err := db.GetUser(claims.Sub, "", claims)
 	if err != nil {
 		return err
 	}
-	if count != 1 {
-		return errors.ErrUserNotUpdated
+
+	if err := setPassword(user.UserBase, password); err != nil {
+		return err
+	}
+
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		count, err := tx.Update(user.UserBase)
+		if err != nil {
+			return err
+		}
+		if count != 1 {
+			return errors.ErrUserNotUpdated
+		}
+		return nil
+	})
+}
+
+// setPassword validates a new password for a user, and replaces their
+// password hash with its hash.
+func setPassword(u *UserBase, password string) error {
+	// Temporarily set PW as plaintext, for validation purposes
+	u.Password = password
+
+	if err := u.validate(); err != nil {
+		return err
 	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	u.Password = string(hash)
 	return nil
 }
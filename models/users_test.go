@@ -0,0 +1,44 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/types"
+)
+
+func TestListUsersQuery(t *testing.T) {
+	q, vals := listUsersQuery(helpers.ListOptions{})
+	if strings.Contains(q, " AND ") || len(vals) != 0 {
+		t.Errorf("all users got %q %v", q, vals)
+	}
+
+	q, vals = listUsersQuery(helpers.ListOptions{IDs: []int64{3, 5}})
+	if !strings.Contains(q, "AND id IN ($1,$2)") || len(vals) != 2 || vals[0] != int64(3) || vals[1] != int64(5) {
+		t.Errorf("users 3 and 5 got %q %v", q, vals)
+	}
+}
+
+func TestMemoryListUsers(t *testing.T) {
+	mem := NewMemory()
+	var ids []int64
+	for _, email := range []string{"ann@example.com", "bob@example.com", "cal@example.com"} {
+		u, err := mem.AddUser(UserBase{Email: email, Name: email, Role: "R"}, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, u.ID)
+	}
+	claims := &types.Claims{Role: "A"}
+
+	users, err := mem.ListUsers(helpers.ListOptions{}, claims)
+	if err != nil || len(*users) != 3 {
+		t.Errorf("all users got %d, %v", len(*users), err)
+	}
+
+	users, err = mem.ListUsers(helpers.ListOptions{IDs: ids[1:2]}, claims)
+	if err != nil || len(*users) != 1 || (*users)[0].ID != ids[1] {
+		t.Errorf("user %d got %v, %v", ids[1], users, err)
+	}
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"database/sql"
+	"net/url"
+	"regexp"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/jmoiron/modl"
+	"github.com/thermokarst/bactdb/errors"
+	"github.com/thermokarst/bactdb/helpers"
+	"github.com/thermokarst/bactdb/types"
+)
+
+func init() {
+	addTable(WebhookBase{}, "webhooks")
+	addTable(WebhookDelivery{}, "webhook_deliveries")
+}
+
+// webhookEvent is an event filter: an entity type and an action, either of
+// which can be *, or just *.
+var webhookEvent = regexp.MustCompile(`^(\*|(\*|species|strains|characteristics|measurements)\.(\*|create|update|delete))$`)
+
+// PreInsert is a modl hook.
+func (w *WebhookBase) PreInsert(e modl.SqlExecutor) error {
+	ct := helpers.CurrentTime()
+	w.CreatedAt = ct
+	w.UpdatedAt = ct
+	return nil
+}
+
+// PreUpdate is a modl hook.
+func (w *WebhookBase) PreUpdate(e modl.SqlExecutor) error {
+	w.UpdatedAt = helpers.CurrentTime()
+	return nil
+}
+
+// UpdateError satisfies base interface.
+func (w *WebhookBase) UpdateError() error {
+	return errors.ErrWebhookNotUpdated
+}
+
+// DeleteError satisfies base interface.
+func (w *WebhookBase) DeleteError() error {
+	return errors.ErrWebhookNotDeleted
+}
+
+func (w *WebhookBase) validate() types.ValidationError {
+	wv := make(types.ValidationError, 0)
+
+	if w.GenusID == 0 {
+		wv = append(wv, types.NewValidationError(
+			"genus",
+			helpers.MustProvideAValue))
+	}
+
+	if u, err := url.Parse(w.URL); w.URL == "" {
+		wv = append(wv, types.NewValidationError(
+			"url",
+			helpers.MustProvideAValue))
+	} else if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		wv = append(wv, types.NewValidationError(
+			"url",
+			"URL must be an absolute http or https URL"))
+	}
+
+	if len(w.Events) == 0 {
+		wv = append(wv, types.NewValidationError(
+			"events",
+			helpers.MustProvideAValue))
+	}
+	for _, event := range w.Events {
+		if !webhookEvent.MatchString(event) {
+			wv = append(wv, types.NewValidationError(
+				"events",
+				"Events must be like strains.create, strains.* or *"))
+			break
+		}
+	}
+
+	if w.Secret == "" {
+		wv = append(wv, types.NewValidationError(
+			"secret",
+			helpers.MustProvideAValue))
+	}
+
+	if len(wv) > 0 {
+		return wv
+	}
+
+	return nil
+}
+
+// WebhookBase is what the DB expects for write operations.
+type WebhookBase struct {
+	ID        int64            `db:"id" json:"id"`
+	GenusID   int64            `db:"genus_id" json:"-"`
+	URL       string           `db:"url" json:"url"`
+	Events    types.StringList `db:"events" json:"events"`
+	Secret    string           `db:"secret" json:"secret,omitempty"`
+	Active    bool             `db:"active" json:"active"`
+	CreatedAt types.NullTime   `db:"created_at" json:"createdAt"`
+	UpdatedAt types.NullTime   `db:"updated_at" json:"updatedAt"`
+	CreatedBy int64            `db:"created_by" json:"createdBy"`
+	UpdatedBy int64            `db:"updated_by" json:"updatedBy"`
+}
+
+// Webhook is what the DB expects for read operations, and is what the API
+// expects to return to the requester. The secret is only ever sent back when
+// the webhook is created.
+type Webhook struct {
+	*WebhookBase
+	GenusName string `db:"genus_name" json:"genusName"`
+}
+
+// Webhooks are multiple webhook entities.
+type Webhooks []*Webhook
+
+// WebhookDelivery is one attempt at calling a webhook.
+type WebhookDelivery struct {
+	ID         int64            `db:"id" json:"id"`
+	WebhookID  int64            `db:"webhook_id" json:"webhook"`
+	Delivery   string           `db:"delivery" json:"delivery"`
+	Event      string           `db:"event" json:"event"`
+	Attempt    int64            `db:"attempt" json:"attempt"`
+	StatusCode types.NullInt64  `db:"status_code" json:"statusCode"`
+	Error      types.NullString `db:"error" json:"error"`
+	DurationMS int64            `db:"duration_ms" json:"durationMs"`
+	CreatedAt  types.NullTime   `db:"created_at" json:"createdAt"`
+}
+
+// WebhookDeliveries are multiple webhook delivery entities.
+type WebhookDeliveries []*WebhookDelivery
+
+// ListWebhooks returns a genus' webhooks. Only active ones are returned when
+// active is set.
+func (db *Store) ListWebhooks(genus string, active bool) (*Webhooks, error) {
+	webhooks := make(Webhooks, 0)
+	q := `SELECT w.*, g.genus_name
+		FROM webhooks w
+		INNER JOIN genera g ON g.id=w.genus_id AND LOWER(g.genus_name)=LOWER($1)
+		WHERE w.active OR NOT $2
+		ORDER BY w.id;`
+	if err := db.Select(&webhooks, q, genus, active); err != nil {
+		return nil, err
+	}
+	return &webhooks, nil
+}
+
+// GetWebhook returns a particular webhook.
+func (db *Store) GetWebhook(id int64, genus string) (*Webhook, error) {
+	var webhook Webhook
+	q := `SELECT w.*, g.genus_name
+		FROM webhooks w
+		INNER JOIN genera g ON g.id=w.genus_id AND LOWER(g.genus_name)=LOWER($1)
+		WHERE w.id=$2;`
+	if err := db.SelectOne(&webhook, q, genus, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// ListWebhookDeliveries returns the latest attempts at calling a webhook,
+// newest first.
+func (db *Store) ListWebhookDeliveries(webhookID int64, limit int64) (*WebhookDeliveries, error) {
+	deliveries := make(WebhookDeliveries, 0)
+	q := `SELECT * FROM webhook_deliveries
+		WHERE webhook_id=$1
+		ORDER BY id DESC
+		LIMIT $2;`
+	if err := db.Select(&deliveries, q, webhookID, limit); err != nil {
+		return nil, err
+	}
+	return &deliveries, nil
+}
+
+// CreateWebhookDelivery logs an attempt at calling a webhook.
+func (db *Store) CreateWebhookDelivery(d *WebhookDelivery) error {
+	return db.Insert(d)
+}
+
+// CreateWebhook registers a new webhook.
+func (db *Store) CreateWebhook(w *WebhookBase) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		return Create(tx, w)
+	})
+}
+
+// UpdateWebhook writes a webhook's changes, if p allows its current version.
+func (db *Store) UpdateWebhook(w *WebhookBase, p types.Precondition) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := CheckVersion(tx, "webhooks", w.ID, p); err != nil {
+			return err
+		}
+		return Update(tx, w)
+	})
+}
+
+// DeleteWebhook deletes a webhook, along with its delivery log, if p allows
+// its current version.
+func (db *Store) DeleteWebhook(w *WebhookBase, p types.Precondition) error {
+	return db.Transact(func(tx modl.SqlExecutor) error {
+		if err := CheckVersion(tx, "webhooks", w.ID, p); err != nil {
+			return err
+		}
+		return Delete(tx, w)
+	})
+}
@@ -0,0 +1,23 @@
+package payloads
+
+import (
+	"encoding/json"
+
+	"github.com/thermokarst/bactdb/models"
+)
+
+// Changes is everything in a genus created, updated or deleted since a sync
+// cursor, and the cursor to sync from next time.
+type Changes struct {
+	Cursor          string                 `json:"cursor"`
+	Species         models.ManySpecies     `json:"species"`
+	Strains         models.Strains         `json:"strains"`
+	Characteristics models.Characteristics `json:"characteristics"`
+	Measurements    models.Measurements    `json:"measurements"`
+	Deleted         []*models.Tombstone    `json:"deleted"`
+}
+
+// Marshal satisfies the CRUD interfaces.
+func (c *Changes) Marshal() ([]byte, error) {
+	return json.Marshal(c)
+}
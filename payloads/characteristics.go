@@ -2,6 +2,7 @@ package payloads
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/thermokarst/bactdb/models"
 )
@@ -10,18 +11,18 @@ import (
 // a particular characteristic.
 type Characteristic struct {
 	Characteristic *models.Characteristic `json:"characteristic"`
-	Measurements   *models.Measurements   `json:"measurements"`
-	Strains        *models.Strains        `json:"strains"`
-	Species        *models.ManySpecies    `json:"species"`
+	Measurements   *models.Measurements   `json:"measurements,omitempty"`
+	Strains        *models.Strains        `json:"strains,omitempty"`
+	Species        *models.ManySpecies    `json:"species,omitempty"`
 }
 
 // Characteristics is a payload that sideloads all of the necessary entities for
 // multiple characteristics.
 type Characteristics struct {
 	Characteristics *models.Characteristics `json:"characteristics"`
-	Measurements    *models.Measurements    `json:"measurements"`
-	Strains         *models.Strains         `json:"strains"`
-	Species         *models.ManySpecies     `json:"species"`
+	Measurements    *models.Measurements    `json:"measurements,omitempty"`
+	Strains         *models.Strains         `json:"strains,omitempty"`
+	Species         *models.ManySpecies     `json:"species,omitempty"`
 }
 
 // Marshal satisfies the CRUD interfaces.
@@ -33,3 +34,13 @@ func (c *Characteristic) Marshal() ([]byte, error) {
 func (c *Characteristics) Marshal() ([]byte, error) {
 	return json.Marshal(c)
 }
+
+// LastModified satisfies interface VersionedEntity.
+func (c *Characteristic) LastModified() time.Time {
+	return c.Characteristic.UpdatedAt.Time
+}
+
+// IncludedModified satisfies interface IncludingEntity.
+func (c *Characteristic) IncludedModified() []time.Time {
+	return updatedAt(updatedAt(updatedAt(nil, c.Measurements), c.Strains), c.Species)
+}
@@ -0,0 +1,99 @@
+package payloads
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// entityTypes maps payload root keys to the entity type names used in
+// fields[type] parameters.
+var entityTypes = map[string]string{
+	"characteristic":  "characteristics",
+	"characteristics": "characteristics",
+	"measurement":     "measurements",
+	"measurements":    "measurements",
+	"species":         "species",
+	"strain":          "strains",
+	"strains":         "strains",
+	"user":            "users",
+	"users":           "users",
+}
+
+// ApplyFieldsets trims the entities in a marshalled payload down to the
+// attributes requested for their type. The id attribute is always kept.
+// Fieldsets for types that aren't in the payload, and fields that entities
+// don't have, are ignored.
+//
+// Trimming is done on the marshalled payload, so fieldsets only make
+// responses smaller, the entities are still read and marshalled in full.
+// Leaving related entities out with include is what saves the queries.
+func ApplyFieldsets(data []byte, fields map[string][]string) ([]byte, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	for key, raw := range root {
+		allowed, ok := fields[entityTypes[key]]
+		if !ok {
+			continue
+		}
+		trimmed, err := trimEntities(raw, allowed)
+		if err != nil {
+			return nil, err
+		}
+		root[key] = trimmed
+	}
+
+	return json.Marshal(root)
+}
+
+func trimEntities(raw json.RawMessage, allowed []string) (json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return raw, nil
+	}
+
+	switch raw[0] {
+	case '[':
+		var entities []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &entities); err != nil {
+			return nil, err
+		}
+		for _, e := range entities {
+			trimEntity(e, allowed)
+		}
+		return json.Marshal(entities)
+	case '{':
+		var entity map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &entity); err != nil {
+			return nil, err
+		}
+		trimEntity(entity, allowed)
+		return json.Marshal(entity)
+	}
+
+	return raw, nil
+}
+
+func trimEntity(entity map[string]json.RawMessage, allowed []string) {
+	for attr := range entity {
+		if attr == "id" {
+			continue
+		}
+		keep := false
+		for _, a := range allowed {
+			if a == attr {
+				keep = true
+				break
+			}
+		}
+		if !keep {
+			delete(entity, attr)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package payloads
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestApplyFieldsets(t *testing.T) {
+	data := []byte(`{
+		"strain": {"id": 3, "strainName": "S3", "notes": "pond", "species": 1},
+		"species": [{"id": 1, "speciesName": "S. one", "etymology": "first"}, {"id": 2, "speciesName": "S. two"}],
+		"measurements": null
+	}`)
+
+	trimmed, err := ApplyFieldsets(data, map[string][]string{
+		"strains":      {"strainName", "noSuchField"},
+		"species":      {},
+		"measurements": {"value"},
+		"genera":       {"genusName"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(trimmed, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"strain":       map[string]interface{}{"id": 3.0, "strainName": "S3"},
+		"species":      []interface{}{map[string]interface{}{"id": 1.0}, map[string]interface{}{"id": 2.0}},
+		"measurements": nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %s", trimmed)
+	}
+
+	// No fieldsets, no work
+	if same, err := ApplyFieldsets(data, nil); err != nil || string(same) != string(data) {
+		t.Errorf("got %s, %v", same, err)
+	}
+	if _, err := ApplyFieldsets([]byte(`not json`), map[string][]string{"strains": nil}); err == nil {
+		t.Error("trimmed a payload that isn't JSON")
+	}
+}
@@ -0,0 +1,292 @@
+package payloads
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/thermokarst/bactdb/errors"
+)
+
+// JSONAPIMediaType is the media type of JSON:API documents.
+const JSONAPIMediaType = "application/vnd.api+json"
+
+// relation is an attribute that refers to other entities.
+type relation struct {
+	typ    string
+	toMany bool
+}
+
+// relationships are the attributes of each entity type that refer to other
+// entities.
+var relationships = map[string]map[string]relation{
+	"characteristics": {
+		"measurements": {"measurements", true},
+		"strains":      {"strains", true},
+	},
+	"measurements": {
+		"characteristic": {"characteristics", false},
+		"strain":         {"strains", false},
+	},
+	"species": {
+		"strains": {"strains", true},
+	},
+	"strains": {
+		"characteristics": {"characteristics", true},
+		"measurements":    {"measurements", true},
+		"species":         {"species", false},
+	},
+	"users": {},
+}
+
+// rootKeys are the payload root keys for a single entity of each type.
+var rootKeys = map[string]string{
+	"characteristics": "characteristic",
+	"measurements":    "measurement",
+	"species":         "species",
+	"strains":         "strain",
+	"users":           "user",
+}
+
+// Document is a JSON:API top-level document.
+type Document struct {
+	// Data is a *Resource, or a []*Resource for lists.
+	Data     interface{}            `json:"data"`
+	Included []*Resource            `json:"included,omitempty"`
+	Links    map[string]string      `json:"links,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+}
+
+// Resource is a JSON:API resource object.
+type Resource struct {
+	Type          string                     `json:"type"`
+	ID            string                     `json:"id,omitempty"`
+	Attributes    map[string]json.RawMessage `json:"attributes"`
+	Relationships map[string]*Relationship   `json:"relationships,omitempty"`
+	Links         map[string]string          `json:"links,omitempty"`
+}
+
+// Relationship is a JSON:API relationship object.
+type Relationship struct {
+	// Data is a *Identifier, or a []*Identifier for to-many relationships.
+	Data interface{} `json:"data"`
+}
+
+// Identifier is a JSON:API resource identifier object.
+type Identifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Marshal satisfies the CRUD interfaces.
+func (d *Document) Marshal() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+// NewDocument translates a marshalled payload into a JSON:API document. typ
+// is the type of the primary data, which is a list unless single is set, and
+// everything else in the payload is included. Resource links are relative to
+// base.
+func NewDocument(data []byte, typ string, single bool, base string) (*Document, error) {
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	primaryKey := typ
+	if single {
+		primaryKey = rootKeys[typ]
+	}
+
+	doc := Document{}
+	seen := make(map[Identifier]bool)
+	for key, raw := range root {
+		t, ok := entityTypes[key]
+		if !ok {
+			continue
+		}
+		resources, err := newResources(raw, t, base)
+		if err != nil {
+			return nil, err
+		}
+
+		if key == primaryKey {
+			if single {
+				if len(resources) > 0 {
+					doc.Data = resources[0]
+				}
+			} else {
+				doc.Data = resources
+				doc.Meta = map[string]interface{}{"count": len(resources)}
+			}
+			continue
+		}
+
+		for _, r := range resources {
+			id := Identifier{Type: r.Type, ID: r.ID}
+			if !seen[id] {
+				seen[id] = true
+				doc.Included = append(doc.Included, r)
+			}
+		}
+	}
+
+	return &doc, nil
+}
+
+// newResources translates an entity, or a list of them.
+func newResources(raw json.RawMessage, typ, base string) ([]*Resource, error) {
+	var entities []map[string]json.RawMessage
+	raw = bytes.TrimSpace(raw)
+	if bytes.HasPrefix(raw, []byte("{")) {
+		var entity map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &entity); err != nil {
+			return nil, err
+		}
+		entities = append(entities, entity)
+	} else if err := json.Unmarshal(raw, &entities); err != nil {
+		return nil, err
+	}
+
+	resources := make([]*Resource, 0, len(entities))
+	for _, entity := range entities {
+		r := Resource{
+			Type:          typ,
+			ID:            rawID(entity["id"]),
+			Attributes:    entity,
+			Relationships: make(map[string]*Relationship),
+		}
+		delete(entity, "id")
+
+		for attr, rel := range relationships[typ] {
+			ids, ok := entity[attr]
+			if !ok {
+				continue
+			}
+			delete(entity, attr)
+			relationship, err := newRelationship(ids, rel)
+			if err != nil {
+				return nil, err
+			}
+			r.Relationships[attr] = relationship
+		}
+
+		if r.ID != "" {
+			r.Links = map[string]string{"self": base + "/" + typ + "/" + r.ID}
+		}
+		resources = append(resources, &r)
+	}
+	return resources, nil
+}
+
+func newRelationship(raw json.RawMessage, rel relation) (*Relationship, error) {
+	if rel.toMany {
+		var ids []int64
+		if err := json.Unmarshal(raw, &ids); err != nil {
+			return nil, err
+		}
+		identifiers := make([]*Identifier, 0, len(ids))
+		for _, id := range ids {
+			identifiers = append(identifiers, &Identifier{Type: rel.typ, ID: strconv.FormatInt(id, 10)})
+		}
+		return &Relationship{Data: identifiers}, nil
+	}
+
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return &Relationship{}, nil
+	}
+	return &Relationship{Data: &Identifier{Type: rel.typ, ID: rawID(raw)}}, nil
+}
+
+func rawID(raw json.RawMessage) string {
+	var id int64
+	if err := json.Unmarshal(raw, &id); err != nil {
+		return ""
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// ParseDocument translates a JSON:API document with a single resource object
+// of type typ into a payload. When id is given, the resource has to have it.
+func ParseDocument(data []byte, typ, id string) ([]byte, error) {
+	var doc struct {
+		Data *Resource `json:"data"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Data == nil {
+		return nil, errors.ErrInvalidDocument
+	}
+	if doc.Data.Type != typ {
+		return nil, errors.ErrResourceTypeConflict
+	}
+	if id != "" && doc.Data.ID != "" && doc.Data.ID != id {
+		return nil, errors.ErrResourceIDConflict
+	}
+
+	entity := doc.Data.Attributes
+	if entity == nil {
+		entity = make(map[string]json.RawMessage)
+	}
+	for name, rel := range doc.Data.Relationships {
+		if _, ok := relationships[typ][name]; !ok {
+			continue
+		}
+		raw, err := relationshipIDs(rel)
+		if err != nil {
+			return nil, err
+		}
+		entity[name] = raw
+	}
+	if doc.Data.ID != "" {
+		if _, err := strconv.ParseInt(doc.Data.ID, 10, 64); err != nil {
+			return nil, errors.ErrInvalidID
+		}
+		entity["id"] = json.RawMessage(doc.Data.ID)
+	}
+
+	return json.Marshal(map[string]interface{}{rootKeys[typ]: entity})
+}
+
+// relationshipIDs is the payload form of a relationship: an ID, a list of
+// them, or null.
+func relationshipIDs(rel *Relationship) (json.RawMessage, error) {
+	var linkage json.RawMessage
+	if rel != nil {
+		b, err := json.Marshal(rel.Data)
+		if err != nil {
+			return nil, err
+		}
+		linkage = bytes.TrimSpace(b)
+	}
+
+	switch {
+	case linkage == nil || bytes.Equal(linkage, []byte("null")):
+		return json.RawMessage("null"), nil
+	case bytes.HasPrefix(linkage, []byte("[")):
+		var identifiers []Identifier
+		if err := json.Unmarshal(linkage, &identifiers); err != nil {
+			return nil, err
+		}
+		ids := make([]int64, 0, len(identifiers))
+		for _, i := range identifiers {
+			id, err := strconv.ParseInt(i.ID, 10, 64)
+			if err != nil {
+				return nil, errors.ErrInvalidID
+			}
+			ids = append(ids, id)
+		}
+		return json.Marshal(ids)
+	}
+
+	var identifier Identifier
+	if err := json.Unmarshal(linkage, &identifier); err != nil {
+		return nil, err
+	}
+	id, err := strconv.ParseInt(identifier.ID, 10, 64)
+	if err != nil {
+		return nil, errors.ErrInvalidID
+	}
+	return json.Marshal(id)
+}
@@ -0,0 +1,125 @@
+package payloads
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/thermokarst/bactdb/errors"
+)
+
+func TestNewDocument(t *testing.T) {
+	data := []byte(`{
+		"strain": {"id": 3, "strainName": "S3", "species": 1, "measurements": [7, 8], "characteristics": null},
+		"species": [{"id": 1, "speciesName": "S. one", "strains": [3]}]
+	}`)
+
+	doc, err := NewDocument(data, "strains", true, "/api/v2/hymenobacter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strain, ok := doc.Data.(*Resource)
+	if !ok {
+		t.Fatalf("data is %T, want *Resource", doc.Data)
+	}
+	if strain.Type != "strains" || strain.ID != "3" {
+		t.Errorf("data is %s %s, want strains 3", strain.Type, strain.ID)
+	}
+	if got := keysOf(strain.Attributes); !reflect.DeepEqual(got, []string{"strainName"}) {
+		t.Errorf("attributes %v, want [strainName]", got)
+	}
+	if got := strain.Links["self"]; got != "/api/v2/hymenobacter/strains/3" {
+		t.Errorf("self link %q", got)
+	}
+
+	if got := strain.Relationships["species"].Data; !reflect.DeepEqual(got, &Identifier{"species", "1"}) {
+		t.Errorf("species relationship %+v", got)
+	}
+	want := []*Identifier{{"measurements", "7"}, {"measurements", "8"}}
+	if got := strain.Relationships["measurements"].Data; !reflect.DeepEqual(got, want) {
+		t.Errorf("measurements relationship %+v", got)
+	}
+	if got := strain.Relationships["characteristics"].Data; !reflect.DeepEqual(got, []*Identifier{}) {
+		t.Errorf("characteristics relationship %+v, want empty", got)
+	}
+
+	if len(doc.Included) != 1 || doc.Included[0].Type != "species" || doc.Included[0].ID != "1" {
+		t.Errorf("included %+v, want species 1", doc.Included)
+	}
+}
+
+func TestNewDocumentList(t *testing.T) {
+	data := []byte(`{"species": [{"id": 1}, {"id": 2}], "strains": [{"id": 3, "species": 1}]}`)
+
+	doc, err := NewDocument(data, "species", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	species, ok := doc.Data.([]*Resource)
+	if !ok || len(species) != 2 {
+		t.Fatalf("data %+v, want 2 species", doc.Data)
+	}
+	if doc.Meta["count"] != 2 {
+		t.Errorf("meta %v", doc.Meta)
+	}
+	if len(doc.Included) != 1 || doc.Included[0].Type != "strains" {
+		t.Errorf("included %+v, want a strain", doc.Included)
+	}
+}
+
+func TestParseDocument(t *testing.T) {
+	body := []byte(`{"data": {
+		"type": "measurements",
+		"id": "5",
+		"attributes": {"value": 4.5},
+		"relationships": {
+			"strain": {"data": {"type": "strains", "id": "3"}},
+			"characteristic": {"data": null}
+		}
+	}}`)
+
+	data, err := ParseDocument(body, "measurements", "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]interface{}{
+		"measurement": {"id": 5.0, "value": 4.5, "strain": 3.0, "characteristic": nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseDocumentConflicts(t *testing.T) {
+	tests := []struct {
+		body string
+		id   string
+		err  error
+	}{
+		{`{"data": null}`, "", errors.ErrInvalidDocument},
+		{`{"data": {"type": "strains"}}`, "", errors.ErrResourceTypeConflict},
+		{`{"data": {"type": "species", "id": "2"}}`, "1", errors.ErrResourceIDConflict},
+		{`{"data": {"type": "species", "id": "x"}}`, "", errors.ErrInvalidID},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseDocument([]byte(test.body), "species", test.id); err != test.err {
+			t.Errorf("%s: got %v, want %v", test.body, err, test.err)
+		}
+	}
+}
+
+func keysOf(m map[string]json.RawMessage) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
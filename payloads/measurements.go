@@ -2,8 +2,10 @@ package payloads
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/thermokarst/bactdb/models"
+	"github.com/thermokarst/bactdb/types"
 )
 
 // Measurement is a payload that sideloads all of the necessary entities for
@@ -15,11 +17,42 @@ type Measurement struct {
 // Measurements is a payload that sideloads all of the necessary entities for
 // multiple measurements.
 type Measurements struct {
-	Strains         *models.Strains         `json:"strains"`
-	Characteristics *models.Characteristics `json:"characteristics"`
+	Strains         *models.Strains         `json:"strains,omitempty"`
+	Characteristics *models.Characteristics `json:"characteristics,omitempty"`
 	Measurements    *models.Measurements    `json:"measurements"`
 }
 
+// MeasurementBatch is a set of measurement writes to apply in a single
+// transaction. When Atomic is set, nothing is written unless every operation
+// succeeds.
+type MeasurementBatch struct {
+	Atomic     bool                   `json:"atomic"`
+	Operations []MeasurementOperation `json:"operations"`
+}
+
+// MeasurementOperation is a single create, update or delete in a batch.
+// Updates are merged on top of the stored measurement.
+type MeasurementOperation struct {
+	Op          string          `json:"op"`
+	ID          int64           `json:"id,omitempty"`
+	Measurement json.RawMessage `json:"measurement,omitempty"`
+}
+
+// MeasurementBatchResults reports the outcome of every operation in a batch,
+// in the same order as the operations were submitted.
+type MeasurementBatchResults struct {
+	Results []*MeasurementOperationResult `json:"results"`
+}
+
+// MeasurementOperationResult is the outcome of a single batch operation.
+type MeasurementOperationResult struct {
+	Op          string                `json:"op"`
+	ID          int64                 `json:"id,omitempty"`
+	Status      int                   `json:"status"`
+	Measurement *models.Measurement   `json:"measurement,omitempty"`
+	Errors      types.ValidationError `json:"errors,omitempty"`
+}
+
 // Marshal satisfies the CRUD interfaces.
 func (m *Measurement) Marshal() ([]byte, error) {
 	return json.Marshal(m)
@@ -29,3 +62,13 @@ func (m *Measurement) Marshal() ([]byte, error) {
 func (m *Measurements) Marshal() ([]byte, error) {
 	return json.Marshal(m)
 }
+
+// LastModified satisfies interface VersionedEntity.
+func (m *Measurement) LastModified() time.Time {
+	return m.Measurement.UpdatedAt.Time
+}
+
+// Marshal satisfies the CRUD interfaces.
+func (m *MeasurementBatchResults) Marshal() ([]byte, error) {
+	return json.Marshal(m)
+}
@@ -2,6 +2,7 @@ package payloads
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/thermokarst/bactdb/models"
 )
@@ -10,14 +11,14 @@ import (
 // particular species.
 type Species struct {
 	Species *models.Species `json:"species"`
-	Strains *models.Strains `json:"strains"`
+	Strains *models.Strains `json:"strains,omitempty"`
 }
 
 // ManySpecies is a payload that sideloads all of the necessary entities for
 // multiple species.
 type ManySpecies struct {
 	Species *models.ManySpecies `json:"species"`
-	Strains *models.Strains     `json:"strains"`
+	Strains *models.Strains     `json:"strains,omitempty"`
 }
 
 // Marshal satisfies the CRUD interfaces.
@@ -29,3 +30,45 @@ func (s *Species) Marshal() ([]byte, error) {
 func (s *ManySpecies) Marshal() ([]byte, error) {
 	return json.Marshal(s)
 }
+
+// LastModified satisfies interface VersionedEntity.
+func (s *Species) LastModified() time.Time {
+	return s.Species.UpdatedAt.Time
+}
+
+// IncludedModified satisfies interface IncludingEntity.
+func (s *Species) IncludedModified() []time.Time {
+	return updatedAt(nil, s.Strains)
+}
+
+// updatedAt appends when each of a list of records was last modified to
+// times. Lists that weren't sideloaded are nil, and add nothing.
+func updatedAt(times []time.Time, records interface{}) []time.Time {
+	switch rs := records.(type) {
+	case *models.ManySpecies:
+		if rs != nil {
+			for _, r := range *rs {
+				times = append(times, r.UpdatedAt.Time)
+			}
+		}
+	case *models.Strains:
+		if rs != nil {
+			for _, r := range *rs {
+				times = append(times, r.UpdatedAt.Time)
+			}
+		}
+	case *models.Characteristics:
+		if rs != nil {
+			for _, r := range *rs {
+				times = append(times, r.UpdatedAt.Time)
+			}
+		}
+	case *models.Measurements:
+		if rs != nil {
+			for _, r := range *rs {
+				times = append(times, r.UpdatedAt.Time)
+			}
+		}
+	}
+	return times
+}
@@ -2,6 +2,7 @@ package payloads
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/thermokarst/bactdb/models"
 )
@@ -10,18 +11,18 @@ import (
 // particular strain.
 type Strain struct {
 	Strain          *models.Strain          `json:"strain"`
-	Species         *models.ManySpecies     `json:"species"`
-	Characteristics *models.Characteristics `json:"characteristics"`
-	Measurements    *models.Measurements    `json:"measurements"`
+	Species         *models.ManySpecies     `json:"species,omitempty"`
+	Characteristics *models.Characteristics `json:"characteristics,omitempty"`
+	Measurements    *models.Measurements    `json:"measurements,omitempty"`
 }
 
 // Strains is a payload that sideloads all of the necessary entities for
 // multiple strains.
 type Strains struct {
 	Strains         *models.Strains         `json:"strains"`
-	Species         *models.ManySpecies     `json:"species"`
-	Characteristics *models.Characteristics `json:"characteristics"`
-	Measurements    *models.Measurements    `json:"measurements"`
+	Species         *models.ManySpecies     `json:"species,omitempty"`
+	Characteristics *models.Characteristics `json:"characteristics,omitempty"`
+	Measurements    *models.Measurements    `json:"measurements,omitempty"`
 }
 
 // Marshal satisfies the CRUD interfaces.
@@ -33,3 +34,13 @@ func (s *Strain) Marshal() ([]byte, error) {
 func (s *Strains) Marshal() ([]byte, error) {
 	return json.Marshal(s)
 }
+
+// LastModified satisfies interface VersionedEntity.
+func (s *Strain) LastModified() time.Time {
+	return s.Strain.UpdatedAt.Time
+}
+
+// IncludedModified satisfies interface IncludingEntity.
+func (s *Strain) IncludedModified() []time.Time {
+	return updatedAt(updatedAt(updatedAt(nil, s.Species), s.Characteristics), s.Measurements)
+}
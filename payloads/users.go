@@ -2,6 +2,7 @@ package payloads
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/thermokarst/bactdb/models"
 )
@@ -27,3 +28,8 @@ func (u *User) Marshal() ([]byte, error) {
 func (u *Users) Marshal() ([]byte, error) {
 	return json.Marshal(u)
 }
+
+// LastModified satisfies interface VersionedEntity.
+func (u *User) LastModified() time.Time {
+	return u.User.UpdatedAt.Time
+}
@@ -0,0 +1,43 @@
+package payloads
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/thermokarst/bactdb/models"
+)
+
+// Webhook is a payload for a particular webhook.
+type Webhook struct {
+	Webhook *models.Webhook `json:"webhook"`
+}
+
+// Webhooks is a payload for multiple webhooks.
+type Webhooks struct {
+	Webhooks *models.Webhooks `json:"webhooks"`
+}
+
+// WebhookDeliveries is a payload for a webhook's delivery log.
+type WebhookDeliveries struct {
+	WebhookDeliveries *models.WebhookDeliveries `json:"webhookDeliveries"`
+}
+
+// Marshal satisfies the CRUD interfaces.
+func (w *Webhook) Marshal() ([]byte, error) {
+	return json.Marshal(w)
+}
+
+// Marshal satisfies the CRUD interfaces.
+func (w *Webhooks) Marshal() ([]byte, error) {
+	return json.Marshal(w)
+}
+
+// Marshal satisfies the CRUD interfaces.
+func (w *WebhookDeliveries) Marshal() ([]byte, error) {
+	return json.Marshal(w)
+}
+
+// LastModified satisfies interface VersionedEntity.
+func (w *Webhook) LastModified() time.Time {
+	return w.Webhook.UpdatedAt.Time
+}
@@ -0,0 +1,253 @@
+package rpc
+
+// The messages of bactdb.proto, numbered the same.
+
+// ListRequest lists all of a genus' entities, or just the ones with IDs.
+type ListRequest struct {
+	Genus string
+	IDs   []int64
+}
+
+// UnmarshalProto satisfies Unmarshaler.
+func (m *ListRequest) UnmarshalProto(fields []Field) error {
+	var err error
+	for _, f := range fields {
+		switch f.Number {
+		case 1:
+			m.Genus = f.String()
+		case 2:
+			m.IDs, err = f.Int64s(m.IDs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalProto satisfies Message.
+func (m *ListRequest) MarshalProto(e *Encoder) {
+	e.String(1, m.Genus)
+	e.Int64s(2, m.IDs)
+}
+
+// GetRequest gets one of a genus' entities.
+type GetRequest struct {
+	Genus string
+	ID    int64
+}
+
+// UnmarshalProto satisfies Unmarshaler.
+func (m *GetRequest) UnmarshalProto(fields []Field) error {
+	for _, f := range fields {
+		switch f.Number {
+		case 1:
+			m.Genus = f.String()
+		case 2:
+			m.ID = f.Int64()
+		}
+	}
+	return nil
+}
+
+// MarshalProto satisfies Message.
+func (m *GetRequest) MarshalProto(e *Encoder) {
+	e.String(1, m.Genus)
+	e.Int64(2, m.ID)
+}
+
+// MeasurementListRequest lists measurements, by ID, strain or characteristic.
+type MeasurementListRequest struct {
+	Genus             string
+	IDs               []int64
+	StrainIDs         []int64
+	CharacteristicIDs []int64
+}
+
+// UnmarshalProto satisfies Unmarshaler.
+func (m *MeasurementListRequest) UnmarshalProto(fields []Field) error {
+	var err error
+	for _, f := range fields {
+		switch f.Number {
+		case 1:
+			m.Genus = f.String()
+		case 2:
+			m.IDs, err = f.Int64s(m.IDs)
+		case 3:
+			m.StrainIDs, err = f.Int64s(m.StrainIDs)
+		case 4:
+			m.CharacteristicIDs, err = f.Int64s(m.CharacteristicIDs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalProto satisfies Message.
+func (m *MeasurementListRequest) MarshalProto(e *Encoder) {
+	e.String(1, m.Genus)
+	e.Int64s(2, m.IDs)
+	e.Int64s(3, m.StrainIDs)
+	e.Int64s(4, m.CharacteristicIDs)
+}
+
+// CompareRequest compares the measurements of strains.
+type CompareRequest struct {
+	Genus             string
+	StrainIDs         []int64
+	CharacteristicIDs []int64
+}
+
+// UnmarshalProto satisfies Unmarshaler.
+func (m *CompareRequest) UnmarshalProto(fields []Field) error {
+	var err error
+	for _, f := range fields {
+		switch f.Number {
+		case 1:
+			m.Genus = f.String()
+		case 2:
+			m.StrainIDs, err = f.Int64s(m.StrainIDs)
+		case 3:
+			m.CharacteristicIDs, err = f.Int64s(m.CharacteristicIDs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalProto satisfies Message.
+func (m *CompareRequest) MarshalProto(e *Encoder) {
+	e.String(1, m.Genus)
+	e.Int64s(2, m.StrainIDs)
+	e.Int64s(3, m.CharacteristicIDs)
+}
+
+// Strain is a strain.
+type Strain struct {
+	ID                  int64
+	SpeciesID           int64
+	StrainName          string
+	TypeStrain          bool
+	AccessionNumbers    string
+	Genbank             string
+	WholeGenomeSequence string
+	IsolatedFrom        string
+	Notes               string
+	MeasurementIDs      []int64
+	CharacteristicIDs   []int64
+	TotalMeasurements   int64
+	CanEdit             bool
+	CreatedAt           string
+	UpdatedAt           string
+	CreatedBy           int64
+	UpdatedBy           int64
+}
+
+// MarshalProto satisfies Message.
+func (m *Strain) MarshalProto(e *Encoder) {
+	e.Int64(1, m.ID)
+	e.Int64(2, m.SpeciesID)
+	e.String(3, m.StrainName)
+	e.Bool(4, m.TypeStrain)
+	e.String(5, m.AccessionNumbers)
+	e.String(6, m.Genbank)
+	e.String(7, m.WholeGenomeSequence)
+	e.String(8, m.IsolatedFrom)
+	e.String(9, m.Notes)
+	e.Int64s(10, m.MeasurementIDs)
+	e.Int64s(11, m.CharacteristicIDs)
+	e.Int64(12, m.TotalMeasurements)
+	e.Bool(13, m.CanEdit)
+	e.String(14, m.CreatedAt)
+	e.String(15, m.UpdatedAt)
+	e.Int64(16, m.CreatedBy)
+	e.Int64(17, m.UpdatedBy)
+}
+
+// StrainList is a list of strains.
+type StrainList struct {
+	Strains []*Strain
+}
+
+// MarshalProto satisfies Message.
+func (m *StrainList) MarshalProto(e *Encoder) {
+	for _, s := range m.Strains {
+		e.Message(1, s)
+	}
+}
+
+// Measurement is a measurement, with its value as text.
+type Measurement struct {
+	ID                 int64
+	StrainID           int64
+	CharacteristicID   int64
+	Value              string
+	ConfidenceInterval float64
+	UnitType           string
+	TestMethod         string
+	Notes              string
+	CanEdit            bool
+	CreatedAt          string
+	UpdatedAt          string
+	CreatedBy          int64
+	UpdatedBy          int64
+}
+
+// MarshalProto satisfies Message.
+func (m *Measurement) MarshalProto(e *Encoder) {
+	e.Int64(1, m.ID)
+	e.Int64(2, m.StrainID)
+	e.Int64(3, m.CharacteristicID)
+	e.String(4, m.Value)
+	e.Double(5, m.ConfidenceInterval)
+	e.String(6, m.UnitType)
+	e.String(7, m.TestMethod)
+	e.String(8, m.Notes)
+	e.Bool(9, m.CanEdit)
+	e.String(10, m.CreatedAt)
+	e.String(11, m.UpdatedAt)
+	e.Int64(12, m.CreatedBy)
+	e.Int64(13, m.UpdatedBy)
+}
+
+// MeasurementList is a list of measurements.
+type MeasurementList struct {
+	Measurements []*Measurement
+}
+
+// MarshalProto satisfies Message.
+func (m *MeasurementList) MarshalProto(e *Encoder) {
+	for _, measurement := range m.Measurements {
+		e.Message(1, measurement)
+	}
+}
+
+// CompareRow is a characteristic's values, one for each of the strains.
+type CompareRow struct {
+	CharacteristicID int64
+	Values           []string
+}
+
+// MarshalProto satisfies Message.
+func (m *CompareRow) MarshalProto(e *Encoder) {
+	e.Int64(1, m.CharacteristicID)
+	e.Strings(2, m.Values)
+}
+
+// CompareMatrix is the comparison of strains, in the order asked for.
+type CompareMatrix struct {
+	StrainIDs []int64
+	Rows      []*CompareRow
+}
+
+// MarshalProto satisfies Message.
+func (m *CompareMatrix) MarshalProto(e *Encoder) {
+	e.Int64s(1, m.StrainIDs)
+	for _, r := range m.Rows {
+		e.Message(2, r)
+	}
+}
@@ -0,0 +1,234 @@
+// Package rpc is the gRPC service, for programmatic bulk access. It is
+// served by net/http's HTTP/2 support, with a small protocol buffers encoding
+// of its own. The service definition, for clients to generate stubs from, is
+// in bactdb.proto.
+package rpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Code is a gRPC status code.
+type Code int
+
+// Status codes
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	AlreadyExists      Code = 6
+	PermissionDenied   Code = 7
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Aborted            Code = 10
+	OutOfRange         Code = 11
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+	DataLoss           Code = 15
+	Unauthenticated    Code = 16
+)
+
+// maxMessageSize is the largest request message accepted, gRPC's default.
+const maxMessageSize = 4 << 20
+
+// Error is a call's failure status.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error: code = %d desc = %s", e.Code, e.Message)
+}
+
+// Errorf creates a status error.
+func Errorf(code Code, format string, a ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// CodeFromHTTPStatus is the status code closest to a HTTP status.
+func CodeFromHTTPStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest, 422:
+		return InvalidArgument
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return PermissionDenied
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusConflict:
+		return AlreadyExists
+	case http.StatusPreconditionFailed:
+		return FailedPrecondition
+	case http.StatusTooManyRequests:
+		return ResourceExhausted
+	case http.StatusNotImplemented:
+		return Unimplemented
+	case http.StatusServiceUnavailable:
+		return Unavailable
+	case http.StatusGatewayTimeout:
+		return DeadlineExceeded
+	}
+	return Internal
+}
+
+// Server is a gRPC service.
+type Server struct {
+	// Service is the fully qualified service name, e.g. bactdb.Bactdb
+	Service string
+	// Authenticate, when set, is called before every call, and fails it
+	// when it returns an error.
+	Authenticate func(r *http.Request) error
+	methods      map[string]method
+}
+
+type method func(w *stream, r *http.Request, msg []byte) error
+
+// NewServer creates a service with no methods.
+func NewServer(service string) *Server {
+	return &Server{Service: service, methods: make(map[string]method)}
+}
+
+// Unary adds a method with a single response. newRequest allocates the
+// request message.
+func (s *Server) Unary(name string, newRequest func() Unmarshaler, fn func(r *http.Request, req Unmarshaler) (Message, error)) {
+	s.methods[name] = func(w *stream, r *http.Request, msg []byte) error {
+		req := newRequest()
+		if err := Unmarshal(msg, req); err != nil {
+			return Errorf(InvalidArgument, "%v", err)
+		}
+		resp, err := fn(r, req)
+		if err != nil {
+			return err
+		}
+		return w.send(resp)
+	}
+}
+
+// Stream adds a method with a stream of responses, which fn sends one at a
+// time.
+func (s *Server) Stream(name string, newRequest func() Unmarshaler, fn func(r *http.Request, req Unmarshaler, send func(Message) error) error) {
+	s.methods[name] = func(w *stream, r *http.Request, msg []byte) error {
+		req := newRequest()
+		if err := Unmarshal(msg, req); err != nil {
+			return Errorf(InvalidArgument, "%v", err)
+		}
+		return fn(r, req, w.send)
+	}
+}
+
+// ServeHTTP makes Server a http.Handler, for HTTP/2 requests.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	st := &stream{w: w, r: r}
+	err := s.call(st, r)
+
+	code, message := OK, ""
+	if err != nil {
+		code, message = Unknown, err.Error()
+		if rpcErr, ok := err.(*Error); ok {
+			code, message = rpcErr.Code, rpcErr.Message
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(code)))
+	if message != "" {
+		w.Header().Set("Grpc-Message", encodeMessage(message))
+	}
+}
+
+func (s *Server) call(w *stream, r *http.Request) error {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || path[:i] != s.Service || s.methods[path[i+1:]] == nil {
+		return Errorf(Unimplemented, "unknown method %s", r.URL.Path)
+	}
+	m := s.methods[path[i+1:]]
+
+	if s.Authenticate != nil {
+		if err := s.Authenticate(r); err != nil {
+			return err
+		}
+	}
+
+	msg, err := readMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	return m(w, r, msg)
+}
+
+// readMessage reads a length-prefixed message, the only one for the
+// methods we have.
+func readMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, Errorf(InvalidArgument, "reading request: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, Errorf(Unimplemented, "compressed requests are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxMessageSize {
+		return nil, Errorf(ResourceExhausted, "request of %d bytes is larger than %d", size, maxMessageSize)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, Errorf(InvalidArgument, "reading request: %v", err)
+	}
+	return msg, nil
+}
+
+// stream writes length-prefixed response messages.
+type stream struct {
+	w http.ResponseWriter
+	r *http.Request
+}
+
+func (s *stream) send(m Message) error {
+	if err := s.r.Context().Err(); err != nil {
+		return Errorf(Canceled, "%v", err)
+	}
+
+	msg := Marshal(m)
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := s.w.Write(append(frame, msg...)); err != nil {
+		return Errorf(Unavailable, "%v", err)
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// encodeMessage percent-encodes a status message, as the gRPC spec requires.
+func encodeMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,173 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWireRoundTrip(t *testing.T) {
+	req := MeasurementListRequest{
+		Genus:             "hymenobacter",
+		IDs:               []int64{1, 300, 1 << 40},
+		CharacteristicIDs: []int64{7},
+	}
+
+	var got MeasurementListRequest
+	if err := Unmarshal(Marshal(&req), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, req) {
+		t.Errorf("got %+v, want %+v", got, req)
+	}
+
+	if err := Unmarshal(Marshal(&req)[:5], &got); err != errTruncated {
+		t.Errorf("truncated message: got %v", err)
+	}
+}
+
+func TestWireUnpacked(t *testing.T) {
+	// Repeated fields may be sent one element at a time
+	data := []byte{0x0a, 0x01, 'g', 0x10, 0x05, 0x10, 0x06}
+	var got ListRequest
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Genus != "g" || !reflect.DeepEqual(got.IDs, []int64{5, 6}) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func testServer(t *testing.T) *httptest.Server {
+	s := NewServer("bactdb.Bactdb")
+	s.Authenticate = func(r *http.Request) error {
+		if r.Header.Get("Authorization") != "Bearer good" {
+			return Errorf(Unauthenticated, "please provide a valid token")
+		}
+		return nil
+	}
+	s.Unary("GetStrain", func() Unmarshaler { return &GetRequest{} }, func(r *http.Request, req Unmarshaler) (Message, error) {
+		get := req.(*GetRequest)
+		if get.ID == 0 {
+			return nil, Errorf(NotFound, "strain not found")
+		}
+		return &Strain{ID: get.ID, StrainName: "ABC 100%"}, nil
+	})
+	s.Stream("StreamCompare", func() Unmarshaler { return &CompareRequest{} }, func(r *http.Request, req Unmarshaler, send func(Message) error) error {
+		for _, id := range req.(*CompareRequest).CharacteristicIDs {
+			if err := send(&CompareRow{CharacteristicID: id, Values: []string{"", "+"}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	srv := httptest.NewUnstartedServer(s)
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	return srv
+}
+
+// call makes a gRPC call, returning the response messages and the status
+// trailers.
+func call(t *testing.T, srv *httptest.Server, method, token string, req Message) ([][]byte, http.Header) {
+	msg := Marshal(req)
+	body := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	body = append(body, msg...)
+
+	r, _ := http.NewRequest("POST", srv.URL+"/bactdb.Bactdb/"+method, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Transport: &http.Transport{Protocols: new(http.Protocols)}}
+	client.Transport.(*http.Transport).Protocols.SetUnencryptedHTTP2(true)
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("response over %s", resp.Proto)
+	}
+
+	var msgs [][]byte
+	for {
+		m, err := readMessage(resp.Body)
+		if err != nil {
+			var rpcErr *Error
+			if !errors.As(err, &rpcErr) || rpcErr.Message != "reading request: EOF" {
+				t.Fatal(err)
+			}
+			break
+		}
+		msgs = append(msgs, m)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return msgs, resp.Trailer
+}
+
+func TestServerUnary(t *testing.T) {
+	srv := testServer(t)
+	defer srv.Close()
+
+	msgs, trailer := call(t, srv, "GetStrain", "good", &GetRequest{Genus: "g", ID: 3})
+	if trailer.Get("Grpc-Status") != "0" || len(msgs) != 1 {
+		t.Fatalf("status %q with %d messages", trailer.Get("Grpc-Status"), len(msgs))
+	}
+	fields, err := Fields(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 || fields[0].Int64() != 3 || fields[1].String() != "ABC 100%" {
+		t.Errorf("fields %+v", fields)
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	srv := testServer(t)
+	defer srv.Close()
+
+	msgs, trailer := call(t, srv, "StreamCompare", "good", &CompareRequest{Genus: "g", CharacteristicIDs: []int64{4, 5, 6}})
+	if trailer.Get("Grpc-Status") != "0" || len(msgs) != 3 {
+		t.Fatalf("status %q with %d messages", trailer.Get("Grpc-Status"), len(msgs))
+	}
+	fields, _ := Fields(msgs[2])
+	if len(fields) != 3 || fields[0].Int64() != 6 || fields[1].String() != "" || fields[2].String() != "+" {
+		t.Errorf("fields %+v", fields)
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	srv := testServer(t)
+	defer srv.Close()
+
+	tests := []struct {
+		method, token string
+		status        string
+		message       string
+	}{
+		{"GetStrain", "bad", "16", "please provide a valid token"},
+		{"GetStrain", "good", "5", "strain not found"},
+		{"DeleteStrain", "good", "12", "unknown method /bactdb.Bactdb/DeleteStrain"},
+	}
+
+	for _, test := range tests {
+		msgs, trailer := call(t, srv, test.method, test.token, &GetRequest{})
+		if len(msgs) != 0 || trailer.Get("Grpc-Status") != test.status || trailer.Get("Grpc-Message") != test.message {
+			t.Errorf("%s: %d messages, status %q %q", test.method, len(msgs), trailer.Get("Grpc-Status"), trailer.Get("Grpc-Message"))
+		}
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	if got := encodeMessage("100% née\n"); got != "100%25 n%C3%A9e%0A" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,208 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Message is a protocol buffers message.
+type Message interface {
+	MarshalProto(e *Encoder)
+}
+
+// Unmarshaler decodes a protocol buffers message.
+type Unmarshaler interface {
+	UnmarshalProto(fields []Field) error
+}
+
+// Wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("rpc: truncated message")
+
+// Encoder writes protocol buffers fields. Zero values are left out, as proto3
+// does.
+type Encoder struct {
+	buf []byte
+}
+
+// Marshal encodes a message.
+func Marshal(m Message) []byte {
+	var e Encoder
+	m.MarshalProto(&e)
+	return e.buf
+}
+
+func (e *Encoder) tag(field, wireType int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wireType))
+}
+
+// Int64 writes an int64 field.
+func (e *Encoder) Int64(field int, v int64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, uint64(v))
+}
+
+// Bool writes a bool field.
+func (e *Encoder) Bool(field int, v bool) {
+	if !v {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.buf = append(e.buf, 1)
+}
+
+// Double writes a double field.
+func (e *Encoder) Double(field int, v float64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireFixed64)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
+
+// String writes a string field.
+func (e *Encoder) String(field int, v string) {
+	if v == "" {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// Strings writes a repeated string field. Empty strings are kept, since
+// they hold a place.
+func (e *Encoder) Strings(field int, vs []string) {
+	for _, v := range vs {
+		e.tag(field, wireBytes)
+		e.buf = binary.AppendUvarint(e.buf, uint64(len(v)))
+		e.buf = append(e.buf, v...)
+	}
+}
+
+// Int64s writes a packed repeated int64 field.
+func (e *Encoder) Int64s(field int, vs []int64) {
+	if len(vs) == 0 {
+		return
+	}
+	var packed []byte
+	for _, v := range vs {
+		packed = binary.AppendUvarint(packed, uint64(v))
+	}
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(packed)))
+	e.buf = append(e.buf, packed...)
+}
+
+// Message writes an embedded message field, which is left out when nil.
+func (e *Encoder) Message(field int, m Message) {
+	if m == nil {
+		return
+	}
+	b := Marshal(m)
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// Field is a decoded field. Varints and fixed width values are in Varint,
+// length delimited values are in Bytes.
+type Field struct {
+	Number   int
+	WireType int
+	Varint   uint64
+	Bytes    []byte
+}
+
+// Unmarshal decodes a message.
+func Unmarshal(data []byte, u Unmarshaler) error {
+	fields, err := Fields(data)
+	if err != nil {
+		return err
+	}
+	return u.UnmarshalProto(fields)
+}
+
+// Fields splits an encoded message into its fields.
+func Fields(data []byte) ([]Field, error) {
+	var fields []Field
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		data = data[n:]
+
+		f := Field{Number: int(key >> 3), WireType: int(key & 7)}
+		switch f.WireType {
+		case wireVarint:
+			if f.Varint, n = binary.Uvarint(data); n <= 0 {
+				return nil, errTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, errTruncated
+			}
+			f.Varint, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, errTruncated
+			}
+			f.Varint, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < l {
+				return nil, errTruncated
+			}
+			f.Bytes, data = data[n:n+int(l)], data[n+int(l):]
+		default:
+			return nil, errors.New("rpc: unsupported wire type")
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// Int64 is the field as an int64.
+func (f Field) Int64() int64 {
+	return int64(f.Varint)
+}
+
+// String is the field as a string.
+func (f Field) String() string {
+	return string(f.Bytes)
+}
+
+// Bool is the field as a bool.
+func (f Field) Bool() bool {
+	return f.Varint != 0
+}
+
+// Int64s is a repeated int64 field, packed or not. Repeated fields occur once
+// for each element, or packed run, so values are appended to vs.
+func (f Field) Int64s(vs []int64) ([]int64, error) {
+	if f.WireType == wireVarint {
+		return append(vs, int64(f.Varint)), nil
+	}
+	data := f.Bytes
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		vs = append(vs, int64(v))
+		data = data[n:]
+	}
+	return vs, nil
+}
@@ -0,0 +1,86 @@
+package types
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CORSPolicy is which cross-origin requests are allowed, and what browsers
+// are told about them.
+type CORSPolicy struct {
+	// Origins are allowed everywhere. An origin is exact, like
+	// https://hymenobacter.info, has a wildcard for any subdomain, like
+	// https://*.hymenobacter.info, or is * for any origin at all.
+	Origins []string
+	// Genera are origins allowed only under a genus, by genus name.
+	Genera map[string][]string
+	// Methods and Headers are what preflight requests are allowed to ask
+	// for.
+	Methods []string
+	Headers []string
+	// Expose are the response headers scripts can read.
+	Expose []string
+	// MaxAge is how long browsers can cache a preflight response.
+	MaxAge time.Duration
+	// Credentials allows cookies and client certificates to be sent.
+	Credentials bool
+}
+
+// DefaultCORS is the CORS policy before any origins are allowed.
+func DefaultCORS() CORSPolicy {
+	return CORSPolicy{
+		Methods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		Headers: []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match", "Idempotency-Key", "X-Request-Id"},
+		Expose:  []string{"ETag", "Idempotent-Replayed", "Retry-After", "X-Request-Id"},
+		MaxAge:  10 * time.Minute,
+	}
+}
+
+// Origin is an allowed origin, parsed.
+type Origin struct {
+	any    bool
+	scheme string
+	// host is the host and port, or the parent domain and port for
+	// subdomains
+	host       string
+	subdomains bool
+}
+
+// ParseOrigin reads an allowed origin, as it's written in a CORSPolicy.
+func ParseOrigin(origin string) (Origin, error) {
+	if origin == "*" {
+		return Origin{any: true}, nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+		return Origin{}, fmt.Errorf("%q isn't an origin, like https://hymenobacter.info or https://*.hymenobacter.info", origin)
+	}
+	o := Origin{scheme: strings.ToLower(u.Scheme), host: strings.ToLower(u.Host)}
+	if strings.HasPrefix(o.host, "*.") {
+		o.subdomains = true
+		o.host = o.host[1:]
+	}
+	if strings.Contains(o.host, "*") {
+		return Origin{}, fmt.Errorf("%q can only have a wildcard for subdomains", origin)
+	}
+	return o, nil
+}
+
+// Matches is whether an origin sent by a browser is allowed by o.
+func (o Origin) Matches(origin string) bool {
+	if o.any {
+		// Sandboxed documents and the like are never let in
+		return origin != "null"
+	}
+	scheme, host, ok := strings.Cut(strings.ToLower(origin), "://")
+	if !ok || scheme != o.scheme {
+		return false
+	}
+	if o.subdomains {
+		// o.host starts with the dot, so the parent domain itself is out
+		return strings.HasSuffix(host, o.host) && len(host) > len(o.host)
+	}
+	return host == o.host
+}
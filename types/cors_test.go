@@ -0,0 +1,43 @@
+package types
+
+import "testing"
+
+func TestParseOrigin(t *testing.T) {
+	for origin, ok := range map[string]bool{
+		"https://hymenobacter.info":       true,
+		"https://*.hymenobacter.info":     true,
+		"http://localhost:4200":           true,
+		"*":                               true,
+		"hymenobacter.info":               false,
+		"https://hymenobacter.info/":      false,
+		"https://hymenobacter.info/api":   false,
+		"https://lab.*.hymenobacter.info": false,
+		"https://*":                       false,
+	} {
+		if _, err := ParseOrigin(origin); (err == nil) != ok {
+			t.Errorf("%s got %v", origin, err)
+		}
+	}
+}
+
+func TestOriginMatches(t *testing.T) {
+	for _, test := range []struct {
+		allowed, origin string
+		ok              bool
+	}{
+		{"https://hymenobacter.info", "https://hymenobacter.info", true},
+		{"https://hymenobacter.info", "http://hymenobacter.info", false},
+		{"https://*.hymenobacter.info", "https://lab.hymenobacter.info", true},
+		{"https://*.hymenobacter.info", "https://hymenobacter.info", false},
+		{"*", "https://anywhere.org", true},
+		{"*", "null", false},
+	} {
+		o, err := ParseOrigin(test.allowed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Matches(test.origin) != test.ok {
+			t.Errorf("%s matching %s got %v", test.allowed, test.origin, !test.ok)
+		}
+	}
+}
@@ -1,6 +1,44 @@
 package types
 
+import "time"
+
 // Entity is a a payload or model.
 type Entity interface {
 	Marshal() ([]byte, error)
 }
+
+// VersionedEntity is an entity that knows when its primary record was last
+// modified.
+type VersionedEntity interface {
+	Entity
+	LastModified() time.Time
+}
+
+// IncludingEntity is a versioned entity that sideloads other records along
+// with its primary one.
+type IncludingEntity interface {
+	VersionedEntity
+	// IncludedModified is when each of the sideloaded records was last
+	// modified, in the order they're sent.
+	IncludedModified() []time.Time
+}
+
+// Precondition is the versions of a record that a write may replace, from an
+// If-Match header. A version is the LastModified time of the record. A nil
+// Precondition allows any version, an empty one allows none.
+type Precondition []time.Time
+
+// Allows reports whether a record last modified at updatedAt can be written.
+func (p Precondition) Allows(updatedAt time.Time) bool {
+	if p == nil {
+		return true
+	}
+	// Postgres stores timestamps with microsecond precision.
+	updatedAt = updatedAt.Truncate(time.Microsecond)
+	for _, v := range p {
+		if v.Equal(updatedAt) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,21 +1,33 @@
 package types
 
-import "encoding/json"
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
 
-// ErrorJSON is an error that serializes to a JSON-encoded representation of the
-// error message.
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/lib/pq"
+	"github.com/thermokarst/bactdb/errors"
+)
+
+// StatusUnprocessableEntity is the HTTP status when Unprocessable Entity.
+const StatusUnprocessableEntity = 422
+
+// ErrorJSON is an error that serializes to the JSON error envelope.
 type ErrorJSON struct {
-	Err error
+	Err    error
+	Status int
+	Code   string
 }
 
 // Error satisfies the necessary interface to make ErrorJSON an error.
 func (ej ErrorJSON) Error() string {
-	e, _ := json.Marshal(struct {
-		Err string `json:"error"`
-	}{
-		Err: ej.Err.Error(),
-	})
-	return string(e)
+	return ValidationError{ErrorDetail{
+		Status: strconv.Itoa(ej.Status),
+		Code:   ej.Code,
+		Detail: ej.Err.Error(),
+	}}.Error()
 }
 
 // AppError returns an error plus an HTTP status code.
@@ -23,3 +35,48 @@ type AppError struct {
 	Error  error
 	Status int
 }
+
+// NewAppError classifies an error for the client. Errors from the errors
+// package, validation failures, JSON decoding failures, bodies over their
+// http.MaxBytesReader limit and database integrity violations carry their own
+// status; status is used for anything else.
+func NewAppError(err error, status int) *AppError {
+	var code string
+
+	switch e := err.(type) {
+	case ValidationError:
+		return &AppError{Error: e, Status: StatusUnprocessableEntity}
+	case *errors.Error:
+		status, code = e.Status, e.Code
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		status, code = http.StatusBadRequest, "json_invalid"
+	case *http.MaxBytesError:
+		tooLarge := errors.ErrRequestTooLarge.(*errors.Error)
+		err, status, code = tooLarge, tooLarge.Status, tooLarge.Code
+	case *pq.Error:
+		if e.Code == "23505" {
+			status, code = http.StatusConflict, "record_conflict"
+		} else if e.Code.Class() == "23" {
+			status, code = StatusUnprocessableEntity, "constraint_violation"
+		}
+	}
+
+	if err == sql.ErrNoRows {
+		status = http.StatusNotFound
+	}
+
+	if code == "" {
+		code = CodeForStatus(status)
+	}
+
+	return &AppError{
+		Error:  ErrorJSON{Err: err, Status: status, Code: code},
+		Status: status,
+	}
+}
+
+// CodeForStatus is the generic error code for an HTTP status, for errors that
+// don't have a more specific one.
+func CodeForStatus(status int) string {
+	return strings.Replace(strings.ToLower(http.StatusText(status)), " ", "_", -1)
+}
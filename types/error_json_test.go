@@ -0,0 +1,61 @@
+package types
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/thermokarst/bactdb/Godeps/_workspace/src/github.com/lib/pq"
+	"github.com/thermokarst/bactdb/errors"
+)
+
+func TestNewAppError(t *testing.T) {
+	var v struct{ ID int64 }
+	syntaxErr := json.Unmarshal([]byte(`{"id":`), &v)
+	typeErr := json.Unmarshal([]byte(`{"id":"one"}`), &v)
+
+	for _, test := range []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"not found", errors.ErrStrainNotFound, http.StatusNotFound, "strain_not_found"},
+		{"conflict", errors.ErrEmailAddressTaken, http.StatusConflict, "email_taken"},
+		{"unprocessable", errors.ErrIdempotencyKeyReused, StatusUnprocessableEntity, "idempotency_key_reused"},
+		{"forbidden", errors.ErrMeasurementForbidden, http.StatusForbidden, "measurement_forbidden"},
+		{"unique violation", &pq.Error{Code: "23505"}, http.StatusConflict, "record_conflict"},
+		{"foreign key violation", &pq.Error{Code: "23503"}, StatusUnprocessableEntity, "constraint_violation"},
+		{"other database error", &pq.Error{Code: "42P01"}, http.StatusInternalServerError, "internal_server_error"},
+		{"no rows", sql.ErrNoRows, http.StatusNotFound, "not_found"},
+		{"JSON syntax", syntaxErr, http.StatusBadRequest, "json_invalid"},
+		{"JSON type", typeErr, http.StatusBadRequest, "json_invalid"},
+		{"body too large", &http.MaxBytesError{Limit: 1}, http.StatusRequestEntityTooLarge, "request_too_large"},
+		{"anything else", fmt.Errorf("disk full"), http.StatusInternalServerError, "internal_server_error"},
+	} {
+		appErr := NewAppError(test.err, http.StatusInternalServerError)
+		if appErr.Status != test.status {
+			t.Errorf("%s got status %d, want %d", test.name, appErr.Status, test.status)
+		}
+		e, ok := appErr.Error.(ErrorJSON)
+		if !ok {
+			t.Errorf("%s got %T", test.name, appErr.Error)
+			continue
+		}
+		if e.Status != test.status || e.Code != test.code {
+			t.Errorf("%s got %d %s, want %d %s", test.name, e.Status, e.Code, test.status, test.code)
+		}
+		if want := fmt.Sprintf(`"status":"%d","code":"%s"`, test.status, test.code); !strings.Contains(e.Error(), want) {
+			t.Errorf("%s got %s, want %s", test.name, e.Error(), want)
+		}
+	}
+
+	// Validation failures are already in the envelope
+	v422 := ValidationError{NewValidationError("email", "Must provide a value")}
+	if appErr := NewAppError(v422, http.StatusInternalServerError); appErr.Status != StatusUnprocessableEntity || appErr.Error.Error() != v422.Error() {
+		t.Errorf("validation got %d %v", appErr.Status, appErr.Error)
+	}
+}
@@ -0,0 +1,35 @@
+package types
+
+import (
+	"database/sql/driver"
+	"strings"
+
+	"github.com/thermokarst/bactdb/errors"
+)
+
+// StringList is a list of strings, kept in the DB as comma-separated text. The
+// strings can't have commas of their own.
+type StringList []string
+
+// Scan makes StringList a sql.Scanner.
+func (l *StringList) Scan(src interface{}) error {
+	var s string
+	switch src := src.(type) {
+	case []byte:
+		s = string(src)
+	case string:
+		s = src
+	default:
+		return errors.ErrSourceNotByteSlice
+	}
+	*l = nil
+	if s != "" {
+		*l = strings.Split(s, ",")
+	}
+	return nil
+}
+
+// Value makes StringList a driver.Valuer.
+func (l StringList) Value() (driver.Value, error) {
+	return strings.Join(l, ","), nil
+}
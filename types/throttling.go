@@ -0,0 +1,78 @@
+package types
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a number of requests allowed per period.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit reads a limit written like "10/1m". "0" or "" is no limit.
+func ParseLimit(s string) (Limit, error) {
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("limit %q isn't requests/period", s)
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("limit %q has a bad number of requests", s)
+	}
+	per, err := time.ParseDuration(parts[1])
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("limit %q has a bad period", s)
+	}
+	return Limit{Requests: n, Per: per}, nil
+}
+
+// String writes a limit the way ParseLimit reads it.
+func (l Limit) String() string {
+	if l.Requests == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%v", l.Requests, l.Per)
+}
+
+// MarshalText satisfies encoding.TextMarshaler.
+func (l Limit) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText satisfies encoding.TextUnmarshaler.
+func (l *Limit) UnmarshalText(b []byte) error {
+	parsed, err := ParseLimit(string(b))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
+// Throttling is the rate limiting of the routes that don't take a token, but
+// do check passwords or send email. Each route is counted separately.
+type Throttling struct {
+	// PerIP limits each client address.
+	PerIP Limit
+	// PerEmail limits each email address logged in as, signed up or locked
+	// out.
+	PerEmail Limit
+	// TrustProxy takes client addresses from X-Forwarded-For, for when the
+	// server is behind a proxy.
+	TrustProxy bool
+}
+
+// DefaultThrottling is the rate limiting unless it's configured.
+func DefaultThrottling() Throttling {
+	return Throttling{
+		PerIP:    Limit{Requests: 30, Per: time.Minute},
+		PerEmail: Limit{Requests: 10, Per: 15 * time.Minute},
+	}
+}
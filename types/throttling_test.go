@@ -0,0 +1,20 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	if l, err := ParseLimit("10/15m"); err != nil || l != (Limit{10, 15 * time.Minute}) {
+		t.Errorf("got %+v, %v", l, err)
+	}
+	if l, err := ParseLimit("0"); err != nil || l.Requests != 0 {
+		t.Errorf("got %+v, %v", l, err)
+	}
+	for _, s := range []string{"10", "x/1m", "0/1m", "10/x", "10/-1m"} {
+		if _, err := ParseLimit(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
@@ -1,23 +1,40 @@
 package types
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"strconv"
+)
 
 type Source struct {
 	Pointer string `json:"pointer"`
 }
 
 type ErrorDetail struct {
-	Source `json:"source"`
-	Detail string `json:"detail"`
+	Status string  `json:"status,omitempty"`
+	Code   string  `json:"code,omitempty"`
+	Source *Source `json:"source,omitempty"`
+	Detail string  `json:"detail"`
 }
 
 func NewValidationError(attr, message string) ErrorDetail {
 	return ErrorDetail{
-		Source: Source{Pointer: "data/attributes/" + attr},
+		Status: strconv.Itoa(StatusUnprocessableEntity),
+		Code:   "attribute_invalid",
+		Source: &Source{Pointer: "data/attributes/" + attr},
 		Detail: message,
 	}
 }
 
+// NewErrorDetail is an error that applies to a whole entity, rather than a
+// single attribute.
+func NewErrorDetail(message string) ErrorDetail {
+	return ErrorDetail{
+		Source: &Source{Pointer: "data"},
+		Detail: message,
+	}
+}
+
+// ValidationError is the JSON error envelope, used for every failed request.
 type ValidationError []ErrorDetail
 
 func (v ValidationError) Error() string {
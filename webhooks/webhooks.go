@@ -0,0 +1,221 @@
+// Package webhooks delivers events to webhooks: signed JSON POSTs, retried
+// with backoff until they're accepted.
+//
+// Every delivery is signed with the webhook's secret, as the hex HMAC-SHA256
+// of the request body:
+//
+//	X-Bactdb-Signature: sha256=<hex>
+//
+// along with X-Bactdb-Event, the event, and X-Bactdb-Delivery, an ID that
+// stays the same across retries.
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hook is somewhere to deliver events.
+type Hook struct {
+	ID     int64
+	URL    string
+	Secret string
+	// Events are the events wanted, like strains.create, strains.* or *.
+	Events []string
+}
+
+// Event is a change to a genus' data.
+type Event struct {
+	Event  string    `json:"event"`
+	Genus  string    `json:"genus"`
+	Entity string    `json:"entity"`
+	ID     int64     `json:"id"`
+	Action string    `json:"action"`
+	User   int64     `json:"user"`
+	Time   time.Time `json:"time"`
+	// Data is the entity as the API would return it, for anything but deletes.
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// NewEvent is an event for an action on an entity. The event is named for both,
+// like strains.create.
+func NewEvent(genus, entity, action string, id, user int64) Event {
+	return Event{
+		Event:  entity + "." + action,
+		Genus:  strings.ToLower(genus),
+		Entity: entity,
+		ID:     id,
+		Action: action,
+		User:   user,
+		Time:   time.Now().UTC(),
+	}
+}
+
+// Attempt is how one try at a delivery went, for the delivery log.
+type Attempt struct {
+	HookID   int64
+	Delivery string
+	Event    string
+	Attempt  int
+	// StatusCode is 0 when there was no response at all.
+	StatusCode int
+	Err        error
+	Duration   time.Duration
+}
+
+// OK reports whether the delivery was accepted.
+func (a Attempt) OK() bool {
+	return a.Err == nil && a.StatusCode >= 200 && a.StatusCode < 300
+}
+
+// retry reports whether the delivery is worth another try. Anything but a
+// server error or being rate limited is down to the request, which won't get
+// any better.
+func (a Attempt) retry() bool {
+	return a.StatusCode == 0 || a.StatusCode >= 500 || a.StatusCode == http.StatusTooManyRequests
+}
+
+// Dispatcher delivers events in the background.
+type Dispatcher struct {
+	Client *http.Client
+	// MaxAttempts is how many times a delivery is tried, in all.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubling for each one after.
+	Backoff time.Duration
+	// Record, if set, is told about every attempt.
+	Record func(Attempt)
+
+	wg sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher with the default retries: five attempts
+// over about fifteen seconds.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 5,
+		Backoff:     time.Second,
+	}
+}
+
+// Dispatch delivers an event to each of the hooks that want it, without
+// waiting for the deliveries.
+func (d *Dispatcher) Dispatch(hooks []Hook, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range hooks {
+		if !Matches(h.Events, e.Event) {
+			continue
+		}
+		id, err := newDeliveryID()
+		if err != nil {
+			return err
+		}
+		d.wg.Add(1)
+		go func(h Hook) {
+			defer d.wg.Done()
+			d.deliver(h, e.Event, id, body)
+		}(h)
+	}
+	return nil
+}
+
+// Wait waits for every delivery under way, retries and all.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) deliver(h Hook, event, id string, body []byte) {
+	wait := d.Backoff
+	for n := 1; ; n++ {
+		a := d.attempt(h, event, id, body)
+		a.Attempt = n
+		if d.Record != nil {
+			d.Record(a)
+		}
+		if a.OK() || !a.retry() || n >= d.MaxAttempts {
+			return
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (d *Dispatcher) attempt(h Hook, event, id string, body []byte) Attempt {
+	a := Attempt{HookID: h.ID, Delivery: id, Event: event}
+	start := time.Now()
+
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		a.Err = err
+		return a
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bactdb-webhooks")
+	req.Header.Set("X-Bactdb-Event", event)
+	req.Header.Set("X-Bactdb-Delivery", id)
+	req.Header.Set("X-Bactdb-Signature", Sign(h.Secret, body))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		a.Err = err
+		a.Duration = time.Since(start)
+		return a
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	a.StatusCode = resp.StatusCode
+	if !a.OK() {
+		a.Err = fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	a.Duration = time.Since(start)
+	return a
+}
+
+// Sign is the signature of a body, as sent in X-Bactdb-Signature.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether a signature is a body's, for receivers.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Matches reports whether an event is one of those wanted.
+func Matches(events []string, event string) bool {
+	entity, action := event, ""
+	if i := strings.IndexByte(event, '.'); i >= 0 {
+		entity, action = event[:i], event[i+1:]
+	}
+	for _, e := range events {
+		if e == "*" || e == event || e == entity+".*" || e == "*."+action {
+			return true
+		}
+	}
+	return false
+}
+
+func newDeliveryID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
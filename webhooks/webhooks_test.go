@@ -0,0 +1,151 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standIn is a webhook receiver, failing with each of statuses in turn before
+// accepting deliveries.
+type standIn struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+	if len(s.statuses) > 0 {
+		w.WriteHeader(s.statuses[0])
+		s.statuses = s.statuses[1:]
+	}
+}
+
+func testDispatcher() (*Dispatcher, *[]Attempt) {
+	var mu sync.Mutex
+	var attempts []Attempt
+	d := NewDispatcher()
+	d.Backoff = time.Millisecond
+	d.Record = func(a Attempt) {
+		mu.Lock()
+		attempts = append(attempts, a)
+		mu.Unlock()
+	}
+	return d, &attempts
+}
+
+func TestDispatch(t *testing.T) {
+	s := &standIn{}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	d, attempts := testDispatcher()
+	hooks := []Hook{
+		{ID: 1, URL: srv.URL, Secret: "shh", Events: []string{"strains.*"}},
+		{ID: 2, URL: srv.URL, Secret: "shh", Events: []string{"species.create"}},
+	}
+	e := NewEvent("Hymenobacter", "strains", "update", 4, 7)
+	if err := d.Dispatch(hooks, e); err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	if len(s.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(s.requests))
+	}
+	r := s.requests[0]
+	if !Verify("shh", s.bodies[0], r.Header.Get("X-Bactdb-Signature")) {
+		t.Errorf("bad signature %q", r.Header.Get("X-Bactdb-Signature"))
+	}
+	if r.Header.Get("X-Bactdb-Event") != "strains.update" || r.Header.Get("X-Bactdb-Delivery") == "" {
+		t.Errorf("headers %v", r.Header)
+	}
+
+	var got Event
+	if err := json.Unmarshal(s.bodies[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Genus != "hymenobacter" || got.Entity != "strains" || got.ID != 4 || got.Action != "update" || got.User != 7 {
+		t.Errorf("got %+v", got)
+	}
+
+	if len(*attempts) != 1 || !(*attempts)[0].OK() || (*attempts)[0].HookID != 1 {
+		t.Errorf("attempts %+v", *attempts)
+	}
+}
+
+func TestDispatchRetries(t *testing.T) {
+	s := &standIn{statuses: []int{http.StatusBadGateway, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	d, attempts := testDispatcher()
+	d.Dispatch([]Hook{{ID: 1, URL: srv.URL, Secret: "shh", Events: []string{"*"}}}, NewEvent("g", "species", "delete", 1, 1))
+	d.Wait()
+
+	if len(*attempts) != 3 {
+		t.Fatalf("got %d attempts, want 3", len(*attempts))
+	}
+	for i, a := range *attempts {
+		if a.Attempt != i+1 || a.OK() != (i == 2) {
+			t.Errorf("attempt %d: %+v", i, a)
+		}
+		if a.Delivery != (*attempts)[0].Delivery {
+			t.Errorf("attempt %d has delivery %q", i, a.Delivery)
+		}
+	}
+	if (*attempts)[0].StatusCode != http.StatusBadGateway || (*attempts)[0].Err == nil {
+		t.Errorf("first attempt %+v", (*attempts)[0])
+	}
+}
+
+func TestDispatchGivesUp(t *testing.T) {
+	s := &standIn{statuses: []int{http.StatusGone, 500, 500, 500, 500, 500}}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	d, attempts := testDispatcher()
+	d.Dispatch([]Hook{{ID: 1, URL: srv.URL, Events: []string{"*"}}}, NewEvent("g", "species", "delete", 1, 1))
+	d.Wait()
+	if len(*attempts) != 1 {
+		t.Errorf("got %d attempts after a 410, want 1", len(*attempts))
+	}
+
+	*attempts = nil
+	d.Dispatch([]Hook{{ID: 1, URL: srv.URL, Events: []string{"*"}}}, NewEvent("g", "species", "delete", 1, 1))
+	d.Wait()
+	if len(*attempts) != d.MaxAttempts {
+		t.Errorf("got %d attempts, want %d", len(*attempts), d.MaxAttempts)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		events []string
+		event  string
+		want   bool
+	}{
+		{[]string{"*"}, "strains.create", true},
+		{[]string{"strains.*"}, "strains.delete", true},
+		{[]string{"*.delete"}, "species.delete", true},
+		{[]string{"species.create", "strains.update"}, "strains.update", true},
+		{[]string{"strains.*"}, "species.create", false},
+		{[]string{"*.create"}, "strains.update", false},
+		{nil, "strains.update", false},
+	}
+	for _, test := range tests {
+		if got := Matches(test.events, test.event); got != test.want {
+			t.Errorf("Matches(%v, %q) = %v", test.events, test.event, got)
+		}
+	}
+}